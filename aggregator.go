@@ -0,0 +1,215 @@
+package captainslog
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Aggregator merges continuation lines, such as the lines of a Java
+// stack trace or a Python traceback, into the message that started
+// them. Messages are grouped by Host, Tag.Program and Tag.Pid, so
+// interleaved messages from different sources are merged separately.
+type Aggregator struct {
+	startPattern *regexp.Regexp
+	indentation  bool
+	timeout      time.Duration
+	maxSize      int
+	pending      map[aggregateKey]*aggregate
+	order        []aggregateKey
+	mutex        sync.Mutex
+}
+
+type aggregateKey struct {
+	host    string
+	program string
+	pid     string
+}
+
+type aggregate struct {
+	msg      SyslogMsg
+	lines    []string
+	size     int
+	lastSeen time.Time
+}
+
+// NewAggregator returns a new Aggregator. Without any options,
+// every message is treated as the start of a new message.
+func NewAggregator(options ...func(*Aggregator)) *Aggregator {
+	a := Aggregator{pending: make(map[aggregateKey]*aggregate)}
+	for _, option := range options {
+		option(&a)
+	}
+	return &a
+}
+
+// AggregatorOptionStartPattern sets the pattern that content must match
+// to start a new message. Content that does not match is treated as a
+// continuation of the previous message. The single space that usually
+// separates the tag from the content is removed before matching.
+func AggregatorOptionStartPattern(re *regexp.Regexp) func(*Aggregator) {
+	return func(a *Aggregator) {
+		a.startPattern = re
+	}
+}
+
+// AggregatorOptionIndentation sets the aggregator to treat content that
+// starts with a space or a tab as a continuation of the previous message.
+// The single space that usually separates the tag from the content is
+// not counted as indentation.
+func AggregatorOptionIndentation(a *Aggregator) {
+	a.indentation = true
+}
+
+// AggregatorOptionTimeout sets how long a message waits for more
+// continuation lines before Expire returns it. A zero timeout, the
+// default, means messages only complete when the next message starts
+// or Flush is called.
+func AggregatorOptionTimeout(timeout time.Duration) func(*Aggregator) {
+	return func(a *Aggregator) {
+		a.timeout = timeout
+	}
+}
+
+// AggregatorOptionMaxSize sets the maximum size in bytes of merged
+// content. A continuation line that would exceed it starts a new
+// message instead. The default of zero means no limit.
+func AggregatorOptionMaxSize(size int) func(*Aggregator) {
+	return func(a *Aggregator) {
+		a.maxSize = size
+	}
+}
+
+// Add adds a message to the aggregator and returns any messages
+// that were completed by it. A message is completed when a new
+// message from the same source starts, or when it reaches the
+// maximum size.
+func (a *Aggregator) Add(msg SyslogMsg) []SyslogMsg {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	var done []SyslogMsg
+	key := aggregateKey{host: msg.Host, program: msg.Tag.Program, pid: msg.Tag.Pid}
+	line := continuationLine(&msg)
+
+	if agg, ok := a.pending[key]; ok {
+		if a.isContinuation(line) && (a.maxSize == 0 || agg.size+1+len(line) <= a.maxSize) {
+			agg.lines = append(agg.lines, line)
+			agg.size += 1 + len(line)
+			agg.lastSeen = time.Now()
+			return done
+		}
+		done = append(done, a.remove(key))
+	}
+
+	first := messageLine(&msg)
+	a.pending[key] = &aggregate{
+		msg:      msg,
+		lines:    []string{first},
+		size:     len(first),
+		lastSeen: time.Now(),
+	}
+	a.order = append(a.order, key)
+	return done
+}
+
+// Expire returns the messages that have not seen a continuation line
+// within the timeout as of now. It does nothing if no timeout is set.
+func (a *Aggregator) Expire(now time.Time) []SyslogMsg {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	var done []SyslogMsg
+	if a.timeout == 0 {
+		return done
+	}
+
+	for _, key := range append([]aggregateKey(nil), a.order...) {
+		if now.Sub(a.pending[key].lastSeen) >= a.timeout {
+			done = append(done, a.remove(key))
+		}
+	}
+	return done
+}
+
+// Flush returns all pending messages, in the order they were started.
+func (a *Aggregator) Flush() []SyslogMsg {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	var done []SyslogMsg
+	for len(a.order) > 0 {
+		done = append(done, a.remove(a.order[0]))
+	}
+	return done
+}
+
+func (a *Aggregator) isContinuation(line string) bool {
+	if a.indentation && len(line) > 0 && isCEESpace(line[0]) {
+		return true
+	}
+	if a.startPattern != nil && !a.startPattern.MatchString(line) {
+		return true
+	}
+	return false
+}
+
+// remove deletes the pending message at key and returns it with
+// its lines merged.
+func (a *Aggregator) remove(key aggregateKey) SyslogMsg {
+	agg := a.pending[key]
+	delete(a.pending, key)
+	for i, k := range a.order {
+		if k == key {
+			a.order = append(a.order[:i], a.order[i+1:]...)
+			break
+		}
+	}
+
+	if len(agg.lines) == 1 {
+		return agg.msg
+	}
+
+	lines := agg.lines
+	if lines[0] == "" {
+		lines = lines[1:]
+	}
+	merged := strings.Join(lines, "\n")
+	if agg.msg.IsJSON {
+		// the message shares its JSONValues with the one that was added
+		values := make(map[string]interface{}, len(agg.msg.JSONValues)+1)
+		for k, v := range agg.msg.JSONValues {
+			values[k] = v
+		}
+		values["msg"] = merged
+		agg.msg.JSONValues = values
+	} else {
+		agg.msg.Content = merged
+	}
+	return agg.msg
+}
+
+// messageLine returns the text of a message that starts an aggregate.
+// Plain content keeps its leading space so it serializes unchanged,
+// and a JSON message without a "msg" key starts with no text.
+func messageLine(msg *SyslogMsg) string {
+	_ = msg.DecodeJSON()
+	if msg.IsJSON {
+		m, _ := msg.JSONValues["msg"].(string)
+		return m
+	}
+	return msg.Content
+}
+
+// continuationLine returns the text of a message that may continue an
+// aggregate, without the space that separates it from the tag.
+func continuationLine(msg *SyslogMsg) string {
+	_ = msg.DecodeJSON()
+	if msg.IsJSON {
+		if m, ok := msg.JSONValues["msg"].(string); ok {
+			return m
+		}
+	}
+	return strings.TrimPrefix(msg.Content, " ")
+}
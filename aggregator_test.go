@@ -0,0 +1,239 @@
+package captainslog_test
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/captainslog"
+)
+
+func parseLines(t *testing.T, lines []string) []captainslog.SyslogMsg {
+	msgs := make([]captainslog.SyslogMsg, 0, len(lines))
+	for _, line := range lines {
+		msg, err := captainslog.NewSyslogMsgFromBytes([]byte(line))
+		if err != nil {
+			t.Fatal(err)
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs
+}
+
+func TestAggregator(t *testing.T) {
+	testCases := []struct {
+		name    string
+		options []func(*captainslog.Aggregator)
+		input   []string
+		want    []string
+	}{
+		{
+			name:    "java stack trace with indentation",
+			options: []func(*captainslog.Aggregator){captainslog.AggregatorOptionIndentation},
+			input: []string{
+				"<11>2016-03-08T14:59:36.293816+00:00 host.example.com java[12]: Exception in thread \"main\" java.lang.NullPointerException\n",
+				"<11>2016-03-08T14:59:36.293816+00:00 host.example.com java[12]: \tat com.example.App.run(App.java:14)\n",
+				"<11>2016-03-08T14:59:36.293816+00:00 host.example.com java[12]: \tat com.example.App.main(App.java:5)\n",
+				"<14>2016-03-08T14:59:37.293816+00:00 host.example.com java[12]: done\n",
+			},
+			want: []string{
+				" Exception in thread \"main\" java.lang.NullPointerException\n\tat com.example.App.run(App.java:14)\n\tat com.example.App.main(App.java:5)",
+				" done",
+			},
+		},
+		{
+			name:    "python traceback with start pattern",
+			options: []func(*captainslog.Aggregator){captainslog.AggregatorOptionStartPattern(regexp.MustCompile(`^(Traceback|INFO|ERROR)`))},
+			input: []string{
+				"<11>2016-03-08T14:59:36.293816+00:00 host.example.com app[7]: Traceback (most recent call last):\n",
+				"<11>2016-03-08T14:59:36.293816+00:00 host.example.com app[7]:   File \"app.py\", line 1, in <module>\n",
+				"<11>2016-03-08T14:59:36.293816+00:00 host.example.com app[7]: ZeroDivisionError: division by zero\n",
+				"<14>2016-03-08T14:59:37.293816+00:00 host.example.com app[7]: INFO restarting\n",
+			},
+			want: []string{
+				" Traceback (most recent call last):\n  File \"app.py\", line 1, in <module>\nZeroDivisionError: division by zero",
+				" INFO restarting",
+			},
+		},
+		{
+			name:    "interleaved sources",
+			options: []func(*captainslog.Aggregator){captainslog.AggregatorOptionIndentation},
+			input: []string{
+				"<11>2016-03-08T14:59:36.293816+00:00 host.example.com java[12]: first\n",
+				"<11>2016-03-08T14:59:36.293816+00:00 host.example.com java[13]: second\n",
+				"<11>2016-03-08T14:59:36.293816+00:00 host.example.com java[12]:   first continued\n",
+				"<11>2016-03-08T14:59:36.293816+00:00 host.example.com java[13]:   second continued\n",
+			},
+			want: []string{
+				" first\n  first continued",
+				" second\n  second continued",
+			},
+		},
+		{
+			name: "max size",
+			options: []func(*captainslog.Aggregator){
+				captainslog.AggregatorOptionIndentation,
+				captainslog.AggregatorOptionMaxSize(12),
+			},
+			input: []string{
+				"<11>2016-03-08T14:59:36.293816+00:00 host.example.com java[12]: first\n",
+				"<11>2016-03-08T14:59:36.293816+00:00 host.example.com java[12]:  two\n",
+				"<11>2016-03-08T14:59:36.293816+00:00 host.example.com java[12]:  three\n",
+			},
+			want: []string{
+				" first\n two",
+				"  three",
+			},
+		},
+		{
+			name:    "no rules",
+			options: []func(*captainslog.Aggregator){},
+			input: []string{
+				"<11>2016-03-08T14:59:36.293816+00:00 host.example.com java[12]: first\n",
+				"<11>2016-03-08T14:59:36.293816+00:00 host.example.com java[12]:   second\n",
+			},
+			want: []string{
+				" first",
+				"   second",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			a := captainslog.NewAggregator(tc.options...)
+
+			var got []captainslog.SyslogMsg
+			for _, msg := range parseLines(t, tc.input) {
+				got = append(got, a.Add(msg)...)
+			}
+			got = append(got, a.Flush()...)
+
+			if want, got := len(tc.want), len(got); want != got {
+				t.Fatalf("messages: want %d, got %d", want, got)
+			}
+
+			for i := range tc.want {
+				if want, got := tc.want[i], got[i].Content; want != got {
+					t.Errorf("content: want %q, got %q", want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestAggregatorCEE(t *testing.T) {
+	a := captainslog.NewAggregator(captainslog.AggregatorOptionIndentation)
+
+	msgs := parseLines(t, []string{
+		"<11>2016-03-08T14:59:36.293816+00:00 host.example.com java[12]: @cee:{\"level\":\"error\",\"msg\":\"request failed\"}\n",
+		"<11>2016-03-08T14:59:36.293816+00:00 host.example.com java[12]: @cee:{\"msg\":\"\\tat com.example.App.run(App.java:14)\"}\n",
+		"<11>2016-03-08T14:59:36.293816+00:00 host.example.com java[12]: \tat com.example.App.main(App.java:5)\n",
+	})
+
+	for _, msg := range msgs {
+		if done := a.Add(msg); len(done) != 0 {
+			t.Errorf("want no messages, got %d", len(done))
+		}
+	}
+
+	done := a.Flush()
+	if want, got := 1, len(done); want != got {
+		t.Fatalf("want %d, got %d", want, got)
+	}
+
+	wanted := "request failed\n\tat com.example.App.run(App.java:14)\n\tat com.example.App.main(App.java:5)"
+	if want, got := wanted, done[0].JSONValues["msg"]; want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+
+	if want, got := "error", done[0].JSONValues["level"]; want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+
+	// the message that was added is left as it was
+	if want, got := "request failed", msgs[0].JSONValues["msg"]; want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func TestAggregatorCEENotJSON(t *testing.T) {
+	a := captainslog.NewAggregator(captainslog.AggregatorOptionIndentation)
+	decoder := captainslog.ContentDecoderFunc(func(content []byte) (map[string]interface{}, error) {
+		return map[string]interface{}{"a": string(content)}, nil
+	})
+
+	for _, line := range []string{
+		"<11>2016-03-08T14:59:36.293816+00:00 host.example.com java[12]: @cee: Exception x\n",
+		"<11>2016-03-08T14:59:36.293816+00:00 host.example.com java[12]: @cee:   at foo\n",
+	} {
+		msg, err := captainslog.NewSyslogMsgFromBytes([]byte(line),
+			captainslog.OptionContentDecoder(decoder, captainslog.ContentConditionCEE))
+		if err != nil {
+			t.Fatal(err)
+		}
+		a.Add(msg)
+	}
+
+	done := a.Flush()
+	if want, got := 1, len(done); want != got {
+		t.Fatalf("want %d, got %d", want, got)
+	}
+	want := "<11>2016-03-08T14:59:36.293816+00:00 host.example.com java[12]: @cee:{\"a\":\"Exception x\",\"msg\":\"Exception x\\n  at foo\"}\n"
+	if got := done[0].String(); want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func TestAggregatorCEEWithoutMsg(t *testing.T) {
+	a := captainslog.NewAggregator(captainslog.AggregatorOptionIndentation)
+
+	msgs := parseLines(t, []string{
+		"<11>2016-03-08T14:59:36.293816+00:00 host.example.com java[12]: @cee:{\"level\":\"error\"}\n",
+		"<11>2016-03-08T14:59:36.293816+00:00 host.example.com java[12]: \tat com.example.App.main(App.java:5)\n",
+	})
+	for _, msg := range msgs {
+		a.Add(msg)
+	}
+
+	done := a.Flush()
+	if want, got := 1, len(done); want != got {
+		t.Fatalf("want %d, got %d", want, got)
+	}
+	if want, got := "\tat com.example.App.main(App.java:5)", done[0].JSONValues["msg"]; want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func TestAggregatorExpire(t *testing.T) {
+	a := captainslog.NewAggregator(
+		captainslog.AggregatorOptionIndentation,
+		captainslog.AggregatorOptionTimeout(time.Second),
+	)
+
+	msgs := parseLines(t, []string{
+		"<11>2016-03-08T14:59:36.293816+00:00 host.example.com java[12]: first\n",
+		"<11>2016-03-08T14:59:36.293816+00:00 host.example.com java[12]:   continued\n",
+	})
+
+	for _, msg := range msgs {
+		a.Add(msg)
+	}
+
+	if want, got := 0, len(a.Expire(time.Now())); want != got {
+		t.Errorf("want %d, got %d", want, got)
+	}
+
+	done := a.Expire(time.Now().Add(2 * time.Second))
+	if want, got := 1, len(done); want != got {
+		t.Fatalf("want %d, got %d", want, got)
+	}
+
+	if want, got := " first\n  continued", done[0].Content; want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+
+	if want, got := 0, len(a.Flush()); want != got {
+		t.Errorf("want %d, got %d", want, got)
+	}
+}
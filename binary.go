@@ -0,0 +1,415 @@
+package captainslog
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// binaryVersion is the version of the binary encoding, written as
+	// its first byte.
+	binaryVersion = 1
+
+	// binaryMaxDepth limits the nesting of decoded JSONValues, so a
+	// corrupt message can't exhaust the stack.
+	binaryMaxDepth = 1000
+)
+
+// the bits of the flags of the binary encoding
+const (
+	binaryTagHasColon = 1 << iota
+	binaryTagStartsWithBracket
+	binaryIsJSON
+	binaryIsCee
+	binaryIsLogfmt
+	binaryDontParseJSON
+	binaryUseLocalFormat
+	binaryLogfmtContent
+	binaryUseGJSON
+	binaryPendingJSON
+)
+
+// the types of the values of the binary encoding
+const (
+	binaryNil = iota
+	binaryFalse
+	binaryTrue
+	binaryString
+	binaryFloat64
+	binaryNumber
+	binaryInt
+	binaryInt64
+	binaryUint64
+	binaryArray
+	binaryObject
+	binaryNoObject
+	binaryStrings
+)
+
+// binaryDecoders are the ContentDecoders that can be encoded, by their
+// position.
+var binaryDecoders = []ContentDecoder{
+	nil,
+	JSONContentDecoder,
+	GJSONContentDecoder,
+	LogfmtContentDecoder,
+	CEFContentDecoder,
+	LEEFContentDecoder,
+	cefDecoder{headerInTag: true},
+	leefDecoder{headerInTag: true},
+}
+
+var (
+	//ErrBadBinaryMsg is returned when a binary encoded SyslogMsg is not valid.
+	ErrBadBinaryMsg = errors.New("Binary message not valid")
+
+	//ErrBadBinaryValue is returned when a JSONValues value has a type the binary encoding doesn't support.
+	ErrBadBinaryValue = errors.New("Binary value type not valid")
+)
+
+// MarshalBinary encodes the SyslogMsg in a compact, versioned binary
+// format that UnmarshalBinary decodes back to an identical SyslogMsg,
+// including the time format, the content flags and the types of the
+// JSONValues, which RFC3164 and JSON lose. JSONValues can hold nil,
+// bool, string, float64, json.Number, int, int64, uint64, []string,
+// []interface{} and map[string]interface{} values. A message decoded
+// by a ContentDecoder other than the ones of this package is encoded
+// without its decoder.
+func (s *SyslogMsg) MarshalBinary() ([]byte, error) {
+	var flags uint64
+	for _, f := range []struct {
+		bit uint64
+		set bool
+	}{
+		{binaryTagHasColon, s.Tag.HasColon},
+		{binaryTagStartsWithBracket, s.Tag.StartsWithBracket},
+		{binaryIsJSON, s.IsJSON},
+		{binaryIsCee, s.IsCee},
+		{binaryIsLogfmt, s.IsLogfmt},
+		{binaryDontParseJSON, s.optionDontParseJSON},
+		{binaryUseLocalFormat, s.optionUseLocalFormat},
+		{binaryLogfmtContent, s.optionLogfmtContent},
+		{binaryUseGJSON, s.optionUseGJSON},
+		{binaryPendingJSON, s.pendingJSON},
+	} {
+		if f.set {
+			flags |= f.bit
+		}
+	}
+
+	t, err := s.Time.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	b := []byte{binaryVersion}
+	b = binary.AppendVarint(b, int64(s.Pri.Priority))
+	b = binary.AppendVarint(b, int64(s.Pri.Facility))
+	b = binary.AppendVarint(b, int64(s.Pri.Severity))
+	b = appendBinaryBytes(b, t)
+	b = appendBinaryBytes(b, []byte(s.Time.Location().String()))
+	b = appendBinaryBytes(b, []byte(s.Host))
+	b = appendBinaryBytes(b, []byte(s.Tag.Program))
+	b = appendBinaryBytes(b, []byte(s.Tag.Pid))
+	b = binary.AppendUvarint(b, flags)
+	b = appendBinaryBytes(b, []byte(s.Cee))
+	b = binary.AppendUvarint(b, uint64(binaryDecoderID(s.decoder)))
+	b = appendBinaryBytes(b, []byte(s.Content))
+	b = appendBinaryBytes(b, []byte(s.timeFormat))
+
+	if s.JSONValues == nil {
+		return append(b, binaryNoObject), nil
+	}
+	return appendBinaryValue(b, s.JSONValues)
+}
+
+// UnmarshalBinary decodes a SyslogMsg encoded by MarshalBinary.
+func (s *SyslogMsg) UnmarshalBinary(b []byte) error {
+	d := binaryDecoder{b: b}
+	if d.byte() != binaryVersion {
+		return ErrBadBinaryMsg
+	}
+
+	msg := SyslogMsg{mutex: &sync.Mutex{}}
+	msg.Pri.Priority = int(d.varint())
+	msg.Pri.Facility = Facility(d.varint())
+	msg.Pri.Severity = Severity(d.varint())
+	if t := d.bytes(); d.err == nil {
+		if err := msg.Time.UnmarshalBinary(t); err != nil {
+			return ErrBadBinaryMsg
+		}
+	}
+	msg.Time = binaryLocation(msg.Time, d.string())
+	msg.Host = d.string()
+	msg.Tag.Program = d.string()
+	msg.Tag.Pid = d.string()
+
+	flags := d.uvarint()
+	msg.Tag.HasColon = flags&binaryTagHasColon != 0
+	msg.Tag.StartsWithBracket = flags&binaryTagStartsWithBracket != 0
+	msg.IsJSON = flags&binaryIsJSON != 0
+	msg.IsCee = flags&binaryIsCee != 0
+	msg.IsLogfmt = flags&binaryIsLogfmt != 0
+	msg.optionDontParseJSON = flags&binaryDontParseJSON != 0
+	msg.optionUseLocalFormat = flags&binaryUseLocalFormat != 0
+	msg.optionLogfmtContent = flags&binaryLogfmtContent != 0
+	msg.optionUseGJSON = flags&binaryUseGJSON != 0
+	msg.pendingJSON = flags&binaryPendingJSON != 0
+
+	msg.Cee = d.string()
+	if id := d.uvarint(); id < uint64(len(binaryDecoders)) {
+		msg.decoder = binaryDecoders[id]
+	} else {
+		d.fail()
+	}
+	msg.Content = d.string()
+	msg.timeFormat = d.string()
+
+	values := d.value(0)
+	if d.err == nil && values != nil {
+		m, ok := values.(map[string]interface{})
+		if !ok {
+			d.fail()
+		}
+		msg.JSONValues = m
+	}
+	if d.err != nil || len(d.b) != 0 {
+		return ErrBadBinaryMsg
+	}
+
+	*s = msg
+	return nil
+}
+
+// NewSyslogMsgFromBinary returns the SyslogMsg encoded by
+// SyslogMsg.MarshalBinary in b.
+func NewSyslogMsgFromBinary(b []byte) (SyslogMsg, error) {
+	var msg SyslogMsg
+	err := msg.UnmarshalBinary(b)
+	return msg, err
+}
+
+// binaryLocation returns t in the location named name, which
+// time.Time.MarshalBinary leaves out, keeping only the offset. A
+// location that can't be loaded with the same offset becomes a fixed
+// zone with that name.
+func binaryLocation(t time.Time, name string) time.Time {
+	switch name {
+	case "", "UTC", "Local":
+		return t
+	}
+	_, offset := t.Zone()
+	if loc, err := time.LoadLocation(name); err == nil {
+		if _, o := t.In(loc).Zone(); o == offset {
+			return t.In(loc)
+		}
+	}
+	return t.In(time.FixedZone(name, offset))
+}
+
+func binaryDecoderID(decoder ContentDecoder) int {
+	for id, d := range binaryDecoders {
+		if id > 0 && d == decoder {
+			return id
+		}
+	}
+	return 0
+}
+
+func appendBinaryBytes(b, v []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+// appendBinaryValue appends a JSONValues value with its type. The keys
+// of objects are sorted, so equal messages are encoded the same.
+func appendBinaryValue(b []byte, v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case nil:
+		return append(b, binaryNil), nil
+	case bool:
+		if v {
+			return append(b, binaryTrue), nil
+		}
+		return append(b, binaryFalse), nil
+	case string:
+		return appendBinaryBytes(append(b, binaryString), []byte(v)), nil
+	case float64:
+		return binary.BigEndian.AppendUint64(append(b, binaryFloat64), math.Float64bits(v)), nil
+	case json.Number:
+		return appendBinaryBytes(append(b, binaryNumber), []byte(v)), nil
+	case int:
+		return binary.AppendVarint(append(b, binaryInt), int64(v)), nil
+	case int64:
+		return binary.AppendVarint(append(b, binaryInt64), v), nil
+	case uint64:
+		return binary.AppendUvarint(append(b, binaryUint64), v), nil
+	case []string:
+		b = binary.AppendUvarint(append(b, binaryStrings), uint64(len(v)))
+		for _, e := range v {
+			b = appendBinaryBytes(b, []byte(e))
+		}
+		return b, nil
+	case []interface{}:
+		b = binary.AppendUvarint(append(b, binaryArray), uint64(len(v)))
+		for _, e := range v {
+			var err error
+			if b, err = appendBinaryValue(b, e); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		b = binary.AppendUvarint(append(b, binaryObject), uint64(len(v)))
+		for _, key := range keys {
+			b = appendBinaryBytes(b, []byte(key))
+			var err error
+			if b, err = appendBinaryValue(b, v[key]); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	}
+	return nil, ErrBadBinaryValue
+}
+
+// binaryDecoder decodes the binary encoding. Once it fails, it returns
+// zero values.
+type binaryDecoder struct {
+	b   []byte
+	err error
+}
+
+func (d *binaryDecoder) fail() {
+	d.err = ErrBadBinaryMsg
+	d.b = nil
+}
+
+func (d *binaryDecoder) byte() byte {
+	if len(d.b) == 0 {
+		d.fail()
+		return 0
+	}
+	c := d.b[0]
+	d.b = d.b[1:]
+	return c
+}
+
+func (d *binaryDecoder) uvarint() uint64 {
+	v, n := binary.Uvarint(d.b)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *binaryDecoder) varint() int64 {
+	v, n := binary.Varint(d.b)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *binaryDecoder) bytes() []byte {
+	n := d.uvarint()
+	if n > uint64(len(d.b)) {
+		d.fail()
+		return nil
+	}
+	v := d.b[:n]
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *binaryDecoder) string() string {
+	return string(d.bytes())
+}
+
+// value decodes a JSONValues value. A message without JSONValues is
+// decoded as nil.
+func (d *binaryDecoder) value(depth int) interface{} {
+	if depth > binaryMaxDepth {
+		d.fail()
+		return nil
+	}
+
+	switch d.byte() {
+	case binaryNil, binaryNoObject:
+		return nil
+	case binaryFalse:
+		return false
+	case binaryTrue:
+		return true
+	case binaryString:
+		return d.string()
+	case binaryFloat64:
+		if len(d.b) < 8 {
+			d.fail()
+			return nil
+		}
+		v := math.Float64frombits(binary.BigEndian.Uint64(d.b))
+		d.b = d.b[8:]
+		return v
+	case binaryNumber:
+		return json.Number(d.string())
+	case binaryInt:
+		return int(d.varint())
+	case binaryInt64:
+		return d.varint()
+	case binaryUint64:
+		return d.uvarint()
+	case binaryStrings:
+		// every value takes at least a byte
+		n := d.uvarint()
+		if n > uint64(len(d.b)) {
+			d.fail()
+			return nil
+		}
+		v := make([]string, 0, n)
+		for i := uint64(0); i < n && d.err == nil; i++ {
+			v = append(v, d.string())
+		}
+		return v
+	case binaryArray:
+		n := d.uvarint()
+		if n > uint64(len(d.b)) {
+			d.fail()
+			return nil
+		}
+		v := make([]interface{}, 0, n)
+		for i := uint64(0); i < n && d.err == nil; i++ {
+			v = append(v, d.value(depth+1))
+		}
+		return v
+	case binaryObject:
+		n := d.uvarint()
+		if n > uint64(len(d.b)) {
+			d.fail()
+			return nil
+		}
+		v := make(map[string]interface{}, n)
+		for i := uint64(0); i < n && d.err == nil; i++ {
+			key := d.string()
+			v[key] = d.value(depth + 1)
+		}
+		return v
+	}
+	d.fail()
+	return nil
+}
@@ -0,0 +1,212 @@
+package captainslog_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/captainslog"
+)
+
+func TestSyslogMsgBinaryRoundTrip(t *testing.T) {
+	testCases := []struct {
+		name    string
+		input   string
+		options []func(*captainslog.Parser)
+	}{
+		{
+			name:  "plain",
+			input: "<4>2016-03-08T14:59:36.293816+00:00 host.example.com kernel[12]: test\n",
+		},
+		{
+			name:  "rfc3164 time",
+			input: "<38>Mar  8 14:59:36 host.example.com sshd: test\n",
+		},
+		{
+			name:  "cee",
+			input: "<191>2006-01-02T15:04:05.999999-07:00 host.example.org test: @cee:{\"a\":\"b\",\"n\":1,\"l\":[1,true,null],\"o\":{\"k\":\"v\"}}\n",
+		},
+		{
+			name:  "json",
+			input: "<191>2006-01-02T15:04:05.999999-07:00 host.example.org test: {\"a\":\"b\"}\n",
+		},
+		{
+			name:    "gjson",
+			input:   "<191>2006-01-02T15:04:05.999999-07:00 host.example.org test: @cee:{\"a\":\"b\",\"n\":1.5}\n",
+			options: []func(*captainslog.Parser){captainslog.OptionUseGJSONParser},
+		},
+		{
+			name:    "lazy json",
+			input:   "<191>2006-01-02T15:04:05.999999-07:00 host.example.org test: @cee:{\"a\":\"b\"}\n",
+			options: []func(*captainslog.Parser){captainslog.OptionLazyParseJSON},
+		},
+		{
+			name:    "dont parse json",
+			input:   "<191>2006-01-02T15:04:05.999999-07:00 host.example.org test: @cee:{\"a\":\"b\"}\n",
+			options: []func(*captainslog.Parser){captainslog.OptionDontParseJSON},
+		},
+		{
+			name:    "logfmt",
+			input:   "<191>2006-01-02T15:04:05.999999-07:00 host.example.org test: level=info user=bob\n",
+			options: []func(*captainslog.Parser){captainslog.OptionParseLogfmt},
+		},
+		{
+			name:    "cef",
+			input:   "<191>2006-01-02T15:04:05.999999-07:00 host.example.org CEF:0|Vendor|Product|1.0|100|Blocked|5|src=10.0.0.1\n",
+			options: []func(*captainslog.Parser){captainslog.OptionParseCEF},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			msg, err := captainslog.NewParser(tc.options...).ParseBytes([]byte(tc.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			b, err := msg.MarshalBinary()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := captainslog.NewSyslogMsgFromBinary(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// every field is encoded, so equal messages encode the same
+			again, err := got.MarshalBinary()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(b, again) {
+				t.Errorf("want %x, got %x", b, again)
+			}
+			if !reflect.DeepEqual(msg.JSONValues, got.JSONValues) {
+				t.Errorf("want %#v, got %#v", msg.JSONValues, got.JSONValues)
+			}
+			if want, got := msg.String(), got.String(); want != got {
+				t.Errorf("want %q, got %q", want, got)
+			}
+			if want, got := msg.Bytes(captainslog.OptionUseLocalFormat), got.Bytes(captainslog.OptionUseLocalFormat); !bytes.Equal(want, got) {
+				t.Errorf("want %q, got %q", want, got)
+			}
+		})
+	}
+}
+
+func TestSyslogMsgBinaryValues(t *testing.T) {
+	msg := captainslog.NewSyslogMsg()
+	msg.SetTime(time.Date(2006, 1, 2, 15, 4, 5, 999999000, time.FixedZone("MST", -7*60*60)))
+	msg.SetHost("host.example.org")
+	msg.SetProgram("test")
+	msg.SetPid("42")
+	msg.SetFacility(captainslog.Local7)
+	msg.SetSeverity(captainslog.Debug)
+	msg.IsCee = true
+	msg.JSONValues = map[string]interface{}{
+		"nil":     nil,
+		"false":   false,
+		"string":  "s",
+		"float":   1.5,
+		"number":  json.Number("12345678901234567890"),
+		"int":     -1,
+		"int64":   int64(-1 << 62),
+		"uint64":  uint64(1 << 63),
+		"strings": []string{"a", "b"},
+		"array":   []interface{}{true, "x", []interface{}{}},
+		"object":  map[string]interface{}{"k": map[string]interface{}{}},
+	}
+
+	b, err := msg.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got captainslog.SyslogMsg
+	if err := got.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(msg.JSONValues, got.JSONValues) {
+		t.Errorf("want %#v, got %#v", msg.JSONValues, got.JSONValues)
+	}
+	if want, got := msg.Time, got.Time; !want.Equal(got) || want.Location().String() != got.Location().String() {
+		t.Errorf("want %v, got %v", want, got)
+	}
+	if want, got := msg.Pri, got.Pri; want != got {
+		t.Errorf("want %v, got %v", want, got)
+	}
+	if want, got := msg.Tag, got.Tag; want != got {
+		t.Errorf("want %v, got %v", want, got)
+	}
+	if want, got := true, got.IsCee; want != got {
+		t.Errorf("want %v, got %v", want, got)
+	}
+
+	// a message without JSONValues stays without them
+	msg.JSONValues = nil
+	if b, err = msg.MarshalBinary(); err != nil {
+		t.Fatal(err)
+	}
+	if err := got.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+	if got.JSONValues != nil {
+		t.Errorf("want nil JSONValues, got %#v", got.JSONValues)
+	}
+}
+
+func TestSyslogMsgBinaryErrors(t *testing.T) {
+	msg := captainslog.NewSyslogMsg()
+	msg.JSONValues["bad"] = struct{}{}
+	if _, err := msg.MarshalBinary(); err != captainslog.ErrBadBinaryValue {
+		t.Errorf("want %v, got %v", captainslog.ErrBadBinaryValue, err)
+	}
+
+	msg, err := captainslog.NewSyslogMsgFromBytes([]byte("<191>2006-01-02T15:04:05.999999-07:00 host.example.org test: @cee:{\"a\":[\"b\"]}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := msg.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// every truncation is detected, and so is trailing data
+	for n := 0; n < len(b); n++ {
+		if _, err := captainslog.NewSyslogMsgFromBinary(b[:n]); err != captainslog.ErrBadBinaryMsg {
+			t.Errorf("length %d: want %v, got %v", n, captainslog.ErrBadBinaryMsg, err)
+		}
+	}
+	if _, err := captainslog.NewSyslogMsgFromBinary(append(b, 0)); err != captainslog.ErrBadBinaryMsg {
+		t.Errorf("want %v, got %v", captainslog.ErrBadBinaryMsg, err)
+	}
+
+	version := append([]byte{99}, b[1:]...)
+	if _, err := captainslog.NewSyslogMsgFromBinary(version); err != captainslog.ErrBadBinaryMsg {
+		t.Errorf("want %v, got %v", captainslog.ErrBadBinaryMsg, err)
+	}
+
+	// deeply nested values are refused instead of exhausting the stack
+	deep := captainslog.NewSyslogMsg()
+	var v interface{} = "x"
+	for i := 0; i < 2000; i++ {
+		v = []interface{}{v}
+	}
+	deep.JSONValues["deep"] = v
+	if b, err = deep.MarshalBinary(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := captainslog.NewSyslogMsgFromBinary(b); err != captainslog.ErrBadBinaryMsg {
+		t.Errorf("want %v, got %v", captainslog.ErrBadBinaryMsg, err)
+	}
+
+	// a corrupt length can't allocate more than the message holds
+	huge := append([]byte(nil), b[:strings.Index(string(b), "deep")+4]...)
+	huge = append(huge, 9, 0xff, 0xff, 0xff, 0xff, 0x0f)
+	if _, err := captainslog.NewSyslogMsgFromBinary(huge); err != captainslog.ErrBadBinaryMsg {
+		t.Errorf("want %v, got %v", captainslog.ErrBadBinaryMsg, err)
+	}
+}
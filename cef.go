@@ -0,0 +1,217 @@
+package captainslog
+
+import (
+	"errors"
+	"sort"
+	"strings"
+)
+
+const (
+	cefPrefix  = "CEF:"
+	cefProgram = "CEF"
+	cefKey     = "cef"
+)
+
+var (
+	//ErrBadCEF is returned when CEF content is malformed.
+	ErrBadCEF = errors.New("CEF content not valid")
+
+	// cefHeaderFields names the header fields of a CEF event.
+	cefHeaderFields = []string{
+		"version",
+		"device_vendor",
+		"device_product",
+		"device_version",
+		"device_event_class_id",
+		"name",
+		"severity",
+	}
+)
+
+// DecodeCEF decodes an ArcSight CEF event, such as
+// `CEF:0|Vendor|Product|1.0|100|Blocked|5|src=10.0.0.1 msg=Port scan`,
+// into a map. The header fields are added to a map under the "cef" key,
+// named version, device_vendor, device_product, device_version,
+// device_event_class_id, name and severity. The extension key=value pairs
+// are added at the top level. Escaped pipes and backslashes in the header,
+// and escaped equals signs, backslashes and newlines in the extension
+// values are unescaped. All values are decoded as strings.
+func DecodeCEF(b []byte) (map[string]interface{}, error) {
+	s := string(b)
+	if !strings.HasPrefix(s, cefPrefix) {
+		return nil, ErrBadCEF
+	}
+	s = s[len(cefPrefix):]
+
+	header := make(map[string]interface{})
+	for _, name := range cefHeaderFields {
+		var field strings.Builder
+		i := 0
+		for ; i < len(s) && s[i] != '|'; i++ {
+			if s[i] == '\\' && i+1 < len(s) && (s[i+1] == '|' || s[i+1] == '\\') {
+				i++
+			}
+			field.WriteByte(s[i])
+		}
+		if i >= len(s) {
+			return nil, ErrBadCEF
+		}
+		header[name] = field.String()
+		s = s[i+1:]
+	}
+
+	m := decodeCEFExtension(strings.TrimRight(s, " \t\r\n"))
+	m[cefKey] = header
+	return m, nil
+}
+
+// decodeCEFExtension decodes space separated key=value pairs, where
+// values may contain unescaped spaces and run until the next key.
+func decodeCEFExtension(s string) map[string]interface{} {
+	m := make(map[string]interface{})
+
+	key, rest, ok := cefNextKey(s)
+	for ok {
+		var value strings.Builder
+		i := 0
+		for ; i < len(rest); i++ {
+			c := rest[i]
+			if c == ' ' {
+				if _, _, ok := cefNextKey(strings.TrimLeft(rest[i:], " ")); ok {
+					break
+				}
+			}
+			if c == '\\' && i+1 < len(rest) {
+				switch rest[i+1] {
+				case '\\', '=':
+					c = rest[i+1]
+					i++
+				case 'n':
+					c = '\n'
+					i++
+				case 'r':
+					c = '\r'
+					i++
+				}
+			}
+			value.WriteByte(c)
+		}
+		m[key] = value.String()
+
+		key, rest, ok = cefNextKey(strings.TrimLeft(rest[i:], " "))
+	}
+	return m
+}
+
+// cefNextKey returns the extension key at the start of s, and the rest
+// of s after the "=" that follows it.
+func cefNextKey(s string) (string, string, bool) {
+	i := 0
+	for i < len(s) && isCEFKeyByte(s[i]) {
+		i++
+	}
+	if i == 0 || i >= len(s) || s[i] != '=' {
+		return "", "", false
+	}
+	return s[:i], s[i+1:], true
+}
+
+func isCEFKeyByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		c == '_' || c == '.' || c == '-' || c == '[' || c == ']'
+}
+
+// EncodeCEF encodes a map decoded by DecodeCEF back to a CEF event. The
+// header is read from the map under the "cef" key, and ErrBadCEF is
+// returned if it is missing. All other keys are encoded as extension
+// key=value pairs, sorted by key, with characters that are not allowed
+// in keys replaced with "_".
+func EncodeCEF(m map[string]interface{}) ([]byte, error) {
+	header, ok := m[cefKey].(map[string]interface{})
+	if !ok {
+		return nil, ErrBadCEF
+	}
+
+	var b strings.Builder
+	b.WriteString(cefPrefix)
+	for _, name := range cefHeaderFields {
+		b.WriteString(cefHeaderEscaper.Replace(logfmtString(header[name])))
+		b.WriteByte('|')
+	}
+
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		if key != cefKey {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for i, key := range keys {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(cefExtensionKey(key))
+		b.WriteByte('=')
+		b.WriteString(cefValueEscaper.Replace(logfmtString(m[key])))
+	}
+	return []byte(b.String()), nil
+}
+
+var (
+	cefHeaderEscaper = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\n", " ", "\r", " ")
+	cefValueEscaper  = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\n", `\n`, "\r", `\r`)
+)
+
+func cefExtensionKey(key string) string {
+	if key == "" {
+		return "_"
+	}
+	return strings.Map(func(r rune) rune {
+		if r < 0x80 && isCEFKeyByte(byte(r)) {
+			return r
+		}
+		return '_'
+	}, key)
+}
+
+// cefDecoder is the built-in CEF ContentDecoder. If headerInTag is set,
+// the content follows a "CEF:" tag, as sent by devices that leave out
+// the syslog tag, and it is encoded without the "CEF:" prefix.
+type cefDecoder struct {
+	headerInTag bool
+}
+
+// DecodeContent decodes CEF content. Content starting with "CEF:",
+// or following a "CEF:" tag, that is not CEF is left as plain text.
+func (d cefDecoder) DecodeContent(b []byte) (map[string]interface{}, error) {
+	if d.headerInTag {
+		b = append([]byte(cefPrefix), b...)
+	}
+
+	m, err := DecodeCEF(b)
+	if err != nil {
+		return nil, nil
+	}
+	return m, nil
+}
+
+// EncodeContent encodes CEF content.
+func (d cefDecoder) EncodeContent(m map[string]interface{}) ([]byte, error) {
+	b, err := EncodeCEF(m)
+	if err != nil || !d.headerInTag {
+		return b, err
+	}
+	return b[len(cefPrefix):], nil
+}
+
+// contentConditionCEF applies a decoder to CEF content.
+func contentConditionCEF(msg *SyslogMsg, content []byte) bool {
+	return strings.HasPrefix(string(content), cefPrefix)
+}
+
+// contentConditionCEFTag applies a decoder to CEF content that was
+// parsed as a "CEF:" tag.
+func contentConditionCEFTag(msg *SyslogMsg, content []byte) bool {
+	return msg.Tag.Program == cefProgram && msg.Tag.Pid == ""
+}
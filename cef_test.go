@@ -0,0 +1,186 @@
+package captainslog_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/digitalocean/captainslog"
+)
+
+func TestDecodeCEF(t *testing.T) {
+	testCases := []struct {
+		name    string
+		input   string
+		want    map[string]interface{}
+		wantErr bool
+	}{
+		{
+			name:  "simple",
+			input: "CEF:0|Security|Firewall|1.0|100|Port scan|5|src=10.0.0.1 dst=10.0.0.2 msg=Scan from outside",
+			want: map[string]interface{}{
+				"cef": map[string]interface{}{
+					"version":               "0",
+					"device_vendor":         "Security",
+					"device_product":        "Firewall",
+					"device_version":        "1.0",
+					"device_event_class_id": "100",
+					"name":                  "Port scan",
+					"severity":              "5",
+				},
+				"src": "10.0.0.1",
+				"dst": "10.0.0.2",
+				"msg": "Scan from outside",
+			},
+		},
+		{
+			name:  "escaped",
+			input: `CEF:0|Sec\|urity|C:\\Program|1.0|100|Blocked|High|request=http://a/?b\=c msg=line\nnext path=C:\\tmp x=a|b`,
+			want: map[string]interface{}{
+				"cef": map[string]interface{}{
+					"version":               "0",
+					"device_vendor":         "Sec|urity",
+					"device_product":        `C:\Program`,
+					"device_version":        "1.0",
+					"device_event_class_id": "100",
+					"name":                  "Blocked",
+					"severity":              "High",
+				},
+				"request": "http://a/?b=c",
+				"msg":     "line\nnext",
+				"path":    `C:\tmp`,
+				"x":       "a|b",
+			},
+		},
+		{
+			name:  "no extension",
+			input: "CEF:0|Security|Firewall|1.0|100|Port scan|5|",
+			want: map[string]interface{}{
+				"cef": map[string]interface{}{
+					"version":               "0",
+					"device_vendor":         "Security",
+					"device_product":        "Firewall",
+					"device_version":        "1.0",
+					"device_event_class_id": "100",
+					"name":                  "Port scan",
+					"severity":              "5",
+				},
+			},
+		},
+		{
+			name:    "short header",
+			input:   "CEF:0|Security|Firewall|1.0",
+			wantErr: true,
+		},
+		{
+			name:    "not CEF",
+			input:   "hello",
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := captainslog.DecodeCEF([]byte(tc.input))
+			if tc.wantErr {
+				if want, got := captainslog.ErrBadCEF, err; want != got {
+					t.Errorf("want %v, got %v", want, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(tc.want, got) {
+				t.Errorf("want %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestEncodeCEF(t *testing.T) {
+	input := `CEF:0|Sec\|urity|Firewall|1.0|100|Blocked|5|dst=10.0.0.2 msg=a\=b\nc src=10.0.0.1`
+	m, err := captainslog.DecodeCEF([]byte(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := captainslog.EncodeCEF(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := input, string(b); want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+
+	if _, err := captainslog.EncodeCEF(map[string]interface{}{"src": "10.0.0.1"}); err != captainslog.ErrBadCEF {
+		t.Errorf("want %v, got %v", captainslog.ErrBadCEF, err)
+	}
+}
+
+func TestParserOptionParseCEF(t *testing.T) {
+	testCases := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name:  "tagged",
+			input: "<134>2016-03-08T14:59:36.293816+00:00 fw.example.com fw[12]: CEF:0|Security|Firewall|1.0|100|Blocked|5|src=10.0.0.1\n",
+			want:  "<134>2016-03-08T14:59:36.293816+00:00 fw.example.com fw[12]: CEF:0|Security|Firewall|1.0|100|Blocked|5|act=drop src=10.0.0.1\n",
+		},
+		{
+			name:  "untagged",
+			input: "<134>2016-03-08T14:59:36.293816+00:00 fw.example.com CEF:0|Security|Firewall|1.0|100|Blocked|5|src=10.0.0.1 dpt=22\n",
+			want:  "<134>2016-03-08T14:59:36.293816+00:00 fw.example.com CEF:0|Security|Firewall|1.0|100|Blocked|5|act=drop dpt=22 src=10.0.0.1\n",
+		},
+	}
+
+	p := captainslog.NewParser(captainslog.OptionParseCEF)
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			msg, err := p.ParseBytes([]byte(tc.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if want, got := tc.input, msg.String(); want != got {
+				t.Errorf("want %q, got %q", want, got)
+			}
+
+			name, ok := msg.JSONValue("cef.name")
+			if !ok {
+				t.Fatal("could not find cef.name")
+			}
+
+			if want, got := "Blocked", name; want != got {
+				t.Errorf("want %q, got %q", want, got)
+			}
+
+			msg.AddTag("act", "drop")
+			if want, got := tc.want, msg.String(); want != got {
+				t.Errorf("want %q, got %q", want, got)
+			}
+		})
+	}
+}
+
+func TestParserOptionParseCEFBad(t *testing.T) {
+	testCases := []string{
+		"<134>2016-03-08T14:59:36.293816+00:00 fw.example.com fw: CEF:0|Security\n",
+		"<134>2016-03-08T14:59:36.293816+00:00 fw.example.com fw: CEF: forwarding disabled\n",
+		"<134>2016-03-08T14:59:36.293816+00:00 fw.example.com CEF: not an event\n",
+	}
+
+	p := captainslog.NewParser(captainslog.OptionParseCEF)
+	for _, input := range testCases {
+		msg, err := p.ParseBytes([]byte(input))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want, got := input, msg.String(); want != got {
+			t.Errorf("want %q, got %q", want, got)
+		}
+	}
+}
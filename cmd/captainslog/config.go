@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+
+	"github.com/digitalocean/captainslog"
+)
+
+// relayConfig is the configuration of the relay, read from a YAML or
+// TOML file.
+type relayConfig struct {
+	Inputs    []inputConfig    `yaml:"inputs" toml:"inputs"`
+	Filter    string           `yaml:"filter" toml:"filter"`
+	Mutations []mutationConfig `yaml:"mutations" toml:"mutations"`
+	Outputs   []outputConfig   `yaml:"outputs" toml:"outputs"`
+}
+
+// inputConfig is a socket the relay listens on, or with the "file"
+// network, files the relay follows.
+type inputConfig struct {
+	Network         string   `yaml:"network" toml:"network"`
+	Address         string   `yaml:"address" toml:"address"`
+	Paths           []string `yaml:"paths" toml:"paths"`
+	Checkpoint      string   `yaml:"checkpoint" toml:"checkpoint"`
+	StartAtEnd      bool     `yaml:"start_at_end" toml:"start_at_end"`
+	NoHostname      bool     `yaml:"no_hostname" toml:"no_hostname"`
+	Location        string   `yaml:"location" toml:"location"`
+	SanitizeProgram bool     `yaml:"sanitize_program" toml:"sanitize_program"`
+	GJSON           bool     `yaml:"gjson" toml:"gjson"`
+	DontParseJSON   bool     `yaml:"dont_parse_json" toml:"dont_parse_json"`
+	ParseLogfmt     bool     `yaml:"parse_logfmt" toml:"parse_logfmt"`
+	ParseCEF        bool     `yaml:"parse_cef" toml:"parse_cef"`
+	ParseLEEF       bool     `yaml:"parse_leef" toml:"parse_leef"`
+	MaxMessageSize  int      `yaml:"max_message_size" toml:"max_message_size"`
+}
+
+// mutationConfig is a stage of the relay's pipeline. It has exactly one
+// action, which is only applied to messages matching the When filter
+// expression if it is set.
+type mutationConfig struct {
+	When           string                `yaml:"when" toml:"when"`
+	SetKey         *keyValueConfig       `yaml:"set_key" toml:"set_key"`
+	DeleteKey      string                `yaml:"delete_key" toml:"delete_key"`
+	RenameKey      *renameConfig         `yaml:"rename_key" toml:"rename_key"`
+	ReplaceValue   *replaceConfig        `yaml:"replace_value" toml:"replace_value"`
+	ReplaceContent *replaceConfig        `yaml:"replace_content" toml:"replace_content"`
+	SetFacility    *captainslog.Facility `yaml:"set_facility" toml:"set_facility"`
+	SetSeverity    *captainslog.Severity `yaml:"set_severity" toml:"set_severity"`
+	SetProgram     string                `yaml:"set_program" toml:"set_program"`
+	AddTags        *tagsConfig           `yaml:"add_tags" toml:"add_tags"`
+	Drop           bool                  `yaml:"drop" toml:"drop"`
+}
+
+type keyValueConfig struct {
+	Key   string      `yaml:"key" toml:"key"`
+	Value interface{} `yaml:"value" toml:"value"`
+}
+
+type renameConfig struct {
+	From string `yaml:"from" toml:"from"`
+	To   string `yaml:"to" toml:"to"`
+}
+
+type replaceConfig struct {
+	Key         string `yaml:"key" toml:"key"`
+	Pattern     string `yaml:"pattern" toml:"pattern"`
+	Replacement string `yaml:"replacement" toml:"replacement"`
+}
+
+type tagsConfig struct {
+	Key    string        `yaml:"key" toml:"key"`
+	Values []interface{} `yaml:"values" toml:"values"`
+}
+
+// outputConfig is a destination the relay forwards messages to. The
+// path of a file output is a FileSink path template, and the settings
+// after it are only used by file outputs.
+type outputConfig struct {
+	Name             string        `yaml:"name" toml:"name"`
+	Type             string        `yaml:"type" toml:"type"`
+	Address          string        `yaml:"address" toml:"address"`
+	Format           string        `yaml:"format" toml:"format"`
+	OctetCounting    bool          `yaml:"octet_counting" toml:"octet_counting"`
+	Filter           string        `yaml:"filter" toml:"filter"`
+	QueueSize        int           `yaml:"queue_size" toml:"queue_size"`
+	BackPressure     string        `yaml:"back_pressure" toml:"back_pressure"`
+	Buffer           string        `yaml:"buffer" toml:"buffer"`
+	BufferMaxSize    int64         `yaml:"buffer_max_size" toml:"buffer_max_size"`
+	Path             string        `yaml:"path" toml:"path"`
+	MaxSize          int64         `yaml:"max_size" toml:"max_size"`
+	RotateEvery      time.Duration `yaml:"rotate_every" toml:"rotate_every"`
+	Compress         string        `yaml:"compress" toml:"compress"`
+	MaxBackups       int           `yaml:"max_backups" toml:"max_backups"`
+	MaxAge           time.Duration `yaml:"max_age" toml:"max_age"`
+	SyncEveryMessage bool          `yaml:"sync_every_message" toml:"sync_every_message"`
+	SyncInterval     time.Duration `yaml:"sync_interval" toml:"sync_interval"`
+}
+
+// format returns the format of a file or stdout output.
+func (o *outputConfig) format() string {
+	if o.Format == "" {
+		return "rfc3164"
+	}
+	return o.Format
+}
+
+// loadConfig reads the relay configuration from a file, which is
+// decoded as TOML if its name ends in .toml and as YAML otherwise.
+func loadConfig(path string) (*relayConfig, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg relayConfig
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		md, err := toml.Decode(string(b), &cfg)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return nil, fmt.Errorf("%s: unknown key %s", path, undecoded[0])
+		}
+	} else {
+		decoder := yaml.NewDecoder(strings.NewReader(string(b)))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(&cfg); err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return &cfg, nil
+}
+
+// validate checks the configuration for mistakes that can be found
+// without opening sockets or files.
+func (c *relayConfig) validate() error {
+	if len(c.Inputs) == 0 {
+		return fmt.Errorf("no inputs")
+	}
+	if len(c.Outputs) == 0 {
+		return fmt.Errorf("no outputs")
+	}
+
+	for i, input := range c.Inputs {
+		switch input.Network {
+		case "udp", "udp4", "udp6", "tcp", "tcp4", "tcp6", "unix", "unixgram":
+			if input.Address == "" {
+				return fmt.Errorf("input %d: no address", i)
+			}
+		case "file":
+			if len(input.Paths) == 0 {
+				return fmt.Errorf("input %d: no paths", i)
+			}
+		default:
+			return fmt.Errorf("input %d: unknown network %q", i, input.Network)
+		}
+	}
+
+	for i, m := range c.Mutations {
+		if n := m.actions(); n != 1 {
+			return fmt.Errorf("mutation %d: want one action, got %d", i, n)
+		}
+	}
+
+	names := make(map[string]bool)
+	for i := range c.Outputs {
+		o := &c.Outputs[i]
+		if o.Name == "" {
+			o.Name = fmt.Sprintf("output%d", i)
+		}
+		if names[o.Name] {
+			return fmt.Errorf("output %s: name is used twice", o.Name)
+		}
+		names[o.Name] = true
+
+		switch o.Type {
+		case "udp", "udp4", "udp6", "tcp", "tcp4", "tcp6", "unix", "unixgram":
+			if o.Address == "" {
+				return fmt.Errorf("output %s: no address", o.Name)
+			}
+			switch o.Format {
+			case "", "rfc3164", "local", "rfc5424":
+			default:
+				return fmt.Errorf("output %s: unknown format %q", o.Name, o.Format)
+			}
+		case "file":
+			if o.Path == "" {
+				return fmt.Errorf("output %s: no path", o.Name)
+			}
+			switch o.Compress {
+			case "", "gzip", "zstd":
+			default:
+				return fmt.Errorf("output %s: unknown compress %q", o.Name, o.Compress)
+			}
+			fallthrough
+		case "stdout":
+			if _, ok := formatters[o.Format]; !ok && o.Format != "" {
+				return fmt.Errorf("output %s: unknown format %q", o.Name, o.Format)
+			}
+		default:
+			return fmt.Errorf("output %s: unknown type %q", o.Name, o.Type)
+		}
+
+		if _, ok := backPressurePolicies[o.BackPressure]; !ok {
+			return fmt.Errorf("output %s: unknown back_pressure %q", o.Name, o.BackPressure)
+		}
+	}
+	return nil
+}
+
+// actions returns the number of actions set in the mutation.
+func (m *mutationConfig) actions() int {
+	n := 0
+	for _, set := range []bool{
+		m.SetKey != nil,
+		m.DeleteKey != "",
+		m.RenameKey != nil,
+		m.ReplaceValue != nil,
+		m.ReplaceContent != nil,
+		m.SetFacility != nil,
+		m.SetSeverity != nil,
+		m.SetProgram != "",
+		m.AddTags != nil,
+		m.Drop,
+	} {
+		if set {
+			n++
+		}
+	}
+	return n
+}
+
+var backPressurePolicies = map[string]captainslog.BackPressurePolicy{
+	"":            captainslog.BackPressureBlock,
+	"block":       captainslog.BackPressureBlock,
+	"drop_oldest": captainslog.BackPressureDropOldest,
+	"drop_newest": captainslog.BackPressureDropNewest,
+}
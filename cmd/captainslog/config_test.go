@@ -0,0 +1,200 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/captainslog"
+)
+
+const testYAMLConfig = `
+inputs:
+  - network: udp
+    address: "127.0.0.1:0"
+    no_hostname: true
+filter: 'severity <= info'
+mutations:
+  - set_key: {key: env, value: prod}
+  - when: 'program == "sshd"'
+    set_facility: auth
+  - delete_key: password
+outputs:
+  - name: archive
+    type: file
+    path: "/tmp/archive/{{.Host}}.log"
+    format: json
+    rotate_every: 24h
+    compress: gzip
+  - type: tcp
+    address: "logs.example.com:514"
+    filter: 'severity <= err'
+    back_pressure: drop_oldest
+    queue_size: 100
+`
+
+const testTOMLConfig = `
+# the same configuration as TOML
+filter = 'severity <= info'
+
+[[inputs]]
+network = "udp"
+address = "127.0.0.1:0"
+no_hostname = true
+
+[[mutations]]
+set_key = { key = "env", value = "prod" }
+
+[[mutations]]
+when = 'program == "sshd"'
+set_facility = "auth"
+
+[[mutations]]
+delete_key = "password" # secrets stay here
+
+[[outputs]]
+name = "archive"
+type = "file"
+path = "/tmp/archive/{{.Host}}.log"
+format = "json"
+rotate_every = "24h"
+compress = "gzip"
+
+[[outputs]]
+type = "tcp"
+address = "logs.example.com:514"
+filter = 'severity <= err'
+back_pressure = "drop_oldest"
+queue_size = 100
+`
+
+func writeConfig(t *testing.T, name, config string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	yamlConfig, err := loadConfig(writeConfig(t, "relay.yaml", testYAMLConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tomlConfig, err := loadConfig(writeConfig(t, "relay.toml", testTOMLConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(yamlConfig, tomlConfig) {
+		t.Errorf("want YAML and TOML to match, got %+v and %+v", yamlConfig, tomlConfig)
+	}
+
+	if want, got := captainslog.Auth, *yamlConfig.Mutations[1].SetFacility; want != got {
+		t.Errorf("want %v, got %v", want, got)
+	}
+	if want, got := "output1", yamlConfig.Outputs[1].Name; want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+	if want, got := 24*time.Hour, tomlConfig.Outputs[0].RotateEvery; want != got {
+		t.Errorf("want %v, got %v", want, got)
+	}
+	if want, got := 100, tomlConfig.Outputs[1].QueueSize; want != got {
+		t.Errorf("want %d, got %d", want, got)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	testCases := []struct {
+		name   string
+		config string
+		want   string
+	}{
+		{
+			name:   "no inputs",
+			config: "outputs: [{type: stdout}]",
+			want:   "no inputs",
+		},
+		{
+			name:   "unknown field",
+			config: "inputs: [{network: udp, address: ':514', colour: red}]\noutputs: [{type: stdout}]",
+			want:   "colour",
+		},
+		{
+			name:   "file input without paths",
+			config: "inputs: [{network: file}]\noutputs: [{type: stdout}]",
+			want:   "input 0: no paths",
+		},
+		{
+			name:   "two actions",
+			config: "inputs: [{network: udp, address: ':514'}]\nmutations: [{delete_key: a, drop: true}]\noutputs: [{type: stdout}]",
+			want:   "want one action, got 2",
+		},
+		{
+			name:   "bad facility",
+			config: "inputs: [{network: udp, address: ':514'}]\nmutations: [{set_facility: loud}]\noutputs: [{type: stdout}]",
+			want:   "loud",
+		},
+		{
+			name:   "unknown output type",
+			config: "inputs: [{network: udp, address: ':514'}]\noutputs: [{type: pigeon}]",
+			want:   `unknown type "pigeon"`,
+		},
+		{
+			name:   "unknown compression",
+			config: "inputs: [{network: udp, address: ':514'}]\noutputs: [{type: file, path: /tmp/out.log, compress: xz}]",
+			want:   `unknown compress "xz"`,
+		},
+		{
+			name:   "unknown back pressure",
+			config: "inputs: [{network: udp, address: ':514'}]\noutputs: [{type: stdout, back_pressure: panic}]",
+			want:   `unknown back_pressure "panic"`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := loadConfig(writeConfig(t, "relay.yaml", tc.config))
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("want error containing %q, got %v", tc.want, err)
+			}
+		})
+	}
+}
+
+func TestLoadConfigTOML(t *testing.T) {
+	cfg, err := loadConfig(writeConfig(t, "relay.toml", `
+[[inputs]]
+network = "file"
+paths = [
+  "/var/log/messages",
+  "/var/log/secure", # trailing comma
+]
+
+[[outputs]]
+type = "stdout"
+queue_size = 1_000
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := []string{"/var/log/messages", "/var/log/secure"}, cfg.Inputs[0].Paths; !reflect.DeepEqual(want, got) {
+		t.Errorf("want %q, got %q", want, got)
+	}
+	if want, got := 1000, cfg.Outputs[0].QueueSize; want != got {
+		t.Errorf("want %d, got %d", want, got)
+	}
+
+	// TOML doesn't allow leading zeros, rather than reading them as octal
+	if _, err := loadConfig(writeConfig(t, "relay.toml", "[[outputs]]\ntype = \"stdout\"\nqueue_size = 010\n")); err == nil {
+		t.Errorf("want error for a leading zero")
+	}
+
+	if _, err := loadConfig(writeConfig(t, "relay.toml", "[[outputs]]\ntype = \"stdout\"\nqueue_sise = 10\n")); err == nil || !strings.Contains(err.Error(), "queue_sise") {
+		t.Errorf("want error naming the unknown key, got %v", err)
+	}
+}
@@ -0,0 +1,68 @@
+// Command captainslog parses, converts, sends and relays syslog messages.
+//
+// Usage:
+//
+//	captainslog parse [flags] [file...]
+//	captainslog send [flags] [message...]
+//	captainslog relay -config file
+//
+// Run "captainslog <command> -h" for the flags of a command.
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// command is a subcommand of captainslog.
+type command struct {
+	name    string
+	summary string
+	run     func(args []string, stdin io.Reader, stdout, stderr io.Writer) int
+}
+
+func commands() []command {
+	return []command{
+		{name: "parse", summary: "parse syslog messages and print them as JSON, RFC3164 or RFC5424", run: parseCommand},
+		{name: "convert", summary: "same as parse", run: parseCommand},
+		{name: "send", summary: "send a syslog message, like logger(1)", run: sendCommand},
+		{name: "relay", summary: "relay syslog messages from sockets to outputs", run: relayCommand},
+	}
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run runs the command named by the first argument, and returns the
+// exit status.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		usage(stderr)
+		return 2
+	}
+
+	switch args[0] {
+	case "help", "-h", "-help", "--help":
+		usage(stdout)
+		return 0
+	}
+
+	for _, cmd := range commands() {
+		if cmd.name == args[0] {
+			return cmd.run(args[1:], stdin, stdout, stderr)
+		}
+	}
+
+	fmt.Fprintf(stderr, "captainslog: unknown command %q\n", args[0])
+	usage(stderr)
+	return 2
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: captainslog <command> [flags] [args]\n\nCommands:\n")
+	for _, cmd := range commands() {
+		fmt.Fprintf(w, "  %-10s %s\n", cmd.name, cmd.summary)
+	}
+}
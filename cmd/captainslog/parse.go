@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/digitalocean/captainslog"
+)
+
+const (
+	// maxLineLen is the longest line that is read.
+	maxLineLen = 1024 * 1024
+)
+
+// formatters serialize a parsed message in each output format.
+var formatters = map[string]func(msg *captainslog.SyslogMsg) ([]byte, error){
+	"json": func(msg *captainslog.SyslogMsg) ([]byte, error) {
+		b, err := msg.JSON()
+		return append(b, '\n'), err
+	},
+	"rfc3164": func(msg *captainslog.SyslogMsg) ([]byte, error) {
+		return msg.Bytes(captainslog.OptionUseRemoteFormat), nil
+	},
+	"local": func(msg *captainslog.SyslogMsg) ([]byte, error) {
+		return msg.Bytes(captainslog.OptionUseLocalFormat), nil
+	},
+	"rfc5424": func(msg *captainslog.SyslogMsg) ([]byte, error) {
+		return msg.RFC5424(), nil
+	},
+}
+
+// parseCommand parses a syslog message from each line of the files, or
+// stdin, and writes it to stdout in the chosen format. Lines that fail
+// to parse are reported on stderr with their file and line number, and
+// the exit status is 1.
+func parseCommand(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("parse", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: captainslog parse [flags] [file...]\n\nReads stdin if no files are given.\n\nFlags:\n")
+		flags.PrintDefaults()
+	}
+
+	format := flags.String("format", "json", "output `format`: json, rfc3164, local or rfc5424")
+	noHostname := flags.Bool("no-hostname", false, "messages have no hostname")
+	location := flags.String("location", "", "time `zone` of timestamps without one, such as America/New_York (default UTC)")
+	sanitizeProgram := flags.Bool("sanitize-program", false, "sanitize program names such as /usr/bin/someprogram")
+	useGJSON := flags.Bool("gjson", false, "decode JSON content with gjson")
+	dontParseJSON := flags.Bool("dont-parse-json", false, "do not decode JSON content")
+	if err := flags.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+
+	formatter, ok := formatters[*format]
+	if !ok {
+		fmt.Fprintf(stderr, "captainslog parse: unknown format %q\n", *format)
+		return 2
+	}
+
+	var options []func(*captainslog.Parser)
+	if *noHostname {
+		options = append(options, captainslog.OptionNoHostname)
+	}
+	if *location != "" {
+		loc, err := time.LoadLocation(*location)
+		if err != nil {
+			fmt.Fprintf(stderr, "captainslog parse: %s\n", err)
+			return 2
+		}
+		options = append(options, captainslog.OptionLocation(loc))
+	}
+	if *sanitizeProgram {
+		options = append(options, captainslog.OptionSanitizeProgram)
+	}
+	if *useGJSON {
+		options = append(options, captainslog.OptionUseGJSONParser)
+	}
+	if *dontParseJSON {
+		options = append(options, captainslog.OptionDontParseJSON)
+	}
+	parser := captainslog.NewParser(options...)
+
+	files := flags.Args()
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+
+	out := bufio.NewWriter(stdout)
+	defer out.Flush()
+
+	status := 0
+	for _, file := range files {
+		if file == "-" {
+			if !parseLines(parser, formatter, "<stdin>", stdin, out, stderr) {
+				status = 1
+			}
+			continue
+		}
+
+		f, err := os.Open(file)
+		if err != nil {
+			fmt.Fprintf(stderr, "captainslog parse: %s\n", err)
+			status = 1
+			continue
+		}
+		if !parseLines(parser, formatter, file, f, out, stderr) {
+			status = 1
+		}
+		f.Close()
+	}
+	return status
+}
+
+// parseLines parses and writes each line of r, and returns false if
+// any line failed to parse or r could not be read.
+func parseLines(parser *captainslog.Parser, formatter func(*captainslog.SyslogMsg) ([]byte, error), name string, r io.Reader, out io.Writer, stderr io.Writer) bool {
+	ok := true
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLineLen)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		b := make([]byte, 0, len(scanner.Bytes())+1)
+		b = append(append(b, scanner.Bytes()...), '\n')
+		msg, err := parser.ParseBytes(b)
+		if err != nil {
+			fmt.Fprintf(stderr, "%s:%d: %s\n", name, line, err)
+			ok = false
+			continue
+		}
+
+		b, err = formatter(&msg)
+		if err != nil {
+			fmt.Fprintf(stderr, "%s:%d: %s\n", name, line, err)
+			ok = false
+			continue
+		}
+		out.Write(b)
+	}
+
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintf(stderr, "%s:%d: %s\n", name, line+1, err)
+		ok = false
+	}
+	return ok
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseCommand(t *testing.T) {
+	input := "<191>2006-01-02T15:04:05.999999-07:00 host.example.org test[12]: engage\n"
+
+	testCases := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{
+			name: "json",
+			args: []string{"parse"},
+			want: `{"syslog_content":" engage","syslog_facilitytext":"local7","syslog_host":"host.example.org","syslog_pid":"12","syslog_programname":"test","syslog_severitytext":"debug","syslog_tag":"test[12]:","syslog_time":"2006-01-02T15:04:05.999999-07:00"}` + "\n",
+		},
+		{
+			name: "rfc3164",
+			args: []string{"parse", "-format", "rfc3164"},
+			want: input,
+		},
+		{
+			name: "local",
+			args: []string{"convert", "--format=local"},
+			want: "<191>Jan  2 15:04:05 test[12]: engage\n",
+		},
+		{
+			name: "rfc5424",
+			args: []string{"parse", "-format", "rfc5424"},
+			want: "<191>1 2006-01-02T15:04:05.999999-07:00 host.example.org test 12 - - engage\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			status := run(tc.args, strings.NewReader(input), &stdout, &stderr)
+			if want, got := 0, status; want != got {
+				t.Fatalf("want status %d, got %d: %s", want, got, stderr.String())
+			}
+			if want, got := tc.want, stdout.String(); want != got {
+				t.Errorf("want %q, got %q", want, got)
+			}
+		})
+	}
+}
+
+func TestParseCommandOptions(t *testing.T) {
+	input := "<191>Jan  2 15:04:05 /usr/bin/test[12]: engage\n"
+
+	var stdout, stderr bytes.Buffer
+	args := []string{"parse", "--no-hostname", "--sanitize-program", "--location", "America/New_York", "--format", "rfc5424"}
+	status := run(args, strings.NewReader(input), &stdout, &stderr)
+	if want, got := 0, status; want != got {
+		t.Fatalf("want status %d, got %d: %s", want, got, stderr.String())
+	}
+
+	if got := stdout.String(); !strings.Contains(got, " test 12 - - engage\n") || !strings.Contains(got, "-05:00 ") {
+		t.Errorf("want sanitized program and New York time, got %q", got)
+	}
+}
+
+func TestParseCommandErrors(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "messages")
+	input := "<191>2006-01-02T15:04:05.999999-07:00 host.example.org test: one\n" +
+		"not syslog\n" +
+		"\n" +
+		"<191>2006-01-02T15:04:05.999999-07:00 host.example.org test: two\n"
+	if err := os.WriteFile(file, []byte(input), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	status := run([]string{"parse", "-format", "rfc3164", file, filepath.Join(dir, "missing")}, nil, &stdout, &stderr)
+	if want, got := 1, status; want != got {
+		t.Errorf("want status %d, got %d", want, got)
+	}
+
+	if want, got := 2, strings.Count(stdout.String(), "\n"); want != got {
+		t.Errorf("want %d messages, got %d: %q", want, got, stdout.String())
+	}
+	if want, got := file+":2: ", stderr.String(); !strings.HasPrefix(got, want) {
+		t.Errorf("want error starting with %q, got %q", want, got)
+	}
+	if want, got := "missing", stderr.String(); !strings.Contains(got, want) {
+		t.Errorf("want error for %q, got %q", want, got)
+	}
+}
+
+func TestRunUsage(t *testing.T) {
+	testCases := []struct {
+		args   []string
+		status int
+	}{
+		{args: nil, status: 2},
+		{args: []string{"nope"}, status: 2},
+		{args: []string{"help"}, status: 0},
+		{args: []string{"parse", "-format", "xml"}, status: 2},
+		{args: []string{"parse", "-bogus"}, status: 2},
+	}
+
+	for _, tc := range testCases {
+		var stdout, stderr bytes.Buffer
+		if want, got := tc.status, run(tc.args, strings.NewReader(""), &stdout, &stderr); want != got {
+			t.Errorf("%v: want status %d, got %d", tc.args, want, got)
+		}
+	}
+}
@@ -0,0 +1,471 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"regexp"
+	"sync"
+	"syscall"
+	"text/template"
+	"time"
+
+	"github.com/digitalocean/captainslog"
+)
+
+// relayCommand runs the relay daemon until it receives SIGINT or SIGTERM.
+func relayCommand(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("relay", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: captainslog relay -config file\n\nFlags:\n")
+		flags.PrintDefaults()
+	}
+
+	configPath := flags.String("config", "/etc/captainslog/relay.yaml", "configuration `file`, YAML or TOML")
+	check := flags.Bool("check", false, "check the configuration and exit")
+	if err := flags.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(stderr, "captainslog relay: %s\n", err)
+		return 2
+	}
+
+	if *check {
+		if err := checkRelay(cfg); err != nil {
+			fmt.Fprintf(stderr, "captainslog relay: %s\n", err)
+			return 2
+		}
+		fmt.Fprintf(stdout, "%s: ok\n", *configPath)
+		return 0
+	}
+
+	logger := log.New(stderr, "captainslog relay: ", log.LstdFlags)
+	r, err := newRelay(cfg, logger)
+	if err != nil {
+		fmt.Fprintf(stderr, "captainslog relay: %s\n", err)
+		return 2
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		s := <-signals
+		logger.Printf("received %s, shutting down", s)
+		r.Close()
+	}()
+
+	r.Run()
+	return 0
+}
+
+// relay receives messages from its listeners, runs them through its
+// pipeline and routes them to its outputs.
+type relay struct {
+	listeners []captainslog.Listener
+	pipeline  *captainslog.Pipeline
+	router    *captainslog.Router
+	sinks     []captainslog.Sink
+	logger    *log.Logger
+	closeOnce sync.Once
+}
+
+// newRelay opens the inputs and outputs of the configuration.
+func newRelay(cfg *relayConfig, logger *log.Logger) (*relay, error) {
+	r := relay{logger: logger}
+
+	pipeline, err := newRelayPipeline(cfg)
+	if err != nil {
+		return nil, err
+	}
+	r.pipeline = pipeline
+
+	routerOptions := []func(*captainslog.Router){
+		captainslog.RouterOptionFanOut,
+		captainslog.RouterOptionErrorHandler(func(sink captainslog.Sink, msg captainslog.SyslogMsg, err error) {
+			logger.Printf("output %s: %s", sinkName(sink), err)
+		}),
+	}
+	for _, o := range cfg.Outputs {
+		sink, err := r.newOutput(o)
+		if err != nil {
+			r.closeSinks()
+			return nil, err
+		}
+
+		match := func(*captainslog.SyslogMsg) bool { return true }
+		if o.Filter != "" {
+			f, err := captainslog.CompileFilter(o.Filter)
+			if err != nil {
+				r.closeSinks()
+				return nil, fmt.Errorf("output %s: %s", o.Name, err)
+			}
+			match = f.Match
+		}
+
+		routerOptions = append(routerOptions, captainslog.RouterOptionRoute(match, sink))
+		if o.QueueSize > 0 || o.BackPressure != "" {
+			size := o.QueueSize
+			if size <= 0 {
+				size = 1024
+			}
+			routerOptions = append(routerOptions, captainslog.RouterOptionSinkQueue(sink, size, backPressurePolicies[o.BackPressure]))
+		}
+	}
+	r.router = captainslog.NewRouter(routerOptions...)
+
+	for i, input := range cfg.Inputs {
+		l, err := newInput(input)
+		if err != nil {
+			r.Close()
+			return nil, fmt.Errorf("input %d: %s", i, err)
+		}
+		r.listeners = append(r.listeners, l)
+	}
+	return &r, nil
+}
+
+// checkRelay checks the parts of the configuration that newRelay
+// compiles, without opening its inputs and outputs, so that it can be
+// run while the relay is listening.
+func checkRelay(cfg *relayConfig) error {
+	if _, err := newRelayPipeline(cfg); err != nil {
+		return err
+	}
+	for i, input := range cfg.Inputs {
+		if input.Location != "" {
+			if _, err := time.LoadLocation(input.Location); err != nil {
+				return fmt.Errorf("input %d: %s", i, err)
+			}
+		}
+	}
+	for _, o := range cfg.Outputs {
+		if o.Filter != "" {
+			if _, err := captainslog.CompileFilter(o.Filter); err != nil {
+				return fmt.Errorf("output %s: %s", o.Name, err)
+			}
+		}
+		if o.Type == "file" {
+			if _, err := template.New("path").Parse(o.Path); err != nil {
+				return fmt.Errorf("output %s: %s", o.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// newRelayPipeline builds the pipeline of the global filter and the
+// mutations.
+func newRelayPipeline(cfg *relayConfig) (*captainslog.Pipeline, error) {
+	var options []func(*captainslog.Pipeline)
+
+	if cfg.Filter != "" {
+		f, err := captainslog.CompileFilter(cfg.Filter)
+		if err != nil {
+			return nil, fmt.Errorf("filter: %s", err)
+		}
+		notMatch := func(msg *captainslog.SyslogMsg) bool { return !f.Match(msg) }
+		options = append(options, captainslog.PipelineOptionStage(
+			captainslog.MutatorWhen(notMatch, captainslog.MutatorDrop()),
+			captainslog.StageOptionName("filter")))
+	}
+
+	for i, m := range cfg.Mutations {
+		mutator, err := newMutator(m)
+		if err != nil {
+			return nil, fmt.Errorf("mutation %d: %s", i, err)
+		}
+		if m.When != "" {
+			f, err := captainslog.CompileFilter(m.When)
+			if err != nil {
+				return nil, fmt.Errorf("mutation %d: %s", i, err)
+			}
+			mutator = captainslog.MutatorWhen(f.Match, mutator)
+		}
+		options = append(options, captainslog.PipelineOptionStage(mutator,
+			captainslog.StageOptionName(fmt.Sprintf("mutation %d", i)),
+			captainslog.StageOptionOnError(captainslog.StageErrorContinue)))
+	}
+	return captainslog.NewPipeline(options...), nil
+}
+
+// newMutator returns the built-in Mutator of the mutation's action.
+func newMutator(m mutationConfig) (captainslog.Mutator, error) {
+	switch {
+	case m.SetKey != nil:
+		return captainslog.MutatorSetKey(m.SetKey.Key, m.SetKey.Value), nil
+	case m.DeleteKey != "":
+		return captainslog.MutatorDeleteKey(m.DeleteKey), nil
+	case m.RenameKey != nil:
+		return captainslog.MutatorRenameKey(m.RenameKey.From, m.RenameKey.To), nil
+	case m.ReplaceValue != nil:
+		re, err := regexp.Compile(m.ReplaceValue.Pattern)
+		if err != nil {
+			return nil, err
+		}
+		return captainslog.MutatorReplaceValue(m.ReplaceValue.Key, re, m.ReplaceValue.Replacement), nil
+	case m.ReplaceContent != nil:
+		re, err := regexp.Compile(m.ReplaceContent.Pattern)
+		if err != nil {
+			return nil, err
+		}
+		return captainslog.MutatorReplaceContent(re, m.ReplaceContent.Replacement), nil
+	case m.SetFacility != nil:
+		return captainslog.MutatorSetFacility(*m.SetFacility), nil
+	case m.SetSeverity != nil:
+		return captainslog.MutatorSetSeverity(*m.SetSeverity), nil
+	case m.SetProgram != "":
+		return captainslog.MutatorSetProgram(m.SetProgram), nil
+	case m.AddTags != nil:
+		return captainslog.MutatorAddTags(m.AddTags.Key, m.AddTags.Values...), nil
+	default:
+		return captainslog.MutatorDrop(), nil
+	}
+}
+
+// newInput opens the listener of an input.
+func newInput(input inputConfig) (captainslog.Listener, error) {
+	var parserOptions []func(*captainslog.Parser)
+	for _, option := range []struct {
+		set    bool
+		option func(*captainslog.Parser)
+	}{
+		{input.NoHostname, captainslog.OptionNoHostname},
+		{input.SanitizeProgram, captainslog.OptionSanitizeProgram},
+		{input.GJSON, captainslog.OptionUseGJSONParser},
+		{input.DontParseJSON, captainslog.OptionDontParseJSON},
+		{input.ParseLogfmt, captainslog.OptionParseLogfmt},
+		{input.ParseCEF, captainslog.OptionParseCEF},
+		{input.ParseLEEF, captainslog.OptionParseLEEF},
+	} {
+		if option.set {
+			parserOptions = append(parserOptions, option.option)
+		}
+	}
+	if input.Location != "" {
+		loc, err := time.LoadLocation(input.Location)
+		if err != nil {
+			return nil, err
+		}
+		parserOptions = append(parserOptions, captainslog.OptionLocation(loc))
+	}
+
+	if input.Network == "file" {
+		options := []func(*captainslog.Tail){captainslog.TailOptionParser(parserOptions...)}
+		if input.Checkpoint != "" {
+			options = append(options, captainslog.TailOptionCheckpoint(input.Checkpoint))
+		}
+		if input.StartAtEnd {
+			options = append(options, captainslog.TailOptionStartAtEnd)
+		}
+		if input.MaxMessageSize > 0 {
+			options = append(options, captainslog.TailOptionMaxLineSize(input.MaxMessageSize))
+		}
+		return captainslog.NewTail(input.Paths, options...)
+	}
+
+	options := []func(*captainslog.SyslogListener){captainslog.SyslogListenerOptionParser(parserOptions...)}
+	if input.MaxMessageSize > 0 {
+		options = append(options, captainslog.SyslogListenerOptionMaxMessageSize(input.MaxMessageSize))
+	}
+	return captainslog.NewSyslogListener(input.Network, input.Address, options...)
+}
+
+// newOutput opens the sink of an output, buffered in a DiskQueue if
+// the output has a buffer directory.
+func (r *relay) newOutput(o outputConfig) (captainslog.Sink, error) {
+	var sink captainslog.Sink
+	switch o.Type {
+	case "file":
+		options := []func(*captainslog.FileSink){
+			captainslog.FileSinkOptionFormatter(formatters[o.format()]),
+			captainslog.FileSinkOptionMaxSize(o.MaxSize),
+			captainslog.FileSinkOptionRotateEvery(o.RotateEvery),
+			captainslog.FileSinkOptionMaxBackups(o.MaxBackups),
+			captainslog.FileSinkOptionMaxAge(o.MaxAge),
+			captainslog.FileSinkOptionSyncInterval(o.SyncInterval),
+			captainslog.FileSinkOptionErrorHandler(func(err error) {
+				r.logger.Printf("output %s: %s", o.Name, err)
+			}),
+		}
+		switch o.Compress {
+		case "gzip":
+			options = append(options, captainslog.FileSinkOptionGzip)
+		case "zstd":
+			options = append(options, captainslog.FileSinkOptionZstd)
+		}
+		if o.SyncEveryMessage {
+			options = append(options, captainslog.FileSinkOptionSyncEveryMessage)
+		}
+		fs, err := captainslog.NewFileSink(o.Path, options...)
+		if err != nil {
+			return nil, fmt.Errorf("output %s: %s", o.Name, err)
+		}
+		sink = &fileSink{name: o.Name, FileSink: fs}
+	case "stdout":
+		sink = &writerSink{name: o.Name, w: os.Stdout, format: formatters[o.format()]}
+	default:
+		var options []func(*captainslog.SyslogWriter)
+		switch o.Format {
+		case "rfc3164":
+			options = append(options, captainslog.SyslogWriterOptionFormat(captainslog.SyslogWriterFormatRFC3164))
+		case "local":
+			options = append(options, captainslog.SyslogWriterOptionFormat(captainslog.SyslogWriterFormatLocal))
+		case "rfc5424":
+			options = append(options, captainslog.SyslogWriterOptionFormat(captainslog.SyslogWriterFormatRFC5424))
+		}
+		if o.OctetCounting {
+			options = append(options, captainslog.SyslogWriterOptionOctetCounting)
+		}
+		sink = &upstreamSink{name: o.Name, network: o.Type, addr: o.Address, options: options}
+	}
+
+	if o.Buffer != "" {
+		spool, err := newSpoolSink(o.Name, sink, o.Buffer, o.BufferMaxSize, spoolRetryInterval, func(err error) {
+			r.logger.Printf("output %s: %s", o.Name, err)
+		})
+		if err != nil {
+			if c, ok := sink.(io.Closer); ok {
+				c.Close()
+			}
+			return nil, fmt.Errorf("output %s: %s", o.Name, err)
+		}
+		sink = spool
+	}
+	r.sinks = append(r.sinks, sink)
+	return sink, nil
+}
+
+// Run relays messages until the relay is closed, and then waits for
+// the queued messages to be sent.
+func (r *relay) Run() {
+	var wg sync.WaitGroup
+	for _, l := range r.listeners {
+		wg.Add(1)
+		go func(l captainslog.Listener) {
+			defer wg.Done()
+			r.receive(l)
+		}(l)
+	}
+
+	wg.Wait()
+	r.router.Close()
+	r.closeSinks()
+}
+
+func (r *relay) receive(l captainslog.Listener) {
+	for {
+		msg, err := l.Receive()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			r.logger.Printf("dropping message: %s", err)
+			continue
+		}
+
+		if err := r.pipeline.Mutate(&msg); err != nil {
+			if err != captainslog.ErrMsgDropped {
+				r.logger.Print(err)
+			}
+			continue
+		}
+		if _, err := r.router.Route(msg); err != nil {
+			return
+		}
+	}
+}
+
+// Close stops the relay's listeners. Run returns once the messages
+// that were already received have been sent.
+func (r *relay) Close() {
+	r.closeOnce.Do(func() {
+		for _, l := range r.listeners {
+			l.Close()
+		}
+	})
+}
+
+func (r *relay) closeSinks() {
+	for _, sink := range r.sinks {
+		if c, ok := sink.(io.Closer); ok {
+			c.Close()
+		}
+	}
+}
+
+// sinkName returns the name of an output's sink for logging.
+func sinkName(sink captainslog.Sink) string {
+	switch s := sink.(type) {
+	case *spoolSink:
+		return s.name
+	case *writerSink:
+		return s.name
+	case *upstreamSink:
+		return s.name
+	case *fileSink:
+		return s.name
+	}
+	return "?"
+}
+
+// fileSink is a FileSink with the name of its output.
+type fileSink struct {
+	name string
+	*captainslog.FileSink
+}
+
+// writerSink writes formatted messages to stdout.
+type writerSink struct {
+	name   string
+	w      io.Writer
+	format func(msg *captainslog.SyslogMsg) ([]byte, error)
+}
+
+func (s *writerSink) Send(msg captainslog.SyslogMsg) error {
+	b, err := s.format(&msg)
+	if err != nil {
+		return err
+	}
+	_, err = s.w.Write(b)
+	return err
+}
+
+// upstreamSink sends messages to a syslog server, connecting on the
+// first message so that the relay starts while the server is down.
+type upstreamSink struct {
+	name    string
+	network string
+	addr    string
+	options []func(*captainslog.SyslogWriter)
+	writer  *captainslog.SyslogWriter
+}
+
+func (s *upstreamSink) Send(msg captainslog.SyslogMsg) error {
+	if s.writer == nil {
+		w, err := captainslog.NewSyslogWriter(s.network, s.addr, s.options...)
+		if err != nil {
+			return err
+		}
+		s.writer = w
+	}
+	return s.writer.Send(msg)
+}
+
+func (s *upstreamSink) Close() error {
+	if s.writer == nil {
+		return nil
+	}
+	return s.writer.Close()
+}
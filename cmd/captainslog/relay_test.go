@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/captainslog"
+)
+
+func TestRelay(t *testing.T) {
+	dir := t.TempDir()
+	all := filepath.Join(dir, "all.log")
+	errs := filepath.Join(dir, "errors.log")
+
+	cfg := &relayConfig{
+		Inputs: []inputConfig{{Network: "tcp", Address: "127.0.0.1:0"}},
+		Filter: `program != "noisy"`,
+		Mutations: []mutationConfig{
+			{SetKey: &keyValueConfig{Key: "env", Value: "prod"}},
+			{When: `program == "sshd"`, DeleteKey: "password"},
+		},
+		Outputs: []outputConfig{
+			{Name: "all", Type: "file", Path: all},
+			{Name: "errors", Type: "file", Path: errs, Format: "json", Filter: `severity <= err`},
+		},
+	}
+	if err := cfg.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	var logs bytes.Buffer
+	r, err := newRelay(cfg, log.New(&logs, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		r.Run()
+		close(done)
+	}()
+
+	addr := r.listeners[0].(*captainslog.SyslogListener).Addr().String()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := "<38>2006-01-02T15:04:05.999999-07:00 host.example.org sshd[12]: @cee: {\"password\":\"x\",\"user\":\"bob\"}\n" +
+		"<187>2006-01-02T15:04:05.999999-07:00 host.example.org nginx: upstream failed\n" +
+		"<190>2006-01-02T15:04:05.999999-07:00 host.example.org noisy: chatter\n" +
+		"garbage\n"
+	if _, err := conn.Write([]byte(input)); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	waitForLines(t, all, 2)
+	r.Close()
+	<-done
+
+	b, err := os.ReadFile(all)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "<38>2006-01-02T15:04:05.999999-07:00 host.example.org sshd[12]: @cee: {\"env\":\"prod\",\"user\":\"bob\"}\n" +
+		"<187>2006-01-02T15:04:05.999999-07:00 host.example.org nginx: @cee:{\"env\":\"prod\",\"msg\":\"upstream failed\"}\n"
+	if got := string(b); want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+
+	b, err = os.ReadFile(errs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(b); strings.Count(got, "\n") != 1 || !strings.Contains(got, `"syslog_programname":"nginx"`) {
+		t.Errorf("want the nginx message as JSON, got %q", got)
+	}
+
+	if !strings.Contains(logs.String(), "dropping message") {
+		t.Errorf("want garbage to be logged, got %q", logs.String())
+	}
+}
+
+func TestRelayFileInput(t *testing.T) {
+	dir := t.TempDir()
+	messages := filepath.Join(dir, "messages")
+	checkpoint := filepath.Join(dir, "checkpoint.json")
+	out := filepath.Join(dir, "out.log")
+	line := "<38>2006-01-02T15:04:05.999999-07:00 host.example.org sshd[12]: accepted\n"
+	if err := os.WriteFile(messages, []byte(line), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &relayConfig{
+		Inputs:  []inputConfig{{Network: "file", Paths: []string{filepath.Join(dir, "mess*")}, Checkpoint: checkpoint}},
+		Outputs: []outputConfig{{Name: "out", Type: "file", Path: out}},
+	}
+	if err := cfg.validate(); err != nil {
+		t.Fatal(err)
+	}
+	r, err := newRelay(cfg, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		r.Run()
+		close(done)
+	}()
+
+	waitForLines(t, out, 1)
+	r.Close()
+	<-done
+
+	b, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := line, string(b); want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+	if b, err := os.ReadFile(checkpoint); err != nil || !strings.Contains(string(b), `"offset":`+strconv.Itoa(len(line))) {
+		t.Errorf("want the offset of the line checkpointed, got %q, %v", b, err)
+	}
+}
+
+func waitForLines(t *testing.T, path string, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if b, err := os.ReadFile(path); err == nil && bytes.Count(b, []byte("\n")) >= n {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %d lines in %s", n, path)
+}
+
+// flakySink fails while down is set.
+type flakySink struct {
+	mutex sync.Mutex
+	down  bool
+	got   []string
+}
+
+func (s *flakySink) Send(msg captainslog.SyslogMsg) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.down {
+		return errors.New("upstream down")
+	}
+	s.got = append(s.got, msg.Tag.Program)
+	return nil
+}
+
+func TestSpoolSink(t *testing.T) {
+	dir := t.TempDir()
+	sink := &flakySink{down: true}
+	open := func() *spoolSink {
+		spool, err := newSpoolSink("upstream", sink, dir, 0, time.Millisecond, func(error) {})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return spool
+	}
+	send := func(spool *spoolSink, program string) {
+		msg, err := captainslog.NewSyslogMsgFromBytes([]byte("<14>2006-01-02T15:04:05.999999-07:00 host.example.org " + program + ": hello\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := spool.Send(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	spool := open()
+	send(spool, "a")
+	send(spool, "b")
+	if err := spool.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := sink.got; len(got) != 0 {
+		t.Fatalf("want nothing sent, got %v", got)
+	}
+
+	// a restart keeps the spooled messages, and sends them first
+	spool = open()
+	sink.mutex.Lock()
+	sink.down = false
+	sink.mutex.Unlock()
+	send(spool, "c")
+	if err := spool.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if want, got := "a b c", strings.Join(sink.got, " "); want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func TestRelayCommandCheck(t *testing.T) {
+	path := writeConfig(t, "relay.yaml", "inputs: [{network: udp, address: '127.0.0.1:0'}]\noutputs: [{type: stdout}]\n")
+
+	var stdout, stderr bytes.Buffer
+	if want, got := 0, run([]string{"relay", "-config", path, "-check"}, nil, &stdout, &stderr); want != got {
+		t.Fatalf("want status %d, got %d: %s", want, got, stderr.String())
+	}
+	if want, got := path+": ok\n", stdout.String(); want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+
+	// the check doesn't bind the addresses of the inputs
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	path = writeConfig(t, "relay.yaml", "inputs: [{network: tcp, address: '"+l.Addr().String()+"'}]\noutputs: [{type: stdout}]\n")
+	if want, got := 0, run([]string{"relay", "-config", path, "-check"}, nil, io.Discard, &stderr); want != got {
+		t.Errorf("want status %d, got %d: %s", want, got, stderr.String())
+	}
+
+	path = writeConfig(t, "relay.yaml", "inputs: [{network: udp, address: ':0'}]\noutputs: [{type: stdout, filter: 'severity <='}]\n")
+	if want, got := 2, run([]string{"relay", "-config", path, "-check"}, nil, io.Discard, io.Discard); want != got {
+		t.Errorf("want status %d, got %d", want, got)
+	}
+
+	if want, got := 2, run([]string{"relay", "-config", filepath.Join(t.TempDir(), "missing.yaml")}, nil, io.Discard, io.Discard); want != got {
+		t.Errorf("want status %d, got %d", want, got)
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/digitalocean/captainslog"
+)
+
+const (
+	// devLog is the default unix socket of the local syslog daemon.
+	devLog = "/dev/log"
+)
+
+// jsonPairs collects -j key=value flags.
+type jsonPairs []string
+
+func (p *jsonPairs) String() string {
+	return strings.Join(*p, ",")
+}
+
+func (p *jsonPairs) Set(v string) error {
+	if i := strings.Index(v, "="); i < 1 {
+		return fmt.Errorf("want key=value, got %q", v)
+	}
+	*p = append(*p, v)
+	return nil
+}
+
+// jsonValue decodes a -j value as JSON, such as 12, true or
+// {"a":"b"}, falling back to the value as a string.
+func jsonValue(v string) interface{} {
+	decoder := json.NewDecoder(strings.NewReader(v))
+	decoder.UseNumber()
+
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil || decoder.More() {
+		return v
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return v
+	}
+	return value
+}
+
+// sendCommand sends a syslog message built from its flags, like
+// logger(1). The message is the arguments joined by spaces, or each
+// line of stdin if there are none. Key=value pairs given with -j make
+// it a CEE message with the text in the "msg" key, or with the keys of
+// text that is a JSON object.
+func sendCommand(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("send", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: captainslog send [flags] [message...]\n\nReads messages from stdin, one per line, if none is given.\n\nFlags:\n")
+		flags.PrintDefaults()
+	}
+
+	var pairs jsonPairs
+	priority := flags.String("p", "", "`facility.severity`, such as local0.info, overriding -facility and -severity")
+	facility := flags.String("facility", "user", "`facility` name or code")
+	severity := flags.String("severity", "notice", "`severity` name or code")
+	program := flags.String("t", os.Getenv("USER"), "`program` name of the tag")
+	pid := flags.String("pid", "", "`pid` of the tag")
+	ownPid := flags.Bool("i", false, "use the pid of this process")
+	host := flags.String("host", "", "`hostname` of the message (default the local hostname)")
+	flags.Var(&pairs, "j", "add a `key=value` pair to a CEE payload, may be repeated; values that are valid JSON are decoded")
+	unixSocket := flags.String("unix", "", "send to the unix `socket` (default /dev/log)")
+	udpAddr := flags.String("udp", "", "send over UDP to `host:port`")
+	tcpAddr := flags.String("tcp", "", "send over TCP to `host:port`")
+	printOnly := flags.Bool("print", false, "print the message instead of sending it")
+	format := flags.String("format", "", "send in `format` rfc3164, local or rfc5424 (default local for unix sockets, rfc3164 otherwise)")
+	if err := flags.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+
+	if *priority != "" {
+		parts := strings.SplitN(*priority, ".", 2)
+		if len(parts) != 2 {
+			fmt.Fprintf(stderr, "captainslog send: want facility.severity, got %q\n", *priority)
+			return 2
+		}
+		*facility, *severity = parts[0], parts[1]
+	}
+
+	var f captainslog.Facility
+	if err := f.UnmarshalText([]byte(*facility)); err != nil {
+		fmt.Fprintf(stderr, "captainslog send: %s\n", err)
+		return 2
+	}
+	var sv captainslog.Severity
+	if err := sv.UnmarshalText([]byte(*severity)); err != nil {
+		fmt.Fprintf(stderr, "captainslog send: %s\n", err)
+		return 2
+	}
+
+	if *ownPid {
+		*pid = strconv.Itoa(os.Getpid())
+	}
+	if *host == "" {
+		h, err := os.Hostname()
+		if err != nil {
+			fmt.Fprintf(stderr, "captainslog send: %s\n", err)
+			return 2
+		}
+		*host = h
+	}
+
+	network, addr := "unix", *unixSocket
+	switch {
+	case *printOnly:
+		network = ""
+	case *udpAddr != "":
+		network, addr = "udp", *udpAddr
+	case *tcpAddr != "":
+		network, addr = "tcp", *tcpAddr
+	case addr == "":
+		addr = devLog
+	}
+
+	if *format == "" {
+		*format = "rfc3164"
+		if network == "unix" || network == "" {
+			*format = "local"
+		}
+	}
+	formatter, ok := formatters[*format]
+	if !ok || *format == "json" {
+		fmt.Fprintf(stderr, "captainslog send: unknown format %q\n", *format)
+		return 2
+	}
+
+	var w io.Writer = stdout
+	if network != "" {
+		conn, err := dialSyslog(network, addr)
+		if err != nil {
+			fmt.Fprintf(stderr, "captainslog send: %s\n", err)
+			return 1
+		}
+		defer conn.Close()
+		w = conn
+	}
+
+	send := func(text string) error {
+		msg := captainslog.NewSyslogMsg()
+		msg.Tag = *captainslog.NewTag()
+		msg.SetTime(time.Now())
+		if err := msg.SetFacility(f); err != nil {
+			return err
+		}
+		if err := msg.SetSeverity(sv); err != nil {
+			return err
+		}
+		msg.SetHost(*host)
+		msg.SetProgram(*program)
+		if *pid != "" {
+			msg.SetPid(*pid)
+		}
+		// content that is not valid JSON is sent as text
+		_ = msg.SetContent(" " + text)
+		for _, pair := range pairs {
+			kv := strings.SplitN(pair, "=", 2)
+			msg.AddTag(kv[0], jsonValue(kv[1]))
+		}
+		// JSON text is merged with the pairs into the CEE payload
+		if len(pairs) > 0 && msg.IsJSON && !msg.IsCee {
+			msg.IsCee = true
+			msg.Cee = " @cee:"
+		}
+
+		b, err := formatter(&msg)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(b)
+		return err
+	}
+
+	if flags.NArg() > 0 {
+		if err := send(strings.Join(flags.Args(), " ")); err != nil {
+			fmt.Fprintf(stderr, "captainslog send: %s\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	scanner := bufio.NewScanner(stdin)
+	scanner.Buffer(make([]byte, 64*1024), maxLineLen)
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		if err := send(scanner.Text()); err != nil {
+			fmt.Fprintf(stderr, "captainslog send: %s\n", err)
+			return 1
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintf(stderr, "captainslog send: %s\n", err)
+		return 1
+	}
+	return 0
+}
+
+// dialSyslog connects to a syslog daemon. Unix sockets are tried as
+// datagram sockets first, as /dev/log usually is, and then as stream
+// sockets.
+func dialSyslog(network, addr string) (net.Conn, error) {
+	if network != "unix" {
+		return net.Dial(network, addr)
+	}
+	conn, err := net.Dial("unixgram", addr)
+	if err == nil {
+		return conn, nil
+	}
+	return net.Dial("unix", addr)
+}
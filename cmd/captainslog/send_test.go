@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestSendCommandPrint(t *testing.T) {
+	testCases := []struct {
+		name  string
+		args  []string
+		stdin string
+		want  string
+	}{
+		{
+			name: "text",
+			args: []string{"send", "-print", "-p", "local0.warn", "-t", "deploy", "-pid", "42", "-host", "web1", "release", "started"},
+			want: `^<132>\w{3} [ \d]\d \d\d:\d\d:\d\d deploy\[42\]: release started\n$`,
+		},
+		{
+			name: "cee",
+			args: []string{"send", "-print", "-facility", "daemon", "-severity", "error", "-t", "app", "-j", "user=bob", "-j", "count=5", "-j", "ok=true", "login"},
+			want: `^<27>\w{3} [ \d]\d \d\d:\d\d:\d\d app: @cee:\{"count":5,"msg":"login","ok":true,"user":"bob"\}\n$`,
+		},
+		{
+			name: "cee json",
+			args: []string{"send", "-print", "-t", "app", "-j", "user=bob", `{"msg":"login"}`},
+			want: `^<13>\w{3} [ \d]\d \d\d:\d\d:\d\d app: @cee: ?\{"msg":"login","user":"bob"\}\n$`,
+		},
+		{
+			name:  "stdin",
+			args:  []string{"send", "-print", "-t", "app"},
+			stdin: "one\n\ntwo\n",
+			want:  `^<13>[^\n]* app: one\n<13>[^\n]* app: two\n$`,
+		},
+		{
+			name: "rfc5424",
+			args: []string{"send", "-print", "-format", "rfc5424", "-t", "app", "-host", "web1", "-p", "user.info", "hi"},
+			want: `^<14>1 \S+ web1 app - - - hi\n$`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			if want, got := 0, run(tc.args, strings.NewReader(tc.stdin), &stdout, &stderr); want != got {
+				t.Fatalf("want status %d, got %d: %s", want, got, stderr.String())
+			}
+			if !regexp.MustCompile(tc.want).MatchString(stdout.String()) {
+				t.Errorf("want match for %q, got %q", tc.want, stdout.String())
+			}
+		})
+	}
+}
+
+func TestSendCommandUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	var stdout, stderr bytes.Buffer
+	args := []string{"send", "-udp", conn.LocalAddr().String(), "-host", "web1", "-t", "app", "-i", "hello"}
+	if want, got := 0, run(args, nil, &stdout, &stderr); want != got {
+		t.Fatalf("want status %d, got %d: %s", want, got, stderr.String())
+	}
+
+	b := make([]byte, 1024)
+	n, _, err := conn.ReadFrom(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := regexp.MustCompile(`^<13>\S+ web1 app\[\d+\]: hello\n$`)
+	if got := string(b[:n]); !want.MatchString(got) {
+		t.Errorf("want match for %q, got %q", want, got)
+	}
+}
+
+func TestSendCommandTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	lines := make(chan string, 2)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+
+	var stdout, stderr bytes.Buffer
+	args := []string{"send", "-tcp", ln.Addr().String(), "-host", "web1", "-t", "app"}
+	if want, got := 0, run(args, strings.NewReader("one\ntwo\n"), &stdout, &stderr); want != got {
+		t.Fatalf("want status %d, got %d: %s", want, got, stderr.String())
+	}
+
+	var got []string
+	for line := range lines {
+		got = append(got, line)
+	}
+	if want := 2; len(got) != want {
+		t.Fatalf("want %d messages, got %q", want, got)
+	}
+	if !strings.HasSuffix(got[1], " web1 app: two") {
+		t.Errorf("want second message two, got %q", got[1])
+	}
+}
+
+func TestSendCommandUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log")
+	conn, err := net.ListenPacket("unixgram", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	var stdout, stderr bytes.Buffer
+	args := []string{"send", "-unix", path, "-t", "app", "hello"}
+	if want, got := 0, run(args, nil, &stdout, &stderr); want != got {
+		t.Fatalf("want status %d, got %d: %s", want, got, stderr.String())
+	}
+
+	b := make([]byte, 1024)
+	n, _, err := conn.ReadFrom(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := regexp.MustCompile(`^<13>\w{3} [ \d]\d \d\d:\d\d:\d\d app: hello\n$`)
+	if got := string(b[:n]); !want.MatchString(got) {
+		t.Errorf("want match for %q, got %q", want, got)
+	}
+}
+
+func TestSendCommandErrors(t *testing.T) {
+	testCases := [][]string{
+		{"send", "-print", "-p", "local0"},
+		{"send", "-print", "-facility", "nope", "x"},
+		{"send", "-print", "-severity", "9", "x"},
+		{"send", "-print", "-j", "novalue", "x"},
+		{"send", "-print", "-format", "json", "x"},
+	}
+
+	for _, args := range testCases {
+		var stdout, stderr bytes.Buffer
+		if want, got := 2, run(args, nil, &stdout, &stderr); want != got {
+			t.Errorf("%v: want status %d, got %d", args, want, got)
+		}
+	}
+}
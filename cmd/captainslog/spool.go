@@ -0,0 +1,89 @@
+package main
+
+import (
+	"io"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/digitalocean/captainslog"
+)
+
+const (
+	// spoolRetryInterval is how often a failing sink is retried.
+	spoolRetryInterval = 10 * time.Second
+
+	// spoolMaxSize is the default disk usage of a buffer, beyond which
+	// its oldest messages are dropped.
+	spoolMaxSize = 1 << 30
+)
+
+// spoolSink keeps the messages of an output in a captainslog.DiskQueue
+// in the output's buffer directory, and forwards them in order to its
+// sink, retrying while the sink fails. Messages left in the queue by an
+// earlier run are sent first.
+type spoolSink struct {
+	name    string
+	sink    captainslog.Sink
+	queue   *captainslog.DiskQueue
+	failing int32
+	done    chan struct{}
+}
+
+// newSpoolSink opens the queue of the named output in dir, and starts
+// forwarding its messages to sink.
+func newSpoolSink(name string, sink captainslog.Sink, dir string, maxSize int64, retryInterval time.Duration, errorHandler func(err error)) (*spoolSink, error) {
+	if maxSize <= 0 {
+		maxSize = spoolMaxSize
+	}
+	queue, err := captainslog.NewDiskQueue(filepath.Join(dir, name),
+		captainslog.DiskQueueOptionMaxDiskUsage(maxSize),
+		captainslog.DiskQueueOptionOverflow(captainslog.BackPressureDropOldest),
+		captainslog.DiskQueueOptionErrorHandler(errorHandler),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	s := spoolSink{name: name, sink: sink, queue: queue, done: make(chan struct{})}
+	go func() {
+		defer close(s.done)
+		queue.Forward(captainslog.SinkFunc(s.forward), retryInterval)
+	}()
+	return &s, nil
+}
+
+// Send appends the message to the queue.
+func (s *spoolSink) Send(msg captainslog.SyslogMsg) error {
+	return s.queue.Send(msg)
+}
+
+// forward sends a message from the queue to the sink, and records
+// whether the sink is failing.
+func (s *spoolSink) forward(msg captainslog.SyslogMsg) error {
+	err := s.sink.Send(msg)
+	failing := int32(0)
+	if err != nil {
+		failing = 1
+	}
+	atomic.StoreInt32(&s.failing, failing)
+	return err
+}
+
+// Close waits for the queued messages to be sent, unless the sink is
+// failing, in which case they stay in the queue for the next run, and
+// closes the queue and the sink.
+func (s *spoolSink) Close() error {
+	for s.queue.Len() > 0 && atomic.LoadInt32(&s.failing) == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	err := s.queue.Close()
+	<-s.done
+
+	if c, ok := s.sink.(io.Closer); ok {
+		if e := c.Close(); err == nil {
+			err = e
+		}
+	}
+	return err
+}
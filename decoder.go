@@ -0,0 +1,184 @@
+package captainslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+
+	"github.com/tidwall/gjson"
+)
+
+// ContentDecoder decodes the content of a syslog message into the
+// SyslogMsg.JSONValues. DecodeContent is passed the content without
+// its leading whitespace. It returns a nil map and a nil error if the
+// content is not in the decoder's format and should be left to the
+// next decoder, or as plain text. An error means the content was
+// meant for the decoder but is malformed, and is returned by the parser.
+type ContentDecoder interface {
+	DecodeContent(content []byte) (map[string]interface{}, error)
+}
+
+// ContentEncoder is implemented by ContentDecoders that can encode
+// JSONValues back to their format. SyslogMsg.String() and
+// SyslogMsg.Bytes() use it for messages whose content it decoded.
+type ContentEncoder interface {
+	EncodeContent(m map[string]interface{}) ([]byte, error)
+}
+
+// ContentDecoderFunc adapts a function to a ContentDecoder.
+type ContentDecoderFunc func(content []byte) (map[string]interface{}, error)
+
+// DecodeContent calls f(content).
+func (f ContentDecoderFunc) DecodeContent(content []byte) (map[string]interface{}, error) {
+	return f(content)
+}
+
+// ContentCondition reports whether a ContentDecoder applies to a message.
+// It is passed the message with every field but the content parsed,
+// and the content without its leading whitespace.
+type ContentCondition func(msg *SyslogMsg, content []byte) bool
+
+var (
+	// JSONContentDecoder decodes JSON objects with encoding/json. It is
+	// the decoder used for JSON content by default.
+	JSONContentDecoder ContentDecoder = jsonDecoder{}
+
+	// GJSONContentDecoder decodes JSON objects with "github.com/tidwall/gjson".
+	// It is the decoder used for JSON content with OptionUseGJSONParser.
+	GJSONContentDecoder ContentDecoder = jsonDecoder{useGJSON: true}
+
+	// LogfmtContentDecoder decodes logfmt key=value pairs. It is the
+	// decoder used with OptionParseLogfmt. Content that is not valid
+	// logfmt, or looks like prose rather than key=value pairs, is left
+	// as plain text.
+	LogfmtContentDecoder ContentDecoder = logfmtDecoder{}
+
+	// CEFContentDecoder decodes ArcSight CEF events with DecodeCEF,
+	// and encodes them with EncodeCEF. It is the decoder used with
+	// OptionParseCEF.
+	CEFContentDecoder ContentDecoder = cefDecoder{}
+
+	// LEEFContentDecoder decodes QRadar LEEF events with DecodeLEEF,
+	// and encodes them with EncodeLEEF. It is the decoder used with
+	// OptionParseLEEF.
+	LEEFContentDecoder ContentDecoder = leefDecoder{}
+)
+
+// ContentConditionLeadingByte applies a decoder to content starting with b.
+func ContentConditionLeadingByte(b byte) ContentCondition {
+	return func(msg *SyslogMsg, content []byte) bool {
+		return len(content) > 0 && content[0] == b
+	}
+}
+
+// ContentConditionProgram applies a decoder to messages from the program.
+func ContentConditionProgram(program string) ContentCondition {
+	return func(msg *SyslogMsg, content []byte) bool {
+		return msg.Tag.Program == program
+	}
+}
+
+// ContentConditionCEE applies a decoder to messages with a @cee: cookie.
+func ContentConditionCEE(msg *SyslogMsg, content []byte) bool {
+	return msg.IsCee
+}
+
+// contentConditionNotCEE applies a decoder to messages without a @cee: cookie.
+func contentConditionNotCEE(msg *SyslogMsg, content []byte) bool {
+	return !msg.IsCee
+}
+
+// OptionContentDecoder registers a ContentDecoder on the parser, used for
+// messages that meet all of the conditions. Decoders are tried in the
+// order they were registered, before the built-in decoders, and the
+// first one that decodes the content is used. Messages whose content was
+// decoded by a decoder that also implements ContentEncoder keep their
+// content as it was received, and are serialized with the encoder once
+// their JSONValues are changed. Messages whose content is a JSON object
+// are JSON messages, whichever decoder decoded it. Other messages whose
+// content was decoded by a decoder other than LogfmtContentDecoder keep
+// their content as is, and are serialized as CEE with the content in
+// the "msg" key.
+func OptionContentDecoder(decoder ContentDecoder, conditions ...ContentCondition) func(*Parser) {
+	return func(p *Parser) {
+		p.decoders = append(p.decoders, conditionalDecoder{decoder: decoder, conditions: conditions})
+	}
+}
+
+// conditionalDecoder is a ContentDecoder along with the conditions
+// it applies under.
+type conditionalDecoder struct {
+	decoder    ContentDecoder
+	conditions []ContentCondition
+}
+
+func (d conditionalDecoder) applies(msg *SyslogMsg, content []byte) bool {
+	for _, condition := range d.conditions {
+		if !condition(msg, content) {
+			return false
+		}
+	}
+	return true
+}
+
+// jsonDecoder is the built-in JSON ContentDecoder.
+type jsonDecoder struct {
+	useGJSON bool
+}
+
+// DecodeContent decodes a JSON object into a map, using either
+// encoding/json or "github.com/tidwall/gjson".
+func (d jsonDecoder) DecodeContent(b []byte) (map[string]interface{}, error) {
+	if d.useGJSON {
+		if !gjson.ValidBytes(b) {
+			return nil, errors.New("gjson parse failed")
+		}
+		m, ok := gjson.ParseBytes(b).Value().(map[string]interface{})
+		if !ok {
+			return nil, errors.New("gjson parse failed")
+		}
+		return m, nil
+	}
+
+	m := make(map[string]interface{})
+	decoder := json.NewDecoder(bytes.NewBuffer(b))
+	decoder.UseNumber()
+	err := decoder.Decode(&m)
+	return m, err
+}
+
+// logfmtDecoder is the built-in logfmt ContentDecoder.
+type logfmtDecoder struct{}
+
+// DecodeContent decodes logfmt content, leaving content that is not
+// valid logfmt or looks like prose to other decoders.
+func (d logfmtDecoder) DecodeContent(b []byte) (map[string]interface{}, error) {
+	m, err := DecodeLogfmt(b)
+	if err != nil || !isLogfmtPairs(m) {
+		return nil, nil
+	}
+	return m, nil
+}
+
+// isLogfmtPairs reports whether decoded logfmt is made of key=value
+// pairs, so that plain text with an "=" in it is not taken as logfmt.
+// Every token must be a pair, or most of them must be and no key may
+// hold punctuation, as the words of a sentence do.
+func isLogfmtPairs(m map[string]interface{}) bool {
+	pairs, punctuated := 0, false
+	for key, value := range m {
+		if _, ok := value.(string); ok {
+			pairs++
+		}
+		for i := 0; i < len(key); i++ {
+			c := key[i]
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '.' || c == '-') {
+				punctuated = true
+			}
+		}
+	}
+	if pairs == len(m) {
+		return pairs > 0
+	}
+	return pairs > len(m)-pairs && !punctuated
+}
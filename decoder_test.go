@@ -0,0 +1,169 @@
+package captainslog_test
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/digitalocean/captainslog"
+)
+
+// csvDecoder decodes "a,b,c" content into numbered columns.
+var csvDecoder = captainslog.ContentDecoderFunc(func(content []byte) (map[string]interface{}, error) {
+	m := make(map[string]interface{})
+	for i, column := range strings.Split(strings.TrimSpace(string(content)), ",") {
+		m[string(rune('a'+i))] = column
+	}
+	return m, nil
+})
+
+func TestParserOptionContentDecoder(t *testing.T) {
+	errBadXML := errors.New("bad xml")
+	xmlDecoder := captainslog.ContentDecoderFunc(func(content []byte) (map[string]interface{}, error) {
+		if !strings.HasSuffix(strings.TrimSpace(string(content)), ">") {
+			return nil, errBadXML
+		}
+		return map[string]interface{}{"xml": true}, nil
+	})
+	declineDecoder := captainslog.ContentDecoderFunc(func(content []byte) (map[string]interface{}, error) {
+		return nil, nil
+	})
+
+	p := captainslog.NewParser(
+		captainslog.OptionContentDecoder(declineDecoder),
+		captainslog.OptionContentDecoder(csvDecoder, captainslog.ContentConditionProgram("csvapp")),
+		captainslog.OptionContentDecoder(xmlDecoder, captainslog.ContentConditionLeadingByte('<')),
+		captainslog.OptionContentDecoder(csvDecoder, captainslog.ContentConditionCEE, captainslog.ContentConditionProgram("ceeapp")),
+	)
+
+	testCases := []struct {
+		name   string
+		input  string
+		want   map[string]interface{}
+		isJSON bool
+		err    error
+	}{
+		{
+			name:  "program",
+			input: "<191>2006-01-02T15:04:05.999999-07:00 host.example.org csvapp[12]: bob,12ms\n",
+			want:  map[string]interface{}{"a": "bob", "b": "12ms"},
+		},
+		{
+			name:  "program overrides json",
+			input: "<191>2006-01-02T15:04:05.999999-07:00 host.example.org csvapp[12]: {x,y\n",
+			want:  map[string]interface{}{"a": "{x", "b": "y"},
+		},
+		{
+			name:  "leading byte",
+			input: "<191>2006-01-02T15:04:05.999999-07:00 host.example.org other[12]: <event/>\n",
+			want:  map[string]interface{}{"xml": true},
+		},
+		{
+			name:  "decoder error",
+			input: "<191>2006-01-02T15:04:05.999999-07:00 host.example.org other[12]: <event\n",
+			want:  map[string]interface{}{},
+			err:   errBadXML,
+		},
+		{
+			name:  "cee",
+			input: "<191>2006-01-02T15:04:05.999999-07:00 host.example.org ceeapp[12]: @cee: bob,12ms\n",
+			want:  map[string]interface{}{"a": "bob", "b": "12ms"},
+		},
+		{
+			name:   "built-in json",
+			input:  "<191>2006-01-02T15:04:05.999999-07:00 host.example.org other[12]: @cee: {\"a\":\"b\"}\n",
+			want:   map[string]interface{}{"a": "b"},
+			isJSON: true,
+		},
+		{
+			name:  "plain text",
+			input: "<191>2006-01-02T15:04:05.999999-07:00 host.example.org other[12]: bob,12ms\n",
+			want:  map[string]interface{}{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			msg, err := p.ParseBytes([]byte(tc.input))
+			if want, got := tc.err, err; want != got {
+				t.Fatalf("want %v, got %v", want, got)
+			}
+
+			if !reflect.DeepEqual(tc.want, msg.JSONValues) {
+				t.Errorf("want %v, got %v", tc.want, msg.JSONValues)
+			}
+
+			if want, got := tc.isJSON, msg.IsJSON; want != got {
+				t.Errorf("IsJSON: want %v, got %v", want, got)
+			}
+		})
+	}
+}
+
+func TestParserOptionContentDecoderString(t *testing.T) {
+	input := "<191>2006-01-02T15:04:05.999999-07:00 host.example.org csvapp[12]: bob,12ms\n"
+	msg, err := captainslog.NewSyslogMsgFromBytes([]byte(input),
+		captainslog.OptionContentDecoder(csvDecoder, captainslog.ContentConditionProgram("csvapp")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "<191>2006-01-02T15:04:05.999999-07:00 host.example.org csvapp[12]: @cee:{\"a\":\"bob\",\"b\":\"12ms\",\"msg\":\"bob,12ms\"}\n"
+	if got := msg.String(); want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func TestParserOptionContentDecoderJSON(t *testing.T) {
+	jsonDecoder := captainslog.ContentDecoderFunc(func(content []byte) (map[string]interface{}, error) {
+		var m map[string]interface{}
+		err := json.Unmarshal(content, &m)
+		return m, err
+	})
+
+	input := "<191>2006-01-02T15:04:05.999999-07:00 host.example.org app[12]: {\"a\":\"b\"}\n"
+	msg, err := captainslog.NewSyslogMsgFromBytes([]byte(input), captainslog.OptionContentDecoder(jsonDecoder))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !msg.IsJSON {
+		t.Error("want a JSON message")
+	}
+	if got := msg.String(); input != got {
+		t.Errorf("want %q, got %q", input, got)
+	}
+}
+
+func TestBuiltInContentDecoders(t *testing.T) {
+	for _, decoder := range []captainslog.ContentDecoder{captainslog.JSONContentDecoder, captainslog.GJSONContentDecoder} {
+		m, err := decoder.DecodeContent([]byte(`{"a":"b"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want, got := "b", m["a"]; want != got {
+			t.Errorf("want %q, got %q", want, got)
+		}
+
+		if _, err := decoder.DecodeContent([]byte(`{"a":`)); err == nil {
+			t.Error("Did not get error decoding bad JSON")
+		}
+	}
+
+	m, err := captainslog.LogfmtContentDecoder.DecodeContent([]byte("a=b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := "b", m["a"]; want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+
+	m, err = captainslog.LogfmtContentDecoder.DecodeContent([]byte("plain text"))
+	if m != nil || err != nil {
+		t.Errorf("want nil, nil, got %v, %v", m, err)
+	}
+}
@@ -0,0 +1,695 @@
+package captainslog
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// diskQueueSegmentSize is the default size a DiskQueue segment
+	// grows to before a new one is started.
+	diskQueueSegmentSize = 16 * 1024 * 1024
+
+	// diskQueueMaxRecordSize limits the size of a record, so a corrupt
+	// length can't exhaust memory.
+	diskQueueMaxRecordSize = 16 * 1024 * 1024
+
+	// diskQueueAckInterval is how often acks are written to the ack
+	// file, unless every message is synced.
+	diskQueueAckInterval = time.Second
+
+	// diskQueueHeaderSize is the size of a record header: the length
+	// of the payload, the CRC32 of the encoding and the payload, and
+	// the encoding.
+	diskQueueHeaderSize = 9
+
+	// diskQueueEncodingRFC3164 is the encoding of records holding a
+	// message as SyslogMsg.Bytes().
+	diskQueueEncodingRFC3164 = 1
+
+	// diskQueueEncodingBinary is the encoding of records holding a
+	// message as SyslogMsg.MarshalBinary().
+	diskQueueEncodingBinary = 2
+
+	diskQueueSegmentExt = ".seg"
+	diskQueueAckFile    = "ack"
+)
+
+var (
+	//ErrBadQueueRecord is returned when a DiskQueue record can't be decoded.
+	ErrBadQueueRecord = errors.New("Queue record not valid")
+
+	// diskQueueMagic starts every segment file.
+	diskQueueMagic = []byte("CLQ1")
+
+	diskQueueCRCTable = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// DiskQueue is a persistent queue of SyslogMsgs, stored in a directory
+// as a log of append-only segment files. It is a Sink, so messages are
+// added with Send, and a Listener, so they are read back in order with
+// Receive. Forward sends the messages to another Sink. Messages stay in
+// the queue until they are acked, and the ones that were not acked
+// are returned again after a restart. Delivery is at-least-once: acks
+// are written to the ack file at most once a second, unless every
+// message is synced, so after a crash the messages acked since the
+// last write are returned again too.
+//
+// Each record in a segment has a header with its length and a CRC32,
+// so a record that was only partly written when the process crashed
+// is detected and removed when the queue is opened again, along with
+// everything after it in its segment. A segment is removed once all
+// of its messages are acked.
+//
+// A DiskQueue is meant to have one reader, and must not be opened by
+// more than one process at a time.
+type DiskQueue struct {
+	dir           string
+	segmentSize   int64
+	maxDiskUsage  int64
+	policy        BackPressurePolicy
+	syncEvery     bool
+	parserOptions []func(*Parser)
+	errorHandler  func(err error)
+
+	segments   []*queueSegment
+	writer     *os.File
+	reader     *os.File
+	readSeg    *queueSegment
+	readOffset int64
+	readIndex  uint64
+	readSeq    uint64
+	ackSeq     uint64
+	nextSeq    uint64
+	usage      int64
+	ackWritten time.Time
+	dropped    uint64
+	closed     bool
+	done       chan struct{}
+	mutex      sync.Mutex
+	cond       *sync.Cond
+}
+
+// queueSegment is a segment file of a DiskQueue, named after the
+// sequence number of its first record.
+type queueSegment struct {
+	base   uint64
+	count  uint64
+	size   int64
+	path   string
+	sealed bool
+}
+
+// NewDiskQueue opens the queue in dir, creating it if needed, and
+// recovers the messages that were not acked.
+func NewDiskQueue(dir string, options ...func(*DiskQueue)) (*DiskQueue, error) {
+	q := DiskQueue{
+		dir:          dir,
+		segmentSize:  diskQueueSegmentSize,
+		errorHandler: func(err error) {},
+		done:         make(chan struct{}),
+	}
+	q.cond = sync.NewCond(&q.mutex)
+	for _, option := range options {
+		option(&q)
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	if err := q.recover(); err != nil {
+		return nil, err
+	}
+	return &q, nil
+}
+
+// DiskQueueOptionSegmentSize sets the size a segment grows to before a
+// new one is started. The default is 16MiB.
+func DiskQueueOptionSegmentSize(size int64) func(*DiskQueue) {
+	return func(q *DiskQueue) {
+		q.segmentSize = size
+	}
+}
+
+// DiskQueueOptionMaxDiskUsage sets the size the segments can take up.
+// When a message doesn't fit, the overflow policy applies. The default
+// is no limit.
+func DiskQueueOptionMaxDiskUsage(size int64) func(*DiskQueue) {
+	return func(q *DiskQueue) {
+		q.maxDiskUsage = size
+	}
+}
+
+// DiskQueueOptionOverflow sets what Send does when the queue is at its
+// maximum disk usage. BackPressureBlock, the default, blocks until
+// messages are acked, BackPressureDropOldest removes the oldest
+// segment, and BackPressureDropNewest returns ErrQueueFull.
+func DiskQueueOptionOverflow(policy BackPressurePolicy) func(*DiskQueue) {
+	return func(q *DiskQueue) {
+		q.policy = policy
+	}
+}
+
+// DiskQueueOptionSyncEveryMessage sets the DiskQueue to fsync every
+// message and ack. By default segments are synced when they are full
+// and when the queue is closed, so a crash of the process loses no
+// messages, but a crash of the operating system can.
+func DiskQueueOptionSyncEveryMessage(q *DiskQueue) {
+	q.syncEvery = true
+}
+
+// DiskQueueOptionParser sets the options of the Parser used to decode
+// messages stored as RFC3164, by older versions of DiskQueue or when
+// their JSONValues can't be binary encoded.
+func DiskQueueOptionParser(options ...func(*Parser)) func(*DiskQueue) {
+	return func(q *DiskQueue) {
+		q.parserOptions = options
+	}
+}
+
+// DiskQueueOptionErrorHandler sets a function called with errors that
+// Forward retries and with errors writing the ack file.
+func DiskQueueOptionErrorHandler(handler func(err error)) func(*DiskQueue) {
+	return func(q *DiskQueue) {
+		q.errorHandler = handler
+	}
+}
+
+// Send appends the message to the queue, encoded with
+// SyslogMsg.MarshalBinary so Receive returns it unchanged. Once the
+// queue is closed, Send returns os.ErrClosed.
+func (q *DiskQueue) Send(msg SyslogMsg) error {
+	var record []byte
+	if payload, err := msg.MarshalBinary(); err == nil {
+		record = encodeQueueRecord(diskQueueEncodingBinary, payload)
+	} else {
+		record = encodeQueueRecord(diskQueueEncodingRFC3164, msg.Bytes(OptionUseRemoteFormat))
+	}
+	if len(record) > diskQueueMaxRecordSize {
+		return ErrMessageTooLong
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for !q.closed && q.maxDiskUsage > 0 && q.usage > 0 && q.usage+int64(len(record)) > q.maxDiskUsage {
+		switch q.policy {
+		case BackPressureDropNewest:
+			q.dropped++
+			return ErrQueueFull
+		case BackPressureDropOldest:
+			if err := q.dropOldest(); err != nil {
+				return err
+			}
+		default:
+			q.cond.Wait()
+		}
+	}
+	if q.closed {
+		return os.ErrClosed
+	}
+
+	seg, err := q.writable(int64(len(record)))
+	if err != nil {
+		return err
+	}
+	n, err := q.writer.Write(record)
+	seg.size += int64(n)
+	q.usage += int64(n)
+	if err != nil {
+		// the partial record is removed when the queue is opened
+		// again, and the segment is not written to any more
+		seg.sealed = true
+		q.closeWriter()
+		return err
+	}
+	seg.count++
+	q.nextSeq++
+	q.cond.Broadcast()
+
+	if q.syncEvery {
+		return q.writer.Sync()
+	}
+	return nil
+}
+
+// Receive blocks until there is a message in the queue and returns it.
+// The message returned by Receive is acked once Receive is called
+// again or Ack is called. A record that can't be decoded is returned
+// with an error. Once the queue is closed, Receive returns
+// net.ErrClosed.
+func (q *DiskQueue) Receive() (SyslogMsg, error) {
+	q.mutex.Lock()
+	q.ack()
+	for !q.closed && q.readSeq >= q.nextSeq {
+		q.cond.Wait()
+	}
+	if q.closed {
+		q.mutex.Unlock()
+		return SyslogMsg{}, net.ErrClosed
+	}
+	encoding, payload, err := q.read()
+	q.mutex.Unlock()
+	if err != nil {
+		return SyslogMsg{}, err
+	}
+	return q.decode(encoding, payload)
+}
+
+// Ack acks the messages returned by Receive, so they are removed from
+// the queue.
+func (q *DiskQueue) Ack() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.ack()
+}
+
+// Forward sends the messages in the queue to the sink until the queue
+// is closed, and acks each one once it was sent. Failed sends are
+// retried after retryInterval.
+func (q *DiskQueue) Forward(sink Sink, retryInterval time.Duration) {
+	for {
+		msg, err := q.Receive()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			q.errorHandler(err)
+			continue
+		}
+
+		for {
+			err := sink.Send(msg)
+			if err == nil {
+				break
+			}
+			q.errorHandler(err)
+			select {
+			case <-time.After(retryInterval):
+			case <-q.done:
+				return
+			}
+		}
+		q.Ack()
+	}
+}
+
+// Len returns the number of messages in the queue that were not acked.
+func (q *DiskQueue) Len() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return int(q.nextSeq - q.ackSeq)
+}
+
+// Dropped returns the number of messages dropped by the overflow
+// policy.
+func (q *DiskQueue) Dropped() uint64 {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.dropped
+}
+
+// Close syncs the queue and closes its files. Messages returned by
+// Receive that were not acked stay in the queue.
+func (q *DiskQueue) Close() error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if q.closed {
+		return nil
+	}
+	q.closed = true
+	close(q.done)
+	q.cond.Broadcast()
+
+	err := q.writeAck()
+	if e := q.closeWriter(); err == nil {
+		err = e
+	}
+	if q.reader != nil {
+		q.reader.Close()
+		q.reader = nil
+	}
+	return err
+}
+
+// recover loads the segments and the ack file, removing records that
+// were partly written and segments that were acked.
+func (q *DiskQueue) recover() error {
+	entries, err := os.ReadDir(q.dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, diskQueueSegmentExt) {
+			continue
+		}
+		base, err := strconv.ParseUint(strings.TrimSuffix(name, diskQueueSegmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		seg, err := recoverSegment(filepath.Join(q.dir, name), base)
+		if err != nil {
+			return err
+		}
+		if seg.count == 0 {
+			if err := os.Remove(seg.path); err != nil {
+				return err
+			}
+			continue
+		}
+		q.segments = append(q.segments, seg)
+	}
+	sort.Slice(q.segments, func(i, j int) bool {
+		return q.segments[i].base < q.segments[j].base
+	})
+
+	q.ackSeq = q.readAck()
+	if n := len(q.segments); n > 0 {
+		last := q.segments[n-1]
+		q.nextSeq = last.base + last.count
+	}
+	if q.nextSeq < q.ackSeq {
+		// records that were acked were lost, so new records start
+		// after the ack in a new segment
+		q.nextSeq = q.ackSeq
+	}
+	if len(q.segments) > 0 && q.segments[0].base > q.ackSeq {
+		q.ackSeq = q.segments[0].base
+	}
+	q.readSeq = q.ackSeq
+
+	for _, seg := range q.segments {
+		q.usage += seg.size
+	}
+	return q.removeAcked()
+}
+
+// recoverSegment scans a segment file and truncates it after its last
+// complete record.
+func recoverSegment(path string, base uint64) (*queueSegment, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	seg := queueSegment{base: base, path: path, size: int64(len(diskQueueMagic))}
+	if len(b) < len(diskQueueMagic) || string(b[:len(diskQueueMagic)]) != string(diskQueueMagic) {
+		// the header itself was not written
+		seg.size = 0
+	} else {
+		for {
+			n, ok := decodeQueueRecordSize(b[seg.size:])
+			if !ok {
+				break
+			}
+			seg.size += n
+			seg.count++
+		}
+	}
+
+	if seg.size < int64(len(b)) {
+		if err := os.Truncate(path, seg.size); err != nil {
+			return nil, err
+		}
+	}
+	return &seg, nil
+}
+
+// readAck returns the sequence number of the first message that was
+// not acked, or 0 if the ack file is missing or corrupt, in which case
+// all the messages in the segments are returned again.
+func (q *DiskQueue) readAck() uint64 {
+	b, err := os.ReadFile(filepath.Join(q.dir, diskQueueAckFile))
+	if err != nil || len(b) != 12 || crc32.Checksum(b[:8], diskQueueCRCTable) != binary.BigEndian.Uint32(b[8:]) {
+		return 0
+	}
+	return binary.BigEndian.Uint64(b)
+}
+
+// writeAck replaces the ack file. The mutex must be held.
+func (q *DiskQueue) writeAck() error {
+	b := make([]byte, 12)
+	binary.BigEndian.PutUint64(b, q.ackSeq)
+	binary.BigEndian.PutUint32(b[8:], crc32.Checksum(b[:8], diskQueueCRCTable))
+
+	path := filepath.Join(q.dir, diskQueueAckFile)
+	f, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if err == nil && q.syncEvery {
+		err = f.Sync()
+	}
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		return err
+	}
+	q.ackWritten = time.Now()
+	return os.Rename(path+".tmp", path)
+}
+
+// ack acks the messages returned by Receive, and removes the segments
+// that were completely acked. The mutex must be held.
+func (q *DiskQueue) ack() {
+	if q.closed || q.ackSeq == q.readSeq {
+		return
+	}
+	q.ackSeq = q.readSeq
+
+	if q.syncEvery || time.Since(q.ackWritten) >= diskQueueAckInterval {
+		if err := q.writeAck(); err != nil {
+			q.errorHandler(err)
+		}
+	}
+	if err := q.removeAcked(); err != nil {
+		q.errorHandler(err)
+	}
+	q.cond.Broadcast()
+}
+
+// removeAcked removes the segments whose messages were all acked. The
+// segment being written is only removed once the queue is empty. The
+// mutex must be held.
+func (q *DiskQueue) removeAcked() error {
+	for len(q.segments) > 0 {
+		seg := q.segments[0]
+		if seg.base+seg.count > q.ackSeq || len(q.segments) == 1 && q.ackSeq < q.nextSeq {
+			return nil
+		}
+		if err := q.removeSegment(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dropOldest removes the oldest segment, acked or not. The mutex must
+// be held.
+func (q *DiskQueue) dropOldest() error {
+	seg := q.segments[0]
+	if end := seg.base + seg.count; end > q.ackSeq {
+		q.dropped += end - q.ackSeq
+		q.ackSeq = end
+	}
+	if q.readSeq < q.ackSeq {
+		q.readSeq = q.ackSeq
+	}
+	if len(q.segments) == 1 {
+		q.nextSeq = q.ackSeq
+	}
+	if err := q.removeSegment(); err != nil {
+		return err
+	}
+	// the segments must not be replayed if the process crashes
+	return q.writeAck()
+}
+
+// removeSegment removes the oldest segment. The mutex must be held.
+func (q *DiskQueue) removeSegment() error {
+	seg := q.segments[0]
+	if seg == q.readSeg {
+		q.reader.Close()
+		q.reader = nil
+		q.readSeg = nil
+	}
+	if len(q.segments) == 1 && q.writer != nil {
+		// the segment is removed, so it is not synced
+		q.writer.Close()
+		q.writer = nil
+	}
+	if err := os.Remove(seg.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	q.usage -= seg.size
+	q.segments = q.segments[1:]
+	return nil
+}
+
+// writable returns the segment a record is appended to, starting a new
+// segment if the last one is full or was not written by this process.
+// The mutex must be held.
+func (q *DiskQueue) writable(size int64) (*queueSegment, error) {
+	if n := len(q.segments); n > 0 {
+		last := q.segments[n-1]
+		fits := last.count == 0 || last.size+size <= q.segmentSize
+		if q.writer != nil && fits {
+			return last, nil
+		}
+		if q.writer == nil && fits && !last.sealed && last.base+last.count == q.nextSeq && last.count > 0 {
+			f, err := os.OpenFile(last.path, os.O_WRONLY|os.O_APPEND, 0644)
+			if err != nil {
+				return nil, err
+			}
+			q.writer = f
+			return last, nil
+		}
+	}
+	if err := q.closeWriter(); err != nil {
+		return nil, err
+	}
+	if n := len(q.segments); n > 0 && q.segments[n-1].count == 0 {
+		// a segment whose first write failed is replaced
+		q.usage -= q.segments[n-1].size
+		q.segments = q.segments[:n-1]
+	}
+
+	seg := &queueSegment{base: q.nextSeq, path: filepath.Join(q.dir, fmt.Sprintf("%020d%s", q.nextSeq, diskQueueSegmentExt))}
+	f, err := os.OpenFile(seg.path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Write(diskQueueMagic); err != nil {
+		f.Close()
+		os.Remove(seg.path)
+		return nil, err
+	}
+	seg.size = int64(len(diskQueueMagic))
+	q.usage += seg.size
+	q.writer = f
+	q.segments = append(q.segments, seg)
+	return seg, nil
+}
+
+// closeWriter syncs and closes the segment being written. The mutex
+// must be held.
+func (q *DiskQueue) closeWriter() error {
+	if q.writer == nil {
+		return nil
+	}
+	err := q.writer.Sync()
+	if e := q.writer.Close(); err == nil {
+		err = e
+	}
+	q.writer = nil
+	return err
+}
+
+// read reads the record at readSeq and advances readSeq. The mutex must
+// be held.
+func (q *DiskQueue) read() (byte, []byte, error) {
+	var seg *queueSegment
+	for _, s := range q.segments {
+		if s.base+s.count > q.readSeq {
+			seg = s
+			break
+		}
+	}
+	if seg == nil {
+		q.readSeq = q.nextSeq
+		return 0, nil, ErrBadQueueRecord
+	}
+	if q.readSeq < seg.base {
+		q.readSeq = seg.base
+	}
+
+	if seg != q.readSeg || q.readIndex != q.readSeq-seg.base {
+		if q.reader != nil {
+			q.reader.Close()
+			q.reader = nil
+		}
+		f, err := os.Open(seg.path)
+		if err != nil {
+			return 0, nil, err
+		}
+		q.reader, q.readSeg, q.readIndex, q.readOffset = f, seg, 0, int64(len(diskQueueMagic))
+
+		// skip the records that were already read
+		header := make([]byte, diskQueueHeaderSize)
+		for q.readIndex < q.readSeq-seg.base {
+			if _, err := q.reader.ReadAt(header, q.readOffset); err != nil {
+				return 0, nil, err
+			}
+			q.readOffset += diskQueueHeaderSize + int64(binary.BigEndian.Uint32(header))
+			q.readIndex++
+		}
+	}
+
+	header := make([]byte, diskQueueHeaderSize)
+	if _, err := q.reader.ReadAt(header, q.readOffset); err != nil {
+		return 0, nil, err
+	}
+	payload := make([]byte, binary.BigEndian.Uint32(header))
+	if _, err := q.reader.ReadAt(payload, q.readOffset+diskQueueHeaderSize); err != nil && err != io.EOF {
+		return 0, nil, err
+	}
+	q.readOffset += diskQueueHeaderSize + int64(len(payload))
+	q.readIndex++
+	q.readSeq++
+	return header[8], payload, nil
+}
+
+// decode decodes the payload of a record.
+func (q *DiskQueue) decode(encoding byte, payload []byte) (SyslogMsg, error) {
+	switch encoding {
+	case diskQueueEncodingRFC3164:
+		return NewParser(q.parserOptions...).ParseBytes(payload)
+	case diskQueueEncodingBinary:
+		msg, err := NewSyslogMsgFromBinary(payload)
+		if err != nil {
+			return SyslogMsg{}, ErrBadQueueRecord
+		}
+		return msg, nil
+	}
+	return SyslogMsg{}, ErrBadQueueRecord
+}
+
+// encodeQueueRecord returns a record with its header.
+func encodeQueueRecord(encoding byte, payload []byte) []byte {
+	record := make([]byte, diskQueueHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(record, uint32(len(payload)))
+	record[8] = encoding
+	copy(record[diskQueueHeaderSize:], payload)
+	binary.BigEndian.PutUint32(record[4:], crc32.Checksum(record[8:], diskQueueCRCTable))
+	return record
+}
+
+// decodeQueueRecordSize returns the size of the complete record at the
+// start of b, and false if b doesn't start with one.
+func decodeQueueRecordSize(b []byte) (int64, bool) {
+	if len(b) < diskQueueHeaderSize {
+		return 0, false
+	}
+	n := int64(binary.BigEndian.Uint32(b))
+	if n > diskQueueMaxRecordSize || int64(len(b)) < diskQueueHeaderSize+n {
+		return 0, false
+	}
+	if crc32.Checksum(b[8:diskQueueHeaderSize+n], diskQueueCRCTable) != binary.BigEndian.Uint32(b[4:]) {
+		return 0, false
+	}
+	return diskQueueHeaderSize + n, true
+}
@@ -0,0 +1,414 @@
+package captainslog_test
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/captainslog"
+)
+
+func queueMsg(t *testing.T, content string) captainslog.SyslogMsg {
+	t.Helper()
+	return fileSinkMsg(t, "host.example.org", "prog", content)
+}
+
+func newDiskQueue(t *testing.T, dir string, options ...func(*captainslog.DiskQueue)) *captainslog.DiskQueue {
+	t.Helper()
+	q, err := captainslog.NewDiskQueue(dir, options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+func sendQueue(t *testing.T, q *captainslog.DiskQueue, contents ...string) {
+	t.Helper()
+	for _, content := range contents {
+		if err := q.Send(queueMsg(t, content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// segments returns the names of the segment files in a queue directory.
+func segments(t *testing.T, dir string) []string {
+	t.Helper()
+	var names []string
+	for _, name := range listDir(t, dir) {
+		if strings.HasSuffix(name, ".seg") {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func TestDiskQueue(t *testing.T) {
+	dir := t.TempDir()
+	q := newDiskQueue(t, dir)
+	sendQueue(t, q, "1", "2", "3")
+
+	if want, got := []string{" 1", " 2"}, receiveContents(t, q, 2); !equalStrings(want, got) {
+		t.Errorf("want %q, got %q", want, got)
+	}
+	q.Ack()
+	if want, got := 1, q.Len(); want != got {
+		t.Errorf("want %d, got %d", want, got)
+	}
+	if err := q.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.Receive(); err == nil {
+		t.Errorf("want error after Close")
+	}
+	if err := q.Send(queueMsg(t, "late")); err == nil {
+		t.Errorf("want error after Close")
+	}
+
+	q = newDiskQueue(t, dir)
+	defer q.Close()
+	sendQueue(t, q, "4")
+	if want, got := []string{" 3", " 4"}, receiveContents(t, q, 2); !equalStrings(want, got) {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func TestDiskQueueReplaysUnacked(t *testing.T) {
+	dir := t.TempDir()
+	q := newDiskQueue(t, dir)
+	sendQueue(t, q, "1", "2")
+	receiveContents(t, q, 1)
+	if err := q.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	q = newDiskQueue(t, dir)
+	defer q.Close()
+	if want, got := []string{" 1", " 2"}, receiveContents(t, q, 2); !equalStrings(want, got) {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func TestDiskQueueCrashRecovery(t *testing.T) {
+	testCases := []struct {
+		name  string
+		crash func(t *testing.T, dir, segment string)
+		want  []string
+	}{
+		{
+			name:  "torn header",
+			crash: func(t *testing.T, dir, segment string) { appendFile(t, segment, "\x00\x00\x01") },
+			want:  []string{" 2", " 3"},
+		},
+		{
+			name: "torn payload",
+			crash: func(t *testing.T, dir, segment string) {
+				appendFile(t, segment, "\x00\x00\x00\x40\x01\x02\x03\x04\x01<38>2006")
+			},
+			want: []string{" 2", " 3"},
+		},
+		{
+			name: "corrupt last record",
+			crash: func(t *testing.T, dir, segment string) {
+				b, err := os.ReadFile(segment)
+				if err != nil {
+					t.Fatal(err)
+				}
+				b[len(b)-2] ^= 0xff
+				if err := os.WriteFile(segment, b, 0644); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: []string{" 2"},
+		},
+		{
+			name: "missing ack file",
+			crash: func(t *testing.T, dir, segment string) {
+				if err := os.Remove(filepath.Join(dir, "ack")); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: []string{" 1", " 2", " 3"},
+		},
+		{
+			name:  "corrupt ack file",
+			crash: func(t *testing.T, dir, segment string) { appendFile(t, filepath.Join(dir, "ack"), "x") },
+			want:  []string{" 1", " 2", " 3"},
+		},
+		{
+			name: "segment without header",
+			crash: func(t *testing.T, dir, segment string) {
+				appendFile(t, filepath.Join(dir, "00000000000000000100.seg"), "CL")
+			},
+			want: []string{" 2", " 3"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			q := newDiskQueue(t, dir)
+			sendQueue(t, q, "1", "2", "3")
+			receiveContents(t, q, 1)
+			q.Ack()
+			if err := q.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			names := segments(t, dir)
+			tc.crash(t, dir, filepath.Join(dir, names[len(names)-1]))
+
+			q = newDiskQueue(t, dir)
+			sendQueue(t, q, "4")
+			want := append(tc.want, " 4")
+			if got := receiveContents(t, q, len(want)); !equalStrings(want, got) {
+				t.Errorf("want %q, got %q", want, got)
+			}
+			q.Ack()
+			if err := q.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			// the recovered queue is consistent
+			q = newDiskQueue(t, dir)
+			defer q.Close()
+			if want, got := 0, q.Len(); want != got {
+				t.Errorf("want %d, got %d", want, got)
+			}
+			sendQueue(t, q, "5")
+			if want, got := []string{" 5"}, receiveContents(t, q, 1); !equalStrings(want, got) {
+				t.Errorf("want %q, got %q", want, got)
+			}
+		})
+	}
+}
+
+func TestDiskQueueCrashWithoutClose(t *testing.T) {
+	dir := t.TempDir()
+	crashed := newDiskQueue(t, dir)
+	sendQueue(t, crashed, "1", "2", "3")
+	receiveContents(t, crashed, 1)
+	crashed.Ack()
+
+	// the first queue is never closed, as if its process was killed
+	q := newDiskQueue(t, dir)
+	defer q.Close()
+	if want, got := []string{" 2", " 3"}, receiveContents(t, q, 2); !equalStrings(want, got) {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func TestDiskQueueLossless(t *testing.T) {
+	msg, err := captainslog.NewSyslogMsgFromBytes([]byte("<38>Mar  8 14:59:36 host.example.org prog: @cee:{\"n\":1}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg.JSONValues["count"] = int64(2)
+
+	q := newDiskQueue(t, t.TempDir())
+	defer q.Close()
+	if err := q.Send(msg); err != nil {
+		t.Fatal(err)
+	}
+	got, err := q.Receive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := msg.JSONValues["count"], got.JSONValues["count"]; want != got {
+		t.Errorf("want %#v, got %#v", want, got)
+	}
+	if want, got := msg.String(), got.String(); want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func TestDiskQueueRFC3164Records(t *testing.T) {
+	// a segment written before messages were binary encoded
+	dir := t.TempDir()
+	segment := []byte("CLQ1")
+	for _, content := range []string{"1", "2"} {
+		msg := queueMsg(t, content)
+		payload := append([]byte{1}, msg.Bytes(captainslog.OptionUseRemoteFormat)...)
+		segment = binary.BigEndian.AppendUint32(segment, uint32(len(payload)-1))
+		segment = binary.BigEndian.AppendUint32(segment, crc32.Checksum(payload, crc32.MakeTable(crc32.Castagnoli)))
+		segment = append(segment, payload...)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "00000000000000000000.seg"), segment, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	q := newDiskQueue(t, dir)
+	defer q.Close()
+	sendQueue(t, q, "3")
+	if want, got := []string{" 1", " 2", " 3"}, receiveContents(t, q, 3); !equalStrings(want, got) {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func TestDiskQueueSegments(t *testing.T) {
+	dir := t.TempDir()
+	q := newDiskQueue(t, dir, captainslog.DiskQueueOptionSegmentSize(1), captainslog.DiskQueueOptionSyncEveryMessage)
+	defer q.Close()
+
+	sendQueue(t, q, "1", "2", "3")
+	if want, got := 3, len(segments(t, dir)); want != got {
+		t.Errorf("want %d segments, got %d", want, got)
+	}
+
+	receiveContents(t, q, 2)
+	q.Ack()
+	if want, got := 1, len(segments(t, dir)); want != got {
+		t.Errorf("want %d segments, got %d", want, got)
+	}
+
+	receiveContents(t, q, 1)
+	q.Ack()
+	if got := segments(t, dir); len(got) != 0 {
+		t.Errorf("want no segments, got %q", got)
+	}
+}
+
+func TestDiskQueueOverflow(t *testing.T) {
+	// each message has its own segment, and two segments fit
+	msg := queueMsg(t, "1")
+	payload, err := msg.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := int64(2 * (4 + 9 + len(payload)))
+	options := func(policy captainslog.BackPressurePolicy) []func(*captainslog.DiskQueue) {
+		return []func(*captainslog.DiskQueue){
+			captainslog.DiskQueueOptionSegmentSize(1),
+			captainslog.DiskQueueOptionMaxDiskUsage(size),
+			captainslog.DiskQueueOptionOverflow(policy),
+		}
+	}
+
+	t.Run("drop newest", func(t *testing.T) {
+		q := newDiskQueue(t, t.TempDir(), options(captainslog.BackPressureDropNewest)...)
+		defer q.Close()
+		sendQueue(t, q, "1", "2")
+		if want, got := captainslog.ErrQueueFull, q.Send(queueMsg(t, "3")); want != got {
+			t.Errorf("want %v, got %v", want, got)
+		}
+		if want, got := uint64(1), q.Dropped(); want != got {
+			t.Errorf("want %d, got %d", want, got)
+		}
+		if want, got := []string{" 1", " 2"}, receiveContents(t, q, 2); !equalStrings(want, got) {
+			t.Errorf("want %q, got %q", want, got)
+		}
+	})
+
+	t.Run("drop oldest", func(t *testing.T) {
+		q := newDiskQueue(t, t.TempDir(), options(captainslog.BackPressureDropOldest)...)
+		defer q.Close()
+		sendQueue(t, q, "1", "2", "3", "4")
+		if want, got := uint64(2), q.Dropped(); want != got {
+			t.Errorf("want %d, got %d", want, got)
+		}
+		if want, got := []string{" 3", " 4"}, receiveContents(t, q, 2); !equalStrings(want, got) {
+			t.Errorf("want %q, got %q", want, got)
+		}
+	})
+
+	t.Run("block", func(t *testing.T) {
+		q := newDiskQueue(t, t.TempDir(), options(captainslog.BackPressureBlock)...)
+		defer q.Close()
+		sendQueue(t, q, "1", "2")
+
+		sent := make(chan error, 1)
+		msg := queueMsg(t, "3")
+		go func() {
+			sent <- q.Send(msg)
+		}()
+		select {
+		case err := <-sent:
+			t.Fatalf("want Send to block, got %v", err)
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		receiveContents(t, q, 1)
+		q.Ack()
+		if err := <-sent; err != nil {
+			t.Fatal(err)
+		}
+		if want, got := []string{" 2", " 3"}, receiveContents(t, q, 2); !equalStrings(want, got) {
+			t.Errorf("want %q, got %q", want, got)
+		}
+	})
+}
+
+// downSink fails until it has been called fails times.
+type downSink struct {
+	mutex sync.Mutex
+	fails int
+	got   []string
+}
+
+func (s *downSink) Send(msg captainslog.SyslogMsg) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.fails > 0 {
+		s.fails--
+		return captainslog.ErrQueueFull
+	}
+	s.got = append(s.got, msg.Content)
+	return nil
+}
+
+func (s *downSink) contents() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]string(nil), s.got...)
+}
+
+func TestDiskQueueForward(t *testing.T) {
+	dir := t.TempDir()
+	var errs []error
+	var mutex sync.Mutex
+	q := newDiskQueue(t, dir, captainslog.DiskQueueOptionErrorHandler(func(err error) {
+		mutex.Lock()
+		errs = append(errs, err)
+		mutex.Unlock()
+	}))
+	sendQueue(t, q, "1", "2", "3")
+
+	sink := &downSink{fails: 2}
+	done := make(chan struct{})
+	go func() {
+		q.Forward(sink, time.Millisecond)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(sink.contents()) < 3 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if want, got := []string{" 1", " 2", " 3"}, sink.contents(); !equalStrings(want, got) {
+		t.Errorf("want %q, got %q", want, got)
+	}
+	if err := q.Close(); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+
+	mutex.Lock()
+	if want, got := 2, len(errs); want != got {
+		t.Errorf("want %d retried errors, got %v", want, errs)
+	}
+	mutex.Unlock()
+
+	q = newDiskQueue(t, dir)
+	defer q.Close()
+	if want, got := 0, q.Len(); want != got {
+		t.Errorf("want %d, got %d", want, got)
+	}
+	if got := segments(t, dir); len(got) != 0 {
+		t.Errorf("want no segments, got %q", got)
+	}
+}
@@ -0,0 +1,531 @@
+package captainslog
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// fileSinkMaxOpenFiles is the default number of files a FileSink
+	// keeps open.
+	fileSinkMaxOpenFiles = 100
+
+	// fileSinkTimeFormat is the format of the time in the names of
+	// rotated files, which sort in the order they were rotated.
+	fileSinkTimeFormat = "2006-01-02T15-04-05.000"
+)
+
+var (
+	//ErrBadFilePath is returned when a FileSink path template expands to a path that is not valid.
+	ErrBadFilePath = errors.New("File path not valid")
+)
+
+// FileSink writes SyslogMsgs to files, like the dynafile feature of
+// rsyslog. The path of each message's file is a text/template executed
+// with the message, such as "/logs/{{.Host}}/{{.Tag.Program}}.log".
+// Files are rotated by size or time, and rotated files can be
+// compressed and removed after a number of rotations or an age, in the
+// background so that Send does not wait for them. It is a Sink, and is
+// safe for concurrent use.
+//
+// A rotated file is renamed by adding the UTC time of its rotation to
+// its name, so /logs/web1/nginx.log becomes
+// /logs/web1/nginx-2006-01-02T15-04-05.000.log, followed by the
+// extension of the compression.
+type FileSink struct {
+	template     *template.Template
+	format       func(*SyslogMsg) ([]byte, error)
+	maxSize      int64
+	rotateEvery  time.Duration
+	compressExt  string
+	compress     func(io.Writer) (io.WriteCloser, error)
+	maxBackups   int
+	maxAge       time.Duration
+	syncEvery    bool
+	syncInterval time.Duration
+	maxOpenFiles int
+	errorHandler func(err error)
+	files        map[string]*sinkFile
+	closed       bool
+	done         chan struct{}
+	wg           sync.WaitGroup
+	mutex        sync.Mutex
+
+	// rotatedMutex is held while rotated files are compressed and
+	// removed, one rotation at a time.
+	rotatedMutex sync.Mutex
+}
+
+// sinkFile is a file open in a FileSink.
+type sinkFile struct {
+	path     string
+	file     *os.File
+	size     int64
+	period   time.Time
+	lastUsed time.Time
+	dirty    bool
+}
+
+// NewFileSink returns a new FileSink writing to the files named by the
+// path template. Messages are written as SyslogMsg.Bytes() unless a
+// formatter is set.
+func NewFileSink(pathTemplate string, options ...func(*FileSink)) (*FileSink, error) {
+	tmpl, err := template.New("path").Option("missingkey=zero").Parse(pathTemplate)
+	if err != nil {
+		return nil, err
+	}
+
+	s := FileSink{
+		template: tmpl,
+		format: func(msg *SyslogMsg) ([]byte, error) {
+			return msg.Bytes(), nil
+		},
+		maxOpenFiles: fileSinkMaxOpenFiles,
+		errorHandler: func(err error) {},
+		files:        make(map[string]*sinkFile),
+		done:         make(chan struct{}),
+	}
+	for _, option := range options {
+		option(&s)
+	}
+
+	if s.syncInterval > 0 {
+		s.wg.Add(1)
+		go s.syncFiles()
+	}
+	return &s, nil
+}
+
+// FileSinkOptionFormatter sets the function the FileSink formats
+// messages with. It must return the message followed by a newline.
+func FileSinkOptionFormatter(format func(*SyslogMsg) ([]byte, error)) func(*FileSink) {
+	return func(s *FileSink) {
+		s.format = format
+	}
+}
+
+// FileSinkOptionJSON sets the FileSink to write messages as
+// SyslogMsg.JSON(), one per line.
+func FileSinkOptionJSON(s *FileSink) {
+	s.format = func(msg *SyslogMsg) ([]byte, error) {
+		b, err := msg.JSON()
+		return append(b, '\n'), err
+	}
+}
+
+// FileSinkOptionMaxSize sets the size in bytes a file can grow to
+// before it is rotated.
+func FileSinkOptionMaxSize(size int64) func(*FileSink) {
+	return func(s *FileSink) {
+		s.maxSize = size
+	}
+}
+
+// FileSinkOptionRotateEvery sets the FileSink to rotate files when the
+// UTC time passes a multiple of the interval, such as every hour or
+// every 24 hours at midnight. A file is rotated when the first message
+// after that time is written to it.
+func FileSinkOptionRotateEvery(interval time.Duration) func(*FileSink) {
+	return func(s *FileSink) {
+		s.rotateEvery = interval
+	}
+}
+
+// FileSinkOptionGzip sets the FileSink to compress rotated files with
+// gzip.
+func FileSinkOptionGzip(s *FileSink) {
+	s.compressExt = ".gz"
+	s.compress = func(w io.Writer) (io.WriteCloser, error) {
+		return gzip.NewWriter(w), nil
+	}
+}
+
+// FileSinkOptionZstd sets the FileSink to compress rotated files with
+// zstd.
+func FileSinkOptionZstd(s *FileSink) {
+	s.compressExt = ".zst"
+	s.compress = func(w io.Writer) (io.WriteCloser, error) {
+		return zstd.NewWriter(w)
+	}
+}
+
+// FileSinkOptionCompressor sets the FileSink to compress rotated files
+// with the writers returned by newWriter, and to add ext to their
+// names. It allows other compression formats, such as xz:
+//
+//	captainslog.FileSinkOptionCompressor(".xz", func(w io.Writer) (io.WriteCloser, error) {
+//		return xz.NewWriter(w)
+//	})
+func FileSinkOptionCompressor(ext string, newWriter func(io.Writer) (io.WriteCloser, error)) func(*FileSink) {
+	return func(s *FileSink) {
+		s.compressExt = ext
+		s.compress = newWriter
+	}
+}
+
+// FileSinkOptionMaxBackups sets the number of rotated files kept for
+// each path. Older ones are removed. The default is to keep them all.
+func FileSinkOptionMaxBackups(n int) func(*FileSink) {
+	return func(s *FileSink) {
+		s.maxBackups = n
+	}
+}
+
+// FileSinkOptionMaxAge sets how long rotated files are kept.
+func FileSinkOptionMaxAge(age time.Duration) func(*FileSink) {
+	return func(s *FileSink) {
+		s.maxAge = age
+	}
+}
+
+// FileSinkOptionSyncEveryMessage sets the FileSink to fsync files after
+// every message. By default, files are only synced when they are
+// rotated or closed.
+func FileSinkOptionSyncEveryMessage(s *FileSink) {
+	s.syncEvery = true
+}
+
+// FileSinkOptionSyncInterval sets the FileSink to fsync files that were
+// written to at the interval.
+func FileSinkOptionSyncInterval(interval time.Duration) func(*FileSink) {
+	return func(s *FileSink) {
+		s.syncInterval = interval
+	}
+}
+
+// FileSinkOptionMaxOpenFiles sets the number of files the FileSink
+// keeps open. The least recently used file is closed to open another.
+// The default is 100.
+func FileSinkOptionMaxOpenFiles(n int) func(*FileSink) {
+	return func(s *FileSink) {
+		s.maxOpenFiles = n
+	}
+}
+
+// FileSinkOptionErrorHandler sets a function called with errors that
+// happen after a message was written, when compressing and removing
+// rotated files and syncing files at an interval. It is called from
+// the FileSink's own goroutines.
+func FileSinkOptionErrorHandler(handler func(err error)) func(*FileSink) {
+	return func(s *FileSink) {
+		s.errorHandler = handler
+	}
+}
+
+// Send writes the message to its file, rotating the file first if
+// needed. Once the FileSink is closed, Send returns os.ErrClosed.
+func (s *FileSink) Send(msg SyslogMsg) error {
+	b, err := s.format(&msg)
+	if err != nil {
+		return err
+	}
+	path, err := s.path(&msg)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return os.ErrClosed
+	}
+
+	now := time.Now()
+	f, err := s.open(path, now)
+	if err != nil {
+		return err
+	}
+	period := f.period
+	if s.rotateEvery > 0 {
+		period = now.Truncate(s.rotateEvery)
+	}
+	if f.size > 0 && (!period.Equal(f.period) || s.maxSize > 0 && f.size+int64(len(b)) > s.maxSize) {
+		if err := s.rotate(f, now); err != nil {
+			return err
+		}
+		if f, err = s.open(path, now); err != nil {
+			return err
+		}
+	}
+	f.period = period
+
+	n, err := f.file.Write(b)
+	f.size += int64(n)
+	f.lastUsed = now
+	if err != nil {
+		return err
+	}
+	if s.syncEvery {
+		return f.file.Sync()
+	}
+	f.dirty = true
+	return nil
+}
+
+// Close syncs and closes the open files, and waits for rotated files
+// to be compressed.
+func (s *FileSink) Close() error {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.done)
+
+	var err error
+	for path, f := range s.files {
+		if e := closeSinkFile(f); e != nil && err == nil {
+			err = e
+		}
+		delete(s.files, path)
+	}
+	s.mutex.Unlock()
+
+	s.wg.Wait()
+	return err
+}
+
+// path executes the path template with the message. Paths with ".."
+// elements, such as from a host named "..", are not valid.
+func (s *FileSink) path(msg *SyslogMsg) (string, error) {
+	var b bytes.Buffer
+	if err := s.template.Execute(&b, msg); err != nil {
+		return "", err
+	}
+	path := b.String()
+	if path == "" || strings.HasSuffix(path, "/") {
+		return "", ErrBadFilePath
+	}
+	for _, element := range strings.Split(filepath.ToSlash(path), "/") {
+		if element == ".." {
+			return "", ErrBadFilePath
+		}
+	}
+	return filepath.Clean(path), nil
+}
+
+// open returns the open file for the path, opening it if needed. The
+// mutex must be held.
+func (s *FileSink) open(path string, now time.Time) (*sinkFile, error) {
+	if f, ok := s.files[path]; ok {
+		return f, nil
+	}
+
+	if s.maxOpenFiles > 0 && len(s.files) >= s.maxOpenFiles {
+		var oldest *sinkFile
+		for _, f := range s.files {
+			if oldest == nil || f.lastUsed.Before(oldest.lastUsed) {
+				oldest = f
+			}
+		}
+		delete(s.files, oldest.path)
+		if err := closeSinkFile(oldest); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	f := &sinkFile{path: path, file: file, size: info.Size(), lastUsed: now}
+	if s.rotateEvery > 0 {
+		// a file left by an earlier run belongs to the period it
+		// was last written in
+		modified := now
+		if info.Size() > 0 {
+			modified = info.ModTime()
+		}
+		f.period = modified.Truncate(s.rotateEvery)
+	}
+	s.files[path] = f
+	return f, nil
+}
+
+// rotate closes a file and renames it, and then compresses it and
+// removes old rotated files in the background. Errors after the file
+// was renamed are passed to the error handler. The mutex must be held.
+func (s *FileSink) rotate(f *sinkFile, now time.Time) error {
+	delete(s.files, f.path)
+	if err := closeSinkFile(f); err != nil {
+		return err
+	}
+
+	ext := filepath.Ext(f.path)
+	base := strings.TrimSuffix(f.path, ext) + "-" + now.UTC().Format(fileSinkTimeFormat)
+	rotated := base + ext
+	for i := 1; fileExists(rotated) || fileExists(rotated+s.compressExt); i++ {
+		rotated = base + "." + strconv.Itoa(i) + ext
+	}
+	if err := os.Rename(f.path, rotated); err != nil {
+		return err
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.rotatedMutex.Lock()
+		defer s.rotatedMutex.Unlock()
+
+		// the file may already have been removed as an old backup
+		if s.compress != nil {
+			if err := s.compressFile(rotated); err != nil && !os.IsNotExist(err) {
+				s.errorHandler(err)
+			}
+		}
+		if err := s.removeBackups(f.path, now); err != nil {
+			s.errorHandler(err)
+		}
+	}()
+	return nil
+}
+
+// compressFile replaces a rotated file with its compressed copy.
+func (s *FileSink) compressFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(path+s.compressExt, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	w, err := s.compress(out)
+	if err == nil {
+		if _, err = io.Copy(w, in); err == nil {
+			err = w.Close()
+		}
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	if e := out.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		os.Remove(path + s.compressExt)
+		return err
+	}
+	return os.Remove(path)
+}
+
+// removeBackups removes the rotated files of a path beyond the maximum
+// number of backups or older than the maximum age.
+func (s *FileSink) removeBackups(path string, now time.Time) error {
+	if s.maxBackups <= 0 && s.maxAge <= 0 {
+		return nil
+	}
+
+	ext := filepath.Ext(path)
+	prefix := filepath.Base(strings.TrimSuffix(path, ext)) + "-"
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+
+	type backup struct {
+		name    string
+		rotated time.Time
+		index   int
+	}
+	var backups []backup
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, prefix) || len(name) < len(prefix)+len(fileSinkTimeFormat) {
+			continue
+		}
+		rest := strings.TrimSuffix(name[len(prefix)+len(fileSinkTimeFormat):], s.compressExt)
+		if !strings.HasSuffix(rest, ext) {
+			continue
+		}
+		rotated, err := time.Parse(fileSinkTimeFormat, name[len(prefix):len(prefix)+len(fileSinkTimeFormat)])
+		if err != nil {
+			continue
+		}
+		// files rotated in the same millisecond are numbered
+		index := 0
+		if n := strings.TrimSuffix(rest, ext); n != "" {
+			if index, err = strconv.Atoi(strings.TrimPrefix(n, ".")); err != nil {
+				continue
+			}
+		}
+		backups = append(backups, backup{name, rotated, index})
+	}
+
+	// newest first
+	sort.Slice(backups, func(i, j int) bool {
+		if !backups[i].rotated.Equal(backups[j].rotated) {
+			return backups[i].rotated.After(backups[j].rotated)
+		}
+		return backups[i].index > backups[j].index
+	})
+	for i, b := range backups {
+		if s.maxBackups > 0 && i >= s.maxBackups || s.maxAge > 0 && now.Sub(b.rotated) > s.maxAge {
+			if e := os.Remove(filepath.Join(filepath.Dir(path), b.name)); e != nil && err == nil {
+				err = e
+			}
+		}
+	}
+	return err
+}
+
+func (s *FileSink) syncFiles() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.syncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.mutex.Lock()
+			for _, f := range s.files {
+				if !f.dirty {
+					continue
+				}
+				if err := f.file.Sync(); err != nil {
+					s.errorHandler(err)
+				}
+				f.dirty = false
+			}
+			s.mutex.Unlock()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func closeSinkFile(f *sinkFile) error {
+	err := f.file.Sync()
+	if e := f.file.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,301 @@
+package captainslog_test
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/captainslog"
+	"github.com/klauspost/compress/zstd"
+)
+
+func fileSinkMsg(t *testing.T, host, program, content string) captainslog.SyslogMsg {
+	t.Helper()
+	msg, err := captainslog.NewSyslogMsgFromBytes([]byte("<38>2006-01-02T15:04:05.999999-07:00 " + host + " " + program + ": " + content + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+// listDir returns the names of the files in a directory, sorted.
+func listDir(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestFileSinkPathTemplate(t *testing.T) {
+	dir := t.TempDir()
+	s, err := captainslog.NewFileSink(filepath.Join(dir, "{{.Host}}", "{{.Tag.Program}}.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, msg := range []captainslog.SyslogMsg{
+		fileSinkMsg(t, "web1", "nginx", "one"),
+		fileSinkMsg(t, "web1", "sshd", "two"),
+		fileSinkMsg(t, "web2", "nginx", "three"),
+		fileSinkMsg(t, "web1", "nginx", "four"),
+	} {
+		if err := s.Send(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := s.Send(fileSinkMsg(t, "..", "nginx", "escape")); err != captainslog.ErrBadFilePath {
+		t.Errorf("want %v, got %v", captainslog.ErrBadFilePath, err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Send(fileSinkMsg(t, "web1", "nginx", "late")); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("want %v, got %v", os.ErrClosed, err)
+	}
+
+	want := "<38>2006-01-02T15:04:05.999999-07:00 web1 nginx: one\n" +
+		"<38>2006-01-02T15:04:05.999999-07:00 web1 nginx: four\n"
+	if got := readFile(t, filepath.Join(dir, "web1", "nginx.log")); want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+	if want, got := []string{"nginx.log", "sshd.log"}, listDir(t, filepath.Join(dir, "web1")); !equalStrings(want, got) {
+		t.Errorf("want %q, got %q", want, got)
+	}
+	if want, got := []string{"nginx.log"}, listDir(t, filepath.Join(dir, "web2")); !equalStrings(want, got) {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func TestFileSinkSizeRotation(t *testing.T) {
+	dir := t.TempDir()
+	line := fileSinkMsg(t, "web1", "nginx", "0")
+	s, err := captainslog.NewFileSink(
+		filepath.Join(dir, "{{.Tag.Program}}.log"),
+		captainslog.FileSinkOptionMaxSize(int64(len(line.Bytes()))),
+		captainslog.FileSinkOptionGzip,
+		captainslog.FileSinkOptionMaxBackups(2),
+		captainslog.FileSinkOptionSyncEveryMessage,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, content := range []string{"0", "1", "2", "3"} {
+		if err := s.Send(fileSinkMsg(t, "web1", "nginx", content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// rotated files are compressed in the background until Close
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := "<38>2006-01-02T15:04:05.999999-07:00 web1 nginx: 3\n", readFile(t, filepath.Join(dir, "nginx.log")); want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+
+	names := listDir(t, dir)
+	if want, got := 3, len(names); want != got {
+		t.Fatalf("want %d files, got %q", want, names)
+	}
+	var contents []string
+	for _, name := range names {
+		if name == "nginx.log" {
+			continue
+		}
+		if !strings.HasPrefix(name, "nginx-") || !strings.HasSuffix(name, ".log.gz") {
+			t.Errorf("want a rotated gzip file, got %q", name)
+			continue
+		}
+		f, err := os.Open(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(r)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents = append(contents, string(b))
+	}
+	sort.Strings(contents)
+	want := []string{
+		"<38>2006-01-02T15:04:05.999999-07:00 web1 nginx: 1\n",
+		"<38>2006-01-02T15:04:05.999999-07:00 web1 nginx: 2\n",
+	}
+	if !equalStrings(want, contents) {
+		t.Errorf("want %q, got %q", want, contents)
+	}
+}
+
+func TestFileSinkTimeRotation(t *testing.T) {
+	dir := t.TempDir()
+	old := filepath.Join(dir, "nginx-2001-01-01T00-00-00.000.log")
+	if err := os.WriteFile(old, []byte("old\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := captainslog.NewFileSink(
+		filepath.Join(dir, "nginx.log"),
+		captainslog.FileSinkOptionRotateEvery(100*time.Millisecond),
+		captainslog.FileSinkOptionMaxAge(24*time.Hour),
+		captainslog.FileSinkOptionSyncInterval(10*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Send(fileSinkMsg(t, "web1", "nginx", "before")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(150 * time.Millisecond)
+	if err := s.Send(fileSinkMsg(t, "web1", "nginx", "after")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	names := listDir(t, dir)
+	if want, got := 2, len(names); want != got || names[1] != "nginx.log" {
+		t.Fatalf("want a rotated file and nginx.log, got %q", names)
+	}
+	if want, got := "<38>2006-01-02T15:04:05.999999-07:00 web1 nginx: before\n", readFile(t, filepath.Join(dir, names[0])); want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+	if want, got := "<38>2006-01-02T15:04:05.999999-07:00 web1 nginx: after\n", readFile(t, filepath.Join(dir, "nginx.log")); want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func TestFileSinkZstd(t *testing.T) {
+	dir := t.TempDir()
+	s, err := captainslog.NewFileSink(
+		filepath.Join(dir, "nginx.log"),
+		captainslog.FileSinkOptionMaxSize(1),
+		captainslog.FileSinkOptionZstd,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, content := range []string{"0", "1"} {
+		if err := s.Send(fileSinkMsg(t, "web1", "nginx", content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	names := listDir(t, dir)
+	if want, got := 2, len(names); want != got || !strings.HasSuffix(names[0], ".log.zst") {
+		t.Fatalf("want a rotated zstd file and nginx.log, got %q", names)
+	}
+	f, err := os.Open(filepath.Join(dir, names[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := zstd.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := "<38>2006-01-02T15:04:05.999999-07:00 web1 nginx: 0\n", string(b); want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+// prefixWriter is a compressor that marks the files it writes.
+type prefixWriter struct {
+	io.Writer
+}
+
+func (w prefixWriter) Close() error {
+	return nil
+}
+
+func TestFileSinkOptions(t *testing.T) {
+	dir := t.TempDir()
+	var handled []error
+	s, err := captainslog.NewFileSink(
+		filepath.Join(dir, "{{.Host}}.json"),
+		captainslog.FileSinkOptionJSON,
+		captainslog.FileSinkOptionMaxSize(1),
+		captainslog.FileSinkOptionMaxOpenFiles(1),
+		captainslog.FileSinkOptionCompressor(".marked", func(w io.Writer) (io.WriteCloser, error) {
+			if _, err := w.Write([]byte("marked:")); err != nil {
+				return nil, err
+			}
+			return prefixWriter{w}, nil
+		}),
+		captainslog.FileSinkOptionErrorHandler(func(err error) {
+			handled = append(handled, err)
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// with one open file, each message closes the other host's file
+	for _, host := range []string{"web1", "web2", "web1"} {
+		if err := s.Send(fileSinkMsg(t, host, "nginx", "hello")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(handled) != 0 {
+		t.Errorf("want no errors, got %v", handled)
+	}
+
+	names := listDir(t, dir)
+	if want, got := 3, len(names); want != got {
+		t.Fatalf("want %d files, got %q", want, names)
+	}
+	if !strings.HasPrefix(names[0], "web1-") || !strings.HasSuffix(names[0], ".json.marked") {
+		t.Errorf("want a rotated web1 file, got %q", names[0])
+	}
+	if got := readFile(t, filepath.Join(dir, names[0])); !strings.HasPrefix(got, `marked:{"`) || !strings.HasSuffix(got, "}\n") {
+		t.Errorf("want compressed JSON, got %q", got)
+	}
+	if got := readFile(t, filepath.Join(dir, "web2.json")); !strings.Contains(got, `"syslog_host":"web2"`) {
+		t.Errorf("want JSON, got %q", got)
+	}
+
+	if _, err := captainslog.NewFileSink("{{.Host"); err == nil {
+		t.Errorf("want error for bad template")
+	}
+}
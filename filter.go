@@ -0,0 +1,576 @@
+package captainslog
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Filter is a compiled filter expression that selects messages, such as
+//
+//	severity <= warning && program == "nginx" && json.status >= 500
+//
+// Expressions compare a field of the message to a value with one of
+// ==, !=, <, <=, >, >=, =~ and !~, and combine comparisons with &&, ||,
+// ! and parentheses. The fields are:
+//
+//	severity  the severity, compared to a name such as warning or a number
+//	facility  the facility, compared to a name such as local0 or a number
+//	host      SyslogMsg.Host
+//	program   SyslogMsg.Tag.Program
+//	pid       SyslogMsg.Tag.Pid
+//	content   SyslogMsg.Content, without its leading whitespace
+//	message   the "msg" key of JSON messages, or the content
+//	json.path the value at the path in the JSON content, as SyslogMsg.JSONValue
+//
+// Severities are ordered by their code, so severity <= warning selects
+// warning and more severe messages. Values are double quoted strings,
+// numbers, or true and false. Strings are compared to strings, numbers
+// to values that can be read as numbers, and =~ and !~ match regular
+// expressions given as strings. A comparison with a json field that is
+// missing is false. A field on its own is true when it is not empty,
+// or for json fields when it is present.
+type Filter struct {
+	expr string
+	root filterNode
+}
+
+// FilterSyntaxError is returned by CompileFilter when an expression
+// is not valid. Offset is the byte offset of the error in the expression.
+type FilterSyntaxError struct {
+	Offset int
+	Msg    string
+}
+
+// Error returns the error message along with its offset.
+func (e *FilterSyntaxError) Error() string {
+	return fmt.Sprintf("filter syntax error at offset %d: %s", e.Offset, e.Msg)
+}
+
+// CompileFilter parses a filter expression. The returned Filter is safe
+// for concurrent use. Errors are returned as a *FilterSyntaxError.
+func CompileFilter(expr string) (*Filter, error) {
+	p := filterParser{lexer: filterLexer{src: expr}}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != filterTokenEOF {
+		return nil, p.errorf("unexpected %s", p.tok)
+	}
+	return &Filter{expr: expr, root: root}, nil
+}
+
+// MustCompileFilter is like CompileFilter but panics if the expression
+// is not valid.
+func MustCompileFilter(expr string) *Filter {
+	f, err := CompileFilter(expr)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+// Match reports whether the message matches the filter. It can be used
+// as the condition of MutatorWhen.
+func (f *Filter) Match(msg *SyslogMsg) bool {
+	return f.root.eval(msg)
+}
+
+// String returns the source of the filter expression.
+func (f *Filter) String() string {
+	return f.expr
+}
+
+type filterTokenKind int
+
+const (
+	filterTokenEOF filterTokenKind = iota
+	filterTokenIdent
+	filterTokenString
+	filterTokenNumber
+	filterTokenOp
+)
+
+type filterToken struct {
+	kind filterTokenKind
+	text string
+	pos  int
+}
+
+func (t filterToken) String() string {
+	switch t.kind {
+	case filterTokenEOF:
+		return "end of expression"
+	case filterTokenString:
+		return "string " + t.text
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+type filterLexer struct {
+	src string
+	pos int
+}
+
+// filterOps lists the operators, longest first.
+var filterOps = []string{"==", "!=", "<=", ">=", "=~", "!~", "&&", "||", "<", ">", "!", "(", ")"}
+
+func (l *filterLexer) next() (filterToken, error) {
+	for l.pos < len(l.src) && (l.src[l.pos] == ' ' || l.src[l.pos] == '\t' || l.src[l.pos] == '\n' || l.src[l.pos] == '\r') {
+		l.pos++
+	}
+	start := l.pos
+	if start >= len(l.src) {
+		return filterToken{kind: filterTokenEOF, pos: start}, nil
+	}
+
+	c := l.src[start]
+	switch {
+	case c == '"':
+		l.pos++
+		for l.pos < len(l.src) && l.src[l.pos] != '"' {
+			if l.src[l.pos] == '\\' {
+				l.pos++
+			}
+			l.pos++
+		}
+		if l.pos >= len(l.src) {
+			return filterToken{}, &FilterSyntaxError{Offset: start, Msg: "unterminated string"}
+		}
+		l.pos++
+		return filterToken{kind: filterTokenString, text: l.src[start:l.pos], pos: start}, nil
+	case c >= '0' && c <= '9' || c == '-' || c == '.':
+		l.pos++
+		for l.pos < len(l.src) && isFilterIdentByte(l.src[l.pos]) {
+			l.pos++
+		}
+		return filterToken{kind: filterTokenNumber, text: l.src[start:l.pos], pos: start}, nil
+	case isFilterIdentByte(c):
+		for l.pos < len(l.src) && isFilterIdentByte(l.src[l.pos]) {
+			l.pos++
+		}
+		return filterToken{kind: filterTokenIdent, text: l.src[start:l.pos], pos: start}, nil
+	}
+
+	for _, op := range filterOps {
+		if strings.HasPrefix(l.src[start:], op) {
+			l.pos += len(op)
+			return filterToken{kind: filterTokenOp, text: op, pos: start}, nil
+		}
+	}
+	return filterToken{}, &FilterSyntaxError{Offset: start, Msg: fmt.Sprintf("unexpected character %q", c)}
+}
+
+func isFilterIdentByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		c == '_' || c == '.' || c == '-' || c == '@'
+}
+
+type filterParser struct {
+	lexer filterLexer
+	tok   filterToken
+}
+
+func (p *filterParser) next() error {
+	tok, err := p.lexer.next()
+	if err != nil {
+		return err
+	}
+	p.tok = tok
+	return nil
+}
+
+func (p *filterParser) errorf(format string, args ...interface{}) error {
+	return &FilterSyntaxError{Offset: p.tok.pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *filterParser) isOp(op string) bool {
+	return p.tok.kind == filterTokenOp && p.tok.text == op
+}
+
+func (p *filterParser) parseOr() (filterNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("||") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = filterOr{left, right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (filterNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("&&") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = filterAnd{left, right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseUnary() (filterNode, error) {
+	if p.isOp("!") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		node, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return filterNot{node}, nil
+	}
+
+	if p.isOp("(") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.isOp(")") {
+			return nil, p.errorf("expected \")\", found %s", p.tok)
+		}
+		return node, p.next()
+	}
+
+	return p.parseComparison()
+}
+
+func (p *filterParser) parseComparison() (filterNode, error) {
+	if p.tok.kind != filterTokenIdent {
+		return nil, p.errorf("expected field, found %s", p.tok)
+	}
+	field, err := parseFilterField(p.tok.text)
+	if err != nil {
+		return nil, p.errorf("%s", err)
+	}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+
+	if p.tok.kind != filterTokenOp {
+		return filterExists{field}, nil
+	}
+	op := p.tok.text
+	switch op {
+	case "==", "!=", "<", "<=", ">", ">=", "=~", "!~":
+	default:
+		return filterExists{field}, nil
+	}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+
+	c := filterCompare{field: field, op: op}
+	tok := p.tok
+	switch {
+	case op == "=~" || op == "!~":
+		if tok.kind != filterTokenString {
+			return nil, p.errorf("expected regular expression string, found %s", tok)
+		}
+		s, _ := strconv.Unquote(tok.text)
+		re, err := regexp.Compile(s)
+		if err != nil {
+			return nil, p.errorf("bad regular expression: %s", err)
+		}
+		c.re = re
+	case field.kind == filterFieldSeverity || field.kind == filterFieldFacility:
+		n, err := parseFilterPriority(field.kind, tok)
+		if err != nil {
+			return nil, p.errorf("%s", err)
+		}
+		c.kind, c.num = filterValueNumber, n
+	case tok.kind == filterTokenString:
+		s, err := strconv.Unquote(tok.text)
+		if err != nil {
+			return nil, p.errorf("bad string %s", tok.text)
+		}
+		c.kind, c.str = filterValueString, s
+	case tok.kind == filterTokenNumber:
+		n, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, p.errorf("bad number %q", tok.text)
+		}
+		c.kind, c.num = filterValueNumber, n
+	case tok.kind == filterTokenIdent && (tok.text == "true" || tok.text == "false"):
+		if op != "==" && op != "!=" {
+			return nil, p.errorf("%s can't be used with %s", op, tok.text)
+		}
+		c.kind, c.boolean = filterValueBool, tok.text == "true"
+	default:
+		return nil, p.errorf("expected value, found %s", tok)
+	}
+	return c, p.next()
+}
+
+// parseFilterPriority parses a severity or facility name or code.
+func parseFilterPriority(kind filterFieldKind, tok filterToken) (float64, error) {
+	text := tok.text
+	switch tok.kind {
+	case filterTokenNumber:
+		n, err := strconv.Atoi(text)
+		if err != nil {
+			return 0, fmt.Errorf("bad number %q", text)
+		}
+		return float64(n), nil
+	case filterTokenString:
+		text, _ = strconv.Unquote(text)
+	case filterTokenIdent:
+	default:
+		return 0, fmt.Errorf("expected value, found %s", tok)
+	}
+
+	if kind == filterFieldSeverity {
+		var s Severity
+		if err := s.FromString(text); err != nil {
+			return 0, fmt.Errorf("unknown severity %q", text)
+		}
+		return float64(s), nil
+	}
+
+	var f Facility
+	if err := f.FromString(text); err != nil {
+		return 0, fmt.Errorf("unknown facility %q", text)
+	}
+	return float64(f), nil
+}
+
+type filterFieldKind int
+
+const (
+	filterFieldSeverity filterFieldKind = iota
+	filterFieldFacility
+	filterFieldHost
+	filterFieldProgram
+	filterFieldPid
+	filterFieldContent
+	filterFieldMessage
+	filterFieldJSON
+)
+
+type filterField struct {
+	kind filterFieldKind
+	path string
+}
+
+const filterJSONPrefix = "json."
+
+func parseFilterField(name string) (filterField, error) {
+	switch name {
+	case "severity":
+		return filterField{kind: filterFieldSeverity}, nil
+	case "facility":
+		return filterField{kind: filterFieldFacility}, nil
+	case "host":
+		return filterField{kind: filterFieldHost}, nil
+	case "program":
+		return filterField{kind: filterFieldProgram}, nil
+	case "pid":
+		return filterField{kind: filterFieldPid}, nil
+	case "content":
+		return filterField{kind: filterFieldContent}, nil
+	case "message":
+		return filterField{kind: filterFieldMessage}, nil
+	}
+	if strings.HasPrefix(name, filterJSONPrefix) && len(name) > len(filterJSONPrefix) {
+		return filterField{kind: filterFieldJSON, path: name[len(filterJSONPrefix):]}, nil
+	}
+	return filterField{}, fmt.Errorf("unknown field %q", name)
+}
+
+// value returns the value of the field in the message, and whether
+// it is present.
+func (f filterField) value(msg *SyslogMsg) (interface{}, bool) {
+	switch f.kind {
+	case filterFieldSeverity:
+		return float64(msg.Pri.Severity), true
+	case filterFieldFacility:
+		return float64(msg.Pri.Facility), true
+	case filterFieldHost:
+		return msg.Host, true
+	case filterFieldProgram:
+		return msg.Tag.Program, true
+	case filterFieldPid:
+		return msg.Tag.Pid, true
+	case filterFieldContent:
+		return trimCEESpace(msg.Content), true
+	case filterFieldMessage:
+		return msg.text(), true
+	}
+	return msg.JSONValue(f.path)
+}
+
+type filterNode interface {
+	eval(msg *SyslogMsg) bool
+}
+
+type filterAnd [2]filterNode
+
+func (n filterAnd) eval(msg *SyslogMsg) bool {
+	return n[0].eval(msg) && n[1].eval(msg)
+}
+
+type filterOr [2]filterNode
+
+func (n filterOr) eval(msg *SyslogMsg) bool {
+	return n[0].eval(msg) || n[1].eval(msg)
+}
+
+type filterNot [1]filterNode
+
+func (n filterNot) eval(msg *SyslogMsg) bool {
+	return !n[0].eval(msg)
+}
+
+type filterExists struct {
+	field filterField
+}
+
+func (n filterExists) eval(msg *SyslogMsg) bool {
+	v, ok := n.field.value(msg)
+	if !ok {
+		return false
+	}
+	if s, ok := v.(string); ok && n.field.kind != filterFieldJSON {
+		return s != ""
+	}
+	return true
+}
+
+type filterValueKind int
+
+const (
+	filterValueString filterValueKind = iota
+	filterValueNumber
+	filterValueBool
+)
+
+type filterCompare struct {
+	field   filterField
+	op      string
+	kind    filterValueKind
+	str     string
+	num     float64
+	boolean bool
+	re      *regexp.Regexp
+}
+
+func (n filterCompare) eval(msg *SyslogMsg) bool {
+	v, ok := n.field.value(msg)
+	if !ok {
+		return false
+	}
+
+	if n.re != nil {
+		s, ok := filterString(v)
+		if !ok {
+			return false
+		}
+		return n.re.MatchString(s) == (n.op == "=~")
+	}
+
+	switch n.kind {
+	case filterValueBool:
+		b, ok := v.(bool)
+		if !ok {
+			return false
+		}
+		return (b == n.boolean) == (n.op == "==")
+	case filterValueNumber:
+		f, ok := filterNumber(v)
+		if !ok {
+			return false
+		}
+		cmp := 0
+		if f < n.num {
+			cmp = -1
+		} else if f > n.num {
+			cmp = 1
+		}
+		return filterCompareResult(n.op, cmp)
+	}
+
+	s, ok := filterString(v)
+	if !ok {
+		return false
+	}
+	return filterCompareResult(n.op, strings.Compare(s, n.str))
+}
+
+// filterCompareResult applies a comparison operator to the result of
+// comparing two values, which is negative, zero or positive.
+func filterCompareResult(op string, cmp int) bool {
+	switch op {
+	case "==":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return false
+}
+
+// filterString converts strings and numbers to a string.
+func filterString(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case int:
+		return strconv.Itoa(v), true
+	}
+	return "", false
+}
+
+// filterNumber converts numbers and numeric strings to a float64.
+func filterNumber(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
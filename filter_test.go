@@ -0,0 +1,125 @@
+package captainslog_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/digitalocean/captainslog"
+)
+
+func TestFilterMatch(t *testing.T) {
+	nginx := "<187>2006-01-02T15:04:05.999999-07:00 web1.example.org nginx[12]: @cee:{\"msg\":\"upstream timed out\",\"status\":504,\"ok\":false,\"user\":{\"name\":\"bob\"}}\n"
+	cron := "<78>2006-01-02T15:04:05.999999-07:00 db1.example.org CRON[99]: (root) CMD (backup)\n"
+
+	testCases := []struct {
+		expr  string
+		input string
+		want  bool
+	}{
+		{`severity <= warning && program == "nginx" && json.status >= 500`, nginx, true},
+		{`severity <= warning && program == "nginx" && json.status >= 500`, cron, false},
+		{`severity == 3`, nginx, true},
+		{`severity > err`, nginx, false},
+		{`severity == "info"`, cron, true},
+		{`facility == local7`, nginx, true},
+		{`facility == cron || facility == local0`, cron, true},
+		{`host =~ "^web[0-9]+\\."`, nginx, true},
+		{`host !~ "^web"`, cron, true},
+		{`pid == 12`, nginx, true},
+		{`pid > 50`, cron, true},
+		{`content =~ "backup"`, cron, true},
+		{`message == "upstream timed out"`, nginx, true},
+		{`message =~ "^\\(root\\)"`, cron, true},
+		{`json.user.name == "bob"`, nginx, true},
+		{`json.ok == false`, nginx, true},
+		{`json.ok != true`, nginx, true},
+		{`json.missing == 1`, nginx, false},
+		{`json.missing != 1`, nginx, false},
+		{`json.status`, nginx, true},
+		{`!json.missing`, nginx, true},
+		{`json.status == "504"`, nginx, true},
+		{`program`, cron, true},
+		{`!(program == "nginx" || program == "CRON")`, cron, false},
+		{`program == "CRON" && (json.status > 1 || host == "db1.example.org")`, cron, true},
+		{`program < "D"`, cron, true},
+		{`json.status >= -1.5`, nginx, true},
+	}
+
+	for _, tc := range testCases {
+		f, err := captainslog.CompileFilter(tc.expr)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.expr, err)
+		}
+
+		msg, err := captainslog.NewSyslogMsgFromBytes([]byte(tc.input))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want, got := tc.want, f.Match(&msg); want != got {
+			t.Errorf("%s: want %v, got %v", tc.expr, want, got)
+		}
+	}
+}
+
+func TestFilterMatchLazyJSON(t *testing.T) {
+	input := "<187>2006-01-02T15:04:05.999999-07:00 web1.example.org nginx[12]: @cee:{\"status\":504}\n"
+	msg, err := captainslog.NewSyslogMsgFromBytes([]byte(input), captainslog.OptionLazyParseJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !captainslog.MustCompileFilter(`json.status == 504`).Match(&msg) {
+		t.Error("filter should match")
+	}
+}
+
+func TestCompileFilterSyntaxError(t *testing.T) {
+	testCases := []struct {
+		expr   string
+		offset int
+	}{
+		{`severity <= `, 12},
+		{`severity <= loud`, 12},
+		{`colour == "red"`, 0},
+		{`program == "nginx`, 11},
+		{`program == "nginx" &&`, 21},
+		{`(program == "nginx"`, 19},
+		{`program == "nginx")`, 18},
+		{`host =~ "("`, 8},
+		{`host =~ web`, 8},
+		{`json.ok < true`, 10},
+		{`program nginx`, 8},
+		{`program == $x`, 11},
+		{``, 0},
+	}
+
+	for _, tc := range testCases {
+		_, err := captainslog.CompileFilter(tc.expr)
+		var syntaxErr *captainslog.FilterSyntaxError
+		if !errors.As(err, &syntaxErr) {
+			t.Errorf("%s: want *FilterSyntaxError, got %v", tc.expr, err)
+			continue
+		}
+
+		if want, got := tc.offset, syntaxErr.Offset; want != got {
+			t.Errorf("%s: want offset %d, got %d (%v)", tc.expr, want, got, err)
+		}
+	}
+}
+
+func TestFilterWithMutatorWhen(t *testing.T) {
+	f := captainslog.MustCompileFilter(`severity == debug`)
+	p := captainslog.NewPipeline(
+		captainslog.PipelineOptionStage(captainslog.MutatorWhen(f.Match, captainslog.MutatorDrop())),
+	)
+
+	msg, err := captainslog.NewSyslogMsgFromBytes([]byte("<191>2006-01-02T15:04:05.999999-07:00 host.example.org app[12]: debug\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := captainslog.ErrMsgDropped, p.Mutate(&msg); want != got {
+		t.Errorf("want %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,201 @@
+package captainslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	gelfVersion      = "1.1"
+	gelfHost         = "host"
+	gelfShortMessage = "short_message"
+	gelfTimestamp    = "timestamp"
+	gelfLevel        = "level"
+	gelfFacility     = "_facility"
+	gelfProgram      = "_program"
+	gelfPid          = "_pid"
+)
+
+var (
+	//ErrBadGELF is returned when a GELF message is malformed.
+	ErrBadGELF = errors.New("GELF message not valid")
+)
+
+// GELF returns a GELF 1.1 representation of the message encoded in a
+// []byte. Severity becomes level, Host becomes host and Content becomes
+// short_message. For JSON messages, the "msg" key is used as the
+// short_message instead. The facility, program name and pid are added as
+// the _facility, _program and _pid additional fields, and JSONValues are
+// added as additional fields with a "_" prefix. Characters that are not
+// allowed in GELF field names are replaced with "_", the reserved "id"
+// key is skipped, and values that are not strings or numbers are
+// encoded as JSON strings.
+func (s *SyslogMsg) GELF() ([]byte, error) {
+	values, err := s.values()
+	if err != nil {
+		return []byte(""), err
+	}
+
+	short := s.message(values)
+
+	doc := make(map[string]interface{})
+	for key, value := range values {
+		name := gelfFieldName(key)
+		if name == "id" {
+			continue
+		}
+		if v, ok := gelfFieldValue(value); ok {
+			doc["_"+name] = v
+		}
+	}
+
+	doc["version"] = gelfVersion
+	doc[gelfHost] = s.Host
+	doc[gelfShortMessage] = short
+	doc[gelfTimestamp] = json.Number(fmt.Sprintf("%d.%06d", s.Time.Unix(), s.Time.Nanosecond()/1000))
+	doc[gelfLevel] = int(s.Pri.Severity)
+	doc[gelfFacility] = s.Pri.Facility.String()
+	if s.Tag.Program != "" {
+		doc[gelfProgram] = s.Tag.Program
+	}
+	if s.Tag.Pid != "" {
+		doc[gelfPid] = s.Tag.Pid
+	}
+
+	return json.Marshal(doc)
+}
+
+// NewSyslogMsgFromGELF accepts a []byte containing a GELF message and
+// returns a SyslogMsg. It is the inverse of SyslogMsg.GELF: level becomes
+// Severity, host becomes Host, short_message becomes Content, and
+// additional fields other than _facility, _program and _pid are added
+// to JSONValues without their "_" prefix. full_message is added to
+// JSONValues as is.
+func NewSyslogMsgFromGELF(b []byte) (SyslogMsg, error) {
+	msg := NewSyslogMsg()
+
+	var doc map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewBuffer(b))
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil {
+		return msg, ErrBadGELF
+	}
+
+	if v, ok := doc["version"].(string); !ok || (v != gelfVersion && v != "1.0") {
+		return msg, ErrBadGELF
+	}
+
+	host, ok := doc[gelfHost].(string)
+	if !ok {
+		return msg, ErrBadGELF
+	}
+	msg.Host = host
+
+	short, ok := doc[gelfShortMessage].(string)
+	if !ok {
+		return msg, ErrBadGELF
+	}
+	msg.Content = " " + short
+
+	msg.Time = time.Now()
+	if v, ok := doc[gelfTimestamp].(json.Number); ok {
+		d, err := parseSeconds(v.String())
+		if err != nil {
+			f, err := v.Float64()
+			if err != nil {
+				return msg, ErrBadTime
+			}
+			d = time.Duration(f * float64(time.Second))
+		}
+		msg.Time = time.Unix(0, int64(d)).UTC()
+	}
+
+	// GELF defaults to alert if no level is given
+	severity := Alert
+	if v, ok := doc[gelfLevel].(json.Number); ok {
+		l, err := strconv.Atoi(v.String())
+		if err != nil {
+			return msg, ErrBadSeverity
+		}
+		severity = Severity(l)
+	}
+	if err := msg.SetSeverity(severity); err != nil {
+		return msg, err
+	}
+
+	facility := User
+	switch v := doc[gelfFacility].(type) {
+	case string:
+		if err := facility.FromString(v); err != nil {
+			return msg, ErrBadFacility
+		}
+	case json.Number:
+		f, err := strconv.Atoi(v.String())
+		if err != nil {
+			return msg, ErrBadFacility
+		}
+		facility = Facility(f)
+	}
+	if err := msg.SetFacility(facility); err != nil {
+		return msg, err
+	}
+
+	tag := NewTag()
+	tag.Program, _ = doc[gelfProgram].(string)
+	switch v := doc[gelfPid].(type) {
+	case string:
+		tag.Pid = v
+	case json.Number:
+		tag.Pid = v.String()
+	}
+	msg.Tag = *tag
+
+	for key, value := range doc {
+		switch key {
+		case "version", gelfHost, gelfShortMessage, gelfTimestamp, gelfLevel,
+			gelfFacility, gelfProgram, gelfPid:
+			continue
+		}
+		msg.JSONValues[strings.TrimPrefix(key, "_")] = value
+	}
+
+	return msg, nil
+}
+
+// gelfFieldName replaces the characters that are not allowed in GELF
+// additional field names with "_".
+func gelfFieldName(key string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9',
+			r == '_', r == '.', r == '-':
+			return r
+		}
+		return '_'
+	}, key)
+}
+
+// gelfFieldValue converts a value to one of the types GELF allows
+// for additional fields, strings and numbers.
+func gelfFieldValue(value interface{}) (interface{}, bool) {
+	switch v := value.(type) {
+	case nil:
+		return nil, false
+	case string, json.Number, int, int8, int16, int32, int64,
+		uint, uint8, uint16, uint32, uint64, float32, float64:
+		return v, true
+	case bool:
+		return strconv.FormatBool(v), true
+	}
+
+	b, err := json.Marshal(value)
+	if err != nil {
+		return nil, false
+	}
+	return string(b), true
+}
@@ -0,0 +1,123 @@
+package captainslog_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/digitalocean/captainslog"
+)
+
+func TestSyslogMsgGELF(t *testing.T) {
+	testCases := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name:  "plain",
+			input: "<4>2016-03-08T14:59:36.293816+00:00 host.example.com kernel[12]: test\n",
+			want:  `{"_facility":"kern","_pid":"12","_program":"kernel","host":"host.example.com","level":4,"short_message":"test","timestamp":1457449176.293816,"version":"1.1"}`,
+		},
+		{
+			name:  "cee",
+			input: "<187>2016-03-08T14:59:36.293816+00:00 host.example.com test: @cee:{\"msg\":\"request failed\",\"status\":500,\"user id\":\"bob\",\"id\":1,\"ok\":false,\"tags\":[\"a\"]}\n",
+			want:  `{"_facility":"local7","_ok":"false","_program":"test","_status":500,"_tags":"[\"a\"]","_user_id":"bob","host":"host.example.com","level":3,"short_message":"request failed","timestamp":1457449176.293816,"version":"1.1"}`,
+		},
+		{
+			name:  "cee without msg",
+			input: "<187>2016-03-08T14:59:36.293816+00:00 host.example.com test: @cee:{\"a\":\"b\"}\n",
+			want:  `{"_a":"b","_facility":"local7","_program":"test","host":"host.example.com","level":3,"short_message":"{\"a\":\"b\"}","timestamp":1457449176.293816,"version":"1.1"}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			msg, err := captainslog.NewSyslogMsgFromBytes([]byte(tc.input))
+			if err != nil {
+				t.Error(err)
+			}
+
+			b, err := msg.GELF()
+			if err != nil {
+				t.Error(err)
+			}
+
+			if want, got := tc.want, string(b); want != got {
+				t.Errorf("want %q, got %q", want, got)
+			}
+		})
+	}
+}
+
+func TestNewSyslogMsgFromGELF(t *testing.T) {
+	input := `{"version":"1.1","host":"host.example.com","short_message":"request failed","full_message":"request failed\nbacktrace","timestamp":1457449176.293816,"level":3,"_facility":"local7","_program":"test","_pid":12,"_status":500}`
+
+	msg, err := captainslog.NewSyslogMsgFromGELF([]byte(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := captainslog.Local7, msg.Pri.Facility; want != got {
+		t.Errorf("facility: want %q, got %q", want, got)
+	}
+
+	if want, got := captainslog.Err, msg.Pri.Severity; want != got {
+		t.Errorf("severity: want %q, got %q", want, got)
+	}
+
+	wantTime := time.Date(2016, time.March, 8, 14, 59, 36, 293816000, time.UTC)
+	if want, got := wantTime, msg.Time; !want.Equal(got) {
+		t.Errorf("time: want %v, got %v", want, got)
+	}
+
+	if want, got := "host.example.com", msg.Host; want != got {
+		t.Errorf("host: want %q, got %q", want, got)
+	}
+
+	if want, got := "test[12]:", msg.Tag.String(); want != got {
+		t.Errorf("tag: want %q, got %q", want, got)
+	}
+
+	if want, got := " request failed", msg.Content; want != got {
+		t.Errorf("content: want %q, got %q", want, got)
+	}
+
+	for _, key := range []string{"status", "full_message"} {
+		if _, ok := msg.JSONValues[key]; !ok {
+			t.Errorf("could not find expected key %q in msg.JSONValues", key)
+		}
+	}
+
+	b, err := msg.GELF()
+	if err != nil {
+		t.Error(err)
+	}
+
+	wanted := `{"_facility":"local7","_full_message":"request failed\nbacktrace","_pid":"12","_program":"test","_status":500,"host":"host.example.com","level":3,"short_message":"request failed","timestamp":1457449176.293816,"version":"1.1"}`
+	if want, got := wanted, string(b); want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func TestNewSyslogMsgFromGELFErrors(t *testing.T) {
+	testCases := []struct {
+		name  string
+		input string
+		err   error
+	}{
+		{"not json", `hello`, captainslog.ErrBadGELF},
+		{"bad version", `{"version":"2","host":"h","short_message":"m"}`, captainslog.ErrBadGELF},
+		{"missing host", `{"version":"1.1","short_message":"m"}`, captainslog.ErrBadGELF},
+		{"missing short_message", `{"version":"1.1","host":"h"}`, captainslog.ErrBadGELF},
+		{"bad level", `{"version":"1.1","host":"h","short_message":"m","level":9}`, captainslog.ErrBadSeverity},
+		{"bad facility", `{"version":"1.1","host":"h","short_message":"m","_facility":"nope"}`, captainslog.ErrBadFacility},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := captainslog.NewSyslogMsgFromGELF([]byte(tc.input)); err != tc.err {
+				t.Errorf("want %v, got %v", tc.err, err)
+			}
+		})
+	}
+}
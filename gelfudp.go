@@ -0,0 +1,388 @@
+package captainslog
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	gelfChunkMagic0    = 0x1e
+	gelfChunkMagic1    = 0x0f
+	gelfChunkHeaderLen = 12
+	gelfMaxChunks      = 128
+	gelfMaxPacketSize  = 65536
+
+	// gelfMaxMessageSize is the largest decompressed GELF message,
+	// which keeps a small compressed payload from expanding without
+	// bound.
+	gelfMaxMessageSize = gelfMaxChunks * gelfMaxPacketSize
+
+	// gelfMaxPartials and gelfMaxPartialSize bound the number and total
+	// size of the incomplete messages kept by a GELFAssembler. The
+	// oldest are discarded to make room for new chunks.
+	gelfMaxPartials    = 1024
+	gelfMaxPartialSize = 4 * gelfMaxMessageSize
+
+	// GELFChunkSizeWAN is the recommended GELF chunk size for
+	// messages that cross a WAN.
+	GELFChunkSizeWAN = 1420
+
+	// GELFChunkSizeLAN is the recommended GELF chunk size for
+	// messages that stay within a LAN.
+	GELFChunkSizeLAN = 8154
+
+	// gelfChunkTimeout is how long chunks of a message are kept
+	// waiting for the rest of them, as required by the GELF spec.
+	gelfChunkTimeout = 5 * time.Second
+)
+
+var (
+	//ErrGELFTooLarge is returned when a GELF message needs more than 128 chunks,
+	//or decompresses to more than 128 chunks of 64 KiB.
+	ErrGELFTooLarge = errors.New("GELF message too large")
+
+	//ErrGELFChunkSize is returned when a GELF chunk size leaves no room for
+	//data after the chunk header.
+	ErrGELFChunkSize = errors.New("GELF chunk size not valid")
+
+	//ErrBadGELFChunk is returned when a GELF chunk is malformed.
+	ErrBadGELFChunk = errors.New("GELF chunk not valid")
+)
+
+// GELFCompression is the compression used for GELF UDP packets.
+type GELFCompression int
+
+const (
+	// GELFCompressionNone sends GELF messages uncompressed.
+	GELFCompressionNone GELFCompression = 0
+
+	// GELFCompressionGzip compresses GELF messages with gzip.
+	GELFCompressionGzip GELFCompression = 1
+
+	// GELFCompressionZlib compresses GELF messages with zlib.
+	GELFCompressionZlib GELFCompression = 2
+)
+
+// GELFWriter sends SyslogMsgs as GELF messages over UDP, compressing
+// and chunking them as needed.
+type GELFWriter struct {
+	conn        net.Conn
+	chunkSize   int
+	compression GELFCompression
+}
+
+// NewGELFWriter returns a new GELFWriter sending to the UDP address addr.
+// Messages are gzip compressed and chunked for a WAN by default.
+func NewGELFWriter(addr string, options ...func(*GELFWriter)) (*GELFWriter, error) {
+	w := GELFWriter{chunkSize: GELFChunkSizeWAN, compression: GELFCompressionGzip}
+	for _, option := range options {
+		option(&w)
+	}
+
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	w.conn = conn
+	return &w, nil
+}
+
+// GELFWriterOptionChunkSize sets the maximum size of the UDP
+// packets sent by the GELFWriter.
+func GELFWriterOptionChunkSize(size int) func(*GELFWriter) {
+	return func(w *GELFWriter) {
+		w.chunkSize = size
+	}
+}
+
+// GELFWriterOptionCompression sets the compression used by the GELFWriter.
+func GELFWriterOptionCompression(compression GELFCompression) func(*GELFWriter) {
+	return func(w *GELFWriter) {
+		w.compression = compression
+	}
+}
+
+// Send sends the message as GELF.
+func (w *GELFWriter) Send(msg SyslogMsg) error {
+	b, err := msg.GELF()
+	if err != nil {
+		return err
+	}
+
+	packets, err := EncodeGELFPackets(b, w.chunkSize, w.compression)
+	if err != nil {
+		return err
+	}
+
+	for _, packet := range packets {
+		if _, err := w.conn.Write(packet); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the GELFWriter's connection.
+func (w *GELFWriter) Close() error {
+	return w.conn.Close()
+}
+
+// EncodeGELFPackets compresses a GELF message and splits it into UDP
+// packets of at most chunkSize bytes, using GELF chunking if it does
+// not fit in a single packet.
+func EncodeGELFPackets(b []byte, chunkSize int, compression GELFCompression) ([][]byte, error) {
+	var buf bytes.Buffer
+	var zw io.WriteCloser
+
+	switch compression {
+	case GELFCompressionGzip:
+		zw = gzip.NewWriter(&buf)
+	case GELFCompressionZlib:
+		zw = zlib.NewWriter(&buf)
+	default:
+		buf.Write(b)
+	}
+	if zw != nil {
+		if _, err := zw.Write(b); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	payload := buf.Bytes()
+	if len(payload) <= chunkSize {
+		return [][]byte{payload}, nil
+	}
+
+	dataLen := chunkSize - gelfChunkHeaderLen
+	if dataLen <= 0 {
+		return nil, ErrGELFChunkSize
+	}
+
+	count := (len(payload) + dataLen - 1) / dataLen
+	if count > gelfMaxChunks {
+		return nil, ErrGELFTooLarge
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	packets := make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		end := (i + 1) * dataLen
+		if end > len(payload) {
+			end = len(payload)
+		}
+
+		packet := make([]byte, 0, gelfChunkHeaderLen+end-i*dataLen)
+		packet = append(packet, gelfChunkMagic0, gelfChunkMagic1)
+		packet = append(packet, id...)
+		packet = append(packet, byte(i), byte(count))
+		packet = append(packet, payload[i*dataLen:end]...)
+		packets = append(packets, packet)
+	}
+	return packets, nil
+}
+
+// GELFAssembler reassembles chunked GELF packets and decompresses
+// them. It is safe for concurrent use.
+type GELFAssembler struct {
+	partials map[string]*gelfPartial
+	size     int
+	timeout  time.Duration
+	mutex    sync.Mutex
+}
+
+type gelfPartial struct {
+	chunks   [][]byte
+	received int
+	size     int
+	started  time.Time
+}
+
+// NewGELFAssembler returns a new GELFAssembler.
+func NewGELFAssembler() *GELFAssembler {
+	return &GELFAssembler{
+		partials: make(map[string]*gelfPartial),
+		timeout:  gelfChunkTimeout,
+	}
+}
+
+// Add adds a UDP packet to the assembler. It returns the decompressed
+// GELF message if the packet completes one, or nil if more chunks
+// are needed. Incomplete messages are discarded after five seconds,
+// or sooner, oldest first, when they take up too much memory.
+func (a *GELFAssembler) Add(packet []byte) ([]byte, error) {
+	if len(packet) < 2 || packet[0] != gelfChunkMagic0 || packet[1] != gelfChunkMagic1 {
+		return decodeGELFPayload(packet)
+	}
+
+	if len(packet) < gelfChunkHeaderLen {
+		return nil, ErrBadGELFChunk
+	}
+
+	id := string(packet[2:10])
+	seq := int(packet[10])
+	count := int(packet[11])
+	if count == 0 || count > gelfMaxChunks || seq >= count {
+		return nil, ErrBadGELFChunk
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	now := time.Now()
+	for key, partial := range a.partials {
+		if now.Sub(partial.started) > a.timeout {
+			a.remove(key)
+		}
+	}
+
+	partial, ok := a.partials[id]
+	if !ok {
+		if len(a.partials) >= gelfMaxPartials {
+			a.removeOldest("")
+		}
+		partial = &gelfPartial{chunks: make([][]byte, count), started: now}
+		a.partials[id] = partial
+	}
+	if len(partial.chunks) != count {
+		a.remove(id)
+		return nil, ErrBadGELFChunk
+	}
+
+	if partial.chunks[seq] == nil {
+		partial.chunks[seq] = append([]byte(nil), packet[gelfChunkHeaderLen:]...)
+		partial.received++
+		partial.size += len(partial.chunks[seq])
+		a.size += len(partial.chunks[seq])
+		for a.size > gelfMaxPartialSize {
+			if !a.removeOldest(id) {
+				break
+			}
+		}
+	}
+	if partial.received < count {
+		return nil, nil
+	}
+
+	a.remove(id)
+	return decodeGELFPayload(bytes.Join(partial.chunks, nil))
+}
+
+// remove discards the incomplete message with the given id.
+func (a *GELFAssembler) remove(id string) {
+	if partial, ok := a.partials[id]; ok {
+		a.size -= partial.size
+		delete(a.partials, id)
+	}
+}
+
+// removeOldest discards the oldest incomplete message other than the
+// one with the given id, and reports whether there was one.
+func (a *GELFAssembler) removeOldest(except string) bool {
+	oldest := ""
+	var started time.Time
+	for key, partial := range a.partials {
+		if key != except && (oldest == "" || partial.started.Before(started)) {
+			oldest, started = key, partial.started
+		}
+	}
+	if oldest == "" {
+		return false
+	}
+	a.remove(oldest)
+	return true
+}
+
+// decodeGELFPayload decompresses a GELF payload, detecting gzip and
+// zlib compression from its first bytes.
+func decodeGELFPayload(payload []byte) ([]byte, error) {
+	var zr io.ReadCloser
+	var err error
+
+	switch {
+	case len(payload) >= 2 && payload[0] == 0x1f && payload[1] == 0x8b:
+		zr, err = gzip.NewReader(bytes.NewReader(payload))
+	case len(payload) >= 2 && payload[0] == 0x78 && (uint(payload[0])<<8|uint(payload[1]))%31 == 0:
+		zr, err = zlib.NewReader(bytes.NewReader(payload))
+	default:
+		return payload, nil
+	}
+	if err != nil {
+		return nil, ErrBadGELF
+	}
+	defer zr.Close()
+
+	b, err := io.ReadAll(io.LimitReader(zr, gelfMaxMessageSize+1))
+	if err != nil {
+		return nil, ErrBadGELF
+	}
+	if len(b) > gelfMaxMessageSize {
+		return nil, ErrGELFTooLarge
+	}
+	return b, nil
+}
+
+// GELFListener receives GELF messages over UDP and converts them to
+// SyslogMsgs.
+type GELFListener struct {
+	conn      net.PacketConn
+	assembler *GELFAssembler
+}
+
+// NewGELFListener returns a new GELFListener listening on the UDP
+// address addr. Use ":0" to listen on a random port.
+func NewGELFListener(addr string) (*GELFListener, error) {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &GELFListener{conn: conn, assembler: NewGELFAssembler()}, nil
+}
+
+// Addr returns the address the GELFListener is listening on.
+func (l *GELFListener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
+
+// Receive blocks until a complete GELF message is received and
+// returns it as a SyslogMsg.
+func (l *GELFListener) Receive() (SyslogMsg, error) {
+	buf := make([]byte, gelfMaxPacketSize)
+	for {
+		n, _, err := l.conn.ReadFrom(buf)
+		if err != nil {
+			return SyslogMsg{}, err
+		}
+
+		b, err := l.assembler.Add(buf[:n])
+		if err != nil {
+			return SyslogMsg{}, err
+		}
+		if b != nil {
+			return NewSyslogMsgFromGELF(b)
+		}
+	}
+}
+
+// SetDeadline sets the deadline for Receive.
+func (l *GELFListener) SetDeadline(t time.Time) error {
+	return l.conn.SetDeadline(t)
+}
+
+// Close closes the GELFListener.
+func (l *GELFListener) Close() error {
+	return l.conn.Close()
+}
@@ -0,0 +1,180 @@
+package captainslog_test
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/captainslog"
+)
+
+func TestGELFPackets(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	letters := "abcdefghijklmnopqrstuvwxyz"
+	large := make([]byte, 20000)
+	for i := range large {
+		large[i] = letters[rnd.Intn(len(letters))]
+	}
+
+	testCases := []struct {
+		name        string
+		payload     []byte
+		chunkSize   int
+		compression captainslog.GELFCompression
+		chunked     bool
+	}{
+		{"small uncompressed", []byte(`{"a":"b"}`), captainslog.GELFChunkSizeWAN, captainslog.GELFCompressionNone, false},
+		{"small gzip", []byte(`{"a":"b"}`), captainslog.GELFChunkSizeWAN, captainslog.GELFCompressionGzip, false},
+		{"small zlib", []byte(`{"a":"b"}`), captainslog.GELFChunkSizeWAN, captainslog.GELFCompressionZlib, false},
+		{"large uncompressed", large, captainslog.GELFChunkSizeWAN, captainslog.GELFCompressionNone, true},
+		{"large gzip", large, 1000, captainslog.GELFCompressionGzip, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			packets, err := captainslog.EncodeGELFPackets(tc.payload, tc.chunkSize, tc.compression)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if want, got := tc.chunked, len(packets) > 1; want != got {
+				t.Errorf("chunked: want %v, got %v", want, got)
+			}
+
+			a := captainslog.NewGELFAssembler()
+
+			// deliver the chunks out of order
+			var b []byte
+			for i := len(packets) - 1; i >= 0; i-- {
+				if len(packets[i]) > tc.chunkSize {
+					t.Errorf("packet size %d exceeds %d", len(packets[i]), tc.chunkSize)
+				}
+
+				b, err = a.Add(packets[i])
+				if err != nil {
+					t.Fatal(err)
+				}
+				if i > 0 && b != nil {
+					t.Errorf("message completed early at chunk %d", i)
+				}
+			}
+
+			if want, got := string(tc.payload), string(b); want != got {
+				t.Errorf("payload mismatch: want %d bytes, got %d bytes", len(want), len(got))
+			}
+		})
+	}
+}
+
+func TestGELFPacketsTooLarge(t *testing.T) {
+	payload := []byte(strings.Repeat("a", 129*100))
+	if _, err := captainslog.EncodeGELFPackets(payload, 100+12, captainslog.GELFCompressionNone); err != captainslog.ErrGELFTooLarge {
+		t.Errorf("want %v, got %v", captainslog.ErrGELFTooLarge, err)
+	}
+}
+
+func TestGELFPacketsChunkSize(t *testing.T) {
+	payload := []byte(strings.Repeat("a", 100))
+	if _, err := captainslog.EncodeGELFPackets(payload, 12, captainslog.GELFCompressionNone); err != captainslog.ErrGELFChunkSize {
+		t.Errorf("want %v, got %v", captainslog.ErrGELFChunkSize, err)
+	}
+}
+
+func TestGELFAssemblerTooLarge(t *testing.T) {
+	payload := make([]byte, 128*65536+1)
+	packets, err := captainslog.EncodeGELFPackets(payload, 65536, captainslog.GELFCompressionGzip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packets) != 1 {
+		t.Fatalf("want 1 packet, got %d", len(packets))
+	}
+
+	a := captainslog.NewGELFAssembler()
+	if _, err := a.Add(packets[0]); err != captainslog.ErrGELFTooLarge {
+		t.Errorf("want %v, got %v", captainslog.ErrGELFTooLarge, err)
+	}
+}
+
+func TestGELFAssemblerMaxPartials(t *testing.T) {
+	a := captainslog.NewGELFAssembler()
+	chunk := func(id, seq int) []byte {
+		return []byte{0x1e, 0x0f, byte(id >> 8), byte(id), 0, 0, 0, 0, 0, 0, byte(seq), 2, 'a'}
+	}
+
+	for id := 0; id <= 1024; id++ {
+		if _, err := a.Add(chunk(id, 0)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the oldest message was discarded to make room for the last one
+	if b, err := a.Add(chunk(0, 1)); err != nil || b != nil {
+		t.Errorf("want no message, got %q, %v", b, err)
+	}
+	if b, err := a.Add(chunk(1024, 1)); err != nil || string(b) != "aa" {
+		t.Errorf("want %q, got %q, %v", "aa", b, err)
+	}
+}
+
+func TestGELFAssemblerBadChunk(t *testing.T) {
+	a := captainslog.NewGELFAssembler()
+	if _, err := a.Add([]byte{0x1e, 0x0f, 1, 2, 3, 4, 5, 6, 7, 8, 2, 2}); err != captainslog.ErrBadGELFChunk {
+		t.Errorf("want %v, got %v", captainslog.ErrBadGELFChunk, err)
+	}
+}
+
+func TestGELFListener(t *testing.T) {
+	l, err := captainslog.NewGELFListener("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	w, err := captainslog.NewGELFWriter(l.Addr().String(),
+		captainslog.GELFWriterOptionChunkSize(512),
+		captainslog.GELFWriterOptionCompression(captainslog.GELFCompressionNone),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	msg := captainslog.NewSyslogMsg()
+	msg.SetFacility(captainslog.Local7)
+	msg.SetSeverity(captainslog.Err)
+	msg.SetTime(time.Date(2016, time.March, 8, 14, 59, 36, 0, time.UTC))
+	msg.SetProgram("test")
+	msg.SetPid("12")
+	msg.SetHost("host.example.com")
+	msg.Content = " " + strings.Repeat("x", 2000)
+	msg.AddTag("status", 500)
+
+	if err := w.Send(msg); err != nil {
+		t.Fatal(err)
+	}
+
+	l.SetDeadline(time.Now().Add(5 * time.Second))
+	got, err := l.Receive()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := msg.Content, got.Content; want != got {
+		t.Errorf("content: want %q, got %q", want, got)
+	}
+
+	if want, got := msg.Pri, got.Pri; want != got {
+		t.Errorf("priority: want %v, got %v", want, got)
+	}
+
+	if want, got := "test[12]:", got.Tag.String(); want != got {
+		t.Errorf("tag: want %q, got %q", want, got)
+	}
+
+	if want, got := "500", fmt.Sprint(got.JSONValues["status"]); want != got {
+		t.Errorf("status: want %q, got %q", want, got)
+	}
+}
@@ -0,0 +1,308 @@
+package captainslog
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"time"
+)
+
+const (
+	journalPriority          = "PRIORITY"
+	journalFacility          = "SYSLOG_FACILITY"
+	journalIdentifier        = "SYSLOG_IDENTIFIER"
+	journalComm              = "_COMM"
+	journalPid               = "_PID"
+	journalSyslogPid         = "SYSLOG_PID"
+	journalHostname          = "_HOSTNAME"
+	journalRealtimeTimestamp = "__REALTIME_TIMESTAMP"
+	journalMessage           = "MESSAGE"
+
+	// journalMaxFieldSize limits the size of a single binary field in
+	// the export format, so a corrupt length can't exhaust memory.
+	journalMaxFieldSize = 64 * 1024 * 1024
+)
+
+var (
+	//ErrBadJournalEntry is returned when a journal entry is malformed.
+	ErrBadJournalEntry = errors.New("Journal entry not valid")
+)
+
+// JournalReader reads systemd journal entries, as written by
+// "journalctl -o export" or "journalctl -o json", and converts
+// them to SyslogMsgs. The format of each entry is detected
+// automatically.
+type JournalReader struct {
+	r        *bufio.Reader
+	location *time.Location
+}
+
+// NewJournalReader returns a new JournalReader reading from r.
+func NewJournalReader(r io.Reader, options ...func(*JournalReader)) *JournalReader {
+	j := JournalReader{r: bufio.NewReader(r), location: time.UTC}
+	for _, option := range options {
+		option(&j)
+	}
+	return &j
+}
+
+// JournalOptionLocation is a helper function to configure the
+// JournalReader to return times in the given timezone. Default
+// timezone is UTC.
+func JournalOptionLocation(location *time.Location) func(*JournalReader) {
+	return func(j *JournalReader) {
+		j.location = location
+	}
+}
+
+// Read reads the next journal entry and returns it as a SyslogMsg.
+// PRIORITY becomes the severity, SYSLOG_FACILITY the facility,
+// SYSLOG_IDENTIFIER and _PID, or else _COMM and SYSLOG_PID, the tag,
+// _HOSTNAME the host, __REALTIME_TIMESTAMP the time and MESSAGE the
+// content. All other fields are added to JSONValues, as a
+// []interface{} if the field appears more than once. Read returns
+// io.EOF when there are no more entries.
+func (j *JournalReader) Read() (SyslogMsg, error) {
+	var fields map[string][]string
+	var b []byte
+	var err error
+
+	// skip blank lines between entries
+	for {
+		b, err = j.r.Peek(1)
+		if err != nil {
+			return SyslogMsg{}, err
+		}
+		if b[0] != '\n' {
+			break
+		}
+		if _, err = j.r.ReadByte(); err != nil {
+			return SyslogMsg{}, err
+		}
+	}
+
+	if b[0] == '{' {
+		fields, err = j.readJSON()
+	} else {
+		fields, err = j.readExport()
+	}
+	if err != nil {
+		return SyslogMsg{}, err
+	}
+
+	return newSyslogMsgFromJournalFields(fields, j.location)
+}
+
+// readExport reads an entry in the journal export format, where each
+// field is either "KEY=value\n", or "KEY\n" followed by a little endian
+// 64 bit length, the binary value and "\n". Entries end with a blank line.
+func (j *JournalReader) readExport() (map[string][]string, error) {
+	fields := make(map[string][]string)
+
+	for {
+		line, err := j.r.ReadBytes('\n')
+		if err == io.EOF && len(line) > 0 {
+			err = nil
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fields, err
+		}
+
+		line = bytes.TrimSuffix(line, []byte("\n"))
+		if len(line) == 0 {
+			break
+		}
+
+		if i := bytes.IndexByte(line, '='); i > 0 {
+			key := string(line[:i])
+			fields[key] = append(fields[key], string(line[i+1:]))
+			continue
+		}
+
+		var size uint64
+		if err := binary.Read(j.r, binary.LittleEndian, &size); err != nil {
+			return fields, ErrBadJournalEntry
+		}
+		if size > journalMaxFieldSize {
+			return fields, ErrBadJournalEntry
+		}
+
+		value := make([]byte, size+1)
+		if _, err := io.ReadFull(j.r, value); err != nil {
+			return fields, ErrBadJournalEntry
+		}
+		if value[size] != '\n' {
+			return fields, ErrBadJournalEntry
+		}
+
+		key := string(line)
+		fields[key] = append(fields[key], string(value[:size]))
+	}
+
+	if len(fields) == 0 {
+		return fields, ErrBadJournalEntry
+	}
+	return fields, nil
+}
+
+// readJSON reads an entry in the journal JSON format, where each entry
+// is an object on a single line. Values are strings, arrays of bytes
+// for binary values, arrays of either for repeated fields, or null.
+func (j *JournalReader) readJSON() (map[string][]string, error) {
+	fields := make(map[string][]string)
+
+	line, err := j.r.ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return fields, err
+	}
+
+	var entry map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewBuffer(line))
+	decoder.UseNumber()
+	if err := decoder.Decode(&entry); err != nil {
+		return fields, ErrBadJournalEntry
+	}
+
+	for key, value := range entry {
+		switch val := value.(type) {
+		case nil:
+		case string:
+			fields[key] = []string{val}
+		case []interface{}:
+			if s, ok := journalJSONBytes(val); ok {
+				fields[key] = []string{s}
+				continue
+			}
+			for _, v := range val {
+				switch v := v.(type) {
+				case string:
+					fields[key] = append(fields[key], v)
+				case []interface{}:
+					s, ok := journalJSONBytes(v)
+					if !ok {
+						return fields, ErrBadJournalEntry
+					}
+					fields[key] = append(fields[key], s)
+				default:
+					return fields, ErrBadJournalEntry
+				}
+			}
+		default:
+			return fields, ErrBadJournalEntry
+		}
+	}
+	return fields, nil
+}
+
+// journalJSONBytes converts a JSON array of byte values to a string.
+func journalJSONBytes(val []interface{}) (string, bool) {
+	b := make([]byte, 0, len(val))
+	for _, v := range val {
+		n, ok := v.(json.Number)
+		if !ok {
+			return "", false
+		}
+		i, err := strconv.Atoi(n.String())
+		if err != nil || i < 0 || i > 255 {
+			return "", false
+		}
+		b = append(b, byte(i))
+	}
+	return string(b), true
+}
+
+func newSyslogMsgFromJournalFields(fields map[string][]string, location *time.Location) (SyslogMsg, error) {
+	msg := NewSyslogMsg()
+
+	first := func(key string) (string, bool) {
+		if v, ok := fields[key]; ok && len(v) > 0 {
+			return v[0], true
+		}
+		return "", false
+	}
+
+	facility := User
+	if v, ok := first(journalFacility); ok {
+		f, err := strconv.Atoi(v)
+		if err != nil {
+			return msg, ErrBadFacility
+		}
+		facility = Facility(f)
+	}
+	if err := msg.SetFacility(facility); err != nil {
+		return msg, err
+	}
+
+	severity := Info
+	if v, ok := first(journalPriority); ok {
+		s, err := strconv.Atoi(v)
+		if err != nil {
+			return msg, ErrBadSeverity
+		}
+		severity = Severity(s)
+	}
+	if err := msg.SetSeverity(severity); err != nil {
+		return msg, err
+	}
+
+	if v, ok := first(journalRealtimeTimestamp); ok {
+		usec, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return msg, ErrBadTime
+		}
+		msg.Time = time.Unix(usec/1e6, (usec%1e6)*1e3).In(location)
+	}
+
+	msg.Host, _ = first(journalHostname)
+
+	// _COMM and SYSLOG_PID are left in JSONValues unless they are
+	// used for the tag
+	used := map[string]bool{journalIdentifier: true, journalPid: true}
+	tag := NewTag()
+	if v, ok := first(journalIdentifier); ok {
+		tag.Program = v
+	} else {
+		tag.Program, used[journalComm] = first(journalComm)
+	}
+	if v, ok := first(journalPid); ok {
+		tag.Pid = v
+	} else {
+		tag.Pid, used[journalSyslogPid] = first(journalSyslogPid)
+	}
+	msg.Tag = *tag
+
+	if v, ok := first(journalMessage); ok {
+		msg.Content = " " + v
+	}
+
+	for key, values := range fields {
+		switch key {
+		case journalPriority, journalFacility, journalHostname,
+			journalRealtimeTimestamp, journalMessage:
+			continue
+		}
+		if used[key] {
+			continue
+		}
+
+		if len(values) == 1 {
+			msg.JSONValues[key] = values[0]
+			continue
+		}
+
+		vals := make([]interface{}, len(values))
+		for i, v := range values {
+			vals[i] = v
+		}
+		msg.JSONValues[key] = vals
+	}
+
+	return msg, nil
+}
@@ -0,0 +1,211 @@
+package captainslog_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/captainslog"
+)
+
+func TestJournalReaderExport(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString("__CURSOR=s=739ad463348b4ceca5a9e69c95a3c93f;i=4ece7\n")
+	buf.WriteString("__REALTIME_TIMESTAMP=1457449176293816\n")
+	buf.WriteString("PRIORITY=3\n")
+	buf.WriteString("SYSLOG_FACILITY=3\n")
+	buf.WriteString("SYSLOG_IDENTIFIER=sshd\n")
+	buf.WriteString("_PID=1234\n")
+	buf.WriteString("_HOSTNAME=host.example.com\n")
+	buf.WriteString("MESSAGE\n")
+	message := "line one\nline two"
+	binary.Write(&buf, binary.LittleEndian, uint64(len(message)))
+	buf.WriteString(message + "\n")
+	buf.WriteString("_SYSTEMD_UNIT=ssh.service\n")
+	buf.WriteString("\n")
+	buf.WriteString("__REALTIME_TIMESTAMP=1457449177000000\n")
+	buf.WriteString("SYSLOG_IDENTIFIER=kernel\n")
+	buf.WriteString("SYSLOG_FACILITY=0\n")
+	buf.WriteString("PRIORITY=6\n")
+	buf.WriteString("_HOSTNAME=host.example.com\n")
+	buf.WriteString("MESSAGE=eth0: link up\n")
+	buf.WriteString("TAG=a\n")
+	buf.WriteString("TAG=b\n")
+	buf.WriteString("\n")
+
+	r := captainslog.NewJournalReader(&buf)
+
+	msg, err := r.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := captainslog.Daemon, msg.Pri.Facility; want != got {
+		t.Errorf("facility: want %q, got %q", want, got)
+	}
+
+	if want, got := captainslog.Err, msg.Pri.Severity; want != got {
+		t.Errorf("severity: want %q, got %q", want, got)
+	}
+
+	wantTime := time.Date(2016, time.March, 8, 14, 59, 36, 293816000, time.UTC)
+	if want, got := wantTime, msg.Time; !want.Equal(got) {
+		t.Errorf("time: want %v, got %v", want, got)
+	}
+
+	if want, got := "host.example.com", msg.Host; want != got {
+		t.Errorf("host: want %q, got %q", want, got)
+	}
+
+	if want, got := "sshd[1234]:", msg.Tag.String(); want != got {
+		t.Errorf("tag: want %q, got %q", want, got)
+	}
+
+	if want, got := " line one\nline two", msg.Content; want != got {
+		t.Errorf("content: want %q, got %q", want, got)
+	}
+
+	if want, got := 2, len(msg.JSONValues); want != got {
+		t.Errorf("keys: want %d, got %d", want, got)
+	}
+
+	if want, got := "ssh.service", msg.JSONValues["_SYSTEMD_UNIT"]; want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+
+	msg, err = r.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := captainslog.Kern, msg.Pri.Facility; want != got {
+		t.Errorf("facility: want %q, got %q", want, got)
+	}
+
+	if want, got := "kernel:", msg.Tag.String(); want != got {
+		t.Errorf("tag: want %q, got %q", want, got)
+	}
+
+	tags, ok := msg.JSONValues["TAG"].([]interface{})
+	if !ok {
+		t.Fatalf("want []interface{}, got %T", msg.JSONValues["TAG"])
+	}
+
+	if want, got := 2, len(tags); want != got {
+		t.Errorf("want %d, got %d", want, got)
+	}
+
+	if _, err = r.Read(); err != io.EOF {
+		t.Errorf("want %v, got %v", io.EOF, err)
+	}
+}
+
+func TestJournalReaderJSON(t *testing.T) {
+	input := strings.Join([]string{
+		`{"__CURSOR":"s=1;i=1","__REALTIME_TIMESTAMP":"1457449176293816","PRIORITY":"4","SYSLOG_FACILITY":"10","SYSLOG_IDENTIFIER":"sudo","_PID":"99","_HOSTNAME":"host.example.com","MESSAGE":"pam_unix(sudo:session): session opened","_BOOT_ID":null}`,
+		`{"__REALTIME_TIMESTAMP":"1457449177000000","_COMM":"app","SYSLOG_PID":"7","_HOSTNAME":"host.example.com","MESSAGE":[104,105,10,116,104,101,114,101],"EXTRA":["a",[98]]}`,
+	}, "\n") + "\n"
+
+	r := captainslog.NewJournalReader(strings.NewReader(input))
+
+	msg, err := r.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wanted := "<84>2016-03-08T14:59:36.293816+00:00 host.example.com sudo[99]: @cee:{\"__CURSOR\":\"s=1;i=1\",\"msg\":\"pam_unix(sudo:session): session opened\"}\n"
+	if want, got := wanted, msg.String(); want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+
+	msg, err = r.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := captainslog.User, msg.Pri.Facility; want != got {
+		t.Errorf("facility: want %q, got %q", want, got)
+	}
+
+	if want, got := captainslog.Info, msg.Pri.Severity; want != got {
+		t.Errorf("severity: want %q, got %q", want, got)
+	}
+
+	if want, got := "app[7]:", msg.Tag.String(); want != got {
+		t.Errorf("tag: want %q, got %q", want, got)
+	}
+
+	if want, got := " hi\nthere", msg.Content; want != got {
+		t.Errorf("content: want %q, got %q", want, got)
+	}
+
+	extra, ok := msg.JSONValues["EXTRA"].([]interface{})
+	if !ok || len(extra) != 2 || extra[1] != "b" {
+		t.Errorf("unexpected EXTRA %v", msg.JSONValues["EXTRA"])
+	}
+
+	// fields used for the tag are not kept
+	if want, got := 1, len(msg.JSONValues); want != got {
+		t.Errorf("want %d values, got %v", want, msg.JSONValues)
+	}
+
+	if _, err = r.Read(); err != io.EOF {
+		t.Errorf("want %v, got %v", io.EOF, err)
+	}
+}
+
+func TestJournalReaderBadEntries(t *testing.T) {
+	testCases := []struct {
+		name  string
+		input string
+		err   error
+	}{
+		{
+			name:  "bad priority",
+			input: "PRIORITY=x\nMESSAGE=hello\n\n",
+			err:   captainslog.ErrBadSeverity,
+		},
+		{
+			name:  "priority out of range",
+			input: "PRIORITY=9\nMESSAGE=hello\n\n",
+			err:   captainslog.ErrBadSeverity,
+		},
+		{
+			name:  "bad facility",
+			input: "SYSLOG_FACILITY=30\nMESSAGE=hello\n\n",
+			err:   captainslog.ErrBadFacility,
+		},
+		{
+			name:  "bad timestamp",
+			input: "__REALTIME_TIMESTAMP=now\nMESSAGE=hello\n\n",
+			err:   captainslog.ErrBadTime,
+		},
+		{
+			name:  "truncated binary field",
+			input: "MESSAGE\n\x10\x00\x00\x00\x00\x00\x00\x00hello\n",
+			err:   captainslog.ErrBadJournalEntry,
+		},
+		{
+			name:  "bad json",
+			input: "{\"MESSAGE\":\n",
+			err:   captainslog.ErrBadJournalEntry,
+		},
+		{
+			name:  "bad json value",
+			input: "{\"MESSAGE\":1}\n",
+			err:   captainslog.ErrBadJournalEntry,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := captainslog.NewJournalReader(strings.NewReader(tc.input))
+			if _, err := r.Read(); err != tc.err {
+				t.Errorf("want %v, got %v", tc.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,476 @@
+package captainslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	//ErrBadJSON is returned when a JSON message is malformed or has none of the fields of its schema.
+	ErrBadJSON = errors.New("JSON message not valid")
+)
+
+// JSONSchema names the syslog fields of a SyslogMsg when it is encoded
+// by SyslogMsg.JSONWithSchema. A field with an empty name is left out.
+// JSONSchemaLegacy, JSONSchemaECS and JSONSchemaOTel are provided, and
+// can be copied and modified, for example to change their Namespace.
+type JSONSchema struct {
+	Time         string
+	Host         string
+	Tag          string
+	Program      string
+	Pid          string
+	FacilityCode string
+	FacilityText string
+	SeverityCode string
+	SeverityText string
+
+	// Content names the field holding SyslogMsg.Content. It is only
+	// set for messages that are not CEE, since the content of CEE
+	// messages is already in JSONValues.
+	Content string
+
+	// Message names the field holding the text of the message, as in
+	// the body of an OTelLogRecord: the "msg" key of JSON messages,
+	// which is then left out of JSONValues, or else the content
+	// without its leading whitespace.
+	Message string
+
+	// Namespace is the key that SyslogMsg.JSONValues are nested
+	// under. If it is empty they are added at the top level, and
+	// syslog fields take precedence over them.
+	Namespace string
+
+	// Nested expands field names containing dots, such as
+	// "host.hostname", into nested objects.
+	Nested bool
+
+	// OmitEmpty leaves out string fields that are empty.
+	OmitEmpty bool
+
+	// NumericPid encodes the pid as a number when it is one.
+	NumericPid bool
+}
+
+var (
+	// JSONSchemaLegacy is the schema used by SyslogMsg.JSON, where syslog
+	// fields are named with a "syslog_" prefix.
+	JSONSchemaLegacy = JSONSchemaPrefix("syslog_")
+
+	// JSONSchemaECS follows the Elastic Common Schema. JSONValues are
+	// nested under "cee".
+	JSONSchemaECS = JSONSchema{
+		Time:         "@timestamp",
+		Host:         "host.hostname",
+		Program:      "process.name",
+		Pid:          "process.pid",
+		FacilityCode: "log.syslog.facility.code",
+		FacilityText: "log.syslog.facility.name",
+		SeverityCode: "log.syslog.severity.code",
+		SeverityText: "log.syslog.severity.name",
+		Message:      "message",
+		Namespace:    "cee",
+		Nested:       true,
+		OmitEmpty:    true,
+		NumericPid:   true,
+	}
+
+	// JSONSchemaOTel follows the OpenTelemetry semantic conventions,
+	// with flat dotted attribute names. JSONValues are nested under
+	// "attributes".
+	JSONSchemaOTel = JSONSchema{
+		Time:         "timestamp",
+		Host:         "host.name",
+		Program:      "process.executable.name",
+		Pid:          "process.pid",
+		FacilityCode: "log.syslog.facility.code",
+		FacilityText: "log.syslog.facility.name",
+		SeverityCode: "log.syslog.severity.code",
+		SeverityText: "severity_text",
+		Message:      "body",
+		Namespace:    "attributes",
+		OmitEmpty:    true,
+		NumericPid:   true,
+	}
+)
+
+// JSONSchemaPrefix returns a schema like JSONSchemaLegacy, with the
+// syslog fields named with the given prefix instead of "syslog_".
+func JSONSchemaPrefix(prefix string) JSONSchema {
+	return JSONSchema{
+		Time:         prefix + "time",
+		Host:         prefix + "host",
+		Tag:          prefix + "tag",
+		Program:      prefix + "programname",
+		Pid:          prefix + "pid",
+		FacilityText: prefix + "facilitytext",
+		SeverityText: prefix + "severitytext",
+		Content:      prefix + "content",
+	}
+}
+
+// JSONWithSchema returns a JSON representation of the message encoded
+// in a []byte, with syslog fields named by the given schema.
+func (s *SyslogMsg) JSONWithSchema(schema JSONSchema) ([]byte, error) {
+	values, err := s.values()
+	if err != nil {
+		return []byte(""), err
+	}
+
+	var message string
+	if schema.Message != "" {
+		message = s.message(values)
+	}
+
+	doc := make(map[string]interface{})
+	if schema.Namespace == "" {
+		for key, value := range values {
+			doc[key] = value
+		}
+	} else if len(values) > 0 {
+		schema.set(doc, schema.Namespace, values)
+	}
+
+	schema.set(doc, schema.Time, s.Time)
+	schema.setString(doc, schema.Host, s.Host)
+	schema.setString(doc, schema.Tag, s.Tag.String())
+	schema.setString(doc, schema.Program, s.Tag.Program)
+	if pid, err := strconv.Atoi(s.Tag.Pid); err == nil && schema.NumericPid {
+		schema.set(doc, schema.Pid, pid)
+	} else {
+		schema.setString(doc, schema.Pid, s.Tag.Pid)
+	}
+	schema.set(doc, schema.FacilityCode, int(s.Pri.Facility))
+	schema.setString(doc, schema.FacilityText, s.Pri.Facility.String())
+	schema.set(doc, schema.SeverityCode, int(s.Pri.Severity))
+	schema.setString(doc, schema.SeverityText, s.Pri.Severity.String())
+
+	if !s.IsCee {
+		schema.setString(doc, schema.Content, s.Content)
+	}
+	schema.setString(doc, schema.Message, message)
+
+	return json.Marshal(doc)
+}
+
+// MarshalJSON implements json.Marshaler with SyslogMsg.JSON. It has a
+// value receiver so that messages are encoded the same whether or not
+// they are addressable.
+func (s SyslogMsg) MarshalJSON() ([]byte, error) {
+	return s.JSON()
+}
+
+// UnmarshalJSON implements json.Unmarshaler with NewSyslogMsgFromJSON,
+// decoding messages encoded by SyslogMsg.JSON.
+func (s *SyslogMsg) UnmarshalJSON(b []byte) error {
+	msg, err := NewSyslogMsgFromJSON(b)
+	if err != nil {
+		return err
+	}
+	*s = msg
+	return nil
+}
+
+// JSONOptions holds the options of NewSyslogMsgFromJSON, which are set
+// by functional arguments such as JSONOptionSchema.
+type JSONOptions struct {
+	schema JSONSchema
+}
+
+// JSONOptionSchema sets the schema that names the syslog fields of a
+// JSON message. The default is JSONSchemaLegacy.
+func JSONOptionSchema(schema JSONSchema) func(*JSONOptions) {
+	return func(opts *JSONOptions) {
+		opts.schema = schema
+	}
+}
+
+// JSONOptionPrefix sets the syslog fields of a JSON message to be named
+// as in JSONSchemaLegacy, with the given prefix instead of "syslog_".
+func JSONOptionPrefix(prefix string) func(*JSONOptions) {
+	return JSONOptionSchema(JSONSchemaPrefix(prefix))
+}
+
+// NewSyslogMsgFromJSON accepts a []byte containing a JSON message and
+// returns a SyslogMsg. It is the inverse of SyslogMsg.JSON, or of
+// SyslogMsg.JSONWithSchema with JSONOptionSchema. The time, host,
+// facility and severity are read from their fields, and the tag from
+// its field or else from the program and pid fields. The facility and
+// severity can be given by name or code, and default to user and
+// notice. A missing time is set to the current time.
+//
+// If the schema has a Namespace, JSONValues are read from it and other
+// fields are ignored. Otherwise every field that is not a syslog field
+// is added to JSONValues. A message without a content field is a CEE
+// message, and one with content that is a JSON object holding the
+// JSONValues is a JSON message. The message field is added to the
+// JSONValues of CEE messages as "msg", unless it holds their JSON, and
+// is the content of other messages. A JSON object without any of the fields
+// of the schema returns ErrBadJSON.
+func NewSyslogMsgFromJSON(b []byte, options ...func(*JSONOptions)) (SyslogMsg, error) {
+	o := JSONOptions{schema: JSONSchemaLegacy}
+	for _, option := range options {
+		option(&o)
+	}
+	schema := o.schema
+
+	msg := NewSyslogMsg()
+
+	var doc map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewBuffer(b))
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil || doc == nil || !schema.matches(doc) {
+		return msg, ErrBadJSON
+	}
+
+	msg.Time = time.Now()
+	if v, ok := schema.take(doc, schema.Time); ok {
+		text, ok := v.(string)
+		if !ok {
+			return msg, ErrBadTime
+		}
+		t, err := time.Parse(time.RFC3339Nano, text)
+		if err != nil {
+			return msg, ErrBadTime
+		}
+		msg.Time = t
+	}
+
+	if v, ok := schema.take(doc, schema.Host); ok {
+		host, ok := v.(string)
+		if !ok {
+			return msg, ErrBadHost
+		}
+		msg.Host = host
+	}
+
+	facility := User
+	if err := schema.takePriority(doc, schema.FacilityText, schema.FacilityCode, facility.FromString, func(code int) {
+		facility = Facility(code)
+	}); err != nil {
+		return msg, ErrBadFacility
+	}
+	if err := msg.SetFacility(facility); err != nil {
+		return msg, err
+	}
+
+	severity := Notice
+	if err := schema.takePriority(doc, schema.SeverityText, schema.SeverityCode, severity.FromString, func(code int) {
+		severity = Severity(code)
+	}); err != nil {
+		return msg, ErrBadSeverity
+	}
+	if err := msg.SetSeverity(severity); err != nil {
+		return msg, err
+	}
+
+	tag := NewTag()
+	if v, ok := schema.take(doc, schema.Program); ok {
+		tag.Program, _ = v.(string)
+	}
+	if v, ok := schema.take(doc, schema.Pid); ok {
+		switch v := v.(type) {
+		case string:
+			tag.Pid = v
+		case json.Number:
+			tag.Pid = v.String()
+		}
+	}
+	if v, ok := schema.take(doc, schema.Tag); ok {
+		text, _ := v.(string)
+		if text != "" {
+			_, parsed, err := ParseTag([]byte(text + " "))
+			if err != nil && tag.Program == "" {
+				return msg, ErrBadTag
+			}
+			if err == nil {
+				tag = &parsed
+			}
+		}
+	}
+	if tag.Program == "" && tag.Pid == "" {
+		tag.HasColon = false
+	}
+	msg.Tag = *tag
+
+	content, hasContent := schema.take(doc, schema.Content)
+	message, hasMessage := schema.take(doc, schema.Message)
+
+	values := doc
+	if schema.Namespace != "" {
+		values = nil
+		if v, ok := schema.take(doc, schema.Namespace); ok {
+			if values, ok = v.(map[string]interface{}); !ok {
+				return msg, ErrBadJSON
+			}
+		}
+	}
+	for key, value := range values {
+		msg.JSONValues[key] = value
+	}
+
+	if hasMessage && !hasContent {
+		text, ok := message.(string)
+		if !ok {
+			return msg, ErrBadContent
+		}
+		if len(msg.JSONValues) == 0 {
+			content, hasContent = " "+text, true
+		} else if m, err := JSONContentDecoder.DecodeContent([]byte(text)); err != nil || m == nil {
+			msg.JSONValues["msg"] = text
+		}
+	}
+
+	if !hasContent {
+		if len(msg.JSONValues) == 0 {
+			return msg, nil
+		}
+		b, err := json.Marshal(msg.JSONValues)
+		if err != nil {
+			return msg, ErrBadJSON
+		}
+		msg.IsCee = true
+		msg.IsJSON = true
+		msg.Cee = " " + ceeCookie
+		msg.Content = string(b)
+		msg.decoder = JSONContentDecoder
+		return msg, nil
+	}
+
+	text, ok := content.(string)
+	if !ok {
+		return msg, ErrBadContent
+	}
+	msg.Content = text
+	if len(msg.JSONValues) > 0 {
+		if m, err := JSONContentDecoder.DecodeContent([]byte(text)); err == nil && m != nil {
+			msg.IsJSON = true
+			msg.decoder = JSONContentDecoder
+		}
+	}
+	return msg, nil
+}
+
+// matches reports whether doc holds any of the fields of the schema.
+func (schema JSONSchema) matches(doc map[string]interface{}) bool {
+	for _, name := range []string{
+		schema.Time, schema.Host, schema.Tag, schema.Program, schema.Pid,
+		schema.FacilityCode, schema.FacilityText, schema.SeverityCode,
+		schema.SeverityText, schema.Content, schema.Message, schema.Namespace,
+	} {
+		if name == "" {
+			continue
+		}
+		keys := []string{name}
+		if schema.Nested {
+			keys = strings.Split(name, ".")
+		}
+		var cur interface{} = doc
+		found := true
+		for _, key := range keys {
+			m, ok := cur.(map[string]interface{})
+			if !ok {
+				found = false
+				break
+			}
+			if cur, found = m[key]; !found {
+				break
+			}
+		}
+		if found {
+			return true
+		}
+	}
+	return false
+}
+
+// take removes the field with the given name from doc and returns it,
+// along with whether it was found.
+func (schema JSONSchema) take(doc map[string]interface{}, name string) (interface{}, bool) {
+	if name == "" {
+		return nil, false
+	}
+
+	if !schema.Nested {
+		value, ok := doc[name]
+		delete(doc, name)
+		return value, ok
+	}
+
+	keys := strings.SplitN(name, ".", 2)
+	value, ok := doc[keys[0]]
+	if !ok || len(keys) == 1 {
+		delete(doc, keys[0])
+		return value, ok
+	}
+	next, isMap := value.(map[string]interface{})
+	if !isMap {
+		return nil, false
+	}
+	value, ok = schema.take(next, keys[1])
+	if len(next) == 0 {
+		delete(doc, keys[0])
+	}
+	return value, ok
+}
+
+// takePriority removes the facility or severity fields with the given
+// names from doc, and passes the name to fromString, or else the code
+// to setCode.
+func (schema JSONSchema) takePriority(doc map[string]interface{}, textName, codeName string, fromString func(string) error, setCode func(int)) error {
+	text, hasText := schema.take(doc, textName)
+	code, hasCode := schema.take(doc, codeName)
+
+	if hasText {
+		v, ok := text.(string)
+		if !ok {
+			return ErrBadJSON
+		}
+		return fromString(v)
+	}
+	if hasCode {
+		v, ok := code.(json.Number)
+		if !ok {
+			return ErrBadJSON
+		}
+		c, err := strconv.Atoi(v.String())
+		if err != nil {
+			return err
+		}
+		setCode(c)
+	}
+	return nil
+}
+
+func (schema JSONSchema) setString(doc map[string]interface{}, name, value string) {
+	if value == "" && schema.OmitEmpty {
+		return
+	}
+	schema.set(doc, name, value)
+}
+
+func (schema JSONSchema) set(doc map[string]interface{}, name string, value interface{}) {
+	if name == "" {
+		return
+	}
+
+	if !schema.Nested {
+		doc[name] = value
+		return
+	}
+
+	keys := strings.Split(name, ".")
+	for _, key := range keys[:len(keys)-1] {
+		next, ok := doc[key].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			doc[key] = next
+		}
+		doc = next
+	}
+	doc[keys[len(keys)-1]] = value
+}
@@ -0,0 +1,250 @@
+package captainslog_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/captainslog"
+)
+
+func TestSyslogMsgJSONWithSchema(t *testing.T) {
+	namespaced := captainslog.JSONSchemaLegacy
+	namespaced.Namespace = "app"
+
+	testCases := []struct {
+		name   string
+		input  string
+		schema captainslog.JSONSchema
+		want   string
+	}{
+		{
+			name:   "legacy plain",
+			input:  "<4>2016-03-08T14:59:36.293816+00:00 host.example.com kernel: test\n",
+			schema: captainslog.JSONSchemaLegacy,
+			want:   `{"syslog_content":" test","syslog_facilitytext":"kern","syslog_host":"host.example.com","syslog_pid":"","syslog_programname":"kernel","syslog_severitytext":"warning","syslog_tag":"kernel:","syslog_time":"2016-03-08T14:59:36.293816Z"}`,
+		},
+		{
+			name:   "legacy with namespace",
+			input:  "<4>2016-03-08T14:59:36.293816+00:00 host.example.com test[12]: @cee:{\"syslog_host\":\"other\"}\n",
+			schema: namespaced,
+			want:   `{"app":{"syslog_host":"other"},"syslog_facilitytext":"kern","syslog_host":"host.example.com","syslog_pid":"12","syslog_programname":"test","syslog_severitytext":"warning","syslog_tag":"test[12]:","syslog_time":"2016-03-08T14:59:36.293816Z"}`,
+		},
+		{
+			name:   "ecs plain",
+			input:  "<4>2016-03-08T14:59:36.293816+00:00 host.example.com kernel: test\n",
+			schema: captainslog.JSONSchemaECS,
+			want:   `{"@timestamp":"2016-03-08T14:59:36.293816Z","host":{"hostname":"host.example.com"},"log":{"syslog":{"facility":{"code":0,"name":"kern"},"severity":{"code":4,"name":"warning"}}},"message":"test","process":{"name":"kernel"}}`,
+		},
+		{
+			name:   "ecs cee",
+			input:  "<187>2016-03-08T14:59:36.293816+00:00 host.example.com nginx[12]: @cee:{\"host\":\"backend\",\"status\":500}\n",
+			schema: captainslog.JSONSchemaECS,
+			want:   `{"@timestamp":"2016-03-08T14:59:36.293816Z","cee":{"host":"backend","status":500},"host":{"hostname":"host.example.com"},"log":{"syslog":{"facility":{"code":23,"name":"local7"},"severity":{"code":3,"name":"err"}}},"message":"{\"host\":\"backend\",\"status\":500}","process":{"name":"nginx","pid":12}}`,
+		},
+		{
+			name:   "otel cee",
+			input:  "<187>2016-03-08T14:59:36.293816+00:00 host.example.com nginx[12]: @cee:{\"msg\":\"failed\",\"status\":500}\n",
+			schema: captainslog.JSONSchemaOTel,
+			want:   `{"attributes":{"status":500},"body":"failed","host.name":"host.example.com","log.syslog.facility.code":23,"log.syslog.facility.name":"local7","log.syslog.severity.code":3,"process.executable.name":"nginx","process.pid":12,"severity_text":"err","timestamp":"2016-03-08T14:59:36.293816Z"}`,
+		},
+		{
+			name:   "custom",
+			input:  "<187>2016-03-08T14:59:36.293816+00:00 host.example.com nginx[abc]: hello\n",
+			schema: captainslog.JSONSchema{Host: "h", Pid: "p", Content: "c", NumericPid: true},
+			want:   `{"c":" hello","h":"host.example.com","p":"abc"}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			msg, err := captainslog.NewSyslogMsgFromBytes([]byte(tc.input))
+			if err != nil {
+				t.Error(err)
+			}
+
+			b, err := msg.JSONWithSchema(tc.schema)
+			if err != nil {
+				t.Error(err)
+			}
+
+			if want, got := tc.want, string(b); want != got {
+				t.Errorf("want %q, got %q", want, got)
+			}
+		})
+	}
+}
+
+func TestNewSyslogMsgFromJSONRoundTrip(t *testing.T) {
+	inputs := []string{
+		"<4>2016-03-08T14:59:36.293816+00:00 host.example.com kernel: test\n",
+		"<187>2016-03-08T14:59:36.293816-07:00 host.example.com nginx[12]: @cee:{\"host\":\"backend\",\"status\":500,\"tags\":[\"a\"]}\n",
+		"<191>2006-01-02T15:04:05.999999-07:00 host.example.org test[abc]: {\"a\":\"b\"}\n",
+		"<13>2006-01-02T15:04:05.999999-07:00 host.example.org [bracket]: hello world\n",
+	}
+	schemas := map[string]captainslog.JSONSchema{
+		"legacy": captainslog.JSONSchemaLegacy,
+		"prefix": captainslog.JSONSchemaPrefix("log_"),
+		"ecs":    captainslog.JSONSchemaECS,
+		"otel":   captainslog.JSONSchemaOTel,
+	}
+
+	for name, schema := range schemas {
+		for _, input := range inputs {
+			t.Run(name, func(t *testing.T) {
+				msg, err := captainslog.NewSyslogMsgFromBytes([]byte(input))
+				if err != nil {
+					t.Fatal(err)
+				}
+				b, err := msg.JSONWithSchema(schema)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				got, err := captainslog.NewSyslogMsgFromJSON(b, captainslog.JSONOptionSchema(schema))
+				if err != nil {
+					t.Fatal(err)
+				}
+				// only the legacy schemas keep the tag as it was written
+				if schema.Tag == "" && msg.Tag.StartsWithBracket {
+					return
+				}
+				// and JSON messages that are not CEE, rather than
+				// turning them into CEE messages
+				if schema.Content == "" && msg.IsJSON && !msg.IsCee {
+					if !got.IsCee || !reflect.DeepEqual(msg.JSONValues, got.JSONValues) {
+						t.Errorf("want a CEE message with %v, got %q", msg.JSONValues, got.String())
+					}
+					return
+				}
+				if want, got := msg.String(), got.String(); want != got {
+					t.Errorf("want %q, got %q", want, got)
+				}
+			})
+		}
+	}
+}
+
+func TestNewSyslogMsgFromJSON(t *testing.T) {
+	testCases := []struct {
+		name    string
+		input   string
+		options []func(*captainslog.JSONOptions)
+		want    string
+		err     error
+	}{
+		{
+			name:  "defaults",
+			input: `{"syslog_content":" hello"}`,
+			want:  "<13>2016-03-08T14:59:36.293816+00:00   hello\n",
+		},
+		{
+			name:  "codes and program",
+			input: `{"syslog_time":"2016-03-08T14:59:36.293816Z","syslog_host":"h","syslog_programname":"app","syslog_pid":"7","syslog_content":" hi"}`,
+			want:  "<13>2016-03-08T14:59:36.293816+00:00 h app[7]: hi\n",
+		},
+		{
+			name:    "prefix",
+			input:   `{"log_time":"2016-03-08T14:59:36.293816Z","log_host":"h","log_tag":"app:","log_severitytext":"error","log_facilitytext":"local0","n":1}`,
+			options: []func(*captainslog.JSONOptions){captainslog.JSONOptionPrefix("log_")},
+			want:    "<131>2016-03-08T14:59:36.293816+00:00 h app: @cee: {\"n\":1}\n",
+		},
+		{
+			name:    "codes",
+			input:   `{"log":{"syslog":{"facility":{"code":16},"severity":{"code":3}}},"@timestamp":"2016-03-08T14:59:36.293816Z","message":"hi"}`,
+			options: []func(*captainslog.JSONOptions){captainslog.JSONOptionSchema(captainslog.JSONSchemaECS)},
+			want:    "<131>2016-03-08T14:59:36.293816+00:00   hi\n",
+		},
+		{
+			name:  "not json",
+			input: `syslog`,
+			err:   captainslog.ErrBadJSON,
+		},
+		{
+			name:  "bad time",
+			input: `{"syslog_time":"yesterday"}`,
+			err:   captainslog.ErrBadTime,
+		},
+		{
+			name:  "bad facility",
+			input: `{"syslog_facilitytext":"nope"}`,
+			err:   captainslog.ErrBadFacility,
+		},
+		{
+			name:    "bad severity code",
+			input:   `{"log":{"syslog":{"severity":{"code":9}}}}`,
+			options: []func(*captainslog.JSONOptions){captainslog.JSONOptionSchema(captainslog.JSONSchemaECS)},
+			err:     captainslog.ErrBadSeverity,
+		},
+		{
+			name:  "no syslog fields",
+			input: `{"Pri":{},"Host":"h","Content":"hi"}`,
+			err:   captainslog.ErrBadJSON,
+		},
+		{
+			name:    "bad namespace",
+			input:   `{"cee":"flat"}`,
+			options: []func(*captainslog.JSONOptions){captainslog.JSONOptionSchema(captainslog.JSONSchemaECS)},
+			err:     captainslog.ErrBadJSON,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			msg, err := captainslog.NewSyslogMsgFromJSON([]byte(tc.input), tc.options...)
+			if want, got := tc.err, err; want != got {
+				t.Fatalf("want %v, got %v", want, got)
+			}
+			if tc.err != nil {
+				return
+			}
+			if tc.name == "defaults" {
+				msg.SetTime(time.Date(2016, 3, 8, 14, 59, 36, 293816000, time.UTC))
+			}
+			if want, got := tc.want, msg.String(); want != got {
+				t.Errorf("want %q, got %q", want, got)
+			}
+		})
+	}
+}
+
+func TestSyslogMsgJSONMarshaler(t *testing.T) {
+	msg, err := captainslog.NewSyslogMsgFromBytes([]byte("<187>2016-03-08T14:59:36.293816+00:00 host.example.com nginx[12]: @cee:{\"status\":500}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// values and pointers are encoded the same
+	value, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pointer, err := json.Marshal(&msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := string(pointer), string(value); want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+
+	b, err := json.Marshal([]captainslog.SyslogMsg{msg})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := `[{"status":500,"syslog_facilitytext":"local7","syslog_host":"host.example.com","syslog_pid":"12","syslog_programname":"nginx","syslog_severitytext":"err","syslog_tag":"nginx[12]:","syslog_time":"2016-03-08T14:59:36.293816Z"}]`, string(b); want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+
+	var msgs []captainslog.SyslogMsg
+	if err := json.Unmarshal(b, &msgs); err != nil {
+		t.Fatal(err)
+	}
+	if want, got := msg.String(), msgs[0].String(); want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+
+	if err := json.Unmarshal([]byte(`{"syslog_time":1}`), &msgs[0]); err != captainslog.ErrBadTime {
+		t.Errorf("want %v, got %v", captainslog.ErrBadTime, err)
+	}
+}
@@ -0,0 +1,431 @@
+package captainslog
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	// KafkaOffsetNewest is the offset of the next record a partition
+	// will get, as in Kafka.
+	KafkaOffsetNewest int64 = -1
+
+	// KafkaOffsetOldest is the offset of the first record a partition
+	// still holds, as in Kafka.
+	KafkaOffsetOldest int64 = -2
+
+	// kafkaEncodingHeader is the record header holding the KafkaEncoding
+	// of the record's value.
+	kafkaEncodingHeader = "captainslog-encoding"
+)
+
+var (
+	//ErrKafkaUnknownTopic is returned when a Kafka topic or partition does not exist.
+	ErrKafkaUnknownTopic = errors.New("Kafka topic or partition not found")
+
+	//ErrKafkaCompression is returned when a Kafka compression codec is not supported.
+	ErrKafkaCompression = errors.New("Kafka compression not valid")
+
+	//ErrKafkaEncoding is returned when a KafkaEncoding is not supported.
+	ErrKafkaEncoding = errors.New("Kafka encoding not valid")
+
+	//ErrBadKafkaRecord is returned when a Kafka record can't be decoded into a SyslogMsg.
+	ErrBadKafkaRecord = errors.New("Kafka record not valid")
+)
+
+// KafkaRecord is a record of a Kafka topic partition.
+type KafkaRecord struct {
+	Offset  int64
+	Time    time.Time
+	Key     []byte
+	Value   []byte
+	Headers []KafkaHeader
+}
+
+// KafkaHeader is a header of a KafkaRecord.
+type KafkaHeader struct {
+	Key   string
+	Value []byte
+}
+
+// KafkaCompression is the compression codec of a batch of Kafka
+// records. The values match the codec ids of the Kafka protocol, of
+// which only none and gzip are supported.
+type KafkaCompression int8
+
+const (
+	// KafkaCompressionNone leaves batches uncompressed.
+	KafkaCompressionNone KafkaCompression = 0
+	// KafkaCompressionGzip compresses batches with gzip.
+	KafkaCompressionGzip KafkaCompression = 1
+)
+
+// KafkaEncoding is how a SyslogMsg is encoded in the value of a
+// KafkaRecord. KafkaSink records it in a "captainslog-encoding" header,
+// which KafkaSource uses to decode the record.
+type KafkaEncoding string
+
+const (
+	// KafkaEncodingJSON encodes messages with SyslogMsg.JSON.
+	KafkaEncodingJSON KafkaEncoding = "json"
+	// KafkaEncodingBinary encodes messages with SyslogMsg.MarshalBinary.
+	KafkaEncodingBinary KafkaEncoding = "binary"
+	// KafkaEncodingRaw encodes messages with SyslogMsg.Bytes.
+	KafkaEncodingRaw KafkaEncoding = "raw"
+)
+
+// KafkaProducer writes batches of records to Kafka. It is the part of a
+// Kafka client a KafkaSink needs, so any Kafka client library can be
+// used through a small adapter. KafkaBroker implements it in memory.
+type KafkaProducer interface {
+	// Partitions returns the number of partitions of a topic.
+	Partitions(topic string) (int32, error)
+
+	// Produce appends a batch of records to a partition of a topic,
+	// compressed with the given codec. The offsets of the records are
+	// ignored.
+	Produce(topic string, partition int32, compression KafkaCompression, records []KafkaRecord) error
+}
+
+// KafkaConsumer reads records from Kafka and stores the offsets of
+// consumer groups. It is the part of a Kafka client a KafkaSource
+// needs. KafkaBroker implements it in memory.
+type KafkaConsumer interface {
+	// Partitions returns the number of partitions of a topic.
+	Partitions(topic string) (int32, error)
+
+	// Offsets returns the offset of the oldest record of a partition
+	// and the offset of the next record it will get.
+	Offsets(topic string, partition int32) (oldest, newest int64, err error)
+
+	// Fetch returns up to max records of a partition, starting at the
+	// given offset.
+	Fetch(topic string, partition int32, offset int64, max int) ([]KafkaRecord, error)
+
+	// Commit stores the offset of the next record a consumer group
+	// will read from a partition.
+	Commit(group, topic string, partition int32, offset int64) error
+
+	// Committed returns the offset stored by Commit, or
+	// KafkaOffsetNewest if there is none.
+	Committed(group, topic string, partition int32) (int64, error)
+}
+
+// KafkaPartitioner returns the partition of a record with the given
+// key, out of the given number of partitions. Records without a key
+// are spread over the partitions by KafkaSink instead.
+type KafkaPartitioner func(key []byte, partitions int32) int32
+
+// KafkaHashPartitioner is the default KafkaPartitioner. It hashes the
+// key with murmur2 like the Java client of Kafka does, so records with
+// the same key end up in the same partition whichever client wrote
+// them.
+func KafkaHashPartitioner(key []byte, partitions int32) int32 {
+	return int32(kafkaMurmur2(key)&0x7fffffff) % partitions
+}
+
+// kafkaMurmur2 is the murmur2 hash used by Kafka's partitioner.
+func kafkaMurmur2(data []byte) uint32 {
+	const (
+		seed = 0x9747b28c
+		m    = 0x5bd1e995
+		r    = 24
+	)
+
+	h := uint32(seed) ^ uint32(len(data))
+	n := len(data) &^ 3
+	for i := 0; i < n; i += 4 {
+		k := binary.LittleEndian.Uint32(data[i:])
+		k *= m
+		k ^= k >> r
+		k *= m
+		h *= m
+		h ^= k
+	}
+
+	switch len(data) & 3 {
+	case 3:
+		h ^= uint32(data[n+2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(data[n+1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(data[n])
+		h *= m
+	}
+
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+	return h
+}
+
+// KafkaBroker is an in-memory stand-in for a Kafka cluster, for tests
+// and for connecting a KafkaSink to a KafkaSource in one process. It is
+// a KafkaProducer and a KafkaConsumer. Batches are stored compressed
+// with the codec they were produced with, of which it supports
+// KafkaCompressionNone and KafkaCompressionGzip, and records are kept
+// until the broker is discarded. It is safe for concurrent use.
+type KafkaBroker struct {
+	topics    map[string][]*kafkaPartition
+	committed map[kafkaGroupPartition]int64
+	mutex     sync.Mutex
+}
+
+// kafkaPartition is a partition of a KafkaBroker topic.
+type kafkaPartition struct {
+	batches []kafkaBatch
+	next    int64
+}
+
+// kafkaBatch is a batch of records as stored by a KafkaBroker.
+type kafkaBatch struct {
+	baseOffset  int64
+	count       int
+	compression KafkaCompression
+	data        []byte
+}
+
+type kafkaGroupPartition struct {
+	group     string
+	topic     string
+	partition int32
+}
+
+// NewKafkaBroker returns a new KafkaBroker without topics.
+func NewKafkaBroker() *KafkaBroker {
+	return &KafkaBroker{
+		topics:    make(map[string][]*kafkaPartition),
+		committed: make(map[kafkaGroupPartition]int64),
+	}
+}
+
+// CreateTopic creates a topic with the given number of partitions. A
+// topic that exists already is left as it is.
+func (b *KafkaBroker) CreateTopic(topic string, partitions int32) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if _, ok := b.topics[topic]; ok {
+		return
+	}
+	p := make([]*kafkaPartition, partitions)
+	for i := range p {
+		p[i] = &kafkaPartition{}
+	}
+	b.topics[topic] = p
+}
+
+// Partitions returns the number of partitions of a topic.
+func (b *KafkaBroker) Partitions(topic string) (int32, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	p, ok := b.topics[topic]
+	if !ok {
+		return 0, ErrKafkaUnknownTopic
+	}
+	return int32(len(p)), nil
+}
+
+// Produce appends a batch of records to a partition of a topic.
+func (b *KafkaBroker) Produce(topic string, partition int32, compression KafkaCompression, records []KafkaRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+	data, err := compressKafkaBatch(compression, encodeKafkaRecords(records))
+	if err != nil {
+		return err
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	p, err := b.partition(topic, partition)
+	if err != nil {
+		return err
+	}
+	p.batches = append(p.batches, kafkaBatch{
+		baseOffset:  p.next,
+		count:       len(records),
+		compression: compression,
+		data:        data,
+	})
+	p.next += int64(len(records))
+	return nil
+}
+
+// Offsets returns the offset of the oldest record of a partition and
+// the offset of the next record it will get.
+func (b *KafkaBroker) Offsets(topic string, partition int32) (int64, int64, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	p, err := b.partition(topic, partition)
+	if err != nil {
+		return 0, 0, err
+	}
+	return 0, p.next, nil
+}
+
+// Fetch returns up to max records of a partition, starting at the
+// given offset.
+func (b *KafkaBroker) Fetch(topic string, partition int32, offset int64, max int) ([]KafkaRecord, error) {
+	b.mutex.Lock()
+	p, err := b.partition(topic, partition)
+	var batches []kafkaBatch
+	if err == nil {
+		for _, batch := range p.batches {
+			if batch.baseOffset+int64(batch.count) > offset {
+				batches = append(batches, batch)
+			}
+		}
+	}
+	b.mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	var records []KafkaRecord
+	for _, batch := range batches {
+		data, err := decompressKafkaBatch(batch.compression, batch.data)
+		if err != nil {
+			return nil, err
+		}
+		decoded, err := decodeKafkaRecords(data, batch.baseOffset)
+		if err != nil {
+			return nil, err
+		}
+		for _, record := range decoded {
+			if record.Offset < offset {
+				continue
+			}
+			if len(records) == max {
+				return records, nil
+			}
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
+
+// Commit stores the offset of the next record a consumer group will
+// read from a partition.
+func (b *KafkaBroker) Commit(group, topic string, partition int32, offset int64) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if _, err := b.partition(topic, partition); err != nil {
+		return err
+	}
+	b.committed[kafkaGroupPartition{group, topic, partition}] = offset
+	return nil
+}
+
+// Committed returns the offset stored by Commit, or KafkaOffsetNewest
+// if there is none.
+func (b *KafkaBroker) Committed(group, topic string, partition int32) (int64, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if _, err := b.partition(topic, partition); err != nil {
+		return 0, err
+	}
+	offset, ok := b.committed[kafkaGroupPartition{group, topic, partition}]
+	if !ok {
+		return KafkaOffsetNewest, nil
+	}
+	return offset, nil
+}
+
+// partition returns a partition of a topic. The mutex must be held.
+func (b *KafkaBroker) partition(topic string, partition int32) (*kafkaPartition, error) {
+	p, ok := b.topics[topic]
+	if !ok || partition < 0 || int(partition) >= len(p) {
+		return nil, ErrKafkaUnknownTopic
+	}
+	return p[partition], nil
+}
+
+// encodeKafkaRecords encodes records for a KafkaBroker batch, each as
+// its time in unix nanoseconds, its key, its value and its headers.
+// A nil key is encoded with a length of -1, as in Kafka.
+func encodeKafkaRecords(records []KafkaRecord) []byte {
+	var b []byte
+	for _, record := range records {
+		b = binary.AppendVarint(b, record.Time.UnixNano())
+		if record.Key == nil {
+			b = binary.AppendVarint(b, -1)
+		} else {
+			b = binary.AppendVarint(b, int64(len(record.Key)))
+			b = append(b, record.Key...)
+		}
+		b = appendBinaryBytes(b, record.Value)
+		b = binary.AppendUvarint(b, uint64(len(record.Headers)))
+		for _, header := range record.Headers {
+			b = appendBinaryBytes(b, []byte(header.Key))
+			b = appendBinaryBytes(b, header.Value)
+		}
+	}
+	return b
+}
+
+// decodeKafkaRecords decodes the records of a KafkaBroker batch.
+func decodeKafkaRecords(b []byte, baseOffset int64) ([]KafkaRecord, error) {
+	d := binaryDecoder{b: b}
+	var records []KafkaRecord
+	for offset := baseOffset; len(d.b) > 0; offset++ {
+		record := KafkaRecord{Offset: offset}
+		record.Time = time.Unix(0, d.varint())
+		if n := d.varint(); n >= 0 {
+			if n > int64(len(d.b)) {
+				return nil, ErrBadKafkaRecord
+			}
+			record.Key = d.b[:n]
+			d.b = d.b[n:]
+		}
+		record.Value = d.bytes()
+		for n := d.uvarint(); n > 0 && d.err == nil; n-- {
+			record.Headers = append(record.Headers, KafkaHeader{Key: d.string(), Value: d.bytes()})
+		}
+		if d.err != nil {
+			return nil, ErrBadKafkaRecord
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+func compressKafkaBatch(compression KafkaCompression, b []byte) ([]byte, error) {
+	switch compression {
+	case KafkaCompressionNone:
+		return b, nil
+	case KafkaCompressionGzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(b); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	return nil, ErrKafkaCompression
+}
+
+// decompressKafkaBatch returns the records of a batch, which don't
+// share memory with the batch.
+func decompressKafkaBatch(compression KafkaCompression, b []byte) ([]byte, error) {
+	switch compression {
+	case KafkaCompressionNone:
+		return append([]byte(nil), b...), nil
+	case KafkaCompressionGzip:
+		r, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(r)
+	}
+	return nil, ErrKafkaCompression
+}
@@ -0,0 +1,108 @@
+package captainslog_test
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/captainslog"
+)
+
+func TestKafkaHashPartitioner(t *testing.T) {
+	// the murmur2 hashes of Kafka's own tests, made positive
+	testCases := []struct {
+		key  string
+		want int32
+	}{
+		{key: "21", want: 1173551340},
+		{key: "foobar", want: 1357151166},
+		{key: "a-little-bit-long-string", want: 1161502112},
+		{key: "a-little-bit-longer-string", want: 661178819},
+		{key: "lkjh234lh9fiuh90y23oiuhsafujhadof229phr9h19h89h8", want: 2088585677},
+		{key: "abc", want: 479470107},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.key, func(t *testing.T) {
+			if want, got := tc.want, captainslog.KafkaHashPartitioner([]byte(tc.key), math.MaxInt32); want != got {
+				t.Errorf("want %d, got %d", want, got)
+			}
+			if want, got := tc.want%12, captainslog.KafkaHashPartitioner([]byte(tc.key), 12); want != got {
+				t.Errorf("want %d, got %d", want, got)
+			}
+		})
+	}
+}
+
+func TestKafkaBroker(t *testing.T) {
+	b := captainslog.NewKafkaBroker()
+	b.CreateTopic("logs", 2)
+
+	if want, got := captainslog.ErrKafkaUnknownTopic, b.Produce("nope", 0, captainslog.KafkaCompressionNone, []captainslog.KafkaRecord{{}}); want != got {
+		t.Errorf("want %v, got %v", want, got)
+	}
+	if want, got := captainslog.ErrKafkaUnknownTopic, b.Produce("logs", 2, captainslog.KafkaCompressionNone, []captainslog.KafkaRecord{{}}); want != got {
+		t.Errorf("want %v, got %v", want, got)
+	}
+	if want, got := captainslog.ErrKafkaCompression, b.Produce("logs", 0, captainslog.KafkaCompression(4), []captainslog.KafkaRecord{{}}); want != got {
+		t.Errorf("want %v, got %v", want, got)
+	}
+
+	now := time.Unix(1136239445, 999999000)
+	value := strings.Repeat("compressible ", 100)
+	for _, compression := range []captainslog.KafkaCompression{captainslog.KafkaCompressionNone, captainslog.KafkaCompressionGzip} {
+		err := b.Produce("logs", 1, compression, []captainslog.KafkaRecord{
+			{Time: now, Key: []byte("k"), Value: []byte(value), Headers: []captainslog.KafkaHeader{{Key: "h", Value: []byte("v")}}},
+			{Time: now, Value: []byte("no key")},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	oldest, newest, err := b.Offsets("logs", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := [2]int64{0, 4}, [2]int64{oldest, newest}; want != got {
+		t.Errorf("want %v, got %v", want, got)
+	}
+
+	records, err := b.Fetch("logs", 1, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 2, len(records); want != got {
+		t.Fatalf("want %d records, got %d", want, got)
+	}
+	if want, got := int64(1), records[0].Offset; want != got {
+		t.Errorf("want %d, got %d", want, got)
+	}
+	if records[0].Key != nil || string(records[0].Value) != "no key" {
+		t.Errorf("want a record without key, got %q %q", records[0].Key, records[0].Value)
+	}
+	got := records[1]
+	if want := int64(2); want != got.Offset || string(got.Key) != "k" || string(got.Value) != value || !got.Time.Equal(now) {
+		t.Errorf("want the gzip compressed record at %d, got %+v", want, got)
+	}
+	if len(got.Headers) != 1 || got.Headers[0].Key != "h" || string(got.Headers[0].Value) != "v" {
+		t.Errorf("want header h=v, got %+v", got.Headers)
+	}
+
+	// fetched records don't share memory with the broker
+	records[0].Value[0] = 'N'
+	if records, err = b.Fetch("logs", 1, 1, 1); err != nil || string(records[0].Value) != "no key" {
+		t.Errorf("want the stored record unchanged, got %q %v", records[0].Value, err)
+	}
+
+	if offset, err := b.Committed("group", "logs", 1); err != nil || offset != captainslog.KafkaOffsetNewest {
+		t.Errorf("want %d, got %d %v", captainslog.KafkaOffsetNewest, offset, err)
+	}
+	if err := b.Commit("group", "logs", 1, 3); err != nil {
+		t.Fatal(err)
+	}
+	if offset, err := b.Committed("group", "logs", 1); err != nil || offset != 3 {
+		t.Errorf("want %d, got %d %v", 3, offset, err)
+	}
+}
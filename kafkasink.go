@@ -0,0 +1,397 @@
+package captainslog
+
+import (
+	"bytes"
+	"os"
+	"sort"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const (
+	// kafkaSinkBatchSize is the default number of messages a KafkaSink
+	// batches before producing them.
+	kafkaSinkBatchSize = 100
+
+	// kafkaSinkLinger is the default time a KafkaSink waits for a batch
+	// to fill up before producing it.
+	kafkaSinkLinger = 100 * time.Millisecond
+
+	// kafkaSinkRetries is the default number of times a KafkaSink
+	// retries producing a batch.
+	kafkaSinkRetries = 3
+
+	// kafkaSinkRetryBackoff is the default time a KafkaSink waits before
+	// retrying to produce a batch, doubled on each retry.
+	kafkaSinkRetryBackoff = 100 * time.Millisecond
+
+	// kafkaSinkMaxBuffered is the default number of messages a
+	// KafkaSink holds, in batches and being produced, before Send drops
+	// new messages.
+	kafkaSinkMaxBuffered = 10000
+)
+
+// KafkaSink batches SyslogMsgs into records of a Kafka topic, written
+// with a KafkaProducer. The key of each record is a text/template
+// executed with the message, such as "{{.Host}}", and its value is the
+// message encoded with a KafkaEncoding. Records with a key are sent to
+// the partition chosen by a KafkaPartitioner, and records without one
+// are spread over the partitions in turn.
+//
+// Batches are produced once they hold the batch size of messages, by
+// the Send that filled them, and every linger interval in the
+// background. Failed batches are retried with a doubling backoff, and
+// once the retries are used up they are kept, ahead of the messages
+// sent since, to be produced again by the next flush. A Send that fills
+// a batch while another flush is retrying doesn't wait for it, and
+// leaves the batch to the next flush.
+//
+// A KafkaSink holds at most the maximum number of buffered messages,
+// 10000 by default, counting those being produced. While Kafka is down
+// and that many are held, Send drops new messages and returns
+// ErrQueueFull. It is a Sink, and is safe for concurrent use.
+type KafkaSink struct {
+	producer     KafkaProducer
+	topic        string
+	keyTemplate  string
+	key          *template.Template
+	encoding     KafkaEncoding
+	compression  KafkaCompression
+	partitioner  KafkaPartitioner
+	partition    int32
+	partitions   int32
+	batchSize    int
+	linger       time.Duration
+	retries      int
+	retryBackoff time.Duration
+	maxBuffered  int
+	errorHandler func(err error)
+	done         chan struct{}
+	wg           sync.WaitGroup
+
+	// flushMutex is held while batches are produced, so that the
+	// batches of a partition are produced in order
+	flushMutex sync.Mutex
+
+	mutex    sync.Mutex
+	batches  map[int32][]KafkaRecord
+	pending  int
+	buffered int
+	flushing bool
+	next     int32
+	closed   bool
+}
+
+// NewKafkaSink returns a new KafkaSink producing records to the topic,
+// which must exist. Messages are encoded with KafkaEncodingJSON, without
+// a key, unless options are set.
+func NewKafkaSink(producer KafkaProducer, topic string, options ...func(*KafkaSink)) (*KafkaSink, error) {
+	s := KafkaSink{
+		producer:     producer,
+		topic:        topic,
+		encoding:     KafkaEncodingJSON,
+		partitioner:  KafkaHashPartitioner,
+		partition:    -1,
+		batchSize:    kafkaSinkBatchSize,
+		linger:       kafkaSinkLinger,
+		retries:      kafkaSinkRetries,
+		retryBackoff: kafkaSinkRetryBackoff,
+		maxBuffered:  kafkaSinkMaxBuffered,
+		errorHandler: func(err error) {},
+		batches:      make(map[int32][]KafkaRecord),
+		done:         make(chan struct{}),
+	}
+	for _, option := range options {
+		option(&s)
+	}
+
+	switch s.encoding {
+	case KafkaEncodingJSON, KafkaEncodingBinary, KafkaEncodingRaw:
+	default:
+		return nil, ErrKafkaEncoding
+	}
+
+	switch s.compression {
+	case KafkaCompressionNone, KafkaCompressionGzip:
+	default:
+		return nil, ErrKafkaCompression
+	}
+
+	if s.keyTemplate != "" {
+		tmpl, err := template.New("key").Option("missingkey=zero").Parse(s.keyTemplate)
+		if err != nil {
+			return nil, err
+		}
+		s.key = tmpl
+	}
+
+	partitions, err := producer.Partitions(topic)
+	if err != nil {
+		return nil, err
+	}
+	if partitions < 1 || s.partition >= partitions {
+		return nil, ErrKafkaUnknownTopic
+	}
+	s.partitions = partitions
+
+	if s.linger > 0 {
+		s.wg.Add(1)
+		go s.run()
+	}
+	return &s, nil
+}
+
+// KafkaSinkOptionKey sets the text/template the key of each record is
+// executed from with its message, such as "{{.Host}}" or
+// "{{.Tag.Program}}". Messages whose key is empty are sent without one.
+func KafkaSinkOptionKey(keyTemplate string) func(*KafkaSink) {
+	return func(s *KafkaSink) {
+		s.keyTemplate = keyTemplate
+	}
+}
+
+// KafkaSinkOptionEncoding sets how messages are encoded in the values
+// of records.
+func KafkaSinkOptionEncoding(encoding KafkaEncoding) func(*KafkaSink) {
+	return func(s *KafkaSink) {
+		s.encoding = encoding
+	}
+}
+
+// KafkaSinkOptionCompression sets the compression codec of batches,
+// KafkaCompressionNone or KafkaCompressionGzip.
+func KafkaSinkOptionCompression(compression KafkaCompression) func(*KafkaSink) {
+	return func(s *KafkaSink) {
+		s.compression = compression
+	}
+}
+
+// KafkaSinkOptionPartitioner sets the KafkaPartitioner that chooses the
+// partition of records with a key. KafkaHashPartitioner is the default.
+func KafkaSinkOptionPartitioner(partitioner KafkaPartitioner) func(*KafkaSink) {
+	return func(s *KafkaSink) {
+		s.partitioner = partitioner
+	}
+}
+
+// KafkaSinkOptionPartition sends every record to the given partition.
+func KafkaSinkOptionPartition(partition int32) func(*KafkaSink) {
+	return func(s *KafkaSink) {
+		s.partition = partition
+	}
+}
+
+// KafkaSinkOptionBatchSize sets the number of messages batched before
+// they are produced.
+func KafkaSinkOptionBatchSize(n int) func(*KafkaSink) {
+	return func(s *KafkaSink) {
+		s.batchSize = n
+	}
+}
+
+// KafkaSinkOptionLinger sets the interval at which batches that are not
+// full are produced. With an interval of 0, batches are only produced
+// once they are full, or by Flush or Close.
+func KafkaSinkOptionLinger(linger time.Duration) func(*KafkaSink) {
+	return func(s *KafkaSink) {
+		s.linger = linger
+	}
+}
+
+// KafkaSinkOptionRetries sets how many times producing a batch is
+// retried, and how long to wait before the first retry. The wait
+// doubles on each retry.
+func KafkaSinkOptionRetries(retries int, backoff time.Duration) func(*KafkaSink) {
+	return func(s *KafkaSink) {
+		s.retries = retries
+		s.retryBackoff = backoff
+	}
+}
+
+// KafkaSinkOptionMaxBuffered sets the number of messages the KafkaSink
+// holds, in batches and being produced, before Send drops new messages
+// and returns ErrQueueFull. The default is 10000.
+func KafkaSinkOptionMaxBuffered(n int) func(*KafkaSink) {
+	return func(s *KafkaSink) {
+		s.maxBuffered = n
+	}
+}
+
+// KafkaSinkOptionErrorHandler sets a function called with the errors of
+// batches produced in the background, which have no Send to return
+// them. The batches are kept and produced again by the next flush.
+func KafkaSinkOptionErrorHandler(handler func(err error)) func(*KafkaSink) {
+	return func(s *KafkaSink) {
+		s.errorHandler = handler
+	}
+}
+
+// Send adds the message to the batch of its partition, and produces
+// the batches once they hold the batch size of messages, returning
+// their error, unless another flush is producing batches. The message
+// is kept even if an error is returned, except ErrQueueFull when the
+// KafkaSink holds the maximum number of buffered messages. Once the
+// KafkaSink is closed, Send returns os.ErrClosed.
+func (s *KafkaSink) Send(msg SyslogMsg) error {
+	record := KafkaRecord{
+		Time:    msg.Time,
+		Headers: []KafkaHeader{{Key: kafkaEncodingHeader, Value: []byte(s.encoding)}},
+	}
+
+	var err error
+	switch s.encoding {
+	case KafkaEncodingJSON:
+		record.Value, err = msg.JSON()
+	case KafkaEncodingBinary:
+		record.Value, err = msg.MarshalBinary()
+	case KafkaEncodingRaw:
+		record.Value = msg.Bytes()
+	}
+	if err != nil {
+		return err
+	}
+
+	if s.key != nil {
+		var b bytes.Buffer
+		if err := s.key.Execute(&b, &msg); err != nil {
+			return err
+		}
+		if b.Len() > 0 {
+			record.Key = b.Bytes()
+		}
+	}
+
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return os.ErrClosed
+	}
+	if s.buffered >= s.maxBuffered {
+		s.mutex.Unlock()
+		return ErrQueueFull
+	}
+
+	partition := s.partition
+	if partition < 0 && record.Key != nil {
+		partition = s.partitioner(record.Key, s.partitions)
+	} else if partition < 0 {
+		partition = s.next
+		s.next = (s.next + 1) % s.partitions
+	}
+
+	s.batches[partition] = append(s.batches[partition], record)
+	s.pending++
+	s.buffered++
+	full := s.pending >= s.batchSize && !s.flushing
+	s.mutex.Unlock()
+
+	if full {
+		return s.flush()
+	}
+	return nil
+}
+
+// Flush produces the batched messages, and returns the first error of
+// the batches that could not be produced, which are kept for the next
+// flush.
+func (s *KafkaSink) Flush() error {
+	return s.flush()
+}
+
+// Close stops the KafkaSink and produces the batched messages. The
+// messages of batches that could not be produced are lost, and the
+// first error is returned.
+func (s *KafkaSink) Close() error {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.done)
+	s.mutex.Unlock()
+
+	s.wg.Wait()
+	return s.flush()
+}
+
+// flush produces the batches in the order of their partitions, without
+// holding the mutex, and puts back the batches that could not be
+// produced ahead of the messages sent in the meantime.
+func (s *KafkaSink) flush() error {
+	s.flushMutex.Lock()
+	defer s.flushMutex.Unlock()
+
+	s.mutex.Lock()
+	batches := s.batches
+	s.batches = make(map[int32][]KafkaRecord)
+	s.pending = 0
+	s.flushing = true
+	s.mutex.Unlock()
+
+	partitions := make([]int32, 0, len(batches))
+	for partition := range batches {
+		partitions = append(partitions, partition)
+	}
+	sort.Slice(partitions, func(i, j int) bool { return partitions[i] < partitions[j] })
+
+	var err error
+	produced := 0
+	failed := make(map[int32][]KafkaRecord)
+	for _, partition := range partitions {
+		if e := s.produce(partition, batches[partition]); e != nil {
+			failed[partition] = batches[partition]
+			if err == nil {
+				err = e
+			}
+			continue
+		}
+		produced += len(batches[partition])
+	}
+
+	s.mutex.Lock()
+	for partition, records := range failed {
+		s.batches[partition] = append(records, s.batches[partition]...)
+		s.pending += len(records)
+	}
+	s.buffered -= produced
+	s.flushing = false
+	s.mutex.Unlock()
+	return err
+}
+
+// produce produces a batch, retrying with a doubling backoff. The flush
+// mutex must be held.
+func (s *KafkaSink) produce(partition int32, records []KafkaRecord) error {
+	backoff := s.retryBackoff
+	for retry := 0; ; retry++ {
+		err := s.producer.Produce(s.topic, partition, s.compression, records)
+		if err == nil || retry >= s.retries {
+			return err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (s *KafkaSink) run() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.linger)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.mutex.Lock()
+			pending := s.pending
+			s.mutex.Unlock()
+			if pending > 0 {
+				if err := s.flush(); err != nil {
+					s.errorHandler(err)
+				}
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,390 @@
+package captainslog_test
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/captainslog"
+)
+
+func newKafkaBroker(topic string, partitions int32) *captainslog.KafkaBroker {
+	b := captainslog.NewKafkaBroker()
+	b.CreateTopic(topic, partitions)
+	return b
+}
+
+func newKafkaSink(t *testing.T, producer captainslog.KafkaProducer, options ...func(*captainslog.KafkaSink)) *captainslog.KafkaSink {
+	t.Helper()
+	s, err := captainslog.NewKafkaSink(producer, "logs", options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func sendKafka(t *testing.T, s *captainslog.KafkaSink, msgs ...captainslog.SyslogMsg) {
+	t.Helper()
+	for _, msg := range msgs {
+		if err := s.Send(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// fetchAll returns the records of a partition of the "logs" topic.
+func fetchAll(t *testing.T, b *captainslog.KafkaBroker, partition int32) []captainslog.KafkaRecord {
+	t.Helper()
+	records, err := b.Fetch("logs", partition, 0, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+func TestKafkaSinkBatching(t *testing.T) {
+	b := newKafkaBroker("logs", 1)
+	s := newKafkaSink(t, b, captainslog.KafkaSinkOptionBatchSize(3), captainslog.KafkaSinkOptionLinger(0))
+
+	sendKafka(t, s, queueMsg(t, "1"), queueMsg(t, "2"))
+	if got := fetchAll(t, b, 0); len(got) != 0 {
+		t.Errorf("want no records before the batch is full, got %d", len(got))
+	}
+	sendKafka(t, s, queueMsg(t, "3"))
+	if want, got := 3, len(fetchAll(t, b, 0)); want != got {
+		t.Errorf("want %d records, got %d", want, got)
+	}
+
+	sendKafka(t, s, queueMsg(t, "4"))
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 4, len(fetchAll(t, b, 0)); want != got {
+		t.Errorf("want %d records, got %d", want, got)
+	}
+
+	sendKafka(t, s, queueMsg(t, "5"))
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 5, len(fetchAll(t, b, 0)); want != got {
+		t.Errorf("want %d records, got %d", want, got)
+	}
+	if err := s.Send(queueMsg(t, "late")); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("want %v, got %v", os.ErrClosed, err)
+	}
+}
+
+func TestKafkaSinkLinger(t *testing.T) {
+	b := newKafkaBroker("logs", 1)
+	s := newKafkaSink(t, b, captainslog.KafkaSinkOptionLinger(10*time.Millisecond))
+	defer s.Close()
+
+	sendKafka(t, s, queueMsg(t, "1"))
+	deadline := time.Now().Add(5 * time.Second)
+	for len(fetchAll(t, b, 0)) == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if want, got := 1, len(fetchAll(t, b, 0)); want != got {
+		t.Errorf("want %d records, got %d", want, got)
+	}
+}
+
+func TestKafkaSinkPartitions(t *testing.T) {
+	hosts := []string{"web1", "web2", "db1", "web1"}
+	msgs := func(t *testing.T) []captainslog.SyslogMsg {
+		var msgs []captainslog.SyslogMsg
+		for _, host := range hosts {
+			msgs = append(msgs, fileSinkMsg(t, host, "nginx", "hello"))
+		}
+		return msgs
+	}
+
+	t.Run("key", func(t *testing.T) {
+		b := newKafkaBroker("logs", 4)
+		s := newKafkaSink(t, b, captainslog.KafkaSinkOptionKey("{{.Host}}"))
+		sendKafka(t, s, msgs(t)...)
+		if err := s.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		got := make(map[string]int)
+		for partition := int32(0); partition < 4; partition++ {
+			for _, record := range fetchAll(t, b, partition) {
+				if want := captainslog.KafkaHashPartitioner(record.Key, 4); want != partition {
+					t.Errorf("want key %q in partition %d, got %d", record.Key, want, partition)
+				}
+				got[string(record.Key)]++
+			}
+		}
+		if want := map[string]int{"web1": 2, "web2": 1, "db1": 1}; len(want) != len(got) || want["web1"] != got["web1"] || want["db1"] != got["db1"] {
+			t.Errorf("want %v, got %v", want, got)
+		}
+	})
+
+	t.Run("no key", func(t *testing.T) {
+		b := newKafkaBroker("logs", 4)
+		s := newKafkaSink(t, b, captainslog.KafkaSinkOptionKey("{{.Cee}}"))
+		sendKafka(t, s, msgs(t)...)
+		if err := s.Close(); err != nil {
+			t.Fatal(err)
+		}
+		for partition := int32(0); partition < 4; partition++ {
+			records := fetchAll(t, b, partition)
+			if len(records) != 1 || records[0].Key != nil {
+				t.Errorf("want a record without key in partition %d, got %+v", partition, records)
+			}
+		}
+	})
+
+	t.Run("partition", func(t *testing.T) {
+		b := newKafkaBroker("logs", 4)
+		s := newKafkaSink(t, b, captainslog.KafkaSinkOptionKey("{{.Host}}"), captainslog.KafkaSinkOptionPartition(2))
+		sendKafka(t, s, msgs(t)...)
+		if err := s.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if want, got := len(hosts), len(fetchAll(t, b, 2)); want != got {
+			t.Errorf("want %d records, got %d", want, got)
+		}
+	})
+
+	t.Run("partitioner", func(t *testing.T) {
+		b := newKafkaBroker("logs", 4)
+		s := newKafkaSink(t, b,
+			captainslog.KafkaSinkOptionKey("{{.Tag.Program}}"),
+			captainslog.KafkaSinkOptionPartitioner(func(key []byte, partitions int32) int32 {
+				return partitions - 1
+			}),
+		)
+		sendKafka(t, s, msgs(t)...)
+		if err := s.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if want, got := len(hosts), len(fetchAll(t, b, 3)); want != got {
+			t.Errorf("want %d records, got %d", want, got)
+		}
+	})
+}
+
+// flakyProducer fails until it has been called fails times, and
+// records the compression of the batches it produced.
+type flakyProducer struct {
+	*captainslog.KafkaBroker
+	mutex        sync.Mutex
+	fails        int
+	calls        int
+	compressions []captainslog.KafkaCompression
+}
+
+func (p *flakyProducer) Produce(topic string, partition int32, compression captainslog.KafkaCompression, records []captainslog.KafkaRecord) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.calls++
+	if p.fails > 0 {
+		p.fails--
+		return errors.New("leader not available")
+	}
+	p.compressions = append(p.compressions, compression)
+	return p.KafkaBroker.Produce(topic, partition, compression, records)
+}
+
+func TestKafkaSinkRetries(t *testing.T) {
+	b := newKafkaBroker("logs", 1)
+	p := &flakyProducer{KafkaBroker: b, fails: 2}
+	s := newKafkaSink(t, p,
+		captainslog.KafkaSinkOptionLinger(0),
+		captainslog.KafkaSinkOptionRetries(2, time.Millisecond),
+		captainslog.KafkaSinkOptionCompression(captainslog.KafkaCompressionGzip),
+	)
+	defer s.Close()
+
+	sendKafka(t, s, queueMsg(t, "1"))
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 3, p.calls; want != got {
+		t.Errorf("want %d calls, got %d", want, got)
+	}
+	if want, got := []captainslog.KafkaCompression{captainslog.KafkaCompressionGzip}, p.compressions; len(got) != 1 || want[0] != got[0] {
+		t.Errorf("want %v, got %v", want, got)
+	}
+
+	// once the retries are used up the batch is kept for the next flush
+	p.fails = 3
+	sendKafka(t, s, queueMsg(t, "2"))
+	if err := s.Flush(); err == nil {
+		t.Errorf("want error")
+	}
+	sendKafka(t, s, queueMsg(t, "3"))
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, record := range fetchAll(t, b, 0) {
+		msg, err := captainslog.NewSyslogMsgFromJSON(record.Value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, msg.Content)
+	}
+	if want := []string{" 1", " 2", " 3"}; !equalStrings(want, got) {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func TestKafkaSinkErrorHandler(t *testing.T) {
+	p := &flakyProducer{KafkaBroker: newKafkaBroker("logs", 1), fails: 1}
+	errs := make(chan error, 1)
+	s := newKafkaSink(t, p,
+		captainslog.KafkaSinkOptionLinger(time.Millisecond),
+		captainslog.KafkaSinkOptionRetries(0, 0),
+		captainslog.KafkaSinkOptionErrorHandler(func(err error) {
+			select {
+			case errs <- err:
+			default:
+			}
+		}),
+	)
+	defer s.Close()
+
+	sendKafka(t, s, queueMsg(t, "1"))
+	select {
+	case err := <-errs:
+		if err == nil {
+			t.Errorf("want error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for error")
+	}
+}
+
+func TestKafkaSinkMaxBuffered(t *testing.T) {
+	b := newKafkaBroker("logs", 1)
+	p := &flakyProducer{KafkaBroker: b, fails: 2}
+	s := newKafkaSink(t, p,
+		captainslog.KafkaSinkOptionLinger(0),
+		captainslog.KafkaSinkOptionRetries(0, 0),
+		captainslog.KafkaSinkOptionMaxBuffered(2),
+	)
+	defer s.Close()
+
+	sendKafka(t, s, queueMsg(t, "1"), queueMsg(t, "2"))
+	if want, got := captainslog.ErrQueueFull, s.Send(queueMsg(t, "3")); want != got {
+		t.Errorf("want %v, got %v", want, got)
+	}
+
+	// failed batches still count until they are produced
+	if err := s.Flush(); err == nil {
+		t.Errorf("want error")
+	}
+	if want, got := captainslog.ErrQueueFull, s.Send(queueMsg(t, "3")); want != got {
+		t.Errorf("want %v, got %v", want, got)
+	}
+	if err := s.Flush(); err == nil {
+		t.Errorf("want error")
+	}
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	sendKafka(t, s, queueMsg(t, "4"))
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, record := range fetchAll(t, b, 0) {
+		msg, err := captainslog.NewSyslogMsgFromJSON(record.Value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, msg.Content)
+	}
+	if want := []string{" 1", " 2", " 4"}; !equalStrings(want, got) {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+// blockingProducer blocks in Produce until it is released.
+type blockingProducer struct {
+	*captainslog.KafkaBroker
+	started chan struct{}
+	release chan struct{}
+}
+
+func (p *blockingProducer) Produce(topic string, partition int32, compression captainslog.KafkaCompression, records []captainslog.KafkaRecord) error {
+	p.started <- struct{}{}
+	<-p.release
+	return p.KafkaBroker.Produce(topic, partition, compression, records)
+}
+
+func TestKafkaSinkSendDuringFlush(t *testing.T) {
+	b := newKafkaBroker("logs", 1)
+	p := &blockingProducer{KafkaBroker: b, started: make(chan struct{}, 2), release: make(chan struct{})}
+	s := newKafkaSink(t, p,
+		captainslog.KafkaSinkOptionLinger(0),
+		captainslog.KafkaSinkOptionBatchSize(1),
+	)
+
+	flushed := make(chan error, 1)
+	go func() {
+		flushed <- s.Send(queueMsg(t, "1"))
+	}()
+	<-p.started
+
+	// a full batch is left to the next flush rather than waiting for
+	// the one in progress
+	sent := make(chan error, 1)
+	go func() {
+		sent <- s.Send(queueMsg(t, "2"))
+	}()
+	select {
+	case err := <-sent:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Send waited for the flush in progress")
+	}
+
+	close(p.release)
+	if err := <-flushed; err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 2, len(fetchAll(t, b, 0)); want != got {
+		t.Errorf("want %d records, got %d", want, got)
+	}
+}
+
+func TestNewKafkaSinkErrors(t *testing.T) {
+	b := newKafkaBroker("logs", 2)
+	testCases := []struct {
+		name    string
+		topic   string
+		options []func(*captainslog.KafkaSink)
+		want    error
+	}{
+		{name: "unknown topic", topic: "nope", want: captainslog.ErrKafkaUnknownTopic},
+		{name: "unknown partition", topic: "logs", options: []func(*captainslog.KafkaSink){captainslog.KafkaSinkOptionPartition(2)}, want: captainslog.ErrKafkaUnknownTopic},
+		{name: "unknown encoding", topic: "logs", options: []func(*captainslog.KafkaSink){captainslog.KafkaSinkOptionEncoding("xml")}, want: captainslog.ErrKafkaEncoding},
+		{name: "unknown compression", topic: "logs", options: []func(*captainslog.KafkaSink){captainslog.KafkaSinkOptionCompression(4)}, want: captainslog.ErrKafkaCompression},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := captainslog.NewKafkaSink(b, tc.topic, tc.options...); tc.want != err {
+				t.Errorf("want %v, got %v", tc.want, err)
+			}
+		})
+	}
+
+	if _, err := captainslog.NewKafkaSink(b, "logs", captainslog.KafkaSinkOptionKey("{{.Host")); err == nil {
+		t.Errorf("want error for bad template")
+	}
+}
@@ -0,0 +1,364 @@
+package captainslog
+
+import (
+	"bytes"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// kafkaSourcePollInterval is the default interval at which a
+	// KafkaSource fetches new records once it has read them all.
+	kafkaSourcePollInterval = 100 * time.Millisecond
+
+	// kafkaSourceCommitInterval is the default interval at which a
+	// KafkaSource commits the offsets of its consumer group.
+	kafkaSourceCommitInterval = 5 * time.Second
+
+	// kafkaSourceFetchSize is the number of records a KafkaSource
+	// fetches at once.
+	kafkaSourceFetchSize = 100
+)
+
+// KafkaSource consumes the records of a Kafka topic, read with a
+// KafkaConsumer, and decodes them into SyslogMsgs. It is a Listener.
+//
+// Records are decoded with the KafkaEncoding in their
+// "captainslog-encoding" header, as written by KafkaSink, or with the
+// default encoding if they have none. With a consumer group, the
+// offsets of the records that were processed are committed, and a
+// KafkaSource of the same group resumes after them.
+type KafkaSource struct {
+	consumer       KafkaConsumer
+	topic          string
+	partitions     []int32
+	group          string
+	startOffset    int64
+	encoding       KafkaEncoding
+	parserOptions  []func(*Parser)
+	jsonOptions    []func(*JSONOptions)
+	pollInterval   time.Duration
+	commitInterval time.Duration
+	errorHandler   func(err error)
+
+	// fetched is only used by the fetching goroutine
+	fetched map[int32]int64
+
+	records chan kafkaSourceRecord
+	done    chan struct{}
+	wg      sync.WaitGroup
+
+	mutex     sync.Mutex
+	offsets   map[int32]int64
+	committed map[int32]int64
+	last      *kafkaSourceRecord
+	closed    bool
+}
+
+// kafkaSourceRecord is a record fetched by a KafkaSource.
+type kafkaSourceRecord struct {
+	partition int32
+	record    KafkaRecord
+}
+
+// NewKafkaSource returns a new KafkaSource consuming every partition of
+// the topic, from the offsets committed by its consumer group, or else
+// from the oldest records.
+func NewKafkaSource(consumer KafkaConsumer, topic string, options ...func(*KafkaSource)) (*KafkaSource, error) {
+	s := KafkaSource{
+		consumer:       consumer,
+		topic:          topic,
+		startOffset:    KafkaOffsetOldest,
+		encoding:       KafkaEncodingJSON,
+		pollInterval:   kafkaSourcePollInterval,
+		commitInterval: kafkaSourceCommitInterval,
+		errorHandler:   func(err error) {},
+		fetched:        make(map[int32]int64),
+		records:        make(chan kafkaSourceRecord, kafkaSourceFetchSize),
+		done:           make(chan struct{}),
+		offsets:        make(map[int32]int64),
+		committed:      make(map[int32]int64),
+	}
+	for _, option := range options {
+		option(&s)
+	}
+
+	if s.partitions == nil {
+		n, err := consumer.Partitions(topic)
+		if err != nil {
+			return nil, err
+		}
+		for partition := int32(0); partition < n; partition++ {
+			s.partitions = append(s.partitions, partition)
+		}
+	}
+
+	for _, partition := range s.partitions {
+		offset := KafkaOffsetNewest
+		if s.group != "" {
+			committed, err := consumer.Committed(s.group, topic, partition)
+			if err != nil {
+				return nil, err
+			}
+			offset = committed
+		}
+		if offset < 0 {
+			oldest, newest, err := consumer.Offsets(topic, partition)
+			if err != nil {
+				return nil, err
+			}
+			offset = oldest
+			if s.startOffset == KafkaOffsetNewest {
+				offset = newest
+			}
+		}
+		s.fetched[partition] = offset
+		s.offsets[partition] = offset
+		s.committed[partition] = offset
+	}
+
+	s.wg.Add(1)
+	go s.run()
+	return &s, nil
+}
+
+// KafkaSourceOptionGroup sets the consumer group the offsets of the
+// KafkaSource are committed for.
+func KafkaSourceOptionGroup(group string) func(*KafkaSource) {
+	return func(s *KafkaSource) {
+		s.group = group
+	}
+}
+
+// KafkaSourceOptionPartitions sets the partitions of the topic the
+// KafkaSource consumes, instead of all of them.
+func KafkaSourceOptionPartitions(partitions ...int32) func(*KafkaSource) {
+	return func(s *KafkaSource) {
+		s.partitions = partitions
+	}
+}
+
+// KafkaSourceOptionOffset sets where the KafkaSource starts in the
+// partitions its consumer group has no offset for, KafkaOffsetOldest
+// or KafkaOffsetNewest.
+func KafkaSourceOptionOffset(offset int64) func(*KafkaSource) {
+	return func(s *KafkaSource) {
+		s.startOffset = offset
+	}
+}
+
+// KafkaSourceOptionEncoding sets the encoding of records without a
+// "captainslog-encoding" header. KafkaEncodingJSON is the default.
+func KafkaSourceOptionEncoding(encoding KafkaEncoding) func(*KafkaSource) {
+	return func(s *KafkaSource) {
+		s.encoding = encoding
+	}
+}
+
+// KafkaSourceOptionParser sets the options of the Parser used to decode
+// records encoded with KafkaEncodingRaw.
+func KafkaSourceOptionParser(options ...func(*Parser)) func(*KafkaSource) {
+	return func(s *KafkaSource) {
+		s.parserOptions = options
+	}
+}
+
+// KafkaSourceOptionJSON sets the options used to decode records encoded
+// with KafkaEncodingJSON, such as their JSONSchema.
+func KafkaSourceOptionJSON(options ...func(*JSONOptions)) func(*KafkaSource) {
+	return func(s *KafkaSource) {
+		s.jsonOptions = options
+	}
+}
+
+// KafkaSourceOptionPollInterval sets the interval at which the
+// KafkaSource fetches new records once it has read them all.
+func KafkaSourceOptionPollInterval(interval time.Duration) func(*KafkaSource) {
+	return func(s *KafkaSource) {
+		s.pollInterval = interval
+	}
+}
+
+// KafkaSourceOptionCommitInterval sets the interval at which the
+// offsets of the consumer group are committed.
+func KafkaSourceOptionCommitInterval(interval time.Duration) func(*KafkaSource) {
+	return func(s *KafkaSource) {
+		s.commitInterval = interval
+	}
+}
+
+// KafkaSourceOptionErrorHandler sets a function called with the errors
+// of fetching records and committing offsets in the background, which
+// are retried at the next interval.
+func KafkaSourceOptionErrorHandler(handler func(err error)) func(*KafkaSource) {
+	return func(s *KafkaSource) {
+		s.errorHandler = handler
+	}
+}
+
+// Receive blocks until a record is fetched and returns it decoded.
+// Records that fail to decode are returned along with the error. The
+// record returned by Receive is considered processed, and its offset
+// is committed, once Receive is called again or Commit or Close is
+// called. Once the KafkaSource is closed, Receive returns
+// net.ErrClosed.
+func (s *KafkaSource) Receive() (SyslogMsg, error) {
+	var record kafkaSourceRecord
+	select {
+	case record = <-s.records:
+	case <-s.done:
+		return SyslogMsg{}, net.ErrClosed
+	}
+
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return SyslogMsg{}, net.ErrClosed
+	}
+	s.process()
+	s.last = &record
+	s.mutex.Unlock()
+
+	return s.decode(record.record)
+}
+
+// Commit marks the records returned by Receive as processed, and
+// commits the offsets of the consumer group.
+func (s *KafkaSource) Commit() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.process()
+	return s.commit()
+}
+
+// Close stops consuming the topic, marks the records returned by
+// Receive as processed, and commits the offsets of the consumer group.
+// Records that were fetched but not returned by Receive are fetched
+// again by the next KafkaSource of the group.
+func (s *KafkaSource) Close() error {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.done)
+	s.mutex.Unlock()
+
+	s.wg.Wait()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.process()
+	return s.commit()
+}
+
+// process records the offset of the last record returned by Receive.
+// The mutex must be held.
+func (s *KafkaSource) process() {
+	if s.last == nil {
+		return
+	}
+	s.offsets[s.last.partition] = s.last.record.Offset + 1
+	s.last = nil
+}
+
+// commit commits the offsets that changed since they were last
+// committed. The mutex must be held.
+func (s *KafkaSource) commit() error {
+	if s.group == "" {
+		return nil
+	}
+	for _, partition := range s.partitions {
+		offset := s.offsets[partition]
+		if offset == s.committed[partition] {
+			continue
+		}
+		if err := s.consumer.Commit(s.group, s.topic, partition, offset); err != nil {
+			return err
+		}
+		s.committed[partition] = offset
+	}
+	return nil
+}
+
+// decode decodes a record with the encoding in its header.
+func (s *KafkaSource) decode(record KafkaRecord) (SyslogMsg, error) {
+	encoding := s.encoding
+	for _, header := range record.Headers {
+		if header.Key == kafkaEncodingHeader {
+			encoding = KafkaEncoding(header.Value)
+		}
+	}
+
+	switch encoding {
+	case KafkaEncodingJSON:
+		return NewSyslogMsgFromJSON(record.Value, s.jsonOptions...)
+	case KafkaEncodingBinary:
+		return NewSyslogMsgFromBinary(record.Value)
+	case KafkaEncodingRaw:
+		b := record.Value
+		if !bytes.HasSuffix(b, []byte("\n")) {
+			b = append(b, '\n')
+		}
+		return NewParser(s.parserOptions...).ParseBytes(b)
+	}
+	return SyslogMsg{}, ErrKafkaEncoding
+}
+
+func (s *KafkaSource) run() {
+	defer s.wg.Done()
+
+	poll := time.NewTicker(s.pollInterval)
+	defer poll.Stop()
+	commit := time.NewTicker(s.commitInterval)
+	defer commit.Stop()
+
+	if !s.fetch() {
+		return
+	}
+	for {
+		select {
+		case <-poll.C:
+			if !s.fetch() {
+				return
+			}
+		case <-commit.C:
+			s.mutex.Lock()
+			if err := s.commit(); err != nil {
+				s.errorHandler(err)
+			}
+			s.mutex.Unlock()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// fetch fetches the new records of every partition, until there are
+// none left. It returns false once the KafkaSource is closed.
+func (s *KafkaSource) fetch() bool {
+	for more := true; more; {
+		more = false
+		for _, partition := range s.partitions {
+			records, err := s.consumer.Fetch(s.topic, partition, s.fetched[partition], kafkaSourceFetchSize)
+			if err != nil {
+				s.errorHandler(err)
+				continue
+			}
+			for _, record := range records {
+				select {
+				case s.records <- kafkaSourceRecord{partition: partition, record: record}:
+				case <-s.done:
+					return false
+				}
+				s.fetched[partition] = record.Offset + 1
+			}
+			if len(records) == kafkaSourceFetchSize {
+				more = true
+			}
+		}
+	}
+	return true
+}
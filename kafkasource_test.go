@@ -0,0 +1,155 @@
+package captainslog_test
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/digitalocean/captainslog"
+)
+
+func newKafkaSource(t *testing.T, consumer captainslog.KafkaConsumer, options ...func(*captainslog.KafkaSource)) *captainslog.KafkaSource {
+	t.Helper()
+	s, err := captainslog.NewKafkaSource(consumer, "logs", options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// produceKafka sends messages with the given contents through a
+// KafkaSink.
+func produceKafka(t *testing.T, b *captainslog.KafkaBroker, contents ...string) {
+	t.Helper()
+	s := newKafkaSink(t, b)
+	for _, content := range contents {
+		sendKafka(t, s, queueMsg(t, content))
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKafkaSourceEncodings(t *testing.T) {
+	msg, err := captainslog.NewSyslogMsgFromBytes([]byte("<187>2016-03-08T14:59:36.293816-07:00 host.example.com nginx[12]: @cee:{\"status\":500}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg.JSONValues["count"] = int64(2)
+
+	for _, encoding := range []captainslog.KafkaEncoding{captainslog.KafkaEncodingJSON, captainslog.KafkaEncodingBinary, captainslog.KafkaEncodingRaw} {
+		t.Run(string(encoding), func(t *testing.T) {
+			b := newKafkaBroker("logs", 3)
+			sink := newKafkaSink(t, b,
+				captainslog.KafkaSinkOptionKey("{{.Host}}"),
+				captainslog.KafkaSinkOptionEncoding(encoding),
+				captainslog.KafkaSinkOptionCompression(captainslog.KafkaCompressionGzip),
+			)
+			sendKafka(t, sink, msg)
+			if err := sink.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			source := newKafkaSource(t, b)
+			defer source.Close()
+			got, err := receive(t, source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want, got := msg.String(), got.String(); want != got {
+				t.Errorf("want %q, got %q", want, got)
+			}
+
+			// only the binary encoding keeps the types of JSONValues
+			if encoding == captainslog.KafkaEncodingBinary && !reflect.DeepEqual(msg.JSONValues, got.JSONValues) {
+				t.Errorf("want %#v, got %#v", msg.JSONValues, got.JSONValues)
+			}
+		})
+	}
+}
+
+func TestKafkaSourceGroup(t *testing.T) {
+	b := newKafkaBroker("logs", 1)
+	produceKafka(t, b, "1", "2", "3", "4")
+
+	s := newKafkaSource(t, b, captainslog.KafkaSourceOptionGroup("relay"))
+	if want, got := []string{" 1", " 2"}, receiveContents(t, s, 2); !equalStrings(want, got) {
+		t.Errorf("want %q, got %q", want, got)
+	}
+	if err := s.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if offset, err := b.Committed("relay", "logs", 0); err != nil || offset != 2 {
+		t.Errorf("want %d, got %d %v", 2, offset, err)
+	}
+	receiveContents(t, s, 1)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Receive(); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("want %v, got %v", net.ErrClosed, err)
+	}
+
+	// the group resumes after the records that were processed
+	s = newKafkaSource(t, b, captainslog.KafkaSourceOptionGroup("relay"))
+	defer s.Close()
+	if want, got := []string{" 4"}, receiveContents(t, s, 1); !equalStrings(want, got) {
+		t.Errorf("want %q, got %q", want, got)
+	}
+
+	// another group starts from the oldest records
+	other := newKafkaSource(t, b, captainslog.KafkaSourceOptionGroup("archive"))
+	defer other.Close()
+	if want, got := []string{" 1"}, receiveContents(t, other, 1); !equalStrings(want, got) {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func TestKafkaSourceOffsetNewest(t *testing.T) {
+	b := newKafkaBroker("logs", 2)
+	produceKafka(t, b, "old")
+
+	s := newKafkaSource(t, b, captainslog.KafkaSourceOptionOffset(captainslog.KafkaOffsetNewest))
+	defer s.Close()
+	produceKafka(t, b, "new")
+	if want, got := []string{" new"}, receiveContents(t, s, 1); !equalStrings(want, got) {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func TestKafkaSourceForeignRecords(t *testing.T) {
+	b := newKafkaBroker("logs", 2)
+	err := b.Produce("logs", 1, captainslog.KafkaCompressionNone, []captainslog.KafkaRecord{
+		{Value: []byte("<38>2006-01-02T15:04:05.999999-07:00 host.example.org prog: raw")},
+		{Value: []byte("not syslog")},
+		{Value: []byte(`{"syslog_tag":"prog:"}`), Headers: []captainslog.KafkaHeader{{Key: "captainslog-encoding", Value: []byte("json")}}},
+		{Value: []byte("?"), Headers: []captainslog.KafkaHeader{{Key: "captainslog-encoding", Value: []byte("xml")}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := newKafkaSource(t, b,
+		captainslog.KafkaSourceOptionPartitions(1),
+		captainslog.KafkaSourceOptionEncoding(captainslog.KafkaEncodingRaw),
+	)
+	defer s.Close()
+
+	msg, err := receive(t, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := " raw", msg.Content; want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+	if _, err := receive(t, s); err == nil {
+		t.Errorf("want error for a record that is not syslog")
+	}
+	if msg, err = receive(t, s); err != nil || msg.Tag.Program != "prog" {
+		t.Errorf("want a JSON record, got %v %v", msg, err)
+	}
+	if _, err := receive(t, s); err != captainslog.ErrKafkaEncoding {
+		t.Errorf("want %v, got %v", captainslog.ErrKafkaEncoding, err)
+	}
+}
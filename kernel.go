@@ -0,0 +1,244 @@
+package captainslog
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	uptimeStart = '['
+	uptimeEnd   = ']'
+	kmsgSep     = ','
+	kmsgEnd     = ';'
+
+	kernelProgram = "kernel"
+)
+
+var (
+	//ErrBadUptime is returned when the uptime of a kernel message is malformed.
+	ErrBadUptime = errors.New("Uptime not found")
+
+	procUptime = "/proc/uptime"
+)
+
+// OptionKernelFormat sets the parser to expect kernel ring buffer
+// messages instead of RFC3164 messages. Both the dmesg format, as in
+// "<6>[  123.456789] eth0: link up", and /dev/kmsg records, as in
+// "6,1234,5678901,-;eth0: link up", are supported. Monotonic timestamps
+// are converted to wall time using the boot time set by OptionBootTime,
+// or the boot time of the local host if it is not set. The resulting
+// SyslogMsg has the local hostname as its Host and "kernel" as its
+// Tag.Program. Key/value pairs that follow a /dev/kmsg record, such as
+// " SUBSYSTEM=net", are added to JSONValues.
+func OptionKernelFormat(p *Parser) {
+	p.optionKernelFormat = true
+}
+
+// OptionBootTime is a helper function to configure the boot time that
+// monotonic timestamps in kernel messages are relative to.
+func OptionBootTime(bootTime time.Time) func(*Parser) {
+	return func(p *Parser) {
+		p.bootTime = bootTime
+	}
+}
+
+func (p *Parser) parseKernel() error {
+	var err error
+	var offset int
+	var uptime time.Duration
+
+	if len(p.buf) > 0 && p.buf[0] == priStart {
+		offset, p.msg.Pri, err = ParsePri(p.buf)
+		if err != nil {
+			return err
+		}
+		p.cur = p.cur + offset
+
+		offset, uptime, err = ParseUptime(p.buf[p.cur:])
+		if err != nil {
+			return err
+		}
+		p.cur = p.cur + offset
+	} else {
+		offset, p.msg.Pri, uptime, err = ParseKmsgHeader(p.buf)
+		if err != nil {
+			return err
+		}
+		p.cur = p.cur + offset
+	}
+
+	if p.bootTime.IsZero() {
+		p.bootTime, err = SystemBootTime()
+		if err != nil {
+			return err
+		}
+	}
+	p.msg.Time = p.bootTime.Add(uptime).In(p.location)
+
+	if p.hostname == "" {
+		p.hostname, err = os.Hostname()
+		if err != nil {
+			return ErrBadHost
+		}
+	}
+	p.msg.Host = p.hostname
+
+	tag := NewTag()
+	tag.Program = kernelProgram
+	p.msg.Tag = *tag
+
+	copts := make([]func(*contentOpts), 0)
+	if p.requireTerminator {
+		copts = append(copts, ContentOptionRequireTerminator)
+	}
+
+	var content Content
+	offset, content, err = ParseContent(p.buf[p.cur:], copts...)
+	p.cur = p.cur + offset
+	p.msg.Content = content.Content
+	if !strings.HasPrefix(p.msg.Content, " ") {
+		p.msg.Content = " " + p.msg.Content
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, line := range strings.Split(string(p.buf[p.cur:]), "\n") {
+		if !strings.HasPrefix(line, " ") {
+			continue
+		}
+		kv := strings.SplitN(line[1:], "=", 2)
+		if len(kv) == 2 {
+			p.msg.JSONValues[kv[0]] = kv[1]
+		}
+	}
+	return err
+}
+
+// ParseUptime will try to find a bracketed kernel uptime, as in
+// "[  123.456789]", at the beginning of the passed in []byte. It
+// returns the offset from the start of the []byte to the end of
+// the uptime, the uptime, and an error.
+func ParseUptime(buf []byte) (int, time.Duration, error) {
+	var uptime time.Duration
+	var offset int
+
+	if len(buf) == 0 || buf[offset] != uptimeStart {
+		return offset, uptime, ErrBadUptime
+	}
+
+	offset++
+	for offset < len(buf) && buf[offset] == ' ' {
+		offset++
+	}
+
+	tokenStart := offset
+	for offset < len(buf) && buf[offset] != uptimeEnd {
+		offset++
+	}
+	if offset > len(buf)-1 {
+		return offset, uptime, ErrBadUptime
+	}
+
+	uptime, err := parseSeconds(string(buf[tokenStart:offset]))
+	if err != nil {
+		return offset, uptime, ErrBadUptime
+	}
+
+	offset++
+	return offset, uptime, nil
+}
+
+// ParseKmsgHeader will try to find a /dev/kmsg record header, as in
+// "6,1234,5678901,-;", at the beginning of the passed in []byte. It
+// returns the offset from the start of the []byte to the end of the
+// header, a captainslog.Priority, the uptime in the header, and an error.
+func ParseKmsgHeader(buf []byte) (int, Priority, time.Duration, error) {
+	var pri Priority
+	var uptime time.Duration
+	var offset int
+
+	end := 0
+	for end < len(buf) && buf[end] != kmsgEnd && buf[end] != '\n' {
+		end++
+	}
+	if end > len(buf)-1 || buf[end] != kmsgEnd {
+		return offset, pri, uptime, ErrBadPriority
+	}
+
+	// prival, sequence number, timestamp and flags, optionally
+	// followed by more fields such as the caller
+	fields := strings.Split(string(buf[:end]), string(kmsgSep))
+	if len(fields) < 4 {
+		return offset, pri, uptime, ErrBadPriority
+	}
+
+	pVal, err := strconv.Atoi(fields[0])
+	if err != nil || pVal < 0 {
+		return offset, pri, uptime, ErrBadPriority
+	}
+	if err = pri.SetFacility(Facility(pVal / 8)); err != nil {
+		return offset, pri, uptime, err
+	}
+	if err = pri.SetSeverity(Severity(pVal % 8)); err != nil {
+		return offset, pri, uptime, err
+	}
+
+	usec, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil || usec < 0 {
+		return offset, pri, uptime, ErrBadUptime
+	}
+	uptime = time.Duration(usec) * time.Microsecond
+
+	offset = end + 1
+	return offset, pri, uptime, nil
+}
+
+// SystemBootTime returns the time the local host booted, derived
+// from /proc/uptime.
+func SystemBootTime() (time.Time, error) {
+	b, err := os.ReadFile(procUptime)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	fields := strings.Fields(string(b))
+	if len(fields) == 0 {
+		return time.Time{}, ErrBadUptime
+	}
+
+	uptime, err := parseSeconds(fields[0])
+	if err != nil {
+		return time.Time{}, ErrBadUptime
+	}
+	return time.Now().Add(-uptime), nil
+}
+
+// parseSeconds parses a decimal number of seconds such as "123.456789"
+// without the rounding errors of parsing it as a float.
+func parseSeconds(s string) (time.Duration, error) {
+	parts := strings.SplitN(s, ".", 2)
+
+	secs, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || secs < 0 {
+		return 0, ErrBadUptime
+	}
+	d := time.Duration(secs) * time.Second
+
+	if len(parts) == 2 {
+		frac := parts[1]
+		if len(frac) == 0 || len(frac) > 9 {
+			return 0, ErrBadUptime
+		}
+		frac = frac + strings.Repeat("0", 9-len(frac))
+		nsecs, err := strconv.ParseInt(frac, 10, 64)
+		if err != nil || nsecs < 0 {
+			return 0, ErrBadUptime
+		}
+		d += time.Duration(nsecs)
+	}
+	return d, nil
+}
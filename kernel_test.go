@@ -0,0 +1,178 @@
+package captainslog_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/captainslog"
+)
+
+func TestParserKernelFormat(t *testing.T) {
+	bootTime := time.Date(2016, time.March, 8, 14, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name     string
+		input    string
+		err      error
+		facility captainslog.Facility
+		severity captainslog.Severity
+		time     time.Time
+		content  string
+		jsonKeys []string
+	}{
+		{
+			name:     "parse dmesg",
+			input:    "<6>[  123.456789] eth0: link up\n",
+			facility: captainslog.Kern,
+			severity: captainslog.Info,
+			time:     bootTime.Add(123456789 * time.Microsecond),
+			content:  " eth0: link up",
+			jsonKeys: []string{},
+		},
+		{
+			name:     "parse dmesg without leading spaces",
+			input:    "<3>[98765.000001] oom-kill: constraint=CONSTRAINT_NONE\n",
+			facility: captainslog.Kern,
+			severity: captainslog.Err,
+			time:     bootTime.Add(98765000001 * time.Microsecond),
+			content:  " oom-kill: constraint=CONSTRAINT_NONE",
+			jsonKeys: []string{},
+		},
+		{
+			name:     "parse kmsg",
+			input:    "6,1234,5678901,-;eth0: link up\n",
+			facility: captainslog.Kern,
+			severity: captainslog.Info,
+			time:     bootTime.Add(5678901 * time.Microsecond),
+			content:  " eth0: link up",
+			jsonKeys: []string{},
+		},
+		{
+			name:     "parse kmsg with caller and dictionary",
+			input:    "4,581,12345678,-,caller=T1;usb 1-1: new device\n SUBSYSTEM=usb\n DEVICE=c189:1\n",
+			facility: captainslog.Kern,
+			severity: captainslog.Warning,
+			time:     bootTime.Add(12345678 * time.Microsecond),
+			content:  " usb 1-1: new device",
+			jsonKeys: []string{"SUBSYSTEM", "DEVICE"},
+		},
+		{
+			name:     "parse kmsg from userspace",
+			input:    "30,1235,5678999,-;systemd[1]: started\n",
+			facility: captainslog.Daemon,
+			severity: captainslog.Info,
+			time:     bootTime.Add(5678999 * time.Microsecond),
+			content:  " systemd[1]: started",
+			jsonKeys: []string{},
+		},
+		{
+			name:  "parse dmesg with bad uptime",
+			input: "<6>[  12a.456789] eth0: link up\n",
+			err:   captainslog.ErrBadUptime,
+		},
+		{
+			name:  "parse dmesg with unterminated uptime",
+			input: "<6>[  123.456789 eth0: link up\n",
+			err:   captainslog.ErrBadUptime,
+		},
+		{
+			name:  "parse kmsg with missing fields",
+			input: "6,1234;eth0: link up\n",
+			err:   captainslog.ErrBadPriority,
+		},
+		{
+			name:  "parse kmsg with bad timestamp",
+			input: "6,1234,abc,-;eth0: link up\n",
+			err:   captainslog.ErrBadUptime,
+		},
+		{
+			name:  "parse rfc3164",
+			input: "<191>2006-01-02T15:04:05.999999-07:00 host.example.org test: hello world\n",
+			err:   captainslog.ErrBadUptime,
+		},
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := captainslog.NewParser(captainslog.OptionKernelFormat, captainslog.OptionBootTime(bootTime))
+			msg, err := p.ParseBytes([]byte(tc.input))
+
+			if want, got := tc.err, err; want != got {
+				t.Errorf("error: want %v, got %v", want, got)
+			}
+
+			if tc.err == nil {
+				if want, got := tc.facility, msg.Pri.Facility; want != got {
+					t.Errorf("facility: want %q, got %q", want, got)
+				}
+
+				if want, got := tc.severity, msg.Pri.Severity; want != got {
+					t.Errorf("severity: want %q, got %q", want, got)
+				}
+
+				if want, got := tc.time, msg.Time; !want.Equal(got) {
+					t.Errorf("time: want %v, got %v", want, got)
+				}
+
+				if want, got := hostname, msg.Host; want != got {
+					t.Errorf("host: want %q, got %q", want, got)
+				}
+
+				if want, got := "kernel:", msg.Tag.String(); want != got {
+					t.Errorf("tag: want %q, got %q", want, got)
+				}
+
+				if want, got := tc.content, msg.Content; want != got {
+					t.Errorf("content: want %q, got %q", want, got)
+				}
+
+				if want, got := len(tc.jsonKeys), len(msg.JSONValues); want != got {
+					t.Errorf("keys: want %d, got %d", want, got)
+				}
+
+				for _, v := range tc.jsonKeys {
+					if _, ok := msg.JSONValues[v]; !ok {
+						t.Errorf("could not find expected key %q in msg.JSONValues", v)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestParserKernelFormatToString(t *testing.T) {
+	bootTime := time.Date(2016, time.March, 8, 14, 0, 0, 0, time.UTC)
+	p := captainslog.NewParser(captainslog.OptionKernelFormat, captainslog.OptionBootTime(bootTime))
+
+	msg, err := p.ParseBytes([]byte("<6>[  123.456789] eth0: link up\n"))
+	if err != nil {
+		t.Error(err)
+	}
+	msg.SetHost("host.example.com")
+
+	wanted := "<6>2016-03-08T14:02:03.456789+00:00 host.example.com kernel: eth0: link up\n"
+	if want, got := wanted, msg.String(); want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func TestSystemBootTime(t *testing.T) {
+	if _, err := os.Stat("/proc/uptime"); err != nil {
+		t.Skip("/proc/uptime not available")
+	}
+
+	bootTime, err := captainslog.SystemBootTime()
+	if err != nil {
+		t.Error(err)
+	}
+
+	if !bootTime.Before(time.Now()) {
+		t.Errorf("boot time %v is not in the past", bootTime)
+	}
+}
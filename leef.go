@@ -0,0 +1,211 @@
+package captainslog
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	leefPrefix    = "LEEF:"
+	leefProgram   = "LEEF"
+	leefKey       = "leef"
+	leefDelimiter = "delimiter"
+)
+
+var (
+	//ErrBadLEEF is returned when LEEF content is malformed.
+	ErrBadLEEF = errors.New("LEEF content not valid")
+
+	// leefHeaderFields names the header fields of a LEEF event.
+	leefHeaderFields = []string{
+		"version",
+		"vendor",
+		"product",
+		"product_version",
+		"event_id",
+	}
+)
+
+// DecodeLEEF decodes a QRadar LEEF event, such as
+// "LEEF:1.0|Vendor|Product|1.0|100|src=10.0.0.1\tdst=10.0.0.2", into a
+// map. The header fields are added to a map under the "leef" key, named
+// version, vendor, product, product_version and event_id. The attributes
+// are added at the top level. Attributes are separated by tabs, or for
+// LEEF 2.0 by the delimiter given in the optional sixth header field,
+// either as a character or as a hex code such as "x5E". The delimiter
+// field is added to the header as delimiter. All values are decoded as
+// strings.
+func DecodeLEEF(b []byte) (map[string]interface{}, error) {
+	s := string(b)
+	if !strings.HasPrefix(s, leefPrefix) {
+		return nil, ErrBadLEEF
+	}
+	s = s[len(leefPrefix):]
+
+	header := make(map[string]interface{})
+	for _, name := range leefHeaderFields {
+		var field string
+		var ok bool
+		field, s, ok = leefNextField(s)
+		if !ok {
+			return nil, ErrBadLEEF
+		}
+		header[name] = field
+	}
+
+	delimiter := "\t"
+	if strings.HasPrefix(header["version"].(string), "2") {
+		if field, rest, ok := leefNextField(s); ok && !strings.Contains(field, "=") {
+			d, ok := parseLEEFDelimiter(field)
+			if !ok {
+				return nil, ErrBadLEEF
+			}
+			header[leefDelimiter] = field
+			delimiter = d
+			s = rest
+		}
+	}
+
+	m := make(map[string]interface{})
+	for _, attr := range strings.Split(strings.TrimRight(s, "\r\n"), delimiter) {
+		if strings.TrimSpace(attr) == "" {
+			continue
+		}
+		kv := strings.SplitN(attr, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, ErrBadLEEF
+		}
+		m[kv[0]] = kv[1]
+	}
+
+	m[leefKey] = header
+	return m, nil
+}
+
+// leefNextField returns the header field at the start of s, and the
+// rest of s after the "|" that ends it.
+func leefNextField(s string) (string, string, bool) {
+	var field strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '|' {
+			return field.String(), s[i+1:], true
+		}
+		if s[i] == '\\' && i+1 < len(s) && (s[i+1] == '|' || s[i+1] == '\\') {
+			i++
+		}
+		field.WriteByte(s[i])
+	}
+	return "", s, false
+}
+
+// parseLEEFDelimiter parses a LEEF 2.0 delimiter field, which is either
+// empty for the default tab, a single character, or a hex code.
+func parseLEEFDelimiter(field string) (string, bool) {
+	switch {
+	case field == "":
+		return "\t", true
+	case len(field) == 1:
+		return field, true
+	case strings.HasPrefix(field, "0x") || strings.HasPrefix(field, "x"):
+		c, err := strconv.ParseUint(field[strings.Index(field, "x")+1:], 16, 8)
+		if err != nil || c == 0 {
+			return "", false
+		}
+		return string([]byte{byte(c)}), true
+	}
+	return "", false
+}
+
+// EncodeLEEF encodes a map decoded by DecodeLEEF back to a LEEF event.
+// The header is read from the map under the "leef" key, and ErrBadLEEF
+// is returned if it is missing. All other keys are encoded as attributes,
+// sorted by key. Delimiters and newlines in values are replaced with spaces.
+func EncodeLEEF(m map[string]interface{}) ([]byte, error) {
+	header, ok := m[leefKey].(map[string]interface{})
+	if !ok {
+		return nil, ErrBadLEEF
+	}
+
+	var b strings.Builder
+	b.WriteString(leefPrefix)
+	for _, name := range leefHeaderFields {
+		b.WriteString(leefHeaderEscaper.Replace(logfmtString(header[name])))
+		b.WriteByte('|')
+	}
+
+	delimiter := "\t"
+	if field, ok := header[leefDelimiter].(string); ok {
+		d, ok := parseLEEFDelimiter(field)
+		if !ok {
+			return nil, ErrBadLEEF
+		}
+		b.WriteString(field)
+		b.WriteByte('|')
+		delimiter = d
+	}
+
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		if key != leefKey {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	valueEscaper := strings.NewReplacer(delimiter, " ", "\n", " ", "\r", " ")
+	keyEscaper := strings.NewReplacer(delimiter, "_", "=", "_", "\n", "_", "\r", "_")
+	for i, key := range keys {
+		if i > 0 {
+			b.WriteString(delimiter)
+		}
+		b.WriteString(keyEscaper.Replace(key))
+		b.WriteByte('=')
+		b.WriteString(valueEscaper.Replace(logfmtString(m[key])))
+	}
+	return []byte(b.String()), nil
+}
+
+var leefHeaderEscaper = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\n", " ", "\r", " ")
+
+// leefDecoder is the built-in LEEF ContentDecoder. If headerInTag is
+// set, the content follows a "LEEF:" tag, as sent by devices that leave
+// out the syslog tag, and it is encoded without the "LEEF:" prefix.
+type leefDecoder struct {
+	headerInTag bool
+}
+
+// DecodeContent decodes LEEF content. Content starting with "LEEF:",
+// or following a "LEEF:" tag, that is not LEEF is left as plain text.
+func (d leefDecoder) DecodeContent(b []byte) (map[string]interface{}, error) {
+	if d.headerInTag {
+		b = append([]byte(leefPrefix), b...)
+	}
+
+	m, err := DecodeLEEF(b)
+	if err != nil {
+		return nil, nil
+	}
+	return m, nil
+}
+
+// EncodeContent encodes LEEF content.
+func (d leefDecoder) EncodeContent(m map[string]interface{}) ([]byte, error) {
+	b, err := EncodeLEEF(m)
+	if err != nil || !d.headerInTag {
+		return b, err
+	}
+	return b[len(leefPrefix):], nil
+}
+
+// contentConditionLEEF applies a decoder to LEEF content.
+func contentConditionLEEF(msg *SyslogMsg, content []byte) bool {
+	return strings.HasPrefix(string(content), leefPrefix)
+}
+
+// contentConditionLEEFTag applies a decoder to LEEF content that was
+// parsed as a "LEEF:" tag.
+func contentConditionLEEFTag(msg *SyslogMsg, content []byte) bool {
+	return msg.Tag.Program == leefProgram && msg.Tag.Pid == ""
+}
@@ -0,0 +1,196 @@
+package captainslog_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/digitalocean/captainslog"
+)
+
+func TestDecodeLEEF(t *testing.T) {
+	testCases := []struct {
+		name    string
+		input   string
+		want    map[string]interface{}
+		wantErr bool
+	}{
+		{
+			name:  "1.0",
+			input: "LEEF:1.0|Security|Firewall|1.0|100|src=10.0.0.1\tdst=10.0.0.2\tmsg=a b=c",
+			want: map[string]interface{}{
+				"leef": map[string]interface{}{
+					"version":         "1.0",
+					"vendor":          "Security",
+					"product":         "Firewall",
+					"product_version": "1.0",
+					"event_id":        "100",
+				},
+				"src": "10.0.0.1",
+				"dst": "10.0.0.2",
+				"msg": "a b=c",
+			},
+		},
+		{
+			name:  "2.0 delimiter",
+			input: "LEEF:2.0|Security|Firewall|1.0|100|^|src=10.0.0.1^dst=10.0.0.2",
+			want: map[string]interface{}{
+				"leef": map[string]interface{}{
+					"version":         "2.0",
+					"vendor":          "Security",
+					"product":         "Firewall",
+					"product_version": "1.0",
+					"event_id":        "100",
+					"delimiter":       "^",
+				},
+				"src": "10.0.0.1",
+				"dst": "10.0.0.2",
+			},
+		},
+		{
+			name:  "2.0 hex delimiter",
+			input: "LEEF:2.0|Security|Firewall|1.0|100|x7C|src=10.0.0.1|dst=10.0.0.2",
+			want: map[string]interface{}{
+				"leef": map[string]interface{}{
+					"version":         "2.0",
+					"vendor":          "Security",
+					"product":         "Firewall",
+					"product_version": "1.0",
+					"event_id":        "100",
+					"delimiter":       "x7C",
+				},
+				"src": "10.0.0.1",
+				"dst": "10.0.0.2",
+			},
+		},
+		{
+			name:  "2.0 without delimiter",
+			input: "LEEF:2.0|Security|Firewall|1.0|100|src=10.0.0.1\tdst=10.0.0.2",
+			want: map[string]interface{}{
+				"leef": map[string]interface{}{
+					"version":         "2.0",
+					"vendor":          "Security",
+					"product":         "Firewall",
+					"product_version": "1.0",
+					"event_id":        "100",
+				},
+				"src": "10.0.0.1",
+				"dst": "10.0.0.2",
+			},
+		},
+		{
+			name:    "short header",
+			input:   "LEEF:1.0|Security|Firewall",
+			wantErr: true,
+		},
+		{
+			name:    "bad attribute",
+			input:   "LEEF:1.0|Security|Firewall|1.0|100|src",
+			wantErr: true,
+		},
+		{
+			name:    "bad delimiter",
+			input:   "LEEF:2.0|Security|Firewall|1.0|100|xZZ|src=10.0.0.1",
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := captainslog.DecodeLEEF([]byte(tc.input))
+			if tc.wantErr {
+				if want, got := captainslog.ErrBadLEEF, err; want != got {
+					t.Errorf("want %v, got %v", want, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(tc.want, got) {
+				t.Errorf("want %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestEncodeLEEF(t *testing.T) {
+	for _, input := range []string{
+		"LEEF:1.0|Security|Firewall|1.0|100|dst=10.0.0.2\tsrc=10.0.0.1",
+		"LEEF:2.0|Security|Firewall|1.0|100|^|dst=10.0.0.2^src=10.0.0.1",
+	} {
+		m, err := captainslog.DecodeLEEF([]byte(input))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		b, err := captainslog.EncodeLEEF(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want, got := input, string(b); want != got {
+			t.Errorf("want %q, got %q", want, got)
+		}
+	}
+
+	if _, err := captainslog.EncodeLEEF(map[string]interface{}{"src": "10.0.0.1"}); err != captainslog.ErrBadLEEF {
+		t.Errorf("want %v, got %v", captainslog.ErrBadLEEF, err)
+	}
+}
+
+func TestParserOptionParseLEEF(t *testing.T) {
+	testCases := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name:  "tagged",
+			input: "<134>2016-03-08T14:59:36.293816+00:00 ids.example.com ids: LEEF:1.0|Security|IDS|1.0|42|src=10.0.0.1\n",
+			want:  "<134>2016-03-08T14:59:36.293816+00:00 ids.example.com ids: LEEF:1.0|Security|IDS|1.0|42|site=east\tsrc=10.0.0.1\n",
+		},
+		{
+			name:  "untagged",
+			input: "<134>2016-03-08T14:59:36.293816+00:00 ids.example.com LEEF:1.0|Security|IDS|1.0|42|src=10.0.0.1\n",
+			want:  "<134>2016-03-08T14:59:36.293816+00:00 ids.example.com LEEF:1.0|Security|IDS|1.0|42|site=east\tsrc=10.0.0.1\n",
+		},
+	}
+
+	p := captainslog.NewParser(captainslog.OptionParseLEEF)
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			msg, err := p.ParseBytes([]byte(tc.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if want, got := "10.0.0.1", msg.JSONValues["src"]; want != got {
+				t.Errorf("want %q, got %q", want, got)
+			}
+
+			msg.AddTag("site", "east")
+			if want, got := tc.want, msg.String(); want != got {
+				t.Errorf("want %q, got %q", want, got)
+			}
+		})
+	}
+}
+
+func TestParserOptionParseLEEFBad(t *testing.T) {
+	testCases := []string{
+		"<134>2016-03-08T14:59:36.293816+00:00 ids.example.com ids: LEEF:1.0|Security\n",
+		"<134>2016-03-08T14:59:36.293816+00:00 ids.example.com ids: LEEF: feed disabled\n",
+	}
+
+	p := captainslog.NewParser(captainslog.OptionParseLEEF)
+	for _, input := range testCases {
+		msg, err := p.ParseBytes([]byte(input))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want, got := input, msg.String(); want != got {
+			t.Errorf("want %q, got %q", want, got)
+		}
+	}
+}
@@ -0,0 +1,309 @@
+package captainslog
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// listenerMaxMessageSize is the default size of the largest message
+	// a SyslogListener receives.
+	listenerMaxMessageSize = 64 * 1024
+
+	// listenerQueueSize is the number of received messages that are
+	// buffered until Receive is called.
+	listenerQueueSize = 1024
+
+	// listenerRetryDelay is the time a SyslogListener first waits after
+	// a failed read or accept, such as when it runs out of file
+	// descriptors. The delay doubles with each consecutive failure, up
+	// to listenerMaxRetryDelay.
+	listenerRetryDelay = 5 * time.Millisecond
+
+	// listenerMaxRetryDelay is the longest time a SyslogListener waits
+	// after a failed read or accept.
+	listenerMaxRetryDelay = time.Second
+)
+
+var (
+	//ErrMessageTooLong is returned when a received message is longer than the maximum size.
+	ErrMessageTooLong = errors.New("Message too long")
+)
+
+// Listener receives SyslogMsgs, such as from a network socket.
+// GELFListener and SyslogListener are Listeners.
+type Listener interface {
+	Receive() (SyslogMsg, error)
+	Close() error
+}
+
+// SyslogListener receives syslog messages on a UDP, TCP or unix socket
+// and parses them into SyslogMsgs. Messages on stream sockets are
+// separated by newlines, or framed by octet counting as described in
+// RFC6587.
+type SyslogListener struct {
+	packetConn     net.PacketConn
+	listener       net.Listener
+	parserOptions  []func(*Parser)
+	parser         *Parser
+	parserMutex    sync.Mutex
+	maxMessageSize int
+	frames         chan []byte
+	done           chan struct{}
+	conns          map[net.Conn]bool
+	closed         bool
+	mutex          sync.Mutex
+	wg             sync.WaitGroup
+}
+
+// NewSyslogListener returns a new SyslogListener listening on addr.
+// The network is "udp", "tcp", "unixgram" or "unix", or their IPv4
+// and IPv6 variants. Use ":0" to listen on a random port.
+func NewSyslogListener(network, addr string, options ...func(*SyslogListener)) (*SyslogListener, error) {
+	l := SyslogListener{
+		maxMessageSize: listenerMaxMessageSize,
+		frames:         make(chan []byte, listenerQueueSize),
+		done:           make(chan struct{}),
+		conns:          make(map[net.Conn]bool),
+	}
+	for _, option := range options {
+		option(&l)
+	}
+	l.parser = NewParser(l.parserOptions...)
+
+	switch network {
+	case "udp", "udp4", "udp6", "unixgram":
+		conn, err := net.ListenPacket(network, addr)
+		if err != nil {
+			return nil, err
+		}
+		l.packetConn = conn
+		l.wg.Add(1)
+		go l.readPackets()
+	default:
+		listener, err := net.Listen(network, addr)
+		if err != nil {
+			return nil, err
+		}
+		l.listener = listener
+		l.wg.Add(1)
+		go l.accept()
+	}
+
+	go func() {
+		l.wg.Wait()
+		close(l.frames)
+	}()
+	return &l, nil
+}
+
+// SyslogListenerOptionParser sets the options of the Parser used to
+// parse received messages.
+func SyslogListenerOptionParser(options ...func(*Parser)) func(*SyslogListener) {
+	return func(l *SyslogListener) {
+		l.parserOptions = options
+	}
+}
+
+// SyslogListenerOptionMaxMessageSize sets the size of the largest
+// message the SyslogListener receives. The default is 64KiB. Longer
+// datagrams are truncated, and stream connections sending longer
+// messages are closed.
+func SyslogListenerOptionMaxMessageSize(size int) func(*SyslogListener) {
+	return func(l *SyslogListener) {
+		l.maxMessageSize = size
+	}
+}
+
+// Addr returns the address the SyslogListener is listening on.
+func (l *SyslogListener) Addr() net.Addr {
+	if l.packetConn != nil {
+		return l.packetConn.LocalAddr()
+	}
+	return l.listener.Addr()
+}
+
+// Receive blocks until a message is received and returns it parsed.
+// Messages that fail to parse are returned along with the error, so
+// callers can log them and call Receive again. Once the SyslogListener
+// is closed and all received messages have been returned, Receive
+// returns net.ErrClosed.
+func (l *SyslogListener) Receive() (SyslogMsg, error) {
+	frame, ok := <-l.frames
+	if !ok {
+		return SyslogMsg{}, net.ErrClosed
+	}
+	if len(frame) == 0 || frame[len(frame)-1] != '\n' {
+		frame = append(frame, '\n')
+	}
+
+	l.parserMutex.Lock()
+	defer l.parserMutex.Unlock()
+	return l.parser.ParseBytes(frame)
+}
+
+// Close stops listening and closes all connections. Messages that were
+// already received can still be read with Receive.
+func (l *SyslogListener) Close() error {
+	l.mutex.Lock()
+	if !l.closed {
+		l.closed = true
+		close(l.done)
+	}
+	for conn := range l.conns {
+		conn.Close()
+	}
+	l.mutex.Unlock()
+
+	if l.packetConn != nil {
+		return l.packetConn.Close()
+	}
+	return l.listener.Close()
+}
+
+func (l *SyslogListener) readPackets() {
+	defer l.wg.Done()
+	buf := make([]byte, l.maxMessageSize)
+	var delay time.Duration
+	for {
+		n, _, err := l.packetConn.ReadFrom(buf)
+		if err != nil {
+			var ok bool
+			if delay, ok = l.retry(delay); !ok {
+				return
+			}
+			continue
+		}
+		delay = 0
+		if n > 0 && !l.queue(append([]byte(nil), buf[:n]...)) {
+			return
+		}
+	}
+}
+
+func (l *SyslogListener) accept() {
+	defer l.wg.Done()
+	var delay time.Duration
+	for {
+		conn, err := l.listener.Accept()
+		if err != nil {
+			var ok bool
+			if delay, ok = l.retry(delay); !ok {
+				return
+			}
+			continue
+		}
+		delay = 0
+
+		l.mutex.Lock()
+		if l.closed {
+			l.mutex.Unlock()
+			conn.Close()
+			return
+		}
+		l.conns[conn] = true
+		l.wg.Add(1)
+		l.mutex.Unlock()
+
+		go l.readStream(conn)
+	}
+}
+
+func (l *SyslogListener) readStream(conn net.Conn) {
+	defer l.wg.Done()
+	defer func() {
+		l.mutex.Lock()
+		delete(l.conns, conn)
+		l.mutex.Unlock()
+		conn.Close()
+	}()
+
+	r := bufio.NewReaderSize(conn, l.maxMessageSize)
+	for {
+		frame, err := readFrame(r, l.maxMessageSize)
+		if len(frame) > 0 && !l.queue(frame) {
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// queue queues a received message for Receive, and returns false if
+// the SyslogListener was closed while waiting for room.
+func (l *SyslogListener) queue(frame []byte) bool {
+	select {
+	case l.frames <- frame:
+		return true
+	case <-l.done:
+		return false
+	}
+}
+
+// retry waits after a failed read or accept, for twice the previous
+// delay up to listenerMaxRetryDelay, so that persistent errors such as
+// running out of file descriptors don't spin. It returns the delay it
+// waited, and false if the SyslogListener is closed.
+func (l *SyslogListener) retry(delay time.Duration) (time.Duration, bool) {
+	if l.isClosed() {
+		return delay, false
+	}
+	if delay == 0 {
+		delay = listenerRetryDelay
+	} else if delay *= 2; delay > listenerMaxRetryDelay {
+		delay = listenerMaxRetryDelay
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return delay, true
+	case <-l.done:
+		return delay, false
+	}
+}
+
+func (l *SyslogListener) isClosed() bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.closed
+}
+
+// readFrame reads a message from a stream. A message that starts with
+// a digit is framed by octet counting, "<length> <message>", and any
+// other message ends with a newline.
+func readFrame(r *bufio.Reader, maxSize int) ([]byte, error) {
+	c, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+
+	if c[0] >= '0' && c[0] <= '9' {
+		digits, err := r.ReadSlice(' ')
+		if err != nil {
+			return nil, err
+		}
+		n, err := strconv.Atoi(string(digits[:len(digits)-1]))
+		if err != nil || n > maxSize {
+			return nil, ErrMessageTooLong
+		}
+		frame := make([]byte, n)
+		if _, err := io.ReadFull(r, frame); err != nil {
+			return nil, err
+		}
+		return frame, nil
+	}
+
+	line, err := r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return nil, ErrMessageTooLong
+	}
+	return append([]byte(nil), line...), err
+}
@@ -0,0 +1,141 @@
+package captainslog_test
+
+import (
+	"errors"
+	"net"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/captainslog"
+)
+
+func receive(t *testing.T, l captainslog.Listener) (captainslog.SyslogMsg, error) {
+	t.Helper()
+	type result struct {
+		msg captainslog.SyslogMsg
+		err error
+	}
+	c := make(chan result, 1)
+	go func() {
+		msg, err := l.Receive()
+		c <- result{msg, err}
+	}()
+	select {
+	case r := <-c:
+		return r.msg, r.err
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for message")
+	}
+	return captainslog.SyslogMsg{}, nil
+}
+
+func TestSyslogListenerStream(t *testing.T) {
+	l, err := captainslog.NewSyslogListener("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	counted := "<191>2006-01-02T15:04:05.999999-07:00 host.example.org two: counted"
+	frames := "<191>2006-01-02T15:04:05.999999-07:00 host.example.org one: first\n" +
+		strconv.Itoa(len(counted)) + " " + counted +
+		"not syslog\n" +
+		"<191>2006-01-02T15:04:05.999999-07:00 host.example.org three: last"
+	if _, err := conn.Write([]byte(frames)); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	msg, err := receive(t, l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := "one", msg.Tag.Program; want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+
+	msg, err = receive(t, l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := " counted", msg.Content; want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+
+	if _, err := receive(t, l); err == nil {
+		t.Errorf("want parse error")
+	}
+
+	msg, err = receive(t, l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := "three", msg.Tag.Program; want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+
+	l.Close()
+	if _, err := receive(t, l); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("want %v, got %v", net.ErrClosed, err)
+	}
+}
+
+func TestSyslogListenerPacket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log")
+	l, err := captainslog.NewSyslogListener("unixgram", path,
+		captainslog.SyslogListenerOptionParser(captainslog.OptionNoHostname))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	conn, err := net.Dial("unixgram", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("<30>Jan  2 15:04:05 app[12]: hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := receive(t, l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := "app", msg.Tag.Program; want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+	if want, got := " hello", msg.Content; want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func TestSyslogListenerMaxMessageSize(t *testing.T) {
+	l, err := captainslog.NewSyslogListener("tcp", "127.0.0.1:0",
+		captainslog.SyslogListenerOptionMaxMessageSize(32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("1000 <191>")); err != nil {
+		t.Fatal(err)
+	}
+
+	// the connection is closed without a message
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Errorf("want connection closed")
+	}
+}
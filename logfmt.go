@@ -0,0 +1,172 @@
+package captainslog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var (
+	//ErrBadLogfmt is returned when logfmt content is malformed.
+	ErrBadLogfmt = errors.New("Logfmt content not valid")
+)
+
+// DecodeLogfmt decodes logfmt key=value pairs, such as
+// `level=info user=bob msg="request done"`, into a map. Pairs are
+// separated by whitespace. Values may be double quoted, in which case
+// they can contain whitespace and Go string escapes such as \" and \n.
+// Values are always decoded as strings, except for keys without a
+// value, which are decoded as true.
+func DecodeLogfmt(b []byte) (map[string]interface{}, error) {
+	m := make(map[string]interface{})
+
+	i := 0
+	for {
+		for i < len(b) && isLogfmtSpace(b[i]) {
+			i++
+		}
+		if i >= len(b) {
+			break
+		}
+
+		start := i
+		for i < len(b) && isLogfmtKeyByte(b[i]) {
+			i++
+		}
+		if i == start {
+			return m, ErrBadLogfmt
+		}
+		key := string(b[start:i])
+
+		if i >= len(b) || isLogfmtSpace(b[i]) {
+			m[key] = true
+			continue
+		}
+		if b[i] != '=' {
+			return m, ErrBadLogfmt
+		}
+		i++
+
+		if i < len(b) && b[i] == '"' {
+			start = i
+			i++
+			for i < len(b) && b[i] != '"' {
+				if b[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			if i >= len(b) {
+				return m, ErrBadLogfmt
+			}
+			i++
+
+			value, err := strconv.Unquote(string(b[start:i]))
+			if err != nil {
+				return m, ErrBadLogfmt
+			}
+			m[key] = value
+		} else {
+			start = i
+			for i < len(b) && !isLogfmtSpace(b[i]) {
+				if b[i] == '"' {
+					return m, ErrBadLogfmt
+				}
+				i++
+			}
+			m[key] = string(b[start:i])
+		}
+
+		if i < len(b) && !isLogfmtSpace(b[i]) {
+			return m, ErrBadLogfmt
+		}
+	}
+	return m, nil
+}
+
+// EncodeLogfmt encodes a map as logfmt key=value pairs, sorted by key.
+// Values that are empty or contain whitespace, quotes, "=" or control
+// characters are quoted. Values that are not strings, numbers or bools
+// are encoded as JSON, and characters that are not allowed in keys are
+// replaced with "_".
+func EncodeLogfmt(m map[string]interface{}) []byte {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for i, key := range keys {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(logfmtKey(key))
+		b.WriteByte('=')
+		b.WriteString(logfmtValue(m[key]))
+	}
+	return []byte(b.String())
+}
+
+func isLogfmtSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+func isLogfmtKeyByte(c byte) bool {
+	return c > ' ' && c != '=' && c != '"' && c != 0x7f
+}
+
+func logfmtKey(key string) string {
+	if key == "" {
+		return "_"
+	}
+	return strings.Map(func(r rune) rune {
+		if r > ' ' && r != '=' && r != '"' && r != 0x7f && r != utf8.RuneError {
+			return r
+		}
+		return '_'
+	}, key)
+}
+
+func logfmtValue(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+
+	s := logfmtString(value)
+	if s == "" || strings.IndexFunc(s, needsLogfmtQuote) >= 0 {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// logfmtString converts a value to a string, encoding values that are
+// not strings, numbers or bools as JSON.
+func logfmtString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool, int, int8, int16, int32, int64,
+		uint, uint8, uint16, uint32, uint64, float32, float64:
+		return fmt.Sprint(v)
+	}
+
+	b, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(b)
+}
+
+func needsLogfmtQuote(r rune) bool {
+	return r <= ' ' || r == '=' || r == '"' || r == '\\' || r == utf8.RuneError || !unicode.IsPrint(r)
+}
@@ -0,0 +1,203 @@
+package captainslog_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/digitalocean/captainslog"
+)
+
+func TestDecodeLogfmt(t *testing.T) {
+	testCases := []struct {
+		name    string
+		input   string
+		want    map[string]interface{}
+		wantErr bool
+	}{
+		{
+			name:  "simple",
+			input: "level=info user=bob dur=12ms",
+			want:  map[string]interface{}{"level": "info", "user": "bob", "dur": "12ms"},
+		},
+		{
+			name:  "quoted",
+			input: `msg="request done" path=/a?b=c`,
+			want:  map[string]interface{}{"msg": "request done", "path": "/a?b=c"},
+		},
+		{
+			name:  "escaped",
+			input: `msg="say \"hi\"\n" empty="" unicode="café"`,
+			want:  map[string]interface{}{"msg": "say \"hi\"\n", "empty": "", "unicode": "café"},
+		},
+		{
+			name:  "bare key and empty value",
+			input: "  debug user=\tpid=12 ",
+			want:  map[string]interface{}{"debug": true, "user": "", "pid": "12"},
+		},
+		{
+			name:    "unterminated quote",
+			input:   `msg="oops`,
+			wantErr: true,
+		},
+		{
+			name:    "missing key",
+			input:   "=value",
+			wantErr: true,
+		},
+		{
+			name:    "garbage after quote",
+			input:   `msg="a"b`,
+			wantErr: true,
+		},
+		{
+			name:    "quote in value",
+			input:   `msg=a"b`,
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := captainslog.DecodeLogfmt([]byte(tc.input))
+			if tc.wantErr {
+				if want, got := captainslog.ErrBadLogfmt, err; want != got {
+					t.Errorf("want %v, got %v", want, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(tc.want, got) {
+				t.Errorf("want %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestEncodeLogfmt(t *testing.T) {
+	m := map[string]interface{}{
+		"level":   "info",
+		"msg":     "say \"hi\"",
+		"empty":   "",
+		"count":   3,
+		"ok":      true,
+		"nil":     nil,
+		"bad key": "x=y",
+		"tags":    []interface{}{"a", "b"},
+	}
+
+	want := `bad_key="x=y" count=3 empty="" level=info msg="say \"hi\"" nil= ok=true tags="[\"a\",\"b\"]"`
+	if got := string(captainslog.EncodeLogfmt(m)); want != got {
+		t.Errorf("want %s, got %s", want, got)
+	}
+
+	decoded, err := captainslog.DecodeLogfmt(captainslog.EncodeLogfmt(m))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := m["msg"], decoded["msg"]; want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func TestParserOptionParseLogfmt(t *testing.T) {
+	input := []byte("<191>2006-01-02T15:04:05.999999-07:00 host.example.org app[12]: level=info user=bob msg=\"request done\"\n")
+	p := captainslog.NewParser(captainslog.OptionParseLogfmt)
+
+	msg, err := p.ParseBytes(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !msg.IsLogfmt {
+		t.Error("IsLogfmt should be true")
+	}
+
+	if msg.IsJSON {
+		t.Error("IsJSON should be false")
+	}
+
+	if want, got := "bob", msg.JSONValues["user"]; want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+
+	// the content is kept as it was received until it is changed
+	if want, got := string(input), msg.String(); want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+
+	msg.AddTag("status", 200)
+	want := "<191>2006-01-02T15:04:05.999999-07:00 host.example.org app[12]: level=info msg=\"request done\" status=200 user=bob\n"
+	if got := msg.String(); want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func TestLogfmtContentDecoderBareKeys(t *testing.T) {
+	m, err := captainslog.LogfmtContentDecoder.DecodeContent([]byte("level=info user=bob debug"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := true, m["debug"]; want != got {
+		t.Errorf("want %v, got %v", want, got)
+	}
+}
+
+func TestParserOptionParseLogfmtPlainText(t *testing.T) {
+	testCases := []string{
+		"<191>2006-01-02T15:04:05.999999-07:00 host.example.org app[12]: connection reset by peer\n",
+		"<191>2006-01-02T15:04:05.999999-07:00 host.example.org app[12]: Retrying connection to db, attempt=3 of 5\n",
+		"<191>2006-01-02T15:04:05.999999-07:00 host.example.org app[12]: set x=1\n",
+		"<191>2006-01-02T15:04:05.999999-07:00 host.example.org app[12]: value \"unterminated=\n",
+		"<191>2006-01-02T15:04:05.999999-07:00 host.example.org app[12]: @cee: {\"user\":\"bob\"}\n",
+	}
+
+	p := captainslog.NewParser(captainslog.OptionParseLogfmt)
+	for _, input := range testCases {
+		msg, err := p.ParseBytes([]byte(input))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if msg.IsLogfmt {
+			t.Errorf("IsLogfmt should be false for %q", input)
+		}
+
+		if want, got := input, msg.String(); want != got {
+			t.Errorf("want %q, got %q", want, got)
+		}
+	}
+}
+
+func TestSyslogMsgOptionUseLogfmtContent(t *testing.T) {
+	testCases := []struct {
+		input string
+		want  string
+	}{
+		{
+			input: "<191>2006-01-02T15:04:05.999999-07:00 host.example.org app[12]: @cee: {\"msg\":\"done\",\"user\":\"bob smith\"}\n",
+			want:  "<191>2006-01-02T15:04:05.999999-07:00 host.example.org app[12]: msg=done user=\"bob smith\"\n",
+		},
+		{
+			input: "<191>2006-01-02T15:04:05.999999-07:00 host.example.org app[12]: request done\n",
+			want:  "<191>2006-01-02T15:04:05.999999-07:00 host.example.org app[12]: msg=\"request done\"\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		msg, err := captainslog.NewSyslogMsgFromBytes([]byte(tc.input))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want, got := tc.want, msg.String(captainslog.OptionUseLogfmtContent); want != got {
+			t.Errorf("want %q, got %q", want, got)
+		}
+
+		if want, got := tc.input, msg.String(captainslog.OptionUseDefaultContent); want != got {
+			t.Errorf("want %q, got %q", want, got)
+		}
+	}
+}
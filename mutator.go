@@ -0,0 +1,142 @@
+package captainslog
+
+import (
+	"regexp"
+)
+
+// MutatorSetKey returns a Mutator that sets the JSON key to value, as
+// SyslogMsg.AddTag does.
+func MutatorSetKey(key string, value interface{}) Mutator {
+	return MutatorFunc(func(msg *SyslogMsg) error {
+		msg.AddTag(key, value)
+		return nil
+	})
+}
+
+// MutatorDeleteKey returns a Mutator that deletes the JSON key.
+func MutatorDeleteKey(key string) Mutator {
+	return MutatorFunc(func(msg *SyslogMsg) error {
+		if err := msg.DecodeJSON(); err != nil {
+			return err
+		}
+		delete(msg.JSONValues, key)
+		return nil
+	})
+}
+
+// MutatorRenameKey returns a Mutator that moves the value of the JSON
+// key from to the key to, replacing any value already there. Messages
+// without the key are left unchanged.
+func MutatorRenameKey(from, to string) Mutator {
+	return MutatorFunc(func(msg *SyslogMsg) error {
+		if err := msg.DecodeJSON(); err != nil {
+			return err
+		}
+		if value, ok := msg.JSONValues[from]; ok {
+			delete(msg.JSONValues, from)
+			msg.JSONValues[to] = value
+		}
+		return nil
+	})
+}
+
+// MutatorReplaceValue returns a Mutator that replaces matches of re in
+// the string value of the JSON key with repl, as regexp.ReplaceAllString
+// does. It is useful to mask sensitive fields. Values that are not
+// strings are left unchanged.
+func MutatorReplaceValue(key string, re *regexp.Regexp, repl string) Mutator {
+	return MutatorFunc(func(msg *SyslogMsg) error {
+		if err := msg.DecodeJSON(); err != nil {
+			return err
+		}
+		if value, ok := msg.JSONValues[key].(string); ok {
+			msg.JSONValues[key] = re.ReplaceAllString(value, repl)
+		}
+		return nil
+	})
+}
+
+// MutatorReplaceContent returns a Mutator that replaces matches of re in
+// the text of the message with repl, as regexp.ReplaceAllString does. The
+// text is the "msg" key of JSON and CEE messages that have one, or else
+// SyslogMsg.Content without its leading whitespace.
+func MutatorReplaceContent(re *regexp.Regexp, repl string) Mutator {
+	return MutatorFunc(func(msg *SyslogMsg) error {
+		if err := msg.DecodeJSON(); err != nil {
+			return err
+		}
+		if msg.IsJSON || msg.IsCee {
+			if text, ok := msg.JSONValues["msg"].(string); ok {
+				msg.JSONValues["msg"] = re.ReplaceAllString(text, repl)
+				return nil
+			}
+		}
+
+		text := trimCEESpace(msg.Content)
+		msg.Content = msg.Content[:len(msg.Content)-len(text)] + re.ReplaceAllString(text, repl)
+		return nil
+	})
+}
+
+// MutatorSetFacility returns a Mutator that sets the facility.
+func MutatorSetFacility(facility Facility) Mutator {
+	return MutatorFunc(func(msg *SyslogMsg) error {
+		return msg.SetFacility(facility)
+	})
+}
+
+// MutatorSetSeverity returns a Mutator that sets the severity.
+func MutatorSetSeverity(severity Severity) Mutator {
+	return MutatorFunc(func(msg *SyslogMsg) error {
+		return msg.SetSeverity(severity)
+	})
+}
+
+// MutatorSetSeverityOnMatch returns a Mutator that sets the severity
+// of messages whose text matches re. The text is SyslogMsg.Content, or
+// the "msg" key of JSON messages.
+func MutatorSetSeverityOnMatch(re *regexp.Regexp, severity Severity) Mutator {
+	return MutatorWhen(func(msg *SyslogMsg) bool {
+		return re.MatchString(msg.text())
+	}, MutatorSetSeverity(severity))
+}
+
+// MutatorSetProgram returns a Mutator that sets the program name.
+func MutatorSetProgram(program string) Mutator {
+	return MutatorFunc(func(msg *SyslogMsg) error {
+		msg.SetProgram(program)
+		return nil
+	})
+}
+
+// MutatorAddTags returns a Mutator that adds each value to the array
+// of tags at key, as SyslogMsg.AddTagArray does.
+func MutatorAddTags(key string, values ...interface{}) Mutator {
+	return MutatorFunc(func(msg *SyslogMsg) error {
+		for _, value := range values {
+			if err := msg.AddTagArray(key, value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// MutatorWhen returns a Mutator that applies mutator only to messages
+// the condition is true for.
+func MutatorWhen(condition func(msg *SyslogMsg) bool, mutator Mutator) Mutator {
+	return MutatorFunc(func(msg *SyslogMsg) error {
+		if !condition(msg) {
+			return nil
+		}
+		return mutator.Mutate(msg)
+	})
+}
+
+// MutatorDrop returns a Mutator that drops every message. Combined with
+// MutatorWhen it drops the messages a condition is true for.
+func MutatorDrop() Mutator {
+	return MutatorFunc(func(msg *SyslogMsg) error {
+		return ErrMsgDropped
+	})
+}
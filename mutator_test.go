@@ -0,0 +1,149 @@
+package captainslog_test
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/digitalocean/captainslog"
+)
+
+func TestMutators(t *testing.T) {
+	testCases := []struct {
+		name    string
+		input   string
+		mutator captainslog.Mutator
+		want    string
+	}{
+		{
+			name:    "set key",
+			input:   "<191>2006-01-02T15:04:05.999999-07:00 host.example.org app[12]: @cee:{\"a\":\"b\"}\n",
+			mutator: captainslog.MutatorSetKey("env", "prod"),
+			want:    "<191>2006-01-02T15:04:05.999999-07:00 host.example.org app[12]: @cee: {\"a\":\"b\",\"env\":\"prod\"}\n",
+		},
+		{
+			name:    "delete key",
+			input:   "<191>2006-01-02T15:04:05.999999-07:00 host.example.org app[12]: @cee:{\"a\":\"b\",\"password\":\"x\"}\n",
+			mutator: captainslog.MutatorDeleteKey("password"),
+			want:    "<191>2006-01-02T15:04:05.999999-07:00 host.example.org app[12]: @cee: {\"a\":\"b\"}\n",
+		},
+		{
+			name:    "rename key",
+			input:   "<191>2006-01-02T15:04:05.999999-07:00 host.example.org app[12]: @cee:{\"usr\":\"bob\"}\n",
+			mutator: captainslog.MutatorRenameKey("usr", "user"),
+			want:    "<191>2006-01-02T15:04:05.999999-07:00 host.example.org app[12]: @cee: {\"user\":\"bob\"}\n",
+		},
+		{
+			name:    "rename missing key",
+			input:   "<191>2006-01-02T15:04:05.999999-07:00 host.example.org app[12]: @cee:{\"a\":\"b\"}\n",
+			mutator: captainslog.MutatorRenameKey("usr", "user"),
+			want:    "<191>2006-01-02T15:04:05.999999-07:00 host.example.org app[12]: @cee: {\"a\":\"b\"}\n",
+		},
+		{
+			name:    "replace value",
+			input:   "<191>2006-01-02T15:04:05.999999-07:00 host.example.org app[12]: @cee:{\"card\":\"4111111111111111\"}\n",
+			mutator: captainslog.MutatorReplaceValue("card", regexp.MustCompile(`\d{12}(\d{4})`), "************$1"),
+			want:    "<191>2006-01-02T15:04:05.999999-07:00 host.example.org app[12]: @cee: {\"card\":\"************1111\"}\n",
+		},
+		{
+			name:    "replace content",
+			input:   "<191>2006-01-02T15:04:05.999999-07:00 host.example.org app[12]: login from 10.0.0.1\n",
+			mutator: captainslog.MutatorReplaceContent(regexp.MustCompile(`\d+\.\d+\.\d+\.\d+`), "x.x.x.x"),
+			want:    "<191>2006-01-02T15:04:05.999999-07:00 host.example.org app[12]: login from x.x.x.x\n",
+		},
+		{
+			name:    "replace json content",
+			input:   "<191>2006-01-02T15:04:05.999999-07:00 host.example.org app[12]: @cee:{\"msg\":\"login from 10.0.0.1\"}\n",
+			mutator: captainslog.MutatorReplaceContent(regexp.MustCompile(`\d+\.\d+\.\d+\.\d+`), "x.x.x.x"),
+			want:    "<191>2006-01-02T15:04:05.999999-07:00 host.example.org app[12]: @cee: {\"msg\":\"login from x.x.x.x\"}\n",
+		},
+		{
+			name:    "set facility",
+			input:   "<191>2006-01-02T15:04:05.999999-07:00 host.example.org app[12]: hello\n",
+			mutator: captainslog.MutatorSetFacility(captainslog.Local0),
+			want:    "<135>2006-01-02T15:04:05.999999-07:00 host.example.org app[12]: hello\n",
+		},
+		{
+			name:    "set severity",
+			input:   "<191>2006-01-02T15:04:05.999999-07:00 host.example.org app[12]: hello\n",
+			mutator: captainslog.MutatorSetSeverity(captainslog.Err),
+			want:    "<187>2006-01-02T15:04:05.999999-07:00 host.example.org app[12]: hello\n",
+		},
+		{
+			name:    "set severity on match",
+			input:   "<191>2006-01-02T15:04:05.999999-07:00 host.example.org app[12]: ERROR: disk full\n",
+			mutator: captainslog.MutatorSetSeverityOnMatch(regexp.MustCompile(`^ERROR`), captainslog.Err),
+			want:    "<187>2006-01-02T15:04:05.999999-07:00 host.example.org app[12]: ERROR: disk full\n",
+		},
+		{
+			name:    "set severity on no match",
+			input:   "<191>2006-01-02T15:04:05.999999-07:00 host.example.org app[12]: all good\n",
+			mutator: captainslog.MutatorSetSeverityOnMatch(regexp.MustCompile(`^ERROR`), captainslog.Err),
+			want:    "<191>2006-01-02T15:04:05.999999-07:00 host.example.org app[12]: all good\n",
+		},
+		{
+			name:    "set program",
+			input:   "<191>2006-01-02T15:04:05.999999-07:00 host.example.org app[12]: hello\n",
+			mutator: captainslog.MutatorSetProgram("web"),
+			want:    "<191>2006-01-02T15:04:05.999999-07:00 host.example.org web[12]: hello\n",
+		},
+		{
+			name:    "add tags",
+			input:   "<191>2006-01-02T15:04:05.999999-07:00 host.example.org app[12]: hello\n",
+			mutator: captainslog.MutatorAddTags("tags", "prod", "east"),
+			want:    "<191>2006-01-02T15:04:05.999999-07:00 host.example.org app[12]: @cee:{\"msg\":\"hello\",\"tags\":[\"prod\",\"east\"]}\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			msg, err := captainslog.NewSyslogMsgFromBytes([]byte(tc.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := tc.mutator.Mutate(&msg); err != nil {
+				t.Fatal(err)
+			}
+
+			if want, got := tc.want, msg.String(); want != got {
+				t.Errorf("want %q, got %q", want, got)
+			}
+		})
+	}
+}
+
+func TestMutatorReplaceContentUnparsedCEE(t *testing.T) {
+	input := "<191>2006-01-02T15:04:05.999999-07:00 host.example.org app[12]: @cee:{\"msg\":\"login from 10.0.0.1\"}\n"
+	msg, err := captainslog.NewSyslogMsgFromBytes([]byte(input), captainslog.OptionDontParseJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mutator := captainslog.MutatorReplaceContent(regexp.MustCompile(`\d+\.\d+\.\d+\.\d+`), "x.x.x.x")
+	if err := mutator.Mutate(&msg); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "<191>2006-01-02T15:04:05.999999-07:00 host.example.org app[12]: @cee:{\"msg\":\"login from x.x.x.x\"}\n"
+	if got := msg.String(); want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func TestMutatorWhen(t *testing.T) {
+	msg, err := captainslog.NewSyslogMsgFromBytes([]byte("<191>2006-01-02T15:04:05.999999-07:00 host.example.org app[12]: hello\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	isApp := func(msg *captainslog.SyslogMsg) bool { return msg.Tag.Program == "app" }
+	isWeb := func(msg *captainslog.SyslogMsg) bool { return msg.Tag.Program == "web" }
+
+	if err := captainslog.MutatorWhen(isWeb, captainslog.MutatorDrop()).Mutate(&msg); err != nil {
+		t.Errorf("want nil, got %v", err)
+	}
+
+	if want, got := captainslog.ErrMsgDropped, captainslog.MutatorWhen(isApp, captainslog.MutatorDrop()).Mutate(&msg); want != got {
+		t.Errorf("want %v, got %v", want, got)
+	}
+}
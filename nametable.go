@@ -0,0 +1,177 @@
+package captainslog
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// NameTable holds the names of facilities and severities, and other
+// names they are known by. Facility.String(), Severity.String(), their
+// FromString methods and the JSON output of SyslogMsg use the current
+// NameTable, which is set with SetNameTable. A NameTable can not be
+// changed once it is created, so it is safe for concurrent use.
+type NameTable struct {
+	facilities       map[Facility]string
+	severities       map[Severity]string
+	facilitiesByName map[string]Facility
+	severitiesByName map[string]Severity
+}
+
+var (
+	// DefaultNameTable holds the names used by rsyslog and syslog-ng.
+	// Facilities 12 to 15 are named ntp, audit, alert and clock.
+	DefaultNameTable = NewNameTable()
+
+	// BSDNameTable holds the names used by FreeBSD and macOS, where
+	// facility 13 is security and facility 14 is console.
+	BSDNameTable = NewNameTable(
+		NameTableOptionFacility(LogAudit, "security"),
+		NameTableOptionFacility(LogAlert, "console"),
+	)
+
+	// SolarisNameTable holds the names used by Solaris, where facility
+	// 15 is the cron daemon. It is named solaris-cron, as cron is
+	// facility 9.
+	SolarisNameTable = NewNameTable(
+		NameTableOptionFacility(Clock, "solaris-cron"),
+	)
+
+	nameTable atomic.Value
+)
+
+// NewNameTable returns a NameTable with the default names, changed by
+// the given options.
+func NewNameTable(options ...func(*NameTable)) *NameTable {
+	t := NameTable{
+		facilities: map[Facility]string{
+			Kern:     "kern",
+			User:     "user",
+			Mail:     "mail",
+			Daemon:   "daemon",
+			Auth:     "auth",
+			Syslog:   "syslog",
+			LPR:      "lpr",
+			News:     "news",
+			UUCP:     "uucp",
+			Cron:     "cron",
+			AuthPriv: "authpriv",
+			FTP:      "ftp",
+			NTP:      "ntp",
+			LogAudit: "audit",
+			LogAlert: "alert",
+			Clock:    "clock",
+			Local0:   "local0",
+			Local1:   "local1",
+			Local2:   "local2",
+			Local3:   "local3",
+			Local4:   "local4",
+			Local5:   "local5",
+			Local6:   "local6",
+			Local7:   "local7",
+		},
+		severities: map[Severity]string{
+			Emerg:   "emerg",
+			Alert:   "alert",
+			Crit:    "crit",
+			Err:     "err",
+			Warning: "warning",
+			Notice:  "notice",
+			Info:    "info",
+			Debug:   "debug",
+		},
+		facilitiesByName: map[string]Facility{
+			"kernel":   Kern,
+			"security": Auth,
+		},
+		severitiesByName: map[string]Severity{
+			"emergency":     Emerg,
+			"panic":         Emerg,
+			"critical":      Crit,
+			"error":         Err,
+			"warn":          Warning,
+			"informational": Info,
+		},
+	}
+	for f, name := range t.facilities {
+		t.facilitiesByName[name] = f
+	}
+	for s, name := range t.severities {
+		t.severitiesByName[name] = s
+	}
+
+	for _, option := range options {
+		option(&t)
+	}
+	return &t
+}
+
+// NameTableOptionFacility names a facility, and adds aliases that
+// FromString also accepts. The previous name of the facility remains
+// an alias unless it is given to another facility.
+func NameTableOptionFacility(f Facility, name string, aliases ...string) func(*NameTable) {
+	return func(t *NameTable) {
+		t.facilities[f] = strings.ToLower(name)
+		for _, alias := range append([]string{name}, aliases...) {
+			t.facilitiesByName[strings.ToLower(alias)] = f
+		}
+	}
+}
+
+// NameTableOptionSeverity names a severity, and adds aliases that
+// FromString also accepts. The previous name of the severity remains
+// an alias unless it is given to another severity.
+func NameTableOptionSeverity(s Severity, name string, aliases ...string) func(*NameTable) {
+	return func(t *NameTable) {
+		t.severities[s] = strings.ToLower(name)
+		for _, alias := range append([]string{name}, aliases...) {
+			t.severitiesByName[strings.ToLower(alias)] = s
+		}
+	}
+}
+
+// SetNameTable sets the NameTable used to name facilities and
+// severities. Passing nil restores DefaultNameTable.
+func SetNameTable(t *NameTable) {
+	if t == nil {
+		t = DefaultNameTable
+	}
+	nameTable.Store(t)
+}
+
+func currentNameTable() *NameTable {
+	if t, ok := nameTable.Load().(*NameTable); ok {
+		return t
+	}
+	return DefaultNameTable
+}
+
+// FacilityName returns the name of a facility, or "" if it has none.
+func (t *NameTable) FacilityName(f Facility) string {
+	return t.facilities[f]
+}
+
+// SeverityName returns the name of a severity, or "" if it has none.
+func (t *NameTable) SeverityName(s Severity) string {
+	return t.severities[s]
+}
+
+// Facility returns the facility with the given name or alias. It is
+// case-insensitive.
+func (t *NameTable) Facility(name string) (Facility, error) {
+	f, ok := t.facilitiesByName[strings.ToLower(name)]
+	if !ok {
+		return f, fmt.Errorf("Failed to load syslog facility from string: %s", name)
+	}
+	return f, nil
+}
+
+// Severity returns the severity with the given name or alias. It is
+// case-insensitive.
+func (t *NameTable) Severity(name string) (Severity, error) {
+	s, ok := t.severitiesByName[strings.ToLower(name)]
+	if !ok {
+		return s, fmt.Errorf("Failed to load syslog severity from string: %s", name)
+	}
+	return s, nil
+}
@@ -0,0 +1,143 @@
+package captainslog_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/digitalocean/captainslog"
+)
+
+func TestFacilityNames(t *testing.T) {
+	testCases := []struct {
+		name     string
+		table    *captainslog.NameTable
+		facility captainslog.Facility
+		want     string
+	}{
+		{name: "default ntp", table: captainslog.DefaultNameTable, facility: captainslog.NTP, want: "ntp"},
+		{name: "default log audit", table: captainslog.DefaultNameTable, facility: captainslog.LogAudit, want: "audit"},
+		{name: "default log alert", table: captainslog.DefaultNameTable, facility: captainslog.LogAlert, want: "alert"},
+		{name: "default clock", table: captainslog.DefaultNameTable, facility: captainslog.Clock, want: "clock"},
+		{name: "bsd ntp", table: captainslog.BSDNameTable, facility: captainslog.NTP, want: "ntp"},
+		{name: "bsd log audit", table: captainslog.BSDNameTable, facility: captainslog.LogAudit, want: "security"},
+		{name: "bsd log alert", table: captainslog.BSDNameTable, facility: captainslog.LogAlert, want: "console"},
+		{name: "solaris clock", table: captainslog.SolarisNameTable, facility: captainslog.Clock, want: "solaris-cron"},
+		{name: "solaris cron", table: captainslog.SolarisNameTable, facility: captainslog.Cron, want: "cron"},
+		{name: "unknown", table: captainslog.DefaultNameTable, facility: captainslog.Facility(30), want: ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if want, got := tc.want, tc.table.FacilityName(tc.facility); want != got {
+				t.Errorf("want %q, got %q", want, got)
+			}
+
+			if tc.want == "" {
+				return
+			}
+			f, err := tc.table.Facility(tc.want)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want, got := tc.facility, f; want != got {
+				t.Errorf("want %d, got %d", want, got)
+			}
+		})
+	}
+}
+
+func TestNameTableAliases(t *testing.T) {
+	table := captainslog.NewNameTable(
+		captainslog.NameTableOptionFacility(captainslog.Local0, "Firewall", "fw"),
+		captainslog.NameTableOptionSeverity(captainslog.Emerg, "fatal"),
+	)
+
+	testCases := []struct {
+		in   string
+		want captainslog.Facility
+	}{
+		{in: "firewall", want: captainslog.Local0},
+		{in: "FW", want: captainslog.Local0},
+		{in: "local0", want: captainslog.Local0},
+		{in: "security", want: captainslog.Auth},
+	}
+
+	for _, tc := range testCases {
+		f, err := table.Facility(tc.in)
+		if err != nil {
+			t.Errorf("%s: %s", tc.in, err)
+			continue
+		}
+		if want, got := tc.want, f; want != got {
+			t.Errorf("%s: want %d, got %d", tc.in, want, got)
+		}
+	}
+
+	if want, got := "firewall", table.FacilityName(captainslog.Local0); want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+	if want, got := "fatal", table.SeverityName(captainslog.Emerg); want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+	for _, name := range []string{"fatal", "emerg", "panic"} {
+		s, err := table.Severity(name)
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+		if want, got := captainslog.Emerg, s; want != got {
+			t.Errorf("%s: want %d, got %d", name, want, got)
+		}
+	}
+
+	if _, err := table.Facility("nope"); err == nil {
+		t.Errorf("want error for unknown facility")
+	}
+}
+
+func TestSetNameTable(t *testing.T) {
+	defer captainslog.SetNameTable(nil)
+
+	msg, err := captainslog.NewSyslogMsgFromBytes([]byte("<110>2016-03-08T14:59:36.293816+00:00 host.example.com ntpd[12]: time reset\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := msg.JSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(b, []byte(`"syslog_facilitytext":"audit"`)) {
+		t.Errorf("want facility audit, got %s", b)
+	}
+
+	captainslog.SetNameTable(captainslog.BSDNameTable)
+
+	if want, got := "security", msg.Pri.Facility.String(); want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+	b, err = msg.JSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(b, []byte(`"syslog_facilitytext":"security"`)) {
+		t.Errorf("want facility security, got %s", b)
+	}
+
+	var f captainslog.Facility
+	if err := f.FromString("security"); err != nil {
+		t.Fatal(err)
+	}
+	if want, got := captainslog.LogAudit, f; want != got {
+		t.Errorf("want %d, got %d", want, got)
+	}
+
+	captainslog.SetNameTable(nil)
+
+	if err := f.FromString("security"); err != nil {
+		t.Fatal(err)
+	}
+	if want, got := captainslog.Auth, f; want != got {
+		t.Errorf("want %d, got %d", want, got)
+	}
+}
@@ -0,0 +1,410 @@
+package captainslog
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	otelScopeName = "github.com/digitalocean/captainslog"
+
+	otelHostName       = "host.name"
+	otelServiceName    = "service.name"
+	otelProcessPid     = "process.pid"
+	otelFacilityCode   = "log.syslog.facility.code"
+	otelFacilityName   = "log.syslog.facility.name"
+	otelSeverityUnset  = 0
+	otelSeverityDebug  = 5
+	otelSeverityInfo   = 9
+	otelSeverityInfo2  = 10
+	otelSeverityWarn   = 13
+	otelSeverityError  = 17
+	otelSeverityFatal2 = 22
+	otelSeverityFatal3 = 23
+	otelSeverityFatal4 = 24
+)
+
+// OTelLogRecord is an OpenTelemetry log record along with the
+// attributes of the resource that produced it.
+type OTelLogRecord struct {
+	Time           time.Time
+	ObservedTime   time.Time
+	SeverityNumber int
+	SeverityText   string
+	Body           interface{}
+	Attributes     map[string]interface{}
+	Resource       map[string]interface{}
+}
+
+// OTelLogRecord converts the message to an OpenTelemetry log record.
+// Time becomes the timestamp, Severity becomes SeverityNumber and
+// SeverityText, and Content becomes the body. For JSON messages the
+// "msg" key is used as the body instead. Host becomes the host.name
+// resource attribute, and Tag becomes the service.name and process.pid
+// resource attributes. JSONValues and the facility become log attributes.
+func (s *SyslogMsg) OTelLogRecord() (OTelLogRecord, error) {
+	values, err := s.values()
+	if err != nil {
+		return OTelLogRecord{}, err
+	}
+
+	r := OTelLogRecord{
+		Time:           s.Time,
+		ObservedTime:   time.Now(),
+		SeverityNumber: s.Pri.Severity.OTelSeverityNumber(),
+		SeverityText:   s.Pri.Severity.String(),
+		Body:           s.message(values),
+		Attributes:     values,
+		Resource:       make(map[string]interface{}),
+	}
+
+	r.Attributes[otelFacilityCode] = int(s.Pri.Facility)
+	r.Attributes[otelFacilityName] = s.Pri.Facility.String()
+
+	if s.Host != "" {
+		r.Resource[otelHostName] = s.Host
+	}
+	if s.Tag.Program != "" {
+		r.Resource[otelServiceName] = s.Tag.Program
+	}
+	if pid, err := strconv.Atoi(s.Tag.Pid); err == nil {
+		r.Resource[otelProcessPid] = pid
+	} else if s.Tag.Pid != "" {
+		r.Resource[otelProcessPid] = s.Tag.Pid
+	}
+
+	return r, nil
+}
+
+// OTelSeverityNumber returns the OpenTelemetry severity number
+// corresponding to the syslog severity.
+func (s Severity) OTelSeverityNumber() int {
+	switch s {
+	case Emerg:
+		return otelSeverityFatal4
+	case Alert:
+		return otelSeverityFatal3
+	case Crit:
+		return otelSeverityFatal2
+	case Err:
+		return otelSeverityError
+	case Warning:
+		return otelSeverityWarn
+	case Notice:
+		return otelSeverityInfo2
+	case Info:
+		return otelSeverityInfo
+	case Debug:
+		return otelSeverityDebug
+	}
+	return otelSeverityUnset
+}
+
+// otelResourceLogs groups log records that share a resource.
+type otelResourceLogs struct {
+	resource map[string]interface{}
+	records  []OTelLogRecord
+}
+
+func groupOTelLogRecords(records []OTelLogRecord) []*otelResourceLogs {
+	var groups []*otelResourceLogs
+	index := make(map[string]*otelResourceLogs)
+	for _, r := range records {
+		b, _ := json.Marshal(r.Resource)
+		key := string(b)
+		g, ok := index[key]
+		if !ok {
+			g = &otelResourceLogs{resource: r.Resource}
+			index[key] = g
+			groups = append(groups, g)
+		}
+		g.records = append(g.records, r)
+	}
+	return groups
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// EncodeOTLPLogsJSON encodes log records as an OTLP
+// ExportLogsServiceRequest using the OTLP JSON encoding.
+func EncodeOTLPLogsJSON(records []OTelLogRecord) ([]byte, error) {
+	resourceLogs := make([]interface{}, 0)
+	for _, g := range groupOTelLogRecords(records) {
+		logRecords := make([]interface{}, 0, len(g.records))
+		for _, r := range g.records {
+			lr := map[string]interface{}{
+				"timeUnixNano":         otelUnixNano(r.Time),
+				"observedTimeUnixNano": otelUnixNano(r.ObservedTime),
+				"severityNumber":       r.SeverityNumber,
+				"severityText":         r.SeverityText,
+				"body":                 otelJSONAnyValue(r.Body),
+				"attributes":           otelJSONKeyValues(r.Attributes),
+			}
+			logRecords = append(logRecords, lr)
+		}
+
+		resourceLogs = append(resourceLogs, map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": otelJSONKeyValues(g.resource),
+			},
+			"scopeLogs": []interface{}{
+				map[string]interface{}{
+					"scope":      map[string]interface{}{"name": otelScopeName},
+					"logRecords": logRecords,
+				},
+			},
+		})
+	}
+
+	return json.Marshal(map[string]interface{}{"resourceLogs": resourceLogs})
+}
+
+func otelUnixNano(t time.Time) string {
+	if t.IsZero() {
+		return "0"
+	}
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+func otelJSONKeyValues(m map[string]interface{}) []interface{} {
+	kvs := make([]interface{}, 0, len(m))
+	for _, key := range sortedKeys(m) {
+		kvs = append(kvs, map[string]interface{}{
+			"key":   key,
+			"value": otelJSONAnyValue(m[key]),
+		})
+	}
+	return kvs
+}
+
+// otelJSONAnyValue converts a value to an OTLP AnyValue in the JSON
+// encoding, where 64 bit integers are encoded as strings.
+func otelJSONAnyValue(value interface{}) map[string]interface{} {
+	switch v := otelValue(value).(type) {
+	case nil:
+		return map[string]interface{}{}
+	case string:
+		return map[string]interface{}{"stringValue": v}
+	case bool:
+		return map[string]interface{}{"boolValue": v}
+	case int64:
+		return map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}
+	case float64:
+		return map[string]interface{}{"doubleValue": v}
+	case []interface{}:
+		values := make([]interface{}, 0, len(v))
+		for _, e := range v {
+			values = append(values, otelJSONAnyValue(e))
+		}
+		return map[string]interface{}{"arrayValue": map[string]interface{}{"values": values}}
+	case map[string]interface{}:
+		return map[string]interface{}{"kvlistValue": map[string]interface{}{"values": otelJSONKeyValues(v)}}
+	}
+	return map[string]interface{}{}
+}
+
+// otelValue normalizes a value to one of nil, string, bool, int64,
+// float64, []interface{} or map[string]interface{}.
+func otelValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case nil, string, bool, int64, float64, []interface{}, map[string]interface{}:
+		return v
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		if f, err := v.Float64(); err == nil {
+			return f
+		}
+		return v.String()
+	case int:
+		return int64(v)
+	case int8:
+		return int64(v)
+	case int16:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case uint:
+		return otelUint(uint64(v))
+	case uint64:
+		return otelUint(v)
+	case uint8:
+		return int64(v)
+	case uint16:
+		return int64(v)
+	case uint32:
+		return int64(v)
+	case float32:
+		return float64(v)
+	case []string:
+		values := make([]interface{}, len(v))
+		for i, e := range v {
+			values[i] = e
+		}
+		return values
+	}
+	return fmt.Sprint(value)
+}
+
+// otelUint returns an unsigned integer as an int64, or as a decimal
+// string if it is too large for one.
+func otelUint(v uint64) interface{} {
+	if v > math.MaxInt64 {
+		return strconv.FormatUint(v, 10)
+	}
+	return int64(v)
+}
+
+// EncodeOTLPLogsProtobuf encodes log records as an OTLP
+// ExportLogsServiceRequest using the protobuf encoding.
+func EncodeOTLPLogsProtobuf(records []OTelLogRecord) ([]byte, error) {
+	var req protoBuffer
+	for _, g := range groupOTelLogRecords(records) {
+		var resource protoBuffer
+		for _, key := range sortedKeys(g.resource) {
+			resource.message(1, otelProtoKeyValue(key, g.resource[key]))
+		}
+
+		var scope protoBuffer
+		scope.string(1, otelScopeName)
+
+		var scopeLogs protoBuffer
+		scopeLogs.message(1, scope.b)
+		for _, r := range g.records {
+			scopeLogs.message(2, otelProtoLogRecord(r))
+		}
+
+		var resourceLogs protoBuffer
+		resourceLogs.message(1, resource.b)
+		resourceLogs.message(2, scopeLogs.b)
+
+		req.message(1, resourceLogs.b)
+	}
+	return req.b, nil
+}
+
+func otelProtoLogRecord(r OTelLogRecord) []byte {
+	var lr protoBuffer
+	if !r.Time.IsZero() {
+		lr.fixed64(1, uint64(r.Time.UnixNano()))
+	}
+	lr.varint(2, uint64(r.SeverityNumber))
+	lr.string(3, r.SeverityText)
+	lr.message(5, otelProtoAnyValue(r.Body))
+	for _, key := range sortedKeys(r.Attributes) {
+		lr.message(6, otelProtoKeyValue(key, r.Attributes[key]))
+	}
+	if !r.ObservedTime.IsZero() {
+		lr.fixed64(11, uint64(r.ObservedTime.UnixNano()))
+	}
+	return lr.b
+}
+
+func otelProtoKeyValue(key string, value interface{}) []byte {
+	var kv protoBuffer
+	kv.string(1, key)
+	kv.message(2, otelProtoAnyValue(value))
+	return kv.b
+}
+
+func otelProtoAnyValue(value interface{}) []byte {
+	var av protoBuffer
+	switch v := otelValue(value).(type) {
+	case string:
+		av.tag(1, protoWireBytes)
+		av.bytes([]byte(v))
+	case bool:
+		var b uint64
+		if v {
+			b = 1
+		}
+		av.tag(2, protoWireVarint)
+		av.uvarint(b)
+	case int64:
+		av.tag(3, protoWireVarint)
+		av.uvarint(uint64(v))
+	case float64:
+		av.tag(4, protoWireFixed64)
+		av.b = binary.LittleEndian.AppendUint64(av.b, math.Float64bits(v))
+	case []interface{}:
+		var array protoBuffer
+		for _, e := range v {
+			array.message(1, otelProtoAnyValue(e))
+		}
+		av.message(5, array.b)
+	case map[string]interface{}:
+		var kvlist protoBuffer
+		for _, key := range sortedKeys(v) {
+			kvlist.message(1, otelProtoKeyValue(key, v[key]))
+		}
+		av.message(6, kvlist.b)
+	}
+	return av.b
+}
+
+const (
+	protoWireVarint  = 0
+	protoWireFixed64 = 1
+	protoWireBytes   = 2
+)
+
+// protoBuffer builds protobuf wire format messages. Scalar fields
+// with default values are left out, as in proto3.
+type protoBuffer struct {
+	b []byte
+}
+
+func (p *protoBuffer) uvarint(v uint64) {
+	p.b = binary.AppendUvarint(p.b, v)
+}
+
+func (p *protoBuffer) tag(field int, wireType int) {
+	p.uvarint(uint64(field)<<3 | uint64(wireType))
+}
+
+func (p *protoBuffer) bytes(b []byte) {
+	p.uvarint(uint64(len(b)))
+	p.b = append(p.b, b...)
+}
+
+func (p *protoBuffer) varint(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+	p.tag(field, protoWireVarint)
+	p.uvarint(v)
+}
+
+func (p *protoBuffer) fixed64(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+	p.tag(field, protoWireFixed64)
+	p.b = binary.LittleEndian.AppendUint64(p.b, v)
+}
+
+func (p *protoBuffer) string(field int, s string) {
+	if s == "" {
+		return
+	}
+	p.tag(field, protoWireBytes)
+	p.bytes([]byte(s))
+}
+
+func (p *protoBuffer) message(field int, m []byte) {
+	p.tag(field, protoWireBytes)
+	p.bytes(m)
+}
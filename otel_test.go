@@ -0,0 +1,251 @@
+package captainslog_test
+
+import (
+	"encoding/binary"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/captainslog"
+)
+
+func TestSyslogMsgOTelLogRecord(t *testing.T) {
+	input := []byte("<187>2016-03-08T14:59:36.293816+00:00 host.example.com nginx[12]: @cee:{\"msg\":\"request failed\",\"status\":500}\n")
+	msg, err := captainslog.NewSyslogMsgFromBytes(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := msg.OTelLogRecord()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantTime := time.Date(2016, time.March, 8, 14, 59, 36, 293816000, time.UTC)
+	if want, got := wantTime, r.Time; !want.Equal(got) {
+		t.Errorf("time: want %v, got %v", want, got)
+	}
+
+	if want, got := 17, r.SeverityNumber; want != got {
+		t.Errorf("severity number: want %d, got %d", want, got)
+	}
+
+	if want, got := "err", r.SeverityText; want != got {
+		t.Errorf("severity text: want %q, got %q", want, got)
+	}
+
+	if want, got := "request failed", r.Body; want != got {
+		t.Errorf("body: want %q, got %q", want, got)
+	}
+
+	if want, got := "host.example.com", r.Resource["host.name"]; want != got {
+		t.Errorf("host.name: want %q, got %q", want, got)
+	}
+
+	if want, got := "nginx", r.Resource["service.name"]; want != got {
+		t.Errorf("service.name: want %q, got %q", want, got)
+	}
+
+	if want, got := 12, r.Resource["process.pid"]; want != got {
+		t.Errorf("process.pid: want %v, got %v", want, got)
+	}
+
+	for _, key := range []string{"status", "log.syslog.facility.code", "log.syslog.facility.name"} {
+		if _, ok := r.Attributes[key]; !ok {
+			t.Errorf("could not find expected key %q in attributes", key)
+		}
+	}
+
+	if _, ok := r.Attributes["msg"]; ok {
+		t.Errorf("found unexpected key %q in attributes", "msg")
+	}
+}
+
+func TestSeverityOTelSeverityNumber(t *testing.T) {
+	var tests = []struct {
+		in  captainslog.Severity
+		out int
+	}{
+		{captainslog.Emerg, 24},
+		{captainslog.Alert, 23},
+		{captainslog.Crit, 22},
+		{captainslog.Err, 17},
+		{captainslog.Warning, 13},
+		{captainslog.Notice, 10},
+		{captainslog.Info, 9},
+		{captainslog.Debug, 5},
+		{captainslog.Severity(9), 0},
+	}
+
+	for _, tt := range tests {
+		if want, got := tt.out, tt.in.OTelSeverityNumber(); want != got {
+			t.Errorf("%v: want %d, got %d", tt.in, want, got)
+		}
+	}
+}
+
+func testOTelLogRecords() []captainslog.OTelLogRecord {
+	return []captainslog.OTelLogRecord{
+		{
+			Time:           time.Unix(1457449176, 293816000),
+			SeverityNumber: 17,
+			SeverityText:   "err",
+			Body:           "request failed",
+			Attributes:     map[string]interface{}{"status": 500, "ok": false, "tags": []interface{}{"a"}, "ratio": 0.5},
+			Resource:       map[string]interface{}{"host.name": "host.example.com"},
+		},
+		{
+			Time:           time.Unix(1457449177, 0),
+			SeverityNumber: 9,
+			SeverityText:   "info",
+			Body:           "ok",
+			Attributes:     map[string]interface{}{"user": map[string]interface{}{"name": "bob"}},
+			Resource:       map[string]interface{}{"host.name": "host.example.com"},
+		},
+	}
+}
+
+func TestEncodeOTLPLogsJSON(t *testing.T) {
+	b, err := captainslog.EncodeOTLPLogsJSON(testOTelLogRecords())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wanted := `{"resourceLogs":[{"resource":{"attributes":[{"key":"host.name","value":{"stringValue":"host.example.com"}}]},"scopeLogs":[{"logRecords":[` +
+		`{"attributes":[{"key":"ok","value":{"boolValue":false}},{"key":"ratio","value":{"doubleValue":0.5}},{"key":"status","value":{"intValue":"500"}},{"key":"tags","value":{"arrayValue":{"values":[{"stringValue":"a"}]}}}],"body":{"stringValue":"request failed"},"observedTimeUnixNano":"0","severityNumber":17,"severityText":"err","timeUnixNano":"1457449176293816000"},` +
+		`{"attributes":[{"key":"user","value":{"kvlistValue":{"values":[{"key":"name","value":{"stringValue":"bob"}}]}}}],"body":{"stringValue":"ok"},"observedTimeUnixNano":"0","severityNumber":9,"severityText":"info","timeUnixNano":"1457449177000000000"}` +
+		`],"scope":{"name":"github.com/digitalocean/captainslog"}}]}]}`
+	if want, got := wanted, string(b); want != got {
+		t.Errorf("want %s\ngot  %s", want, got)
+	}
+}
+
+func TestEncodeOTLPLogsJSONUnsigned(t *testing.T) {
+	records := []captainslog.OTelLogRecord{
+		{
+			Time:       time.Unix(0, 0),
+			Attributes: map[string]interface{}{"big": uint64(math.MaxUint64), "small": uint64(7), "uint": uint(math.MaxInt64 + 1)},
+		},
+	}
+	b, err := captainslog.EncodeOTLPLogsJSON(records)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		`{"key":"big","value":{"stringValue":"18446744073709551615"}}`,
+		`{"key":"small","value":{"intValue":"7"}}`,
+		`{"key":"uint","value":{"stringValue":"9223372036854775808"}}`,
+	} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("want %s in %s", want, b)
+		}
+	}
+}
+
+// protoField is a decoded protobuf field, used to check the
+// protobuf encoding without depending on generated code.
+type protoField struct {
+	num    int
+	varint uint64
+	bytes  []byte
+}
+
+func decodeProto(t *testing.T, b []byte) []protoField {
+	var fields []protoField
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			t.Fatalf("bad field key")
+		}
+		b = b[n:]
+
+		f := protoField{num: int(key >> 3)}
+		switch key & 7 {
+		case 0:
+			f.varint, n = binary.Uvarint(b)
+			if n <= 0 {
+				t.Fatalf("bad varint")
+			}
+			b = b[n:]
+		case 1:
+			f.varint = binary.LittleEndian.Uint64(b)
+			b = b[8:]
+		case 2:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || int(l) > len(b)-n {
+				t.Fatalf("bad length")
+			}
+			f.bytes = b[n : n+int(l)]
+			b = b[n+int(l):]
+		default:
+			t.Fatalf("unexpected wire type %d", key&7)
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+func protoFieldsNamed(fields []protoField, num int) []protoField {
+	var found []protoField
+	for _, f := range fields {
+		if f.num == num {
+			found = append(found, f)
+		}
+	}
+	return found
+}
+
+func TestEncodeOTLPLogsProtobuf(t *testing.T) {
+	b, err := captainslog.EncodeOTLPLogsProtobuf(testOTelLogRecords())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resourceLogs := protoFieldsNamed(decodeProto(t, b), 1)
+	if want, got := 1, len(resourceLogs); want != got {
+		t.Fatalf("resource logs: want %d, got %d", want, got)
+	}
+
+	rl := decodeProto(t, resourceLogs[0].bytes)
+	resource := decodeProto(t, protoFieldsNamed(rl, 1)[0].bytes)
+	kv := decodeProto(t, protoFieldsNamed(resource, 1)[0].bytes)
+	if want, got := "host.name", string(protoFieldsNamed(kv, 1)[0].bytes); want != got {
+		t.Errorf("resource key: want %q, got %q", want, got)
+	}
+
+	scopeLogs := decodeProto(t, protoFieldsNamed(rl, 2)[0].bytes)
+	records := protoFieldsNamed(scopeLogs, 2)
+	if want, got := 2, len(records); want != got {
+		t.Fatalf("log records: want %d, got %d", want, got)
+	}
+
+	lr := decodeProto(t, records[0].bytes)
+	if want, got := uint64(1457449176293816000), protoFieldsNamed(lr, 1)[0].varint; want != got {
+		t.Errorf("time: want %d, got %d", want, got)
+	}
+
+	if want, got := uint64(17), protoFieldsNamed(lr, 2)[0].varint; want != got {
+		t.Errorf("severity number: want %d, got %d", want, got)
+	}
+
+	if want, got := "err", string(protoFieldsNamed(lr, 3)[0].bytes); want != got {
+		t.Errorf("severity text: want %q, got %q", want, got)
+	}
+
+	body := decodeProto(t, protoFieldsNamed(lr, 5)[0].bytes)
+	if want, got := "request failed", string(protoFieldsNamed(body, 1)[0].bytes); want != got {
+		t.Errorf("body: want %q, got %q", want, got)
+	}
+
+	if want, got := 4, len(protoFieldsNamed(lr, 6)); want != got {
+		t.Errorf("attributes: want %d, got %d", want, got)
+	}
+
+	status := decodeProto(t, protoFieldsNamed(lr, 6)[2].bytes)
+	value := decodeProto(t, protoFieldsNamed(status, 2)[0].bytes)
+	if want, got := uint64(500), protoFieldsNamed(value, 3)[0].varint; want != got {
+		t.Errorf("status: want %d, got %d", want, got)
+	}
+}
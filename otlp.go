@@ -0,0 +1,126 @@
+package captainslog
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	otlpContentTypeProtobuf = "application/x-protobuf"
+	otlpContentTypeJSON     = "application/json"
+
+	// otlpTimeout is the default timeout of an export request, as
+	// in the OpenTelemetry exporter specification.
+	otlpTimeout = 10 * time.Second
+)
+
+// OTLPEncoding is the encoding used by an OTLPExporter.
+type OTLPEncoding int
+
+const (
+	// OTLPEncodingProtobuf sends OTLP requests encoded as protobuf.
+	OTLPEncodingProtobuf OTLPEncoding = 0
+
+	// OTLPEncodingJSON sends OTLP requests encoded as JSON.
+	OTLPEncodingJSON OTLPEncoding = 1
+)
+
+// OTLPExporter sends batches of SyslogMsgs to an OpenTelemetry
+// collector as OTLP/HTTP log export requests.
+type OTLPExporter struct {
+	endpoint string
+	encoding OTLPEncoding
+	headers  map[string]string
+	client   *http.Client
+}
+
+// NewOTLPExporter returns a new OTLPExporter sending to the given
+// endpoint, such as "http://localhost:4318/v1/logs". Requests are
+// encoded as protobuf and time out after 10 seconds by default.
+func NewOTLPExporter(endpoint string, options ...func(*OTLPExporter)) *OTLPExporter {
+	e := OTLPExporter{
+		endpoint: endpoint,
+		headers:  make(map[string]string),
+		client:   &http.Client{Timeout: otlpTimeout},
+	}
+	for _, option := range options {
+		option(&e)
+	}
+	return &e
+}
+
+// OTLPExporterOptionEncoding sets the encoding used by the OTLPExporter.
+func OTLPExporterOptionEncoding(encoding OTLPEncoding) func(*OTLPExporter) {
+	return func(e *OTLPExporter) {
+		e.encoding = encoding
+	}
+}
+
+// OTLPExporterOptionHeader adds a header, such as an authorization
+// header, to the requests sent by the OTLPExporter.
+func OTLPExporterOptionHeader(key, value string) func(*OTLPExporter) {
+	return func(e *OTLPExporter) {
+		e.headers[key] = value
+	}
+}
+
+// OTLPExporterOptionHTTPClient sets the http.Client used by the
+// OTLPExporter, such as one with another timeout.
+func OTLPExporterOptionHTTPClient(client *http.Client) func(*OTLPExporter) {
+	return func(e *OTLPExporter) {
+		e.client = client
+	}
+}
+
+// Export converts the messages to OpenTelemetry log records and
+// sends them in a single request.
+func (e *OTLPExporter) Export(msgs []SyslogMsg) error {
+	records := make([]OTelLogRecord, 0, len(msgs))
+	for i := range msgs {
+		r, err := msgs[i].OTelLogRecord()
+		if err != nil {
+			return err
+		}
+		records = append(records, r)
+	}
+
+	var body []byte
+	var contentType string
+	var err error
+
+	switch e.encoding {
+	case OTLPEncodingJSON:
+		body, err = EncodeOTLPLogsJSON(records)
+		contentType = otlpContentTypeJSON
+	default:
+		body, err = EncodeOTLPLogsProtobuf(records)
+		contentType = otlpContentTypeProtobuf
+	}
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	for key, value := range e.headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("OTLP export failed: %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package captainslog_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/digitalocean/captainslog"
+)
+
+type stubCollector struct {
+	contentType string
+	auth        string
+	body        []byte
+	status      int
+}
+
+func (c *stubCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.contentType = r.Header.Get("Content-Type")
+	c.auth = r.Header.Get("Authorization")
+	c.body, _ = io.ReadAll(r.Body)
+	w.WriteHeader(c.status)
+}
+
+func testOTLPMsgs(t *testing.T) []captainslog.SyslogMsg {
+	var msgs []captainslog.SyslogMsg
+	for _, line := range []string{
+		"<187>2016-03-08T14:59:36.293816+00:00 host.example.com nginx[12]: @cee:{\"msg\":\"request failed\",\"status\":500}\n",
+		"<190>2016-03-08T14:59:37.293816+00:00 host.example.com nginx[12]: request ok\n",
+		"<190>2016-03-08T14:59:37.293816+00:00 other.example.com nginx[99]: request ok\n",
+	} {
+		msg, err := captainslog.NewSyslogMsgFromBytes([]byte(line))
+		if err != nil {
+			t.Fatal(err)
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs
+}
+
+func TestOTLPExporterJSON(t *testing.T) {
+	c := &stubCollector{status: http.StatusOK}
+	srv := httptest.NewServer(c)
+	defer srv.Close()
+
+	e := captainslog.NewOTLPExporter(srv.URL+"/v1/logs",
+		captainslog.OTLPExporterOptionEncoding(captainslog.OTLPEncodingJSON),
+		captainslog.OTLPExporterOptionHeader("Authorization", "Bearer token"),
+	)
+
+	if err := e.Export(testOTLPMsgs(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := "application/json", c.contentType; want != got {
+		t.Errorf("content type: want %q, got %q", want, got)
+	}
+
+	if want, got := "Bearer token", c.auth; want != got {
+		t.Errorf("authorization: want %q, got %q", want, got)
+	}
+
+	var req struct {
+		ResourceLogs []struct {
+			ScopeLogs []struct {
+				LogRecords []struct {
+					SeverityText string `json:"severityText"`
+					Body         struct {
+						StringValue string `json:"stringValue"`
+					} `json:"body"`
+				} `json:"logRecords"`
+			} `json:"scopeLogs"`
+		} `json:"resourceLogs"`
+	}
+	if err := json.Unmarshal(c.body, &req); err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := 2, len(req.ResourceLogs); want != got {
+		t.Fatalf("resource logs: want %d, got %d", want, got)
+	}
+
+	records := req.ResourceLogs[0].ScopeLogs[0].LogRecords
+	if want, got := 2, len(records); want != got {
+		t.Fatalf("log records: want %d, got %d", want, got)
+	}
+
+	if want, got := "request failed", records[0].Body.StringValue; want != got {
+		t.Errorf("body: want %q, got %q", want, got)
+	}
+
+	if want, got := "info", records[1].SeverityText; want != got {
+		t.Errorf("severity text: want %q, got %q", want, got)
+	}
+}
+
+func TestOTLPExporterProtobuf(t *testing.T) {
+	c := &stubCollector{status: http.StatusOK}
+	srv := httptest.NewServer(c)
+	defer srv.Close()
+
+	e := captainslog.NewOTLPExporter(srv.URL + "/v1/logs")
+	if err := e.Export(testOTLPMsgs(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := "application/x-protobuf", c.contentType; want != got {
+		t.Errorf("content type: want %q, got %q", want, got)
+	}
+
+	if want, got := 2, len(protoFieldsNamed(decodeProto(t, c.body), 1)); want != got {
+		t.Errorf("resource logs: want %d, got %d", want, got)
+	}
+}
+
+func TestOTLPExporterError(t *testing.T) {
+	c := &stubCollector{status: http.StatusServiceUnavailable}
+	srv := httptest.NewServer(c)
+	defer srv.Close()
+
+	e := captainslog.NewOTLPExporter(srv.URL + "/v1/logs")
+	if err := e.Export(testOTLPMsgs(t)); err == nil {
+		t.Errorf("Did not get error when collector returned %d", c.status)
+	}
+}
@@ -1,7 +1,6 @@
 package captainslog
 
 import (
-	"bytes"
 	"encoding/json"
 	"errors"
 	"os"
@@ -9,8 +8,6 @@ import (
 	"strings"
 	"time"
 	"unicode"
-
-	"github.com/tidwall/gjson"
 )
 
 const (
@@ -26,6 +23,9 @@ const (
 	dayLen      = 2
 	startDay    = 8
 	datePartSep = "-"
+
+	// ceeCookie marks the content of a message as CEE JSON.
+	ceeCookie = "@cee:"
 )
 
 var (
@@ -41,6 +41,10 @@ var (
 	//ErrBadContent is returned when the content of a message is malformed.
 	ErrBadContent = errors.New("Content not found")
 
+	//ErrBadCEE is returned when the content following a CEE cookie is malformed JSON,
+	//or is not a JSON object with OptionStrictCEE.
+	ErrBadCEE = errors.New("CEE content is not JSON")
+
 	rsyslogTimeFormat = "2006-01-02T15:04:05.999999-07:00"
 
 	timeFormats = []string{
@@ -63,6 +67,16 @@ type Parser struct {
 	optionDontParseJSON   bool
 	optionSanitizeProgram bool
 	optionUseGJSON        bool
+	optionLazyParseJSON   bool
+	optionTreatJSONAsCEE  bool
+	optionStrictCEE       bool
+	optionKernelFormat    bool
+	optionParseLogfmt     bool
+	optionParseCEF        bool
+	optionParseLEEF       bool
+	decoders              []conditionalDecoder
+	bootTime              time.Time
+	hostname              string
 	location              *time.Location
 	msg                   *SyslogMsg
 }
@@ -109,6 +123,64 @@ func OptionUseGJSONParser(p *Parser) {
 	p.optionUseGJSON = true
 }
 
+// OptionLazyParseJSON sets the parser to only record that the content field
+// of the message looks like JSON, without decoding it. The JSON is decoded
+// on the first call to SyslogMsg.DecodeJSON, or when the message is
+// modified or serialized. Individual fields can be read without decoding
+// the whole document using SyslogMsg.JSONValue. This is useful when most
+// messages are routed on fields such as Host or Tag and the JSON is rarely
+// needed. SyslogMsg.JSONValues stays empty until the JSON is decoded, so
+// callers must read fields with SyslogMsg.JSONValue or call
+// SyslogMsg.DecodeJSON first. This setting has no effect when used with
+// OptionDontParseJSON.
+func OptionLazyParseJSON(p *Parser) {
+	p.optionLazyParseJSON = true
+}
+
+// OptionTreatJSONAsCEE sets the parser to treat content that is a
+// JSON object as CEE even when it is not preceded by a @cee: cookie.
+// SyslogMsg.IsCee is set for such messages, but the cookie is not
+// added when the message is serialized.
+func OptionTreatJSONAsCEE(p *Parser) {
+	p.optionTreatJSONAsCEE = true
+}
+
+// OptionStrictCEE sets the parser to return ErrBadCEE for messages whose
+// @cee: cookie is not followed by a JSON object, even with
+// OptionDontParseJSON. By default such content is kept as plain text,
+// and only malformed JSON after a cookie returns ErrBadCEE.
+func OptionStrictCEE(p *Parser) {
+	p.optionStrictCEE = true
+}
+
+// OptionParseLogfmt sets the parser to decode content that is not JSON
+// as logfmt key=value pairs, such as "level=info user=bob dur=12ms",
+// into SyslogMsg.JSONValues. SyslogMsg.IsLogfmt is set for such messages,
+// and SyslogMsg.String() and SyslogMsg.Bytes() encode their content as
+// logfmt. Content that is not valid logfmt, or looks like prose with
+// few key=value pairs, is left as plain text.
+func OptionParseLogfmt(p *Parser) {
+	p.optionParseLogfmt = true
+}
+
+// OptionParseCEF sets the parser to decode ArcSight CEF events in the
+// content field of the message, as in "CEF:0|Vendor|Product|1.0|100|Blocked|5|src=10.0.0.1",
+// into SyslogMsg.JSONValues using DecodeCEF. Events sent without a syslog
+// tag, where "CEF:" is parsed as the tag, are decoded too. SyslogMsg.String()
+// and SyslogMsg.Bytes() encode the content of such messages with EncodeCEF.
+func OptionParseCEF(p *Parser) {
+	p.optionParseCEF = true
+}
+
+// OptionParseLEEF sets the parser to decode QRadar LEEF events in the
+// content field of the message, as in "LEEF:1.0|Vendor|Product|1.0|100|src=10.0.0.1",
+// into SyslogMsg.JSONValues using DecodeLEEF. Events sent without a syslog
+// tag, where "LEEF:" is parsed as the tag, are decoded too. SyslogMsg.String()
+// and SyslogMsg.Bytes() encode the content of such messages with EncodeLEEF.
+func OptionParseLEEF(p *Parser) {
+	p.optionParseLEEF = true
+}
+
 // OptionLocation is a helper function to configure the parser to parse time
 // in the given timezone, If the parsed time contains a valid timezone
 // identifier this takes precedence. Default timezone is UTC.
@@ -126,9 +198,15 @@ func (p *Parser) ParseBytes(b []byte) (SyslogMsg, error) {
 	p.cur = 0
 	msg := NewSyslogMsg()
 	msg.optionDontParseJSON = p.optionDontParseJSON
+	msg.optionUseGJSON = p.optionUseGJSON
 	p.msg = &msg
 
-	err := p.parse()
+	var err error
+	if p.optionKernelFormat {
+		err = p.parseKernel()
+	} else {
+		err = p.parse()
+	}
 	if p.msg.Time.Year() == 0 {
 		p.msg.Time = p.msg.Time.AddDate(time.Now().In(p.location).Year(), 0, 0)
 	}
@@ -195,8 +273,10 @@ func (p *Parser) parse() error {
 		p.msg.IsCee = true
 	}
 
+	lazy := p.optionLazyParseJSON && !p.optionDontParseJSON
+
 	copts := make([]func(*contentOpts), 0)
-	if !p.optionDontParseJSON {
+	if !p.optionDontParseJSON && !lazy {
 		copts = append(copts, ContentOptionParseJSON)
 	}
 
@@ -208,12 +288,42 @@ func (p *Parser) parse() error {
 		copts = append(copts, ContentOptionRequireTerminator)
 	}
 
+	if p.msg.IsCee && p.optionStrictCEE {
+		copts = append(copts, ContentOptionCEE)
+	}
+
+	if p.optionParseLogfmt {
+		copts = append(copts, ContentOptionParseLogfmt)
+	}
+
+	if p.optionParseCEF {
+		copts = append(copts, ContentOptionParseCEF)
+	}
+
+	if p.optionParseLEEF {
+		copts = append(copts, ContentOptionParseLEEF)
+	}
+
+	copts = append(copts, contentOptionDecoders(p.decoders, p.msg))
+
 	var content Content
 	_, content, err = ParseContent(p.buf[p.cur:], copts...)
 	p.msg.Content = content.Content
 	p.msg.JSONValues = content.JSONValues
-	if len(p.msg.JSONValues) > 0 {
+	p.msg.IsLogfmt = content.IsLogfmt
+	p.msg.decoder = content.Decoder
+	// a JSON object is JSON whichever decoder decoded it
+	if _, ok := content.Decoder.(jsonDecoder); ok && len(p.msg.JSONValues) > 0 {
+		p.msg.IsJSON = true
+	} else if _, encoded := content.Decoder.(ContentEncoder); !encoded && !content.IsLogfmt && content.ProbablyJSON && len(p.msg.JSONValues) > 0 {
+		p.msg.IsJSON = json.Valid([]byte(trimCEESpace(content.Content)))
+	}
+	if lazy && content.ProbablyJSON {
 		p.msg.IsJSON = true
+		p.msg.pendingJSON = true
+	}
+	if p.optionTreatJSONAsCEE && !p.msg.IsCee && content.ProbablyJSON && err == nil {
+		p.msg.IsCee = true
 	}
 	return err
 }
@@ -496,60 +606,48 @@ FoundEndOfTag:
 
 // ParseCEE will try to find a syslog cee cookie  at the beginning of the
 // passed in []byte. It returns the offset from the start of the []byte
-// to the end of the cee string, the string, and an error.
+// to the end of the cee string, the string, and an error. Any amount of
+// whitespace may precede the cookie; whitespace following it is left
+// to the content.
 func ParseCEE(buf []byte) (int, string, error) {
 	var err error
 	var cee string
 	var offset int
 
-	if offset >= len(buf)-1 {
-		return offset, cee, err
-	}
-
 	tokenStart := offset
 	tokenEnd := offset
 
-	for buf[tokenEnd] == ' ' {
+	for tokenEnd < len(buf) && isCEESpace(buf[tokenEnd]) {
 		tokenEnd++
-		if tokenEnd >= len(buf)-1 {
-			return offset, cee, err
-		}
-	}
-
-	if tokenEnd+4 > len(buf)-1 {
-		return offset, cee, err
 	}
 
-	if buf[tokenEnd] != '@' {
+	if tokenEnd+len(ceeCookie) > len(buf) {
 		return offset, cee, err
 	}
 
-	tokenEnd++
-	if buf[tokenEnd] != 'c' {
+	if string(buf[tokenEnd:tokenEnd+len(ceeCookie)]) != ceeCookie {
 		return offset, cee, err
 	}
 
-	tokenEnd++
-	if buf[tokenEnd] != 'e' {
-		return offset, cee, err
-	}
-
-	tokenEnd++
-	if buf[tokenEnd] != 'e' {
-		return offset, cee, err
-	}
-
-	tokenEnd++
-	if buf[tokenEnd] != ':' {
-		return offset, cee, err
-	}
-
-	tokenEnd++
+	tokenEnd += len(ceeCookie)
 	offset = tokenEnd
 	cee = string(buf[tokenStart:tokenEnd])
 	return offset, cee, err
 }
 
+// isCEESpace reports whether b is whitespace allowed around a CEE cookie.
+func isCEESpace(b byte) bool {
+	return b == ' ' || b == '\t'
+}
+
+// trimCEESpace returns s without its leading CEE whitespace.
+func trimCEESpace(s string) string {
+	for len(s) > 0 && isCEESpace(s[0]) {
+		s = s[1:]
+	}
+	return s
+}
+
 type tagOpts struct {
 	sanitizeProgram bool
 }
@@ -563,6 +661,12 @@ type contentOpts struct {
 	requireTerminator bool
 	parseJSON         bool
 	useGJSON          bool
+	cee               bool
+	parseLogfmt       bool
+	parseCEF          bool
+	parseLEEF         bool
+	decoders          []conditionalDecoder
+	msg               *SyslogMsg
 }
 
 // ContentOptionRequireTerminator sets ParseContent to require a \n terminator
@@ -580,16 +684,60 @@ func ContentOptionUseGJSON(opts *contentOpts) {
 	opts.useGJSON = true
 }
 
+// ContentOptionCEE tells ParseContent that the content followed a CEE cookie,
+// so it must be a JSON object.
+func ContentOptionCEE(opts *contentOpts) {
+	opts.cee = true
+}
+
+// ContentOptionParseLogfmt will decode content that is not JSON as logfmt
+func ContentOptionParseLogfmt(opts *contentOpts) {
+	opts.parseLogfmt = true
+}
+
+// ContentOptionParseCEF will decode ArcSight CEF events
+func ContentOptionParseCEF(opts *contentOpts) {
+	opts.parseCEF = true
+}
+
+// ContentOptionParseLEEF will decode QRadar LEEF events
+func ContentOptionParseLEEF(opts *contentOpts) {
+	opts.parseLEEF = true
+}
+
+// contentOptionDecoders sets the decoders ParseContent tries before the
+// built-in ones, and the message their conditions are checked against.
+func contentOptionDecoders(decoders []conditionalDecoder, msg *SyslogMsg) func(*contentOpts) {
+	return func(opts *contentOpts) {
+		opts.decoders = decoders
+		opts.msg = msg
+	}
+}
+
 // ParseContent will try to find syslog content at the beginning of the
 // passed in []byte. It returns the offset from the start of the []byte
 // to the end of the content, a captainslog.Content, and an error. It
-// accepts two options:
+// accepts the following options:
 //
 // ContentOptionRequireTerminator: if true, if the syslog message does not
 //		contain a '\n' terminator it will be treated as invalid.
 //
 // ContentOptionParseJSON: if true, it will treat the content field of the
 //		syslog message as a CEE message and parse the JSON.
+//
+// ContentOptionUseGJSON: if true, JSON is parsed with "github.com/tidwall/gjson".
+//
+// ContentOptionCEE: if true, content that is not a JSON object is treated
+//		as invalid and ErrBadCEE is returned.
+//
+// ContentOptionParseLogfmt: if true, content that is not JSON is decoded
+//		as logfmt key=value pairs into the JSONValues if possible.
+//
+// ContentOptionParseCEF: if true, content starting with "CEF:" is decoded
+//		as an ArcSight CEF event into the JSONValues.
+//
+// ContentOptionParseLEEF: if true, content starting with "LEEF:" is decoded
+//		as a QRadar LEEF event into the JSONValues.
 func ParseContent(buf []byte, options ...func(*contentOpts)) (int, Content, error) {
 	var o contentOpts
 	for _, option := range options {
@@ -598,7 +746,6 @@ func ParseContent(buf []byte, options ...func(*contentOpts)) (int, Content, erro
 
 	content := Content{JSONValues: make(map[string]interface{})}
 
-	var err error
 	var offset int
 	var probablyJSON bool
 
@@ -607,7 +754,7 @@ func ParseContent(buf []byte, options ...func(*contentOpts)) (int, Content, erro
 	}
 
 	tokenStart := offset
-	for buf[offset] == ' ' {
+	for isCEESpace(buf[offset]) {
 		offset++
 		if offset >= len(buf)-1 {
 			if o.requireTerminator {
@@ -632,21 +779,71 @@ func ParseContent(buf []byte, options ...func(*contentOpts)) (int, Content, erro
 	}
 
 	content.Content = string(buf[tokenStart:offset])
-	if o.parseJSON && probablyJSON {
+	content.ProbablyJSON = probablyJSON
+
+	decoders := append([]conditionalDecoder(nil), o.decoders...)
+	if o.parseJSON {
+		decoder := JSONContentDecoder
 		if o.useGJSON {
-			m, ok := gjson.Parse(content.Content).Value().(map[string]interface{})
-			if !ok {
-				return offset, content, errors.New("gjson parse failed")
-			}
-			content.JSONValues = m
-		} else {
-			decoder := json.NewDecoder(bytes.NewBuffer(buf[tokenStart:offset]))
-			decoder.UseNumber()
-			err = decoder.Decode(&content.JSONValues)
-			if err != nil {
-				return offset, content, err
+			decoder = GJSONContentDecoder
+		}
+		decoders = append(decoders, conditionalDecoder{
+			decoder:    decoder,
+			conditions: []ContentCondition{ContentConditionLeadingByte('{')},
+		})
+	}
+	if o.parseCEF {
+		decoders = append(decoders,
+			conditionalDecoder{decoder: CEFContentDecoder, conditions: []ContentCondition{contentConditionCEF}},
+			conditionalDecoder{decoder: cefDecoder{headerInTag: true}, conditions: []ContentCondition{contentConditionCEFTag}},
+		)
+	}
+	if o.parseLEEF {
+		decoders = append(decoders,
+			conditionalDecoder{decoder: LEEFContentDecoder, conditions: []ContentCondition{contentConditionLEEF}},
+			conditionalDecoder{decoder: leefDecoder{headerInTag: true}, conditions: []ContentCondition{contentConditionLEEFTag}},
+		)
+	}
+	if o.parseLogfmt {
+		decoders = append(decoders, conditionalDecoder{
+			decoder:    LogfmtContentDecoder,
+			conditions: []ContentCondition{contentConditionNotCEE},
+		})
+	}
+
+	msg := o.msg
+	if msg == nil {
+		msg = &SyslogMsg{IsCee: o.cee}
+	}
+
+	b := buf[tokenStart:offset]
+	for len(b) > 0 && isCEESpace(b[0]) {
+		b = b[1:]
+	}
+	for _, d := range decoders {
+		if !d.applies(msg, b) {
+			continue
+		}
+
+		m, err := d.decoder.DecodeContent(b)
+		if err != nil {
+			if msg.IsCee {
+				return offset, content, ErrBadCEE
 			}
+			return offset, content, err
 		}
+		if m == nil {
+			continue
+		}
+
+		content.JSONValues = m
+		content.Decoder = d.decoder
+		_, content.IsLogfmt = d.decoder.(logfmtDecoder)
+		return offset, content, nil
+	}
+
+	if o.cee && !probablyJSON {
+		return offset, content, ErrBadCEE
 	}
-	return offset, content, err
+	return offset, content, nil
 }
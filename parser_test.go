@@ -431,6 +431,186 @@ func TestParser(t *testing.T) {
 			content:  " {\"a\":\"b\"}",
 			jsonKeys: []string{},
 		},
+		{
+			name:     "parse cee with OptionLazyParseJSON",
+			input:    "<191>2006-01-02T15:04:05.999999-07:00 host.example.org test: @cee:{\"a\":\"b\"}\n",
+			options:  []func(*captainslog.Parser){captainslog.OptionLazyParseJSON},
+			err:      nil,
+			facility: captainslog.Local7,
+			severity: captainslog.Debug,
+			year:     2006,
+			month:    1,
+			day:      2,
+			hour:     15,
+			minute:   4,
+			second:   5,
+			millis:   999999,
+			offset:   -25200,
+			host:     "host.example.org",
+			program:  "test",
+			tag:      "test:",
+			pid:      "",
+			cee:      true,
+			json:     true,
+			content:  "{\"a\":\"b\"}",
+			jsonKeys: []string{},
+		},
+		{
+			name:     "parse cee with tab after cookie",
+			input:    "<191>2006-01-02T15:04:05.999999-07:00 host.example.org test: @cee:\t{\"a\":\"b\"}\n",
+			options:  []func(*captainslog.Parser){},
+			err:      nil,
+			facility: captainslog.Local7,
+			severity: captainslog.Debug,
+			year:     2006,
+			month:    1,
+			day:      2,
+			hour:     15,
+			minute:   4,
+			second:   5,
+			millis:   999999,
+			offset:   -25200,
+			host:     "host.example.org",
+			program:  "test",
+			tag:      "test:",
+			pid:      "",
+			cee:      true,
+			json:     true,
+			content:  "\t{\"a\":\"b\"}",
+			jsonKeys: []string{"a"},
+		},
+		{
+			name:     "parse cee with tab before cookie",
+			input:    "<191>2006-01-02T15:04:05.999999-07:00 host.example.org test:\t@cee: {\"a\":\"b\"}\n",
+			options:  []func(*captainslog.Parser){},
+			err:      nil,
+			facility: captainslog.Local7,
+			severity: captainslog.Debug,
+			year:     2006,
+			month:    1,
+			day:      2,
+			hour:     15,
+			minute:   4,
+			second:   5,
+			millis:   999999,
+			offset:   -25200,
+			host:     "host.example.org",
+			program:  "test",
+			tag:      "test:",
+			pid:      "",
+			cee:      true,
+			json:     true,
+			content:  " {\"a\":\"b\"}",
+			jsonKeys: []string{"a"},
+		},
+		{
+			name:     "parse json without cee with OptionTreatJSONAsCEE",
+			input:    "<191>2006-01-02T15:04:05.999999-07:00 host.example.org test: {\"a\":\"b\"}\n",
+			options:  []func(*captainslog.Parser){captainslog.OptionTreatJSONAsCEE},
+			err:      nil,
+			facility: captainslog.Local7,
+			severity: captainslog.Debug,
+			year:     2006,
+			month:    1,
+			day:      2,
+			hour:     15,
+			minute:   4,
+			second:   5,
+			millis:   999999,
+			offset:   -25200,
+			host:     "host.example.org",
+			program:  "test",
+			tag:      "test:",
+			pid:      "",
+			cee:      true,
+			json:     true,
+			content:  " {\"a\":\"b\"}",
+			jsonKeys: []string{"a"},
+		},
+		{
+			name:     "parse plain text with OptionTreatJSONAsCEE",
+			input:    "<191>2006-01-02T15:04:05.999999-07:00 host.example.org test: hello world\n",
+			options:  []func(*captainslog.Parser){captainslog.OptionTreatJSONAsCEE},
+			err:      nil,
+			facility: captainslog.Local7,
+			severity: captainslog.Debug,
+			year:     2006,
+			month:    1,
+			day:      2,
+			hour:     15,
+			minute:   4,
+			second:   5,
+			millis:   999999,
+			offset:   -25200,
+			host:     "host.example.org",
+			program:  "test",
+			tag:      "test:",
+			pid:      "",
+			cee:      false,
+			json:     false,
+			content:  " hello world",
+			jsonKeys: []string{},
+		},
+		{
+			name:    "parse cee with invalid json",
+			input:   "<191>2006-01-02T15:04:05.999999-07:00 host.example.org test: @cee: {\\\"a\\\":\\\"b\\\"}\n",
+			options: []func(*captainslog.Parser){},
+			err:     captainslog.ErrBadCEE,
+		},
+		{
+			name:    "parse cee with no space and invalid json",
+			input:   "<191>2006-01-02T15:04:05.999999-07:00 host.example.org test:@cee:{\\\"a\\\":\\\"b\\\"}\n",
+			options: []func(*captainslog.Parser){},
+			err:     captainslog.ErrBadCEE,
+		},
+		{
+			name:     "parse cee with plain text",
+			input:    "<191>2006-01-02T15:04:05.999999-07:00 host.example.org test: @cee: hello world\n",
+			options:  []func(*captainslog.Parser){},
+			err:      nil,
+			facility: captainslog.Local7,
+			severity: captainslog.Debug,
+			year:     2006,
+			month:    1,
+			day:      2,
+			hour:     15,
+			minute:   4,
+			second:   5,
+			millis:   999999,
+			offset:   -25200,
+			host:     "host.example.org",
+			program:  "test",
+			tag:      "test:",
+			pid:      "",
+			cee:      true,
+			json:     false,
+			content:  " hello world",
+			jsonKeys: []string{},
+		},
+		{
+			name:    "parse cee with plain text with OptionStrictCEE",
+			input:   "<191>2006-01-02T15:04:05.999999-07:00 host.example.org test: @cee: hello world\n",
+			options: []func(*captainslog.Parser){captainslog.OptionStrictCEE},
+			err:     captainslog.ErrBadCEE,
+		},
+		{
+			name:    "parse cee with plain text with OptionStrictCEE and OptionDontParseJSON",
+			input:   "<191>2006-01-02T15:04:05.999999-07:00 host.example.org test: @cee: hello world\n",
+			options: []func(*captainslog.Parser){captainslog.OptionStrictCEE, captainslog.OptionDontParseJSON},
+			err:     captainslog.ErrBadCEE,
+		},
+		{
+			name:    "parse cee with invalid json with OptionUseGJSONParser",
+			input:   "<191>2006-01-02T15:04:05.999999-07:00 host.example.org test: @cee: {\"a\":\n",
+			options: []func(*captainslog.Parser){captainslog.OptionUseGJSONParser},
+			err:     captainslog.ErrBadCEE,
+		},
+		{
+			name:    "parse cee with plain text with OptionStrictCEE and OptionLazyParseJSON",
+			input:   "<191>2006-01-02T15:04:05.999999-07:00 host.example.org test: @cee: hello world\n",
+			options: []func(*captainslog.Parser){captainslog.OptionStrictCEE, captainslog.OptionLazyParseJSON},
+			err:     captainslog.ErrBadCEE,
+		},
 		{
 			name:     "parse cee early termination",
 			input:    "<191>2006-01-02T15:04:05.999999-07:00 host.example.org test: @cee\n",
@@ -964,6 +1144,21 @@ func BenchmarkParserParseCEEWithOptionDontParseJSON(b *testing.B) {
 	}
 }
 
+func BenchmarkParserParseCEEWithOptionLazyParseJSON(b *testing.B) {
+	m := []byte("<191>2006-01-02T15:04:05.999999-07:00 host.example.org test: @cee:{\"a\":\"b\"}\n")
+
+	for i := 0; i < b.N; i++ {
+		b.SetBytes(int64(len(m)))
+		msg, err := captainslog.NewSyslogMsgFromBytes(m, captainslog.OptionLazyParseJSON)
+		if err != nil {
+			panic(err)
+		}
+		if msg.Host != "host.example.org" {
+			panic("unexpected msg.Host")
+		}
+	}
+}
+
 func BenchmarkParserParseLeastLikelyTime(b *testing.B) {
 	m := []byte("<38>Mon Jan  2 15:04:05 host.example.org test: hello world\n")
 
@@ -0,0 +1,168 @@
+package captainslog
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	//ErrMsgDropped is returned by a Mutator or a Pipeline when a message should be discarded.
+	ErrMsgDropped = errors.New("Message dropped")
+)
+
+// Mutator modifies a SyslogMsg in place. A Mutator may return
+// ErrMsgDropped to have the message discarded.
+type Mutator interface {
+	Mutate(msg *SyslogMsg) error
+}
+
+// MutatorFunc adapts a function to a Mutator.
+type MutatorFunc func(msg *SyslogMsg) error
+
+// Mutate calls f(msg).
+func (f MutatorFunc) Mutate(msg *SyslogMsg) error {
+	return f(msg)
+}
+
+// StageErrorAction is what a Pipeline does when a stage returns an error.
+type StageErrorAction int
+
+const (
+	// StageErrorAbort stops the pipeline and returns the error. Changes
+	// made by earlier stages are kept. It is the default.
+	StageErrorAbort StageErrorAction = 0
+
+	// StageErrorContinue ignores the error and runs the next stage.
+	StageErrorContinue StageErrorAction = 1
+
+	// StageErrorDrop stops the pipeline and returns ErrMsgDropped.
+	StageErrorDrop StageErrorAction = 2
+)
+
+// PipelineError is returned by Pipeline.Mutate when a stage fails and
+// its StageErrorAction is StageErrorAbort.
+type PipelineError struct {
+	Stage int
+	Name  string
+	Err   error
+}
+
+// Error returns the error of the stage along with its position and name.
+func (e *PipelineError) Error() string {
+	if e.Name != "" {
+		return fmt.Sprintf("pipeline stage %d (%s): %s", e.Stage, e.Name, e.Err)
+	}
+	return fmt.Sprintf("pipeline stage %d: %s", e.Stage, e.Err)
+}
+
+// Unwrap returns the error of the stage.
+func (e *PipelineError) Unwrap() error {
+	return e.Err
+}
+
+// Pipeline applies an ordered list of Mutators, its stages, to messages.
+// A Pipeline is itself a Mutator, so pipelines can be nested.
+type Pipeline struct {
+	stages       []PipelineStage
+	errorHandler func(stage int, name string, msg *SyslogMsg, err error)
+}
+
+// PipelineStage is a stage of a Pipeline, configured by the
+// StageOptionName and StageOptionOnError options of PipelineOptionStage.
+type PipelineStage struct {
+	mutator Mutator
+	name    string
+	onError StageErrorAction
+}
+
+// NewPipeline returns a new Pipeline. Stages are added with
+// PipelineOptionStage, and run in the order they were added.
+func NewPipeline(options ...func(*Pipeline)) *Pipeline {
+	p := Pipeline{}
+	for _, option := range options {
+		option(&p)
+	}
+	return &p
+}
+
+// PipelineOptionStage adds a Mutator as the next stage of the pipeline.
+func PipelineOptionStage(mutator Mutator, options ...func(*PipelineStage)) func(*Pipeline) {
+	return func(p *Pipeline) {
+		stage := PipelineStage{mutator: mutator}
+		for _, option := range options {
+			option(&stage)
+		}
+		p.stages = append(p.stages, stage)
+	}
+}
+
+// PipelineOptionErrorHandler sets a function that is called with every
+// error returned by a stage, including the ones that are ignored with
+// StageErrorContinue. It is not called for ErrMsgDropped.
+func PipelineOptionErrorHandler(handler func(stage int, name string, msg *SyslogMsg, err error)) func(*Pipeline) {
+	return func(p *Pipeline) {
+		p.errorHandler = handler
+	}
+}
+
+// StageOptionName names a stage, for errors.
+func StageOptionName(name string) func(*PipelineStage) {
+	return func(s *PipelineStage) {
+		s.name = name
+	}
+}
+
+// StageOptionOnError sets what the pipeline does when the stage returns
+// an error. The default is StageErrorAbort.
+func StageOptionOnError(action StageErrorAction) func(*PipelineStage) {
+	return func(s *PipelineStage) {
+		s.onError = action
+	}
+}
+
+// Mutate runs each stage of the pipeline on the message in order. It
+// returns ErrMsgDropped if a stage dropped the message, or a *PipelineError
+// if a stage failed and its StageErrorAction is StageErrorAbort.
+func (p *Pipeline) Mutate(msg *SyslogMsg) error {
+	for i, stage := range p.stages {
+		err := stage.mutator.Mutate(msg)
+		if err == nil {
+			continue
+		}
+		if errors.Is(err, ErrMsgDropped) {
+			return ErrMsgDropped
+		}
+
+		if p.errorHandler != nil {
+			p.errorHandler(i, stage.name, msg, err)
+		}
+
+		switch stage.onError {
+		case StageErrorContinue:
+			continue
+		case StageErrorDrop:
+			return ErrMsgDropped
+		default:
+			return &PipelineError{Stage: i, Name: stage.name, Err: err}
+		}
+	}
+	return nil
+}
+
+// Apply runs the pipeline on each message, and returns the messages that
+// were not dropped. It stops at the first message a stage fails on with
+// StageErrorAbort, returning the messages before it along with the error.
+func (p *Pipeline) Apply(msgs []SyslogMsg) ([]SyslogMsg, error) {
+	kept := make([]SyslogMsg, 0, len(msgs))
+	for i := range msgs {
+		err := p.Mutate(&msgs[i])
+		if err == ErrMsgDropped {
+			continue
+		}
+		if err != nil {
+			return kept, err
+		}
+		kept = append(kept, msgs[i])
+	}
+	return kept, nil
+}
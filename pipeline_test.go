@@ -0,0 +1,139 @@
+package captainslog_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/digitalocean/captainslog"
+)
+
+func TestPipeline(t *testing.T) {
+	p := captainslog.NewPipeline(
+		captainslog.PipelineOptionStage(captainslog.MutatorSetProgram("web")),
+		captainslog.PipelineOptionStage(captainslog.MutatorSetKey("env", "prod")),
+		captainslog.PipelineOptionStage(captainslog.MutatorRenameKey("env", "environment")),
+	)
+
+	msg, err := captainslog.NewSyslogMsgFromBytes([]byte("<191>2006-01-02T15:04:05.999999-07:00 host.example.org app[12]: @cee:{\"a\":\"b\"}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Mutate(&msg); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "<191>2006-01-02T15:04:05.999999-07:00 host.example.org web[12]: @cee: {\"a\":\"b\",\"environment\":\"prod\"}\n"
+	if got := msg.String(); want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func TestPipelineStageErrors(t *testing.T) {
+	errStage := errors.New("stage failed")
+	failing := captainslog.MutatorFunc(func(msg *captainslog.SyslogMsg) error {
+		return errStage
+	})
+
+	testCases := []struct {
+		name    string
+		action  captainslog.StageErrorAction
+		wantErr error
+		wantEnv bool
+	}{
+		{name: "abort", action: captainslog.StageErrorAbort, wantErr: errStage},
+		{name: "continue", action: captainslog.StageErrorContinue, wantEnv: true},
+		{name: "drop", action: captainslog.StageErrorDrop, wantErr: captainslog.ErrMsgDropped},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var handled []string
+			p := captainslog.NewPipeline(
+				captainslog.PipelineOptionStage(failing,
+					captainslog.StageOptionName("failing"),
+					captainslog.StageOptionOnError(tc.action)),
+				captainslog.PipelineOptionStage(captainslog.MutatorSetKey("env", "prod")),
+				captainslog.PipelineOptionErrorHandler(func(stage int, name string, msg *captainslog.SyslogMsg, err error) {
+					handled = append(handled, name)
+				}),
+			)
+
+			msg := captainslog.NewSyslogMsg()
+			err := p.Mutate(&msg)
+			if !errors.Is(err, tc.wantErr) {
+				t.Errorf("want %v, got %v", tc.wantErr, err)
+			}
+
+			if _, ok := msg.JSONValues["env"]; ok != tc.wantEnv {
+				t.Errorf("env set: want %v, got %v", tc.wantEnv, ok)
+			}
+
+			if want, got := 1, len(handled); want != got {
+				t.Errorf("handled errors: want %d, got %d", want, got)
+			}
+		})
+	}
+}
+
+func TestPipelineError(t *testing.T) {
+	p := captainslog.NewPipeline(
+		captainslog.PipelineOptionStage(captainslog.MutatorSetProgram("web")),
+		captainslog.PipelineOptionStage(captainslog.MutatorSetSeverity(captainslog.Severity(12)), captainslog.StageOptionName("severity")),
+	)
+
+	msg := captainslog.NewSyslogMsg()
+	err := p.Mutate(&msg)
+
+	var pipelineErr *captainslog.PipelineError
+	if !errors.As(err, &pipelineErr) {
+		t.Fatalf("want *PipelineError, got %v", err)
+	}
+
+	if want, got := 1, pipelineErr.Stage; want != got {
+		t.Errorf("stage: want %d, got %d", want, got)
+	}
+
+	if want, got := captainslog.ErrBadSeverity, pipelineErr.Err; want != got {
+		t.Errorf("want %v, got %v", want, got)
+	}
+
+	if want, got := "pipeline stage 1 (severity): "+captainslog.ErrBadSeverity.Error(), err.Error(); want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func TestPipelineApply(t *testing.T) {
+	isDebug := func(msg *captainslog.SyslogMsg) bool { return msg.Pri.Severity == captainslog.Debug }
+	p := captainslog.NewPipeline(
+		captainslog.PipelineOptionStage(captainslog.NewPipeline(
+			captainslog.PipelineOptionStage(captainslog.MutatorWhen(isDebug, captainslog.MutatorDrop())),
+		)),
+		captainslog.PipelineOptionStage(captainslog.MutatorSetProgram("web")),
+	)
+
+	var msgs []captainslog.SyslogMsg
+	for _, line := range []string{
+		"<191>2006-01-02T15:04:05.999999-07:00 host.example.org app[12]: debug\n",
+		"<190>2006-01-02T15:04:05.999999-07:00 host.example.org app[12]: info\n",
+	} {
+		msg, err := captainslog.NewSyslogMsgFromBytes([]byte(line))
+		if err != nil {
+			t.Fatal(err)
+		}
+		msgs = append(msgs, msg)
+	}
+
+	kept, err := p.Apply(msgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := 1, len(kept); want != got {
+		t.Fatalf("want %d, got %d", want, got)
+	}
+
+	if want, got := "web", kept[0].Tag.Program; want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
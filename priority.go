@@ -1,8 +1,11 @@
 package captainslog
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 )
 
 var (
@@ -45,56 +48,84 @@ const (
 	Debug Severity = 7
 )
 
+// String returns the name of the severity in the current NameTable,
+// or "" if it has none.
 func (s Severity) String() string {
-	var severityText string
-
-	switch s {
-	case Emerg:
-		severityText = "emerg"
-	case Alert:
-		severityText = "alert"
-	case Crit:
-		severityText = "crit"
-	case Err:
-		severityText = "err"
-	case Warning:
-		severityText = "warning"
-	case Notice:
-		severityText = "notice"
-	case Info:
-		severityText = "info"
-	case Debug:
-		severityText = "debug"
-	default:
+	return currentNameTable().SeverityName(s)
+}
+
+// FromString loads a syslog severity from a string representation,
+// using the names and aliases of the current NameTable. It is
+// case-insensitive, and by default also accepts the aliases emergency,
+// panic, critical, error, warn and informational.
+func (s *Severity) FromString(v string) error {
+	sv, err := currentNameTable().Severity(v)
+	if err != nil {
+		return err
 	}
+	*s = sv
+	return nil
+}
 
-	return severityText
+// AtLeast reports whether s is as severe as threshold or more severe.
+// Severities are numbered from Emerg, 0, to Debug, 7, so Err.AtLeast(Warning)
+// is true and Info.AtLeast(Warning) is false.
+func (s Severity) AtLeast(threshold Severity) bool {
+	return s <= threshold
 }
 
-// FromString loads a syslog severity from a string representation
-func (s *Severity) FromString(v string) error {
-	switch v {
-	case Emerg.String():
-		*s = Emerg
-	case Alert.String():
-		*s = Alert
-	case Crit.String():
-		*s = Crit
-	case Err.String():
-		*s = Err
-	case Warning.String():
-		*s = Warning
-	case Notice.String():
-		*s = Notice
-	case Info.String():
-		*s = Info
-	case Debug.String():
-		*s = Debug
-	default:
-		return fmt.Errorf("Failed to load syslog severity from string: %s", v)
+// MoreSevereThan reports whether s is more severe than o.
+func (s Severity) MoreSevereThan(o Severity) bool {
+	return s < o
+}
+
+// LessSevereThan reports whether s is less severe than o.
+func (s Severity) LessSevereThan(o Severity) bool {
+	return s > o
+}
+
+// UnmarshalText sets the severity from its name, an alias, or its code.
+func (s *Severity) UnmarshalText(b []byte) error {
+	if code, ok := parsePriorityCode(string(b)); ok {
+		if code > int(Debug) {
+			return ErrBadSeverity
+		}
+		*s = Severity(code)
+		return nil
 	}
+	return s.FromString(string(b))
+}
 
-	return nil
+// UnmarshalJSON sets the severity from a JSON string with its name, an
+// alias or its code, or from a JSON number with its code.
+func (s *Severity) UnmarshalJSON(b []byte) error {
+	return unmarshalPriorityJSON(b, s)
+}
+
+// NamedSeverity is a Severity that marshals to JSON, YAML and text as
+// its name rather than its code, for use in struct fields. It
+// unmarshals like a Severity.
+type NamedSeverity Severity
+
+// MarshalText returns the name of the severity. It returns
+// ErrBadSeverity if the severity is not within allowed values.
+func (s NamedSeverity) MarshalText() ([]byte, error) {
+	name := Severity(s).String()
+	if name == "" {
+		return nil, ErrBadSeverity
+	}
+	return []byte(name), nil
+}
+
+// UnmarshalText sets the severity from its name, an alias, or its code.
+func (s *NamedSeverity) UnmarshalText(b []byte) error {
+	return (*Severity)(s).UnmarshalText(b)
+}
+
+// UnmarshalJSON sets the severity from a JSON string with its name, an
+// alias or its code, or from a JSON number with its code.
+func (s *NamedSeverity) UnmarshalJSON(b []byte) error {
+	return (*Severity)(s).UnmarshalJSON(b)
 }
 
 // Facility represents a syslog facility code
@@ -137,6 +168,18 @@ const (
 	// FTP is the ftp rfc3164 facility.
 	FTP Facility = 11
 
+	// NTP is the ntp facility.
+	NTP Facility = 12
+
+	// LogAudit is the log audit facility, security on BSD.
+	LogAudit Facility = 13
+
+	// LogAlert is the log alert facility, console on BSD.
+	LogAlert Facility = 14
+
+	// Clock is the clock daemon facility, cron on Solaris.
+	Clock Facility = 15
+
 	// Local0 is the local0 rfc3164 facility.
 	Local0 Facility = 16
 
@@ -162,104 +205,93 @@ const (
 	Local7 Facility = 23
 )
 
+// String returns the name of the facility in the current NameTable,
+// or "" if it has none.
 func (f Facility) String() string {
-	var faciliyText string
-
-	switch f {
-	case Kern:
-		faciliyText = "kern"
-	case User:
-		faciliyText = "user"
-	case Mail:
-		faciliyText = "mail"
-	case Daemon:
-		faciliyText = "daemon"
-	case Auth:
-		faciliyText = "auth"
-	case Syslog:
-		faciliyText = "syslog"
-	case LPR:
-		faciliyText = "lpr"
-	case News:
-		faciliyText = "news"
-	case UUCP:
-		faciliyText = "uucp"
-	case Cron:
-		faciliyText = "cron"
-	case AuthPriv:
-		faciliyText = "authpriv"
-	case FTP:
-		faciliyText = "ftp"
-	case Local0:
-		faciliyText = "local0"
-	case Local1:
-		faciliyText = "local1"
-	case Local2:
-		faciliyText = "local2"
-	case Local3:
-		faciliyText = "local3"
-	case Local4:
-		faciliyText = "local4"
-	case Local5:
-		faciliyText = "local5"
-	case Local6:
-		faciliyText = "local6"
-	case Local7:
-		faciliyText = "local7"
-	default:
+	return currentNameTable().FacilityName(f)
+}
+
+// FromString sets the Facility from a string representation, using the
+// names and aliases of the current NameTable. It is case-insensitive,
+// and by default also accepts the aliases kernel and security.
+func (f *Facility) FromString(v string) error {
+	facility, err := currentNameTable().Facility(v)
+	if err != nil {
+		return err
 	}
+	*f = facility
+	return nil
+}
 
-	return faciliyText
+// UnmarshalText sets the facility from its name, an alias, or its code.
+func (f *Facility) UnmarshalText(b []byte) error {
+	if code, ok := parsePriorityCode(string(b)); ok {
+		if code > int(Local7) {
+			return ErrBadFacility
+		}
+		*f = Facility(code)
+		return nil
+	}
+	return f.FromString(string(b))
 }
 
-// FromString sets the Facility from a string representation.
-func (f *Facility) FromString(v string) error {
-	switch v {
-	case Kern.String():
-		*f = Kern
-	case User.String():
-		*f = User
-	case Mail.String():
-		*f = Mail
-	case Daemon.String():
-		*f = Daemon
-	case Auth.String():
-		*f = Auth
-	case Syslog.String():
-		*f = Syslog
-	case LPR.String():
-		*f = LPR
-	case News.String():
-		*f = News
-	case UUCP.String():
-		*f = UUCP
-	case Cron.String():
-		*f = Cron
-	case AuthPriv.String():
-		*f = AuthPriv
-	case FTP.String():
-		*f = FTP
-	case Local0.String():
-		*f = Local0
-	case Local1.String():
-		*f = Local1
-	case Local2.String():
-		*f = Local2
-	case Local3.String():
-		*f = Local3
-	case Local4.String():
-		*f = Local4
-	case Local5.String():
-		*f = Local5
-	case Local6.String():
-		*f = Local6
-	case Local7.String():
-		*f = Local7
-	default:
-		return fmt.Errorf("Failed to load syslog facility from string: %s", v)
+// UnmarshalJSON sets the facility from a JSON string with its name, an
+// alias or its code, or from a JSON number with its code.
+func (f *Facility) UnmarshalJSON(b []byte) error {
+	return unmarshalPriorityJSON(b, f)
+}
+
+// NamedFacility is a Facility that marshals to JSON, YAML and text as
+// its name rather than its code, for use in struct fields. It
+// unmarshals like a Facility.
+type NamedFacility Facility
+
+// MarshalText returns the name of the facility. It returns
+// ErrBadFacility if the facility is not within allowed values.
+func (f NamedFacility) MarshalText() ([]byte, error) {
+	name := Facility(f).String()
+	if name == "" {
+		return nil, ErrBadFacility
 	}
+	return []byte(name), nil
+}
 
-	return nil
+// UnmarshalText sets the facility from its name, an alias, or its code.
+func (f *NamedFacility) UnmarshalText(b []byte) error {
+	return (*Facility)(f).UnmarshalText(b)
+}
+
+// UnmarshalJSON sets the facility from a JSON string with its name, an
+// alias or its code, or from a JSON number with its code.
+func (f *NamedFacility) UnmarshalJSON(b []byte) error {
+	return (*Facility)(f).UnmarshalJSON(b)
+}
+
+// parsePriorityCode parses the decimal code of a facility or severity.
+func parsePriorityCode(v string) (int, bool) {
+	if v == "" || strings.TrimLeft(v, "0123456789") != "" {
+		return 0, false
+	}
+	code, err := strconv.Atoi(v)
+	return code, err == nil
+}
+
+func unmarshalPriorityJSON(b []byte, u interface{ UnmarshalText([]byte) error }) error {
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+
+	switch v := v.(type) {
+	case string:
+		return u.UnmarshalText([]byte(v))
+	case float64:
+		if v != float64(int(v)) || v < 0 {
+			return u.UnmarshalText(b)
+		}
+		return u.UnmarshalText([]byte(strconv.Itoa(int(v))))
+	}
+	return u.UnmarshalText(b)
 }
 
 // Priority represents the PRI of a rfc3164 message.
@@ -269,6 +301,22 @@ type Priority struct {
 	Severity Severity
 }
 
+// NewPriorityFromPRI returns the Priority of a raw PRI value, the
+// number between the angle brackets of a message. It returns
+// ErrBadPriority if the value is not between 0 and 191.
+func NewPriorityFromPRI(pri int) (*Priority, error) {
+	if pri < 0 || pri > int(Local7)*8+int(Debug) {
+		return nil, ErrBadPriority
+	}
+	return NewPriority(Facility(pri/8), Severity(pri%8))
+}
+
+// AtLeast reports whether the severity of the Priority is as severe
+// as threshold or more severe.
+func (p Priority) AtLeast(threshold Severity) bool {
+	return p.Severity.AtLeast(threshold)
+}
+
 // String converts the given Priority to a string.
 func (p Priority) String() string {
 	return fmt.Sprintf("%d", p.Priority)
@@ -1,8 +1,11 @@
 package captainslog_test
 
 import (
+	"encoding/json"
 	"testing"
 
+	"gopkg.in/yaml.v3"
+
 	"github.com/digitalocean/captainslog"
 )
 
@@ -190,6 +193,10 @@ func TestFacilityFromString(t *testing.T) {
 		{"cron", captainslog.Cron},
 		{"authpriv", captainslog.AuthPriv},
 		{"ftp", captainslog.FTP},
+		{"ntp", captainslog.NTP},
+		{"audit", captainslog.LogAudit},
+		{"alert", captainslog.LogAlert},
+		{"clock", captainslog.Clock},
 		{"local0", captainslog.Local0},
 		{"local1", captainslog.Local1},
 		{"local2", captainslog.Local2},
@@ -246,3 +253,248 @@ func TestSeverityFromString(t *testing.T) {
 		t.Errorf("Did not get error when converting foo to captainslog.Severity")
 	}
 }
+
+func TestSeverityFromStringAliases(t *testing.T) {
+	var tests = []struct {
+		in  string
+		out captainslog.Severity
+	}{
+		{"EMERG", captainslog.Emerg},
+		{"panic", captainslog.Emerg},
+		{"Emergency", captainslog.Emerg},
+		{"critical", captainslog.Crit},
+		{"error", captainslog.Err},
+		{"ERR", captainslog.Err},
+		{"warn", captainslog.Warning},
+		{"Warning", captainslog.Warning},
+		{"informational", captainslog.Info},
+	}
+
+	for _, tt := range tests {
+		var s captainslog.Severity
+		if err := s.FromString(tt.in); err != nil {
+			t.Errorf("%s: %s", tt.in, err)
+		}
+		if want, got := tt.out, s; want != got {
+			t.Errorf("%s: want %q, got %q", tt.in, want, got)
+		}
+	}
+}
+
+func TestFacilityFromStringAliases(t *testing.T) {
+	var tests = []struct {
+		in  string
+		out captainslog.Facility
+	}{
+		{"security", captainslog.Auth},
+		{"kernel", captainslog.Kern},
+		{"LOCAL7", captainslog.Local7},
+		{"AuthPriv", captainslog.AuthPriv},
+	}
+
+	for _, tt := range tests {
+		var f captainslog.Facility
+		if err := f.FromString(tt.in); err != nil {
+			t.Errorf("%s: %s", tt.in, err)
+		}
+		if want, got := tt.out, f; want != got {
+			t.Errorf("%s: want %q, got %q", tt.in, want, got)
+		}
+	}
+}
+
+func TestSeverityComparison(t *testing.T) {
+	var tests = []struct {
+		s              captainslog.Severity
+		o              captainslog.Severity
+		atLeast        bool
+		moreSevereThan bool
+		lessSevereThan bool
+	}{
+		{captainslog.Err, captainslog.Warning, true, true, false},
+		{captainslog.Warning, captainslog.Warning, true, false, false},
+		{captainslog.Info, captainslog.Warning, false, false, true},
+		{captainslog.Emerg, captainslog.Debug, true, true, false},
+	}
+
+	for _, tt := range tests {
+		if want, got := tt.atLeast, tt.s.AtLeast(tt.o); want != got {
+			t.Errorf("%s.AtLeast(%s): want %t, got %t", tt.s, tt.o, want, got)
+		}
+		if want, got := tt.moreSevereThan, tt.s.MoreSevereThan(tt.o); want != got {
+			t.Errorf("%s.MoreSevereThan(%s): want %t, got %t", tt.s, tt.o, want, got)
+		}
+		if want, got := tt.lessSevereThan, tt.s.LessSevereThan(tt.o); want != got {
+			t.Errorf("%s.LessSevereThan(%s): want %t, got %t", tt.s, tt.o, want, got)
+		}
+	}
+
+	p, err := captainslog.NewPriority(captainslog.Local7, captainslog.Crit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.AtLeast(captainslog.Err) {
+		t.Errorf("want crit to be at least err")
+	}
+}
+
+func TestNewPriorityFromPRI(t *testing.T) {
+	var tests = []struct {
+		pri      int
+		facility captainslog.Facility
+		severity captainslog.Severity
+		err      error
+	}{
+		{0, captainslog.Kern, captainslog.Emerg, nil},
+		{14, captainslog.User, captainslog.Info, nil},
+		{191, captainslog.Local7, captainslog.Debug, nil},
+		{192, 0, 0, captainslog.ErrBadPriority},
+		{-1, 0, 0, captainslog.ErrBadPriority},
+	}
+
+	for _, tt := range tests {
+		p, err := captainslog.NewPriorityFromPRI(tt.pri)
+		if want, got := tt.err, err; want != got {
+			t.Errorf("%d: want %v, got %v", tt.pri, want, got)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if want, got := tt.pri, p.Priority; want != got {
+			t.Errorf("want %d, got %d", want, got)
+		}
+		if want, got := tt.facility, p.Facility; want != got {
+			t.Errorf("want %q, got %q", want, got)
+		}
+		if want, got := tt.severity, p.Severity; want != got {
+			t.Errorf("want %q, got %q", want, got)
+		}
+	}
+}
+
+func TestPriorityMarshalJSON(t *testing.T) {
+	type level struct {
+		Facility captainslog.Facility `json:"facility"`
+		Severity captainslog.Severity `json:"severity"`
+	}
+
+	// codes are marshaled as numbers unless names are asked for
+	b, err := json.Marshal(level{Facility: captainslog.Local7, Severity: captainslog.Warning})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := `{"facility":23,"severity":4}`, string(b); want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+
+	named := struct {
+		Facility captainslog.NamedFacility `json:"facility"`
+		Severity captainslog.NamedSeverity `json:"severity"`
+	}{Facility: captainslog.NamedFacility(captainslog.Local7), Severity: captainslog.NamedSeverity(captainslog.Warning)}
+	if b, err = json.Marshal(named); err != nil {
+		t.Fatal(err)
+	}
+	if want, got := `{"facility":"local7","severity":"warning"}`, string(b); want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+	if err := json.Unmarshal([]byte(`{"facility":"cron","severity":3}`), &named); err != nil || named.Facility != captainslog.NamedFacility(captainslog.Cron) || named.Severity != captainslog.NamedSeverity(captainslog.Err) {
+		t.Errorf("want cron and err, got %v %v %v", named.Facility, named.Severity, err)
+	}
+
+	named.Severity = captainslog.NamedSeverity(9)
+	if _, err := json.Marshal(named); err == nil {
+		t.Errorf("want error marshaling a bad severity")
+	}
+
+	var tests = []struct {
+		in       string
+		facility captainslog.Facility
+		severity captainslog.Severity
+		err      bool
+	}{
+		{in: `{"facility":"local7","severity":"warning"}`, facility: captainslog.Local7, severity: captainslog.Warning},
+		{in: `{"facility":"Security","severity":"error"}`, facility: captainslog.Auth, severity: captainslog.Err},
+		{in: `{"facility":23,"severity":4}`, facility: captainslog.Local7, severity: captainslog.Warning},
+		{in: `{"facility":"1","severity":"3"}`, facility: captainslog.User, severity: captainslog.Err},
+		{in: `{"severity":8}`, err: true},
+		{in: `{"severity":"loud"}`, err: true},
+		{in: `{"facility":24}`, err: true},
+		{in: `{"facility":true}`, err: true},
+	}
+
+	for _, tt := range tests {
+		var l level
+		err := json.Unmarshal([]byte(tt.in), &l)
+		if want, got := tt.err, err != nil; want != got {
+			t.Errorf("%s: want error %t, got %v", tt.in, want, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if want, got := tt.facility, l.Facility; want != got {
+			t.Errorf("%s: want %q, got %q", tt.in, want, got)
+		}
+		if want, got := tt.severity, l.Severity; want != got {
+			t.Errorf("%s: want %q, got %q", tt.in, want, got)
+		}
+	}
+}
+
+func TestPriorityMarshalText(t *testing.T) {
+	var s captainslog.NamedSeverity
+	if err := s.UnmarshalText([]byte("crit")); err != nil {
+		t.Fatal(err)
+	}
+	b, err := s.MarshalText()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := "crit", string(b); want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+
+	var f captainslog.NamedFacility
+	if err := f.UnmarshalText([]byte("18")); err != nil {
+		t.Fatal(err)
+	}
+	b, err = f.MarshalText()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := "local2", string(b); want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func TestPriorityMarshalYAML(t *testing.T) {
+	b, err := yaml.Marshal(map[string]interface{}{
+		"code": captainslog.Notice,
+		"name": captainslog.NamedSeverity(captainslog.Notice),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := "code: 5\nname: notice\n", string(b); want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+
+	var level struct {
+		Facility captainslog.Facility      `yaml:"facility"`
+		Severity captainslog.NamedSeverity `yaml:"severity"`
+	}
+	if err := yaml.Unmarshal([]byte("facility: cron\nseverity: 3\n"), &level); err != nil {
+		t.Fatal(err)
+	}
+	if want, got := captainslog.Cron, level.Facility; want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+	if want, got := captainslog.NamedSeverity(captainslog.Err), level.Severity; want != got {
+		t.Errorf("want %v, got %v", want, got)
+	}
+
+	if err := yaml.Unmarshal([]byte("facility: [cron]\n"), &level); err == nil {
+		t.Errorf("want error for a sequence")
+	}
+}
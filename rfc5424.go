@@ -0,0 +1,66 @@
+package captainslog
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	rfc5424Version    = 1
+	rfc5424TimeFormat = "2006-01-02T15:04:05.999999Z07:00"
+	rfc5424Nil        = "-"
+	rfc5424HostLen    = 255
+	rfc5424AppNameLen = 48
+	rfc5424ProcIDLen  = 128
+)
+
+// RFC5424 returns the SyslogMsg as an RFC5424 message, such as
+// "<191>1 2006-01-02T15:04:05.999999-07:00 host.example.org test 12 - - engage\n".
+// The program and pid become the APP-NAME and PROCID, and empty fields
+// are written as "-". The message has no MSGID or structured data, and
+// its content is serialized as by SyslogMsg.String(), without its
+// leading whitespace.
+func (s *SyslogMsg) RFC5424() []byte {
+	timestamp := rfc5424Nil
+	if !s.Time.IsZero() {
+		timestamp = s.Time.Format(rfc5424TimeFormat)
+	}
+
+	cee, content := s.formatContent()
+	msg := trimCEESpace(cee + content)
+	msg = strings.TrimRight(msg, "\n")
+
+	header := fmt.Sprintf("<%s>%d %s %s %s %s %s %s",
+		s.Pri.String(),
+		rfc5424Version,
+		timestamp,
+		rfc5424Field(s.Host, rfc5424HostLen),
+		rfc5424Field(s.Tag.Program, rfc5424AppNameLen),
+		rfc5424Field(s.Tag.Pid, rfc5424ProcIDLen),
+		rfc5424Nil,
+		rfc5424Nil,
+	)
+	if msg == "" {
+		return []byte(header + "\n")
+	}
+	return []byte(header + " " + msg + "\n")
+}
+
+// rfc5424Field returns a header field, with characters other than
+// printable US-ASCII replaced with "_", truncated to max bytes, or "-"
+// if it is empty.
+func rfc5424Field(v string, max int) string {
+	if v == "" {
+		return rfc5424Nil
+	}
+	v = strings.Map(func(r rune) rune {
+		if r < '!' || r > '~' {
+			return '_'
+		}
+		return r
+	}, v)
+	if len(v) > max {
+		v = v[:max]
+	}
+	return v
+}
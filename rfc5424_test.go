@@ -0,0 +1,54 @@
+package captainslog_test
+
+import (
+	"testing"
+
+	"github.com/digitalocean/captainslog"
+)
+
+func TestSyslogMsgRFC5424(t *testing.T) {
+	testCases := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name:  "plain",
+			input: "<191>2006-01-02T15:04:05.999999-07:00 host.example.org test[12]: engage\n",
+			want:  "<191>1 2006-01-02T15:04:05.999999-07:00 host.example.org test 12 - - engage\n",
+		},
+		{
+			name:  "no pid",
+			input: "<4>2016-03-08T14:59:36.293816+00:00 host.example.com kernel: test\n",
+			want:  "<4>1 2016-03-08T14:59:36.293816Z host.example.com kernel - - - test\n",
+		},
+		{
+			name:  "cee",
+			input: "<191>2006-01-02T15:04:05.999999-07:00 host.example.org test: @cee: {\"a\":\"b\"}\n",
+			want:  "<191>1 2006-01-02T15:04:05.999999-07:00 host.example.org test - - - @cee: {\"a\":\"b\"}\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			msg, err := captainslog.NewSyslogMsgFromBytes([]byte(tc.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want, got := tc.want, string(msg.RFC5424()); want != got {
+				t.Errorf("want %q, got %q", want, got)
+			}
+		})
+	}
+}
+
+func TestSyslogMsgRFC5424EmptyFields(t *testing.T) {
+	msg := captainslog.NewSyslogMsg()
+	msg.SetFacility(captainslog.Local7)
+	msg.SetSeverity(captainslog.Info)
+	msg.SetProgram("my app")
+
+	if want, got := "<190>1 - - my_app - - -\n", string(msg.RFC5424()); want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
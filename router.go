@@ -0,0 +1,380 @@
+package captainslog
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// routerQueueSize is the default size of each sink's queue.
+	routerQueueSize = 1024
+)
+
+var (
+	//ErrRouterClosed is returned when a message is routed after the Router is closed.
+	ErrRouterClosed = errors.New("Router closed")
+
+	//ErrQueueFull is passed to the Router's error handler for messages dropped from a full queue, and returned by a full DiskQueue.
+	ErrQueueFull = errors.New("Queue full")
+)
+
+// Sink receives messages from a Router. Send is only called from one
+// goroutine at a time for each sink. Each sink a message is routed to
+// gets its own copy of the message's JSONValues map, since formatting a
+// message can set keys in it, but values nested in it are shared, so
+// sinks must not modify them.
+type Sink interface {
+	Send(msg SyslogMsg) error
+}
+
+// SinkFunc adapts a function to a Sink.
+type SinkFunc func(msg SyslogMsg) error
+
+// Send calls f(msg).
+func (f SinkFunc) Send(msg SyslogMsg) error {
+	return f(msg)
+}
+
+// BackPressurePolicy is what a Router does when a sink's queue is full,
+// and what a DiskQueue does when it is at its maximum disk usage.
+type BackPressurePolicy int
+
+const (
+	// BackPressureBlock blocks Route until there is room in the queue.
+	// It is the default.
+	BackPressureBlock BackPressurePolicy = 0
+
+	// BackPressureDropOldest drops the oldest message in the queue to
+	// make room for the new one.
+	BackPressureDropOldest BackPressurePolicy = 1
+
+	// BackPressureDropNewest drops the new message.
+	BackPressureDropNewest BackPressurePolicy = 2
+)
+
+// Router sends messages to Sinks according to ordered routes. Each
+// route has a match function, such as Filter.Match, and the sinks that
+// matching messages are sent to. Every sink has its own bounded queue
+// and goroutine, so a slow sink only holds up its own messages, until
+// its queue is full and its BackPressurePolicy applies.
+type Router struct {
+	routes       []route
+	defaultSinks []Sink
+	fanOut       bool
+	queueSize    int
+	policy       BackPressurePolicy
+	overrides    []sinkQueueOverride
+	errorHandler func(sink Sink, msg SyslogMsg, err error)
+	queues       []*sinkQueue
+	dropped      uint64
+	wg           sync.WaitGroup
+}
+
+type route struct {
+	match  func(msg *SyslogMsg) bool
+	sinks  []Sink
+	queues []*sinkQueue
+}
+
+type sinkQueueOverride struct {
+	sink   Sink
+	size   int
+	policy BackPressurePolicy
+}
+
+// NewRouter returns a new Router and starts a goroutine for each of
+// its sinks. By default a message is sent to the sinks of the first
+// route it matches.
+func NewRouter(options ...func(*Router)) *Router {
+	r := Router{queueSize: routerQueueSize}
+	for _, option := range options {
+		option(&r)
+	}
+
+	for i := range r.routes {
+		for _, sink := range r.routes[i].sinks {
+			r.routes[i].queues = append(r.routes[i].queues, r.queue(sink))
+		}
+	}
+	def := route{match: func(*SyslogMsg) bool { return true }}
+	for _, sink := range r.defaultSinks {
+		def.queues = append(def.queues, r.queue(sink))
+	}
+	r.routes = append(r.routes, def)
+
+	for _, q := range r.queues {
+		r.wg.Add(1)
+		go r.run(q)
+	}
+	return &r
+}
+
+// RouterOptionRoute adds a route sending messages that match to sinks.
+// Routes are checked in the order they were added.
+func RouterOptionRoute(match func(msg *SyslogMsg) bool, sinks ...Sink) func(*Router) {
+	return func(r *Router) {
+		r.routes = append(r.routes, route{match: match, sinks: sinks})
+	}
+}
+
+// RouterOptionDefaultRoute sets the sinks that messages matching no
+// route are sent to. Without it such messages are discarded.
+func RouterOptionDefaultRoute(sinks ...Sink) func(*Router) {
+	return func(r *Router) {
+		r.defaultSinks = sinks
+	}
+}
+
+// RouterOptionFanOut sets the router to send a message to the sinks of
+// every route it matches, rather than only the first one. A sink in more
+// than one matching route gets the message once.
+func RouterOptionFanOut(r *Router) {
+	r.fanOut = true
+}
+
+// RouterOptionQueueSize sets the size of each sink's queue. The default is 1024.
+func RouterOptionQueueSize(size int) func(*Router) {
+	return func(r *Router) {
+		r.queueSize = size
+	}
+}
+
+// RouterOptionBackPressure sets what the router does when a sink's queue
+// is full. The default is BackPressureBlock.
+func RouterOptionBackPressure(policy BackPressurePolicy) func(*Router) {
+	return func(r *Router) {
+		r.policy = policy
+	}
+}
+
+// RouterOptionSinkQueue sets the queue size and back-pressure policy of
+// one sink, overriding RouterOptionQueueSize and RouterOptionBackPressure.
+// Sinks are matched with ==, so sinks that can't be compared, such as a
+// SinkFunc, never match; pass a pointer to them instead, such as &f for
+// a SinkFunc f, both here and in the routes.
+func RouterOptionSinkQueue(sink Sink, size int, policy BackPressurePolicy) func(*Router) {
+	return func(r *Router) {
+		r.overrides = append(r.overrides, sinkQueueOverride{sink: sink, size: size, policy: policy})
+	}
+}
+
+// RouterOptionErrorHandler sets a function that is called with every
+// error returned by a sink, from the sink's goroutine, and with
+// ErrQueueFull for every message dropped from its queue, from the
+// goroutine that called Route. It must be safe for concurrent use.
+func RouterOptionErrorHandler(handler func(sink Sink, msg SyslogMsg, err error)) func(*Router) {
+	return func(r *Router) {
+		r.errorHandler = handler
+	}
+}
+
+// sameSink reports whether two sinks are the same, without panicking
+// on sinks that can't be compared, such as SinkFuncs, which are never
+// the same.
+func sameSink(a, b Sink) bool {
+	if reflect.TypeOf(a) != reflect.TypeOf(b) || !reflect.TypeOf(a).Comparable() {
+		return false
+	}
+	return a == b
+}
+
+// queue returns the queue of a sink, creating it if needed.
+func (r *Router) queue(sink Sink) *sinkQueue {
+	for _, q := range r.queues {
+		if sameSink(q.sink, sink) {
+			return q
+		}
+	}
+
+	size, policy := r.queueSize, r.policy
+	for _, o := range r.overrides {
+		if sameSink(o.sink, sink) {
+			size, policy = o.size, o.policy
+		}
+	}
+	if size < 1 {
+		size = 1
+	}
+
+	q := &sinkQueue{sink: sink, policy: policy, buf: make([]SyslogMsg, size)}
+	q.notEmpty = sync.NewCond(&q.mutex)
+	q.notFull = sync.NewCond(&q.mutex)
+	r.queues = append(r.queues, q)
+	return q
+}
+
+// Route queues the message for the sinks of the routes it matches. It
+// returns the number of sinks it was queued for, which does not count
+// sinks that dropped it with BackPressureDropNewest. It returns
+// ErrRouterClosed if the router is closed. Route is safe for concurrent use.
+func (r *Router) Route(msg SyslogMsg) (int, error) {
+	var targets []*sinkQueue
+	for i, rt := range r.routes {
+		if r.fanOut && len(targets) > 0 && i == len(r.routes)-1 {
+			break
+		}
+		if !rt.match(&msg) {
+			continue
+		}
+		for _, q := range rt.queues {
+			if !containsQueue(targets, q) {
+				targets = append(targets, q)
+			}
+		}
+		if !r.fanOut {
+			break
+		}
+	}
+
+	queued := 0
+	for _, q := range targets {
+		ok, err := q.push(copyJSONValues(msg), r)
+		if err != nil {
+			return queued, err
+		}
+		if ok {
+			queued++
+		}
+	}
+	return queued, nil
+}
+
+// copyJSONValues returns the message with a copy of its JSONValues map.
+func copyJSONValues(msg SyslogMsg) SyslogMsg {
+	if msg.JSONValues == nil {
+		return msg
+	}
+	values := make(map[string]interface{}, len(msg.JSONValues))
+	for k, v := range msg.JSONValues {
+		values[k] = v
+	}
+	msg.JSONValues = values
+	return msg
+}
+
+func containsQueue(queues []*sinkQueue, q *sinkQueue) bool {
+	for _, e := range queues {
+		if e == q {
+			return true
+		}
+	}
+	return false
+}
+
+// Dropped returns the number of messages dropped from full queues.
+func (r *Router) Dropped() uint64 {
+	return atomic.LoadUint64(&r.dropped)
+}
+
+// Close stops accepting messages, waits until every queued message has
+// been sent to its sink, and stops the sinks' goroutines.
+func (r *Router) Close() error {
+	for _, q := range r.queues {
+		q.close()
+	}
+	r.wg.Wait()
+	return nil
+}
+
+func (r *Router) run(q *sinkQueue) {
+	defer r.wg.Done()
+	for {
+		msg, ok := q.pop()
+		if !ok {
+			return
+		}
+		if err := q.sink.Send(msg); err != nil {
+			r.handleError(q.sink, msg, err)
+		}
+	}
+}
+
+func (r *Router) handleError(sink Sink, msg SyslogMsg, err error) {
+	if r.errorHandler != nil {
+		r.errorHandler(sink, msg, err)
+	}
+}
+
+// sinkQueue is a bounded FIFO queue of messages for a sink, kept in a
+// ring buffer.
+type sinkQueue struct {
+	sink     Sink
+	policy   BackPressurePolicy
+	buf      []SyslogMsg
+	head     int
+	count    int
+	closed   bool
+	mutex    sync.Mutex
+	notEmpty *sync.Cond
+	notFull  *sync.Cond
+}
+
+// push adds a message to the queue, applying the back-pressure policy
+// if it is full. It returns false if the message was dropped.
+func (q *sinkQueue) push(msg SyslogMsg, r *Router) (bool, error) {
+	q.mutex.Lock()
+
+	for q.count == len(q.buf) && !q.closed && q.policy == BackPressureBlock {
+		q.notFull.Wait()
+	}
+	if q.closed {
+		q.mutex.Unlock()
+		return false, ErrRouterClosed
+	}
+
+	var dropped *SyslogMsg
+	if q.count == len(q.buf) {
+		if q.policy == BackPressureDropNewest {
+			q.mutex.Unlock()
+			atomic.AddUint64(&r.dropped, 1)
+			r.handleError(q.sink, msg, ErrQueueFull)
+			return false, nil
+		}
+		oldest := q.buf[q.head]
+		dropped = &oldest
+		q.head = (q.head + 1) % len(q.buf)
+		q.count--
+	}
+
+	q.buf[(q.head+q.count)%len(q.buf)] = msg
+	q.count++
+	q.notEmpty.Signal()
+	q.mutex.Unlock()
+
+	if dropped != nil {
+		atomic.AddUint64(&r.dropped, 1)
+		r.handleError(q.sink, *dropped, ErrQueueFull)
+	}
+	return true, nil
+}
+
+// pop removes the oldest message from the queue, waiting for one if it
+// is empty. It returns false once the queue is closed and empty.
+func (q *sinkQueue) pop() (SyslogMsg, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for q.count == 0 && !q.closed {
+		q.notEmpty.Wait()
+	}
+	if q.count == 0 {
+		return SyslogMsg{}, false
+	}
+
+	msg := q.buf[q.head]
+	q.buf[q.head] = SyslogMsg{}
+	q.head = (q.head + 1) % len(q.buf)
+	q.count--
+	q.notFull.Signal()
+	return msg, true
+}
+
+func (q *sinkQueue) close() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.closed = true
+	q.notEmpty.Broadcast()
+	q.notFull.Broadcast()
+}
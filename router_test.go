@@ -0,0 +1,329 @@
+package captainslog_test
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/digitalocean/captainslog"
+)
+
+// recordingSink records the programs of the messages it is sent.
+type recordingSink struct {
+	mutex    sync.Mutex
+	programs []string
+	block    chan struct{}
+	err      error
+}
+
+func (s *recordingSink) Send(msg captainslog.SyslogMsg) error {
+	if s.block != nil {
+		<-s.block
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.programs = append(s.programs, msg.Tag.Program)
+	return s.err
+}
+
+func (s *recordingSink) got() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]string(nil), s.programs...)
+}
+
+func routerMsg(program string, sv captainslog.Severity) captainslog.SyslogMsg {
+	msg := captainslog.NewSyslogMsg()
+	msg.SetFacility(captainslog.Local7)
+	msg.SetSeverity(sv)
+	msg.SetHost("host.example.org")
+	msg.SetProgram(program)
+	msg.SetContent("hello")
+	return msg
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestRouterRoutes(t *testing.T) {
+	errFilter := captainslog.MustCompileFilter(`severity <= err`)
+	nginxFilter := captainslog.MustCompileFilter(`program == "nginx"`)
+
+	testCases := []struct {
+		name        string
+		fanOut      bool
+		wantAlerts  []string
+		wantNginx   []string
+		wantDefault []string
+	}{
+		{
+			name:        "first match",
+			wantAlerts:  []string{"nginx", "app"},
+			wantNginx:   []string{"nginx"},
+			wantDefault: []string{"cron"},
+		},
+		{
+			name:        "fan out",
+			fanOut:      true,
+			wantAlerts:  []string{"nginx", "app"},
+			wantNginx:   []string{"nginx", "nginx"},
+			wantDefault: []string{"cron"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			alerts, nginx, def := &recordingSink{}, &recordingSink{}, &recordingSink{}
+			options := []func(*captainslog.Router){
+				captainslog.RouterOptionRoute(errFilter.Match, alerts),
+				captainslog.RouterOptionRoute(nginxFilter.Match, nginx),
+				captainslog.RouterOptionDefaultRoute(def),
+			}
+			if tc.fanOut {
+				options = append(options, captainslog.RouterOptionFanOut)
+			}
+			r := captainslog.NewRouter(options...)
+
+			for _, msg := range []captainslog.SyslogMsg{
+				routerMsg("nginx", captainslog.Err),
+				routerMsg("nginx", captainslog.Info),
+				routerMsg("app", captainslog.Crit),
+				routerMsg("cron", captainslog.Info),
+			} {
+				if _, err := r.Route(msg); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := r.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			if want, got := tc.wantAlerts, alerts.got(); !equalStrings(want, got) {
+				t.Errorf("alerts: want %v, got %v", want, got)
+			}
+			if want, got := tc.wantNginx, nginx.got(); !equalStrings(want, got) {
+				t.Errorf("nginx: want %v, got %v", want, got)
+			}
+			if want, got := tc.wantDefault, def.got(); !equalStrings(want, got) {
+				t.Errorf("default: want %v, got %v", want, got)
+			}
+		})
+	}
+}
+
+func TestRouterFanOutSharedSink(t *testing.T) {
+	sink := &recordingSink{}
+	all := func(*captainslog.SyslogMsg) bool { return true }
+	r := captainslog.NewRouter(
+		captainslog.RouterOptionRoute(all, sink),
+		captainslog.RouterOptionRoute(all, sink),
+		captainslog.RouterOptionFanOut,
+	)
+
+	n, err := r.Route(routerMsg("app", captainslog.Info))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+
+	if want, got := 1, n; want != got {
+		t.Errorf("want %d, got %d", want, got)
+	}
+	if want, got := []string{"app"}, sink.got(); !equalStrings(want, got) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+}
+
+func TestRouterNoRoute(t *testing.T) {
+	sink := &recordingSink{}
+	r := captainslog.NewRouter(
+		captainslog.RouterOptionRoute(captainslog.MustCompileFilter(`program == "nginx"`).Match, sink),
+	)
+
+	n, err := r.Route(routerMsg("app", captainslog.Info))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+
+	if want, got := 0, n; want != got {
+		t.Errorf("want %d, got %d", want, got)
+	}
+	if got := sink.got(); len(got) != 0 {
+		t.Errorf("want no messages, got %v", got)
+	}
+}
+
+func TestRouterBackPressure(t *testing.T) {
+	testCases := []struct {
+		name   string
+		policy captainslog.BackPressurePolicy
+		want   []string
+	}{
+		{name: "drop oldest", policy: captainslog.BackPressureDropOldest, want: []string{"a", "c", "d"}},
+		{name: "drop newest", policy: captainslog.BackPressureDropNewest, want: []string{"a", "b", "c"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// The sink takes "a" off the queue and blocks on it, so the
+			// queue holds two of the remaining three messages.
+			started := make(chan struct{})
+			sink := &recordingSink{block: make(chan struct{})}
+			first := captainslog.SinkFunc(func(msg captainslog.SyslogMsg) error {
+				if msg.Tag.Program == "a" {
+					close(started)
+				}
+				return sink.Send(msg)
+			})
+
+			var mutex sync.Mutex
+			var dropped []string
+			r := captainslog.NewRouter(
+				captainslog.RouterOptionDefaultRoute(first),
+				captainslog.RouterOptionQueueSize(2),
+				captainslog.RouterOptionBackPressure(tc.policy),
+				captainslog.RouterOptionErrorHandler(func(s captainslog.Sink, msg captainslog.SyslogMsg, err error) {
+					if !errors.Is(err, captainslog.ErrQueueFull) {
+						t.Errorf("want %v, got %v", captainslog.ErrQueueFull, err)
+					}
+					mutex.Lock()
+					dropped = append(dropped, msg.Tag.Program)
+					mutex.Unlock()
+				}),
+			)
+
+			if _, err := r.Route(routerMsg("a", captainslog.Info)); err != nil {
+				t.Fatal(err)
+			}
+			<-started
+			for _, program := range []string{"b", "c", "d"} {
+				if _, err := r.Route(routerMsg(program, captainslog.Info)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			close(sink.block)
+			r.Close()
+
+			if want, got := tc.want, sink.got(); !equalStrings(want, got) {
+				t.Errorf("want %v, got %v", want, got)
+			}
+			if want, got := uint64(1), r.Dropped(); want != got {
+				t.Errorf("want %d dropped, got %d", want, got)
+			}
+			if want, got := 1, len(dropped); want != got {
+				t.Errorf("want %d dropped messages handled, got %d", want, got)
+			}
+		})
+	}
+}
+
+func TestRouterBlock(t *testing.T) {
+	sink := &recordingSink{block: make(chan struct{})}
+	r := captainslog.NewRouter(
+		captainslog.RouterOptionDefaultRoute(sink),
+		captainslog.RouterOptionSinkQueue(sink, 1, captainslog.BackPressureBlock),
+	)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, program := range []string{"a", "b", "c", "d"} {
+			if _, err := r.Route(routerMsg(program, captainslog.Info)); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+
+	for i := 0; i < 4; i++ {
+		sink.block <- struct{}{}
+	}
+	<-done
+	r.Close()
+
+	if want, got := []string{"a", "b", "c", "d"}, sink.got(); !equalStrings(want, got) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+	if want, got := uint64(0), r.Dropped(); want != got {
+		t.Errorf("want %d dropped, got %d", want, got)
+	}
+}
+
+func TestRouterSinkErrors(t *testing.T) {
+	errSink := errors.New("sink failed")
+	sink := &recordingSink{err: errSink}
+
+	var mutex sync.Mutex
+	var handled []string
+	r := captainslog.NewRouter(
+		captainslog.RouterOptionDefaultRoute(sink),
+		captainslog.RouterOptionErrorHandler(func(s captainslog.Sink, msg captainslog.SyslogMsg, err error) {
+			if err != errSink {
+				t.Errorf("want %v, got %v", errSink, err)
+			}
+			mutex.Lock()
+			handled = append(handled, msg.Tag.Program)
+			mutex.Unlock()
+		}),
+	)
+
+	for _, program := range []string{"b", "a"} {
+		if _, err := r.Route(routerMsg(program, captainslog.Info)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r.Close()
+
+	sort.Strings(handled)
+	if want, got := []string{"a", "b"}, handled; !equalStrings(want, got) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+}
+
+func TestRouterClosed(t *testing.T) {
+	r := captainslog.NewRouter(captainslog.RouterOptionDefaultRoute(&recordingSink{}))
+	r.Close()
+
+	if _, err := r.Route(routerMsg("app", captainslog.Info)); err != captainslog.ErrRouterClosed {
+		t.Errorf("want %v, got %v", captainslog.ErrRouterClosed, err)
+	}
+}
+
+func TestRouterSinkQueueFunc(t *testing.T) {
+	started := make(chan struct{}, 1)
+	block := make(chan struct{})
+	f := captainslog.SinkFunc(func(msg captainslog.SyslogMsg) error {
+		started <- struct{}{}
+		<-block
+		return nil
+	})
+	r := captainslog.NewRouter(
+		captainslog.RouterOptionDefaultRoute(&f),
+		captainslog.RouterOptionSinkQueue(&f, 1, captainslog.BackPressureDropNewest),
+	)
+
+	for _, program := range []string{"a", "b", "c"} {
+		if _, err := r.Route(routerMsg(program, captainslog.Info)); err != nil {
+			t.Fatal(err)
+		}
+		if program == "a" {
+			<-started
+		}
+	}
+	close(block)
+	r.Close()
+
+	if want, got := uint64(1), r.Dropped(); want != got {
+		t.Errorf("want %d dropped, got %d", want, got)
+	}
+}
@@ -4,9 +4,12 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"reflect"
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/tidwall/gjson"
 )
 
 // SyslogMsg holds an Unmarshaled rfc3164 message.
@@ -18,8 +21,13 @@ type SyslogMsg struct {
 	Cee                  string
 	IsJSON               bool
 	IsCee                bool
+	IsLogfmt             bool
 	optionDontParseJSON  bool
 	optionUseLocalFormat bool
+	optionLogfmtContent  bool
+	optionUseGJSON       bool
+	pendingJSON          bool
+	decoder              ContentDecoder
 	Content              string
 	timeFormat           string
 	JSONValues           map[string]interface{}
@@ -28,10 +36,17 @@ type SyslogMsg struct {
 
 // Content holds the Content of a syslog message,
 // including the Content as a string, and a struct of
-// the JSONValues of appropriate.
+// the JSONValues of appropriate. ProbablyJSON records
+// whether the content looked like JSON, whether or not
+// it was decoded, Decoder the ContentDecoder that
+// decoded the JSONValues, and IsLogfmt whether they
+// were decoded from logfmt.
 type Content struct {
-	Content    string
-	JSONValues map[string]interface{}
+	Content      string
+	JSONValues   map[string]interface{}
+	ProbablyJSON bool
+	IsLogfmt     bool
+	Decoder      ContentDecoder
 }
 
 // Time holds both the time derviced from a
@@ -145,16 +160,93 @@ func (s *SyslogMsg) SetContent(c string) error {
 	_, content, err := ParseContent([]byte(c), ContentOptionParseJSON)
 	s.Content = content.Content
 	s.JSONValues = content.JSONValues
+	s.pendingJSON = false
+	s.IsLogfmt = false
+	s.decoder = nil
 	if len(s.JSONValues) > 0 {
 		s.IsJSON = true
 	}
 	return err
 }
 
+// DecodeJSON decodes content that was left undecoded by
+// OptionLazyParseJSON into JSONValues. Keys that were added to
+// JSONValues since the message was parsed take precedence over
+// the decoded ones. If the content turns out not to be JSON, the
+// message is treated as plain text and the error is returned, which
+// is ErrBadCEE for CEE messages. It is a no-op if there is no JSON waiting to be decoded.
+func (s *SyslogMsg) DecodeJSON() error {
+	if !s.pendingJSON {
+		return nil
+	}
+	s.pendingJSON = false
+
+	m, err := jsonDecoder{useGJSON: s.optionUseGJSON}.DecodeContent([]byte(s.Content))
+	if err != nil {
+		s.IsJSON = false
+		if s.IsCee {
+			return ErrBadCEE
+		}
+		return err
+	}
+
+	for key, value := range s.JSONValues {
+		m[key] = value
+	}
+	s.JSONValues = m
+	return nil
+}
+
+// JSONValue returns the value at the given path in the message's JSON
+// content, and whether it was found. Paths use gjson syntax, such as
+// "user.name". If the JSON has not been decoded yet, only the requested
+// field is extracted from the content and the rest is left undecoded.
+// Values have the same types either way, so numbers are json.Number
+// unless the message was parsed with OptionUseGJSONParser.
+func (s *SyslogMsg) JSONValue(path string) (interface{}, bool) {
+	if v, ok := s.JSONValues[path]; ok {
+		return v, true
+	}
+
+	if s.pendingJSON {
+		r := gjson.Get(s.Content, path)
+		if !r.Exists() {
+			return nil, false
+		}
+		if s.optionUseGJSON || r.Raw == "" {
+			return r.Value(), true
+		}
+
+		var v interface{}
+		decoder := json.NewDecoder(strings.NewReader(r.Raw))
+		decoder.UseNumber()
+		if err := decoder.Decode(&v); err != nil {
+			return r.Value(), true
+		}
+		return v, true
+	}
+
+	var cur interface{} = s.JSONValues
+	for _, key := range strings.Split(path, ".") {
+		m, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if cur, ok = m[key]; !ok {
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
 // AddTagArray adds a tag to an array of tags at the key. If the key
 // does not already exist, it will create the key and initially it
-// to a []interface{}.
+// to a []interface{}. A message that is not yet CEE is turned into
+// one, with any plain text content moved to the "msg" key.
 func (s *SyslogMsg) AddTagArray(key string, value interface{}) error {
+	// content that fails to decode is tagged as plain text
+	_ = s.DecodeJSON()
+
 	if _, ok := s.JSONValues[key]; !ok {
 		s.JSONValues[key] = make([]interface{}, 0)
 	}
@@ -162,10 +254,12 @@ func (s *SyslogMsg) AddTagArray(key string, value interface{}) error {
 	switch val := s.JSONValues[key].(type) {
 	case []interface{}:
 		s.JSONValues[key] = append(val, value)
-		if !s.IsCee {
+		if _, ok := s.decoder.(ContentEncoder); !ok && !s.IsCee && !s.IsLogfmt {
 			s.IsCee = true
-			s.Cee = " @cee:"
-			s.JSONValues["msg"] = s.Content[1:]
+			s.Cee = " " + ceeCookie
+			if !s.IsJSON {
+				s.JSONValues["msg"] = trimCEESpace(s.Content)
+			}
 		}
 		return nil
 	default:
@@ -176,6 +270,7 @@ func (s *SyslogMsg) AddTagArray(key string, value interface{}) error {
 // AddTag adds a tag to the value at key. If the key exists,
 // the value currently at the key will be overwritten.
 func (s *SyslogMsg) AddTag(key string, value interface{}) {
+	_ = s.DecodeJSON()
 	s.JSONValues[key] = value
 }
 
@@ -195,14 +290,62 @@ func OptionUseRemoteFormat(s *SyslogMsg) {
 	s.optionUseLocalFormat = false
 }
 
+// OptionUseLogfmtContent tells SyslogMsg.String() and SyslogMsg.Byte() to encode
+// the content as logfmt key=value pairs, with the text of messages that are not
+// JSON in the "msg" key. Without it, messages decoded from logfmt keep their
+// content as it was received, and are only encoded as logfmt again once their
+// JSONValues are changed.
+func OptionUseLogfmtContent(s *SyslogMsg) {
+	s.optionLogfmtContent = true
+}
+
+// OptionUseDefaultContent tells SyslogMsg.String() and SyslogMsg.Byte() to
+// encode the content as text or JSON instead of logfmt
+func OptionUseDefaultContent(s *SyslogMsg) {
+	s.optionLogfmtContent = false
+}
+
 // String returns the SyslogMsg as an RFC3164 string.
 func (s *SyslogMsg) String(options ...SyslogMsgOption) string {
 	for _, option := range options {
 		option(s)
 	}
 
+	cee, content := s.formatContent()
+
+	if s.optionUseLocalFormat {
+		return fmt.Sprintf("<%s>%s %s%s%s\n", s.Pri.String(), s.Time.Format(time.Stamp), s.Tag.String(), cee, content)
+	}
+	if s.timeFormat == "" {
+		s.timeFormat = rsyslogTimeFormat
+	}
+	return fmt.Sprintf("<%s>%s %s %s%s%s\n", s.Pri.String(), s.Time.Format(s.timeFormat), s.Host, s.Tag.String(), cee, content)
+}
+
+// formatContent returns the CEE cookie, if any, and the content of the
+// message as they are serialized, along with their leading whitespace.
+func (s *SyslogMsg) formatContent() (string, string) {
+	// leave lazily parsed JSON untouched unless keys were added to it
+	if len(s.JSONValues) > 0 {
+		_ = s.DecodeJSON()
+	}
+
+	encoder, encoded := s.decoder.(ContentEncoder)
+	if !s.optionLogfmtContent && (s.IsLogfmt || encoded) && s.unmodified() {
+		return s.Cee, s.Content
+	}
+
+	logfmt := s.optionLogfmtContent || s.IsLogfmt
+	encoded = encoded && !logfmt
+
 	var content string
-	if s.IsJSON && !s.optionDontParseJSON {
+	if logfmt {
+		content = s.logfmtContent()
+	} else if encoded {
+		content = s.encodedContent(encoder)
+	} else if s.pendingJSON {
+		content = s.Content
+	} else if s.IsJSON && !s.optionDontParseJSON {
 		b, err := json.Marshal(s.JSONValues)
 		if err != nil {
 			panic(err)
@@ -212,9 +355,9 @@ func (s *SyslogMsg) String(options ...SyslogMsgOption) string {
 		content = string(b)
 	} else {
 		if len(s.JSONValues) > 0 {
-			s.JSONValues["msg"] = strings.TrimLeft(s.Content, " ")
+			s.JSONValues["msg"] = trimCEESpace(s.Content)
 			s.IsCee = true
-			s.Cee = " @cee:"
+			s.Cee = " " + ceeCookie
 			b, err := json.Marshal(s.JSONValues)
 			if err != nil {
 				panic(err)
@@ -225,13 +368,11 @@ func (s *SyslogMsg) String(options ...SyslogMsgOption) string {
 		}
 	}
 
-	if s.optionUseLocalFormat {
-		return fmt.Sprintf("<%s>%s %s%s%s\n", s.Pri.String(), s.Time.Format(time.Stamp), s.Tag.String(), s.Cee, content)
+	cee := s.Cee
+	if logfmt || encoded {
+		cee = ""
 	}
-	if s.timeFormat == "" {
-		s.timeFormat = rsyslogTimeFormat
-	}
-	return fmt.Sprintf("<%s>%s %s %s%s%s\n", s.Pri.String(), s.Time.Format(s.timeFormat), s.Host, s.Tag.String(), s.Cee, content)
+	return cee, content
 }
 
 // Bytes returns the SyslogMsg as RFC3164 []byte.
@@ -242,32 +383,99 @@ func (s *SyslogMsg) Bytes(options ...SyslogMsgOption) []byte {
 // JSON returns a JSON representation of the message encoded in a []byte. Syslog fields are named with
 // a "syslog_" prefix to avoid potential collision with fields from the message body.
 func (s *SyslogMsg) JSON() ([]byte, error) {
+	return s.JSONWithSchema(JSONSchemaLegacy)
+}
+
+// unmodified reports whether the JSONValues of the message are still
+// the ones its decoder decodes from its content, so the content can be
+// serialized as it was received.
+func (s *SyslogMsg) unmodified() bool {
+	if s.decoder == nil {
+		return false
+	}
+	m, err := s.decoder.DecodeContent([]byte(trimCEESpace(s.Content)))
+	return err == nil && reflect.DeepEqual(m, s.JSONValues)
+}
+
+// logfmtContent returns the content of the message encoded as logfmt,
+// falling back to the content as is if its JSON can't be decoded.
+func (s *SyslogMsg) logfmtContent() string {
+	values, err := s.values()
+	if err != nil {
+		return s.Content
+	}
+	if !s.IsJSON && !s.IsCee && !s.IsLogfmt {
+		if text := trimCEESpace(s.Content); text != "" {
+			values["msg"] = text
+		}
+	}
+	if len(values) == 0 {
+		return s.Content
+	}
+	return " " + string(EncodeLogfmt(values))
+}
+
+// encodedContent returns the content of the message encoded by the
+// ContentEncoder that decoded it, keeping its leading whitespace, or
+// the content as is if it can't be encoded.
+func (s *SyslogMsg) encodedContent(encoder ContentEncoder) string {
+	values, err := s.values()
+	if err != nil {
+		return s.Content
+	}
+
+	b, err := encoder.EncodeContent(values)
+	if err != nil {
+		return s.Content
+	}
+
+	text := trimCEESpace(s.Content)
+	return s.Content[:len(s.Content)-len(text)] + string(b)
+}
+
+// text returns the text of the message: the "msg" key of JSON
+// messages, or the content without its leading whitespace.
+func (s *SyslogMsg) text() string {
+	if s.IsJSON || s.IsCee {
+		if m, ok := s.JSONValue("msg"); ok {
+			if text, ok := m.(string); ok {
+				return text
+			}
+		}
+	}
+	return trimCEESpace(s.Content)
+}
+
+// values returns the JSON values of the message, decoding the content
+// first if the message was parsed without decoding its JSON.
+func (s *SyslogMsg) values() (map[string]interface{}, error) {
+	if err := s.DecodeJSON(); err != nil {
+		return nil, err
+	}
+
 	content := make(map[string]interface{})
 	if s.optionDontParseJSON && s.IsCee {
 		decoder := json.NewDecoder(bytes.NewBuffer([]byte(s.Content)))
 		decoder.UseNumber()
 		err := decoder.Decode(&content)
 		if err != nil {
-			return []byte(""), err
+			return nil, err
 		}
 
 	}
 	for key, value := range s.JSONValues {
 		content[key] = value
 	}
+	return content, nil
+}
 
-	content["syslog_time"] = s.Time
-	content["syslog_host"] = s.Host
-	content["syslog_tag"] = s.Tag.String()
-	content["syslog_programname"] = s.Tag.Program
-	content["syslog_pid"] = s.Tag.Pid
-	content["syslog_facilitytext"] = s.Pri.Facility.String()
-	content["syslog_severitytext"] = s.Pri.Severity.String()
-
-	if !s.IsCee {
-		content["syslog_content"] = s.Content
+// message returns the text of the message: the "msg" key of JSON
+// messages, which is removed from values, or the content without
+// its leading whitespace.
+func (s *SyslogMsg) message(values map[string]interface{}) string {
+	if m, ok := values["msg"].(string); ok && (s.IsJSON || s.IsCee) {
+		delete(values, "msg")
+		return m
 	}
-
-	b, err := json.Marshal(content)
-	return b, err
+	return trimCEESpace(s.Content)
 }
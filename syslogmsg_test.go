@@ -1,6 +1,7 @@
 package captainslog_test
 
 import (
+	"encoding/json"
 	"strings"
 	"testing"
 	"time"
@@ -95,6 +96,145 @@ func TestSyslogMsgJSONFromCEEWithDontParseJSON(t *testing.T) {
 	}
 }
 
+func TestSyslogMsgLazyParseJSON(t *testing.T) {
+	input := []byte("<4>2016-03-08T14:59:36.293816+00:00 host.example.com test[12]: @cee:{\"a\":1, \"b\":{\"c\":\"d\"}}\n")
+	msg, err := captainslog.NewSyslogMsgFromBytes(input, captainslog.OptionLazyParseJSON)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if want, got := 0, len(msg.JSONValues); want != got {
+		t.Errorf("want %d, got %d", want, got)
+	}
+
+	v, ok := msg.JSONValue("b.c")
+	if want, got := true, ok; want != got {
+		t.Errorf("want '%v', got '%v'", want, got)
+	}
+	if want, got := "d", v; want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+
+	if _, ok := msg.JSONValue("z"); ok {
+		t.Errorf("found unexpected key %q", "z")
+	}
+
+	lazy, _ := msg.JSONValue("a")
+	if want, got := json.Number("1"), lazy; want != got {
+		t.Errorf("want %#v, got %#v", want, got)
+	}
+
+	if want, got := string(input), msg.String(); want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+
+	if err := msg.DecodeJSON(); err != nil {
+		t.Error(err)
+	}
+
+	if want, got := 2, len(msg.JSONValues); want != got {
+		t.Errorf("want %d, got %d", want, got)
+	}
+
+	v, ok = msg.JSONValue("b.c")
+	if want, got := true, ok; want != got {
+		t.Errorf("want '%v', got '%v'", want, got)
+	}
+	if want, got := "d", v; want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+
+	// the decoded number has the same type as the lazily read one
+	if decoded, _ := msg.JSONValue("a"); lazy != decoded {
+		t.Errorf("want %#v, got %#v", lazy, decoded)
+	}
+}
+
+func TestSyslogMsgLazyParseJSONWithAddedKeys(t *testing.T) {
+	input := []byte("<4>2016-03-08T14:59:36.293816+00:00 host.example.com test[12]: @cee:{\"a\":1}\n")
+	msg, err := captainslog.NewSyslogMsgFromBytes(input, captainslog.OptionLazyParseJSON)
+	if err != nil {
+		t.Error(err)
+	}
+
+	msg.JSONValues["b"] = 2
+
+	wanted := "<4>2016-03-08T14:59:36.293816+00:00 host.example.com test[12]: @cee: {\"a\":1,\"b\":2}\n"
+	if want, got := wanted, msg.String(); want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func TestSyslogMsgLazyParseJSONInvalid(t *testing.T) {
+	input := []byte("<4>2016-03-08T14:59:36.293816+00:00 host.example.com test[12]: {not json\n")
+	msg, err := captainslog.NewSyslogMsgFromBytes(input, captainslog.OptionLazyParseJSON)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if want, got := true, msg.IsJSON; want != got {
+		t.Errorf("want '%v', got '%v'", want, got)
+	}
+
+	if err := msg.DecodeJSON(); err == nil {
+		t.Errorf("Did not get error when decoding invalid JSON")
+	}
+
+	if want, got := false, msg.IsJSON; want != got {
+		t.Errorf("want '%v', got '%v'", want, got)
+	}
+
+	if want, got := string(input), msg.String(); want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func TestSyslogMsgAddTagArray(t *testing.T) {
+	testCases := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name:  "plain text",
+			input: "<4>2016-03-08T14:59:36.293816+00:00 host.example.com test[12]: hello world\n",
+			want:  "<4>2016-03-08T14:59:36.293816+00:00 host.example.com test[12]: @cee:{\"msg\":\"hello world\",\"tags\":[\"trace\"]}\n",
+		},
+		{
+			name:  "plain text with no space after tag",
+			input: "<4>2016-03-08T14:59:36.293816+00:00 host.example.com test[12]:hello world\n",
+			want:  "<4>2016-03-08T14:59:36.293816+00:00 host.example.com test[12]: @cee:{\"msg\":\"hello world\",\"tags\":[\"trace\"]}\n",
+		},
+		{
+			name:  "json without cee",
+			input: "<4>2016-03-08T14:59:36.293816+00:00 host.example.com test[12]: {\"a\":1}\n",
+			want:  "<4>2016-03-08T14:59:36.293816+00:00 host.example.com test[12]: @cee: {\"a\":1,\"tags\":[\"trace\"]}\n",
+		},
+		{
+			name:  "cee",
+			input: "<4>2016-03-08T14:59:36.293816+00:00 host.example.com test[12]: @cee:{\"a\":1,\"tags\":[\"web\"]}\n",
+			want:  "<4>2016-03-08T14:59:36.293816+00:00 host.example.com test[12]: @cee: {\"a\":1,\"tags\":[\"web\",\"trace\"]}\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			msg, err := captainslog.NewSyslogMsgFromBytes([]byte(tc.input))
+			if err != nil {
+				t.Error(err)
+			}
+
+			if err := msg.AddTagArray("tags", "trace"); err != nil {
+				t.Error(err)
+			}
+
+			if want, got := tc.want, msg.String(); want != got {
+				t.Errorf("want %q, got %q", want, got)
+			}
+		})
+	}
+}
+
 func TestNginxToSyslogMsgBackToString(t *testing.T) {
 	input := []byte("<174>2017-04-12T13:31:11.918068+00:00 www.example.com nginx 192.168.1.1 - - [12/Apr/2017:13:31:11 +0000] \"GET /hello?from=world HTTP/1.1\" 200 18 \"https://something.example.com\" \"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/57.0.2987.133 Safari/537.36\"")
 	msg, err := captainslog.NewSyslogMsgFromBytes(input)
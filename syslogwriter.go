@@ -0,0 +1,139 @@
+package captainslog
+
+import (
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// syslogWriterDialTimeout is how long a SyslogWriter waits to connect.
+	syslogWriterDialTimeout = 10 * time.Second
+)
+
+// SyslogWriterFormat is the format a SyslogWriter sends messages in.
+type SyslogWriterFormat int
+
+const (
+	// SyslogWriterFormatRFC3164 sends messages as SyslogMsg.Bytes()
+	// in wire format. It is the default for network sockets.
+	SyslogWriterFormatRFC3164 SyslogWriterFormat = 0
+
+	// SyslogWriterFormatLocal sends messages as SyslogMsg.Bytes() in
+	// the local format for /dev/log. It is the default for unix sockets.
+	SyslogWriterFormatLocal SyslogWriterFormat = 1
+
+	// SyslogWriterFormatRFC5424 sends messages as SyslogMsg.RFC5424().
+	SyslogWriterFormatRFC5424 SyslogWriterFormat = 2
+)
+
+// SyslogWriter sends SyslogMsgs to a syslog server over UDP, TCP or a
+// unix socket. It is a Sink. If a write on a stream socket fails, the
+// SyslogWriter reconnects and tries once more.
+type SyslogWriter struct {
+	network       string
+	addr          string
+	format        SyslogWriterFormat
+	formatSet     bool
+	octetCounting bool
+	conn          net.Conn
+	mutex         sync.Mutex
+}
+
+// NewSyslogWriter returns a new SyslogWriter connected to addr. The
+// network is "udp", "tcp", "unixgram" or "unix", or their IPv4 and
+// IPv6 variants.
+func NewSyslogWriter(network, addr string, options ...func(*SyslogWriter)) (*SyslogWriter, error) {
+	w := SyslogWriter{network: network, addr: addr}
+	for _, option := range options {
+		option(&w)
+	}
+	if !w.formatSet && (network == "unix" || network == "unixgram") {
+		w.format = SyslogWriterFormatLocal
+	}
+
+	if err := w.connect(); err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
+
+// SyslogWriterOptionFormat sets the format the SyslogWriter sends
+// messages in.
+func SyslogWriterOptionFormat(format SyslogWriterFormat) func(*SyslogWriter) {
+	return func(w *SyslogWriter) {
+		w.format = format
+		w.formatSet = true
+	}
+}
+
+// SyslogWriterOptionOctetCounting sets the SyslogWriter to frame
+// messages on stream sockets with their length, as described in
+// RFC6587, instead of ending them with a newline.
+func SyslogWriterOptionOctetCounting(w *SyslogWriter) {
+	w.octetCounting = true
+}
+
+// Send sends the message. It is safe for concurrent use.
+func (w *SyslogWriter) Send(msg SyslogMsg) error {
+	var b []byte
+	switch w.format {
+	case SyslogWriterFormatLocal:
+		b = msg.Bytes(OptionUseLocalFormat)
+	case SyslogWriterFormatRFC5424:
+		b = msg.RFC5424()
+	default:
+		b = msg.Bytes(OptionUseRemoteFormat)
+	}
+	if w.octetCounting && w.isStream() {
+		b = append([]byte(strconv.Itoa(len(b)-1)+" "), b[:len(b)-1]...)
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.conn != nil {
+		_, err := w.conn.Write(b)
+		if err == nil || !w.isStream() {
+			return err
+		}
+		w.conn.Close()
+		w.conn = nil
+	}
+
+	if err := w.connect(); err != nil {
+		return err
+	}
+	_, err := w.conn.Write(b)
+	return err
+}
+
+// Close closes the SyslogWriter's connection.
+func (w *SyslogWriter) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.conn == nil {
+		return nil
+	}
+	err := w.conn.Close()
+	w.conn = nil
+	return err
+}
+
+func (w *SyslogWriter) connect() error {
+	conn, err := net.DialTimeout(w.network, w.addr, syslogWriterDialTimeout)
+	if err != nil {
+		return err
+	}
+	w.conn = conn
+	return nil
+}
+
+func (w *SyslogWriter) isStream() bool {
+	switch w.network {
+	case "tcp", "tcp4", "tcp6", "unix":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,102 @@
+package captainslog_test
+
+import (
+	"testing"
+
+	"github.com/digitalocean/captainslog"
+)
+
+func TestSyslogWriter(t *testing.T) {
+	input := "<191>2006-01-02T15:04:05.999999-07:00 host.example.org test[12]: engage\n"
+
+	testCases := []struct {
+		name    string
+		network string
+		options []func(*captainslog.SyslogWriter)
+	}{
+		{name: "udp", network: "udp"},
+		{name: "tcp", network: "tcp"},
+		{name: "tcp octet counting", network: "tcp", options: []func(*captainslog.SyslogWriter){captainslog.SyslogWriterOptionOctetCounting}},
+		{name: "tcp rfc5424", network: "tcp", options: []func(*captainslog.SyslogWriter){captainslog.SyslogWriterOptionFormat(captainslog.SyslogWriterFormatRFC5424)}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			l, err := captainslog.NewSyslogListener(tc.network, "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l.Close()
+
+			w, err := captainslog.NewSyslogWriter(tc.network, l.Addr().String(), tc.options...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer w.Close()
+
+			msg, err := captainslog.NewSyslogMsgFromBytes([]byte(input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Send(msg); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := receive(t, l)
+			if tc.name == "tcp rfc5424" {
+				// RFC5424 is not parsed by the listener
+				if err == nil {
+					t.Errorf("want parse error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want, got := input, got.String(); want != got {
+				t.Errorf("want %q, got %q", want, got)
+			}
+		})
+	}
+}
+
+func TestSyslogWriterReconnect(t *testing.T) {
+	l, err := captainslog.NewSyslogListener("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+
+	w, err := captainslog.NewSyslogWriter("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	msg, err := captainslog.NewSyslogMsgFromBytes([]byte("<191>2006-01-02T15:04:05.999999-07:00 host.example.org test: one\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Send(msg); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := receive(t, l); err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+
+	l, err = captainslog.NewSyslogListener("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// the first write after the server went away may succeed, so send
+	// until the message arrives over a new connection
+	for i := 0; i < 3; i++ {
+		w.Send(msg)
+	}
+	if _, err := receive(t, l); err != nil {
+		t.Fatal(err)
+	}
+}
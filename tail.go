@@ -0,0 +1,493 @@
+package captainslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// tailPollInterval is the default interval at which a Tail looks
+	// for new files and new lines.
+	tailPollInterval = time.Second
+
+	// tailMaxLineSize is the default size of the longest line a Tail
+	// returns as one message.
+	tailMaxLineSize = 64 * 1024
+
+	// tailReadSize is the size of a Tail's reads.
+	tailReadSize = 32 * 1024
+
+	// tailQueueSize is the number of lines read ahead of Receive.
+	tailQueueSize = 1024
+)
+
+// Tail follows files matching glob patterns, such as
+// /var/log/messages*, and parses each line added to them into a
+// SyslogMsg. It is a Listener.
+//
+// Files are followed across rotation: a file that is renamed or
+// removed is read to its end before it is closed, a new file created
+// in its place is read from its start, and a file that is truncated
+// in place is read again from its start. Files are polled, so a file
+// that is truncated and then grows past its old size within one poll
+// interval is not detected as truncated.
+//
+// With a checkpoint file, a Tail persists the offset of each line
+// before Receive returns it, and resumes after the last line returned
+// when it is restarted, even after a crash, so no line is returned
+// twice or skipped. The offsets of files that are missing when the
+// Tail starts are kept until the files are found again. Files are
+// identified by device
+// and inode where supported, so a file that was rotated while the Tail
+// was not running is resumed under its new name if it matches a
+// pattern.
+type Tail struct {
+	patterns       []string
+	checkpointPath string
+	pollInterval   time.Duration
+	maxLineSize    int
+	startAtEnd     bool
+	parserOptions  []func(*Parser)
+
+	// files and saved are only used by the polling goroutine
+	files []*tailFile
+	saved map[tailFileID]tailCheckpoint
+
+	lines chan tailLine
+	done  chan struct{}
+	wg    sync.WaitGroup
+
+	mutex   sync.Mutex
+	offsets map[tailFileID]tailCheckpoint
+	closed  bool
+}
+
+// tailFileID identifies a file across renames. The path is only set
+// where the device and inode are not available.
+type tailFileID struct {
+	device uint64
+	inode  uint64
+	path   string
+}
+
+// tailCheckpoint is an entry of a Tail's checkpoint file.
+type tailCheckpoint struct {
+	Path   string `json:"path"`
+	Device uint64 `json:"device,omitempty"`
+	Inode  uint64 `json:"inode,omitempty"`
+	Offset int64  `json:"offset"`
+}
+
+// tailFile is a file followed by a Tail.
+type tailFile struct {
+	id      tailFileID
+	path    string
+	file    *os.File
+	offset  int64
+	pending []byte
+}
+
+// tailLine is a line read by a Tail, with the offset of its end. A
+// tailLine with no line marks the end of a file that was closed.
+type tailLine struct {
+	id     tailFileID
+	path   string
+	line   []byte
+	offset int64
+}
+
+// NewTail returns a new Tail following the files matching the glob
+// patterns, which use the syntax of filepath.Match. Files are read
+// from their start unless the checkpoint file has an offset for them.
+func NewTail(patterns []string, options ...func(*Tail)) (*Tail, error) {
+	t := Tail{
+		patterns:     patterns,
+		pollInterval: tailPollInterval,
+		maxLineSize:  tailMaxLineSize,
+		saved:        make(map[tailFileID]tailCheckpoint),
+		lines:        make(chan tailLine, tailQueueSize),
+		done:         make(chan struct{}),
+		offsets:      make(map[tailFileID]tailCheckpoint),
+	}
+	for _, option := range options {
+		option(&t)
+	}
+
+	for _, pattern := range patterns {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, err
+		}
+	}
+	if err := t.loadCheckpoint(); err != nil {
+		return nil, err
+	}
+
+	t.wg.Add(1)
+	go t.run()
+	return &t, nil
+}
+
+// TailOptionCheckpoint sets the file the Tail persists its offsets in.
+func TailOptionCheckpoint(path string) func(*Tail) {
+	return func(t *Tail) {
+		t.checkpointPath = path
+	}
+}
+
+// TailOptionPollInterval sets the interval at which the Tail looks for
+// new files and new lines. The default is 1 second.
+func TailOptionPollInterval(interval time.Duration) func(*Tail) {
+	return func(t *Tail) {
+		t.pollInterval = interval
+	}
+}
+
+// TailOptionMaxLineSize sets the size of the longest line the Tail
+// returns as one message. Longer lines are split. The default is 64KiB.
+func TailOptionMaxLineSize(size int) func(*Tail) {
+	return func(t *Tail) {
+		t.maxLineSize = size
+	}
+}
+
+// TailOptionStartAtEnd sets the Tail to skip the lines that are
+// already in files when it starts, unless the checkpoint file has an
+// offset for them. Files created later are always read from their
+// start.
+func TailOptionStartAtEnd(t *Tail) {
+	t.startAtEnd = true
+}
+
+// TailOptionParser sets the options of the Parser used to parse lines.
+func TailOptionParser(options ...func(*Parser)) func(*Tail) {
+	return func(t *Tail) {
+		t.parserOptions = options
+	}
+}
+
+// Receive blocks until a line is read and returns it parsed. The
+// offset of the line is written to the checkpoint file before it is
+// returned. Lines that fail to parse, or whose offset could not be
+// written, are returned along with the error. Once the Tail is closed,
+// Receive returns net.ErrClosed.
+func (t *Tail) Receive() (SyslogMsg, error) {
+	for {
+		var line tailLine
+		select {
+		case line = <-t.lines:
+		case <-t.done:
+			return SyslogMsg{}, net.ErrClosed
+		}
+
+		t.mutex.Lock()
+		if t.closed {
+			t.mutex.Unlock()
+			return SyslogMsg{}, net.ErrClosed
+		}
+		if line.line == nil {
+			delete(t.offsets, line.id)
+			t.mutex.Unlock()
+			continue
+		}
+		t.offsets[line.id] = newTailCheckpoint(line.id, line.path, line.offset)
+		checkpointErr := t.writeCheckpoint()
+		t.mutex.Unlock()
+
+		b := line.line
+		if b[len(b)-1] != '\n' {
+			b = append(b, '\n')
+		}
+		msg, err := NewParser(t.parserOptions...).ParseBytes(b)
+		if err == nil {
+			err = checkpointErr
+		}
+		return msg, err
+	}
+}
+
+// Close stops following the files and writes the checkpoint file.
+// Lines that were read but not returned by Receive are read again by
+// the next Tail using the checkpoint file.
+func (t *Tail) Close() error {
+	t.mutex.Lock()
+	if t.closed {
+		t.mutex.Unlock()
+		return nil
+	}
+	t.closed = true
+	close(t.done)
+	t.mutex.Unlock()
+
+	t.wg.Wait()
+	for _, f := range t.files {
+		f.file.Close()
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.writeCheckpoint()
+}
+
+func newTailCheckpoint(id tailFileID, path string, offset int64) tailCheckpoint {
+	return tailCheckpoint{Path: path, Device: id.device, Inode: id.inode, Offset: offset}
+}
+
+func (t *Tail) loadCheckpoint() error {
+	if t.checkpointPath == "" {
+		return nil
+	}
+	b, err := os.ReadFile(t.checkpointPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var checkpoints []tailCheckpoint
+	if err := json.Unmarshal(b, &checkpoints); err != nil {
+		return err
+	}
+	for _, c := range checkpoints {
+		id := tailFileID{device: c.Device, inode: c.Inode}
+		if c.Inode == 0 {
+			id.path = c.Path
+		}
+		t.saved[id] = c
+		// kept in the checkpoint file until the file is found
+		t.offsets[id] = c
+	}
+	return nil
+}
+
+// writeCheckpoint replaces the checkpoint file with the processed
+// offsets. The mutex must be held.
+func (t *Tail) writeCheckpoint() error {
+	if t.checkpointPath == "" {
+		return nil
+	}
+
+	checkpoints := make([]tailCheckpoint, 0, len(t.offsets))
+	for _, c := range t.offsets {
+		checkpoints = append(checkpoints, c)
+	}
+	sort.Slice(checkpoints, func(i, j int) bool {
+		return checkpoints[i].Path < checkpoints[j].Path
+	})
+	b, err := json.Marshal(checkpoints)
+	if err != nil {
+		return err
+	}
+
+	tmp := t.checkpointPath + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, t.checkpointPath)
+}
+
+func (t *Tail) run() {
+	defer t.wg.Done()
+
+	poll := time.NewTicker(t.pollInterval)
+	defer poll.Stop()
+
+	if !t.poll(true) {
+		return
+	}
+	for {
+		select {
+		case <-poll.C:
+			if !t.poll(false) {
+				return
+			}
+		case <-t.done:
+			return
+		}
+	}
+}
+
+// poll opens the files that started matching the patterns, reads the
+// files that stopped matching them to their end and closes them, and
+// reads the new lines of the other files. It returns false if the Tail
+// was closed.
+func (t *Tail) poll(initial bool) bool {
+	seen := make(map[*tailFile]bool)
+	var opened []*tailFile
+	for _, path := range t.glob() {
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+
+		id := newTailFileID(path, info)
+		if f := t.find(id); f != nil {
+			// a file renamed to a path matching the patterns
+			// keeps being read
+			f.path = path
+			seen[f] = true
+			continue
+		}
+
+		f, err := t.open(path, id, info, initial)
+		if err != nil {
+			continue
+		}
+		seen[f] = true
+		opened = append(opened, f)
+	}
+
+	// files that were removed or rotated are read before the files
+	// that replaced them
+	var files []*tailFile
+	for _, f := range t.files {
+		if seen[f] {
+			files = append(files, f)
+			continue
+		}
+		if !t.read(f, true) || !t.send(tailLine{id: f.id, path: f.path}) {
+			t.files = append(t.files, opened...)
+			return false
+		}
+		f.file.Close()
+	}
+	t.files = append(files, opened...)
+
+	for _, f := range t.files {
+		if !t.read(f, false) {
+			return false
+		}
+	}
+	return true
+}
+
+// glob returns the paths matching the patterns, sorted.
+func (t *Tail) glob() []string {
+	var paths []string
+	found := make(map[string]bool)
+	for _, pattern := range t.patterns {
+		matches, _ := filepath.Glob(pattern)
+		for _, path := range matches {
+			if !found[path] {
+				found[path] = true
+				paths = append(paths, path)
+			}
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func (t *Tail) find(id tailFileID) *tailFile {
+	for _, f := range t.files {
+		if f.id == id {
+			return f
+		}
+	}
+	return nil
+}
+
+// open opens a file at its checkpointed offset, at its end if it was
+// there when the Tail started with TailOptionStartAtEnd, or else at
+// its start.
+func (t *Tail) open(path string, id tailFileID, info os.FileInfo, initial bool) (*tailFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var offset int64
+	if c, ok := t.saved[id]; ok {
+		delete(t.saved, id)
+		if c.Offset <= info.Size() {
+			offset = c.Offset
+		}
+	} else if initial && t.startAtEnd {
+		offset = info.Size()
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	t.mutex.Lock()
+	t.offsets[id] = newTailCheckpoint(id, path, offset)
+	t.mutex.Unlock()
+	return &tailFile{id: id, path: path, file: file, offset: offset}, nil
+}
+
+// read sends the complete lines added to a file. If final is set, the
+// file is not going to grow any more, and a last line without a
+// newline is sent as well. It returns false if the Tail was closed.
+func (t *Tail) read(f *tailFile, final bool) bool {
+	if info, err := f.file.Stat(); err == nil && info.Size() < f.offset+int64(len(f.pending)) {
+		// truncated in place
+		if _, err := f.file.Seek(0, io.SeekStart); err == nil {
+			f.offset = 0
+			f.pending = nil
+		}
+	}
+
+	buf := make([]byte, tailReadSize)
+	for {
+		n, err := f.file.Read(buf)
+		f.pending = append(f.pending, buf[:n]...)
+		for {
+			i := bytes.IndexByte(f.pending, '\n')
+			if (i < 0 || i >= t.maxLineSize) && len(f.pending) >= t.maxLineSize {
+				i = t.maxLineSize - 1
+			}
+			if i < 0 {
+				break
+			}
+			if !t.sendLine(f, f.pending[:i+1]) {
+				return false
+			}
+		}
+		if n == 0 || err != nil {
+			break
+		}
+	}
+
+	if final && len(f.pending) > 0 {
+		return t.sendLine(f, f.pending)
+	}
+	return true
+}
+
+// sendLine sends a line from the start of a file's pending data.
+func (t *Tail) sendLine(f *tailFile, line []byte) bool {
+	f.offset += int64(len(line))
+	f.pending = f.pending[len(line):]
+	return t.send(tailLine{id: f.id, path: f.path, line: append([]byte(nil), line...), offset: f.offset})
+}
+
+// send queues a line for Receive, and returns false if the Tail was
+// closed while waiting for room.
+func (t *Tail) send(line tailLine) bool {
+	select {
+	case t.lines <- line:
+		return true
+	case <-t.done:
+		return false
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package captainslog
+
+import "os"
+
+// newTailFileID identifies a file by its path where its device and
+// inode are not available, so renamed files are not followed.
+func newTailFileID(path string, info os.FileInfo) tailFileID {
+	return tailFileID{path: path}
+}
@@ -0,0 +1,249 @@
+package captainslog_test
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/captainslog"
+)
+
+func tailLine(content string) string {
+	return "<191>2006-01-02T15:04:05.999999-07:00 host.example.org prog: " + content + "\n"
+}
+
+func appendFile(t *testing.T, path string, lines ...string) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for _, line := range lines {
+		if _, err := f.WriteString(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func newTail(t *testing.T, patterns []string, options ...func(*captainslog.Tail)) *captainslog.Tail {
+	t.Helper()
+	options = append(options, captainslog.TailOptionPollInterval(10*time.Millisecond))
+	tail, err := captainslog.NewTail(patterns, options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tail
+}
+
+// receiveContents receives n messages and returns their content.
+func receiveContents(t *testing.T, l captainslog.Listener, n int) []string {
+	t.Helper()
+	var contents []string
+	for i := 0; i < n; i++ {
+		msg, err := receive(t, l)
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents = append(contents, msg.Content)
+	}
+	return contents
+}
+
+func TestTailFollowsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "messages")
+	appendFile(t, path, tailLine("one"), tailLine("two"))
+
+	tail := newTail(t, []string{path})
+	defer tail.Close()
+
+	if want, got := []string{" one", " two"}, receiveContents(t, tail, 2); !equalStrings(want, got) {
+		t.Errorf("want %q, got %q", want, got)
+	}
+
+	// a partial line is returned once it is complete
+	appendFile(t, path, "<191>2006-01-02T15:04:05.999999-07:00 host.example.org prog: thr")
+	time.Sleep(50 * time.Millisecond)
+	appendFile(t, path, "ee\n")
+	if want, got := []string{" three"}, receiveContents(t, tail, 1); !equalStrings(want, got) {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func TestTailRenameRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "messages")
+	appendFile(t, path, tailLine("before"))
+
+	tail := newTail(t, []string{path})
+	defer tail.Close()
+	receiveContents(t, tail, 1)
+
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, path+".1", tailLine("late"))
+	appendFile(t, path, tailLine("after"))
+
+	if want, got := []string{" late", " after"}, receiveContents(t, tail, 2); !equalStrings(want, got) {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func TestTailTruncateRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "messages")
+	appendFile(t, path, tailLine("first"), tailLine("second"))
+
+	tail := newTail(t, []string{path})
+	defer tail.Close()
+	receiveContents(t, tail, 2)
+
+	if err := os.Truncate(path, 0); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	appendFile(t, path, tailLine("new"))
+
+	if want, got := []string{" new"}, receiveContents(t, tail, 1); !equalStrings(want, got) {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func TestTailGlob(t *testing.T) {
+	dir := t.TempDir()
+	appendFile(t, filepath.Join(dir, "a.log"), tailLine("a"))
+	appendFile(t, filepath.Join(dir, "b.log"), tailLine("b"))
+	appendFile(t, filepath.Join(dir, "c.txt"), tailLine("c"))
+
+	tail := newTail(t, []string{filepath.Join(dir, "*.log")})
+	defer tail.Close()
+	if want, got := []string{" a", " b"}, receiveContents(t, tail, 2); !equalStrings(want, got) {
+		t.Errorf("want %q, got %q", want, got)
+	}
+
+	// files created later are read from their start
+	appendFile(t, filepath.Join(dir, "d.log"), tailLine("d"))
+	if want, got := []string{" d"}, receiveContents(t, tail, 1); !equalStrings(want, got) {
+		t.Errorf("want %q, got %q", want, got)
+	}
+
+	if _, err := captainslog.NewTail([]string{"[bad"}); err == nil {
+		t.Errorf("want error for bad pattern")
+	}
+}
+
+func TestTailCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "messages")
+	checkpoint := filepath.Join(dir, "checkpoint.json")
+	appendFile(t, path, tailLine("one"), tailLine("two"), tailLine("three"))
+
+	tail := newTail(t, []string{path}, captainslog.TailOptionCheckpoint(checkpoint))
+	if want, got := []string{" one", " two"}, receiveContents(t, tail, 2); !equalStrings(want, got) {
+		t.Errorf("want %q, got %q", want, got)
+	}
+	if err := tail.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tail.Receive(); err == nil {
+		t.Errorf("want error after Close")
+	}
+
+	appendFile(t, path, tailLine("four"))
+	tail = newTail(t, []string{path}, captainslog.TailOptionCheckpoint(checkpoint))
+	if want, got := []string{" three", " four"}, receiveContents(t, tail, 2); !equalStrings(want, got) {
+		t.Errorf("want %q, got %q", want, got)
+	}
+
+	// the offsets of the lines returned are checkpointed without Close,
+	// as if the process had crashed
+	appendFile(t, path, tailLine("five"))
+	restarted := newTail(t, []string{path}, captainslog.TailOptionCheckpoint(checkpoint))
+	if want, got := []string{" five"}, receiveContents(t, restarted, 1); !equalStrings(want, got) {
+		t.Errorf("want %q, got %q", want, got)
+	}
+	tail.Close()
+
+	// the file is rotated while nothing is following it
+	if err := restarted.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, path+".1", tailLine("six"))
+	appendFile(t, path, tailLine("seven"))
+
+	tail = newTail(t, []string{path + "*"}, captainslog.TailOptionCheckpoint(checkpoint))
+	defer tail.Close()
+	got := receiveContents(t, tail, 2)
+	sort.Strings(got)
+	if want := []string{" seven", " six"}; !equalStrings(want, got) {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func TestTailCheckpointMissingFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "messages")
+	checkpoint := filepath.Join(dir, "checkpoint.json")
+	appendFile(t, path, tailLine("one"), tailLine("two"))
+
+	tail := newTail(t, []string{path}, captainslog.TailOptionCheckpoint(checkpoint))
+	receiveContents(t, tail, 1)
+	if err := tail.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the file is away while a Tail starts and writes its checkpoint
+	if err := os.Rename(path, path+".away"); err != nil {
+		t.Fatal(err)
+	}
+	tail = newTail(t, []string{path}, captainslog.TailOptionCheckpoint(checkpoint))
+	if err := tail.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Rename(path+".away", path); err != nil {
+		t.Fatal(err)
+	}
+	tail = newTail(t, []string{path}, captainslog.TailOptionCheckpoint(checkpoint))
+	defer tail.Close()
+	if want, got := []string{" two"}, receiveContents(t, tail, 1); !equalStrings(want, got) {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func TestTailStartAtEnd(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "messages")
+	appendFile(t, path, tailLine("old"))
+
+	tail := newTail(t, []string{path}, captainslog.TailOptionStartAtEnd)
+	defer tail.Close()
+	time.Sleep(50 * time.Millisecond)
+	appendFile(t, path, tailLine("new"))
+
+	if want, got := []string{" new"}, receiveContents(t, tail, 1); !equalStrings(want, got) {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func TestTailMaxLineSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "messages")
+	appendFile(t, path, tailLine("0123456789"))
+
+	tail := newTail(t, []string{path}, captainslog.TailOptionMaxLineSize(64))
+	defer tail.Close()
+
+	msg, err := receive(t, tail)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := " 012", msg.Content; want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+	if _, err := receive(t, tail); err == nil {
+		t.Errorf("want the rest of the line to fail to parse")
+	}
+}
@@ -0,0 +1,16 @@
+//go:build unix
+
+package captainslog
+
+import (
+	"os"
+	"syscall"
+)
+
+// newTailFileID returns the device and inode of a file.
+func newTailFileID(path string, info os.FileInfo) tailFileID {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return tailFileID{device: uint64(st.Dev), inode: uint64(st.Ino)}
+	}
+	return tailFileID{path: path}
+}